@@ -3,16 +3,25 @@ package main
 import (
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/config"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/envfile"
 	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/executor"
 	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/server"
 	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
 )
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
 func main() {
 	cfg, err := config.Load()
 	if err != nil {
@@ -32,62 +41,400 @@ func main() {
 	default:
 		logLevel = slog.LevelInfo
 	}
-	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+	// Everything logs through the default logger, including the standard
+	// library's log package once it is set, so all output shares a format.
+	handlerOpts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, handlerOpts)
+	if cfg.LogFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, handlerOpts)
+	}
+	slog.SetDefault(slog.New(handler))
 
 	// Create executor
-	var exec executor.Executor
-	switch cfg.Executor {
-	case "docker":
-		exec, err = executor.NewDockerExecutor(executor.DockerExecutorOpts{
-			ForwardLogs: cfg.ForwardContainerLogs,
-			Network:     cfg.DockerNetwork,
-			ExtraHosts:  cfg.DockerExtraHosts,
-			GPU:         cfg.DockerGPU,
-		})
-		if err != nil {
-			slog.Error("failed to create docker executor", "error", err)
-			os.Exit(1)
-		}
-		slog.Info("using docker executor", "forward_container_logs", cfg.ForwardContainerLogs, "gpu", cfg.DockerGPU)
-	case "subprocess":
-		exec = executor.NewSubprocessExecutor()
-		slog.Info("using subprocess executor")
-	default:
-		slog.Error("unknown executor type", "executor", cfg.Executor)
+	exec, err := newExecutor(cfg)
+	if err != nil {
+		slog.Error("failed to create executor", "error", err)
 		os.Exit(1)
 	}
 
-	// Create state store and register jobs from config
+	if cfg.JobsFileMissing {
+		slog.Warn("jobs config file not found; starting with no jobs. Mount your jobs.yaml and set JOBS_CONFIG, or create jobs via the API",
+			"path", cfg.JobsFile)
+	}
+
+	// Create state store and register jobs from config. Config jobs are
+	// synced the same way as on reload, so edits to jobs.yaml apply across
+	// restarts; jobs created via the API are restored as they were.
 	store := state.NewStore()
-	for _, jd := range cfg.Jobs.Jobs {
-		name := fmt.Sprintf("projects/%s/locations/%s/jobs/%s", cfg.ProjectID, cfg.Region, jd.Name)
-		job := &state.Job{
-			Name:    name,
-			Image:   jd.Image,
-			Command: jd.Command,
-			Env:     jd.Env,
-		}
-		if job.Env == nil {
-			job.Env = make(map[string]string)
+	if cfg.StateFile != "" {
+		if store, err = state.OpenStore(cfg.StateFile); err != nil {
+			slog.Error("failed to load state file", "path", cfg.StateFile, "error", err)
+			os.Exit(1)
 		}
-		store.SaveJob(job)
-		slog.Info("registered job", "name", name, "image", jd.Image)
+		slog.Info("persisting state", "path", cfg.StateFile,
+			"jobs", len(store.ListJobs("")), "executions", len(store.ListExecutions("")))
+	}
+	if err := registerJobs(store, cfg); err != nil {
+		slog.Error("invalid job definition", "error", err)
+		os.Exit(1)
+	}
+
+	defaultResources, err := state.ParseResources(cfg.DefaultCPU, cfg.DefaultMemory)
+	if err != nil {
+		slog.Error("invalid DEFAULT_CPU or DEFAULT_MEMORY", "error", err)
+		os.Exit(1)
+	}
+
+	if cfg.RequestTimeout > 0 && cfg.RunJobSyncWait >= cfg.RequestTimeout {
+		slog.Warn("RUN_JOB_SYNC_WAIT is not shorter than REQUEST_TIMEOUT; RunJob calls that wait out the sync wait will time out",
+			"sync_wait", cfg.RunJobSyncWait, "request_timeout", cfg.RequestTimeout)
 	}
 
 	// Start gRPC server
-	srv := server.New(store, exec, cfg.ProjectID, cfg.Region)
+	srv := server.New(store, exec, server.Opts{
+		ProjectID:                cfg.ProjectID,
+		Region:                   cfg.Region,
+		RelaxedNames:             cfg.RelaxedNames,
+		LabelRunSource:           cfg.LabelRunSource,
+		OmitTaskEnv:              !cfg.InjectTaskEnv,
+		ValidateImages:           cfg.ValidateImages,
+		RejectDeleteWhileRunning: cfg.RejectDeleteWhileRunning,
+		ImageCleanup:             cfg.ImageCleanup,
+		DebugDump:                cfg.DebugDump,
+		MetricsExemplars:         cfg.MetricsExemplars,
+		CrashOnExecutorPanic:     cfg.CrashOnExecutorPanic,
+		Version:                  version,
+		DebugConfig:              cfg.Redacted(),
+		RunJobSyncWait:           cfg.RunJobSyncWait,
+		RequestTimeout:           cfg.RequestTimeout,
+		APIKey:                   cfg.APIKey,
+		CompletionWebhookURL:     cfg.CompletionWebhookURL,
+		OperationRetention:       cfg.OperationRetention,
+		ExecutionTTL:             cfg.ExecutionTTL,
+		MaxExecutionsPerJob:      cfg.MaxExecutionsPerJob,
+		DefaultResources:         defaultResources,
+		DefaultTaskCount:         int32(cfg.DefaultTaskCount),
+		DefaultParallelism:       int32(cfg.DefaultParallelism),
+		MaxLogLines:              cfg.MaxLogLines,
+		MaxLogBytes:              cfg.MaxLogBytes,
+		MaxConcurrentExecutions:  cfg.MaxConcurrentExecutions,
+		Scheduler:                cfg.Scheduler,
+		Secrets:                  cfg.Secrets,
+	})
 
 	// Handle graceful shutdown
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
 	go func() {
+		defer close(stopped)
 		<-sigCh
 		slog.Info("shutting down...")
-		srv.Stop()
+		shutdown(cfg, srv, store)
+	}()
+
+	// Reload jobs and rebuild the executor on SIGHUP so the jobs config and
+	// executor settings can change without losing state.
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			reload(srv, store)
+		}
 	}()
 
+	if cfg.EnableSchedules {
+		if err := srv.StartSchedules(); err != nil {
+			slog.Error("failed to start job schedules", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.AdminPort != "" {
+		go func() {
+			if err := srv.StartAdmin(cfg.AdminPort); err != nil {
+				slog.Error("admin server failed", "error", err)
+			}
+		}()
+	}
+
+	if cfg.HTTPPort != "" {
+		go func() {
+			if err := srv.StartREST(cfg.HTTPPort); err != nil {
+				slog.Error("REST server failed", "error", err)
+			}
+		}()
+	}
+
 	if err := srv.Start(cfg.Port); err != nil {
 		slog.Error("server failed", "error", err)
 		os.Exit(1)
 	}
+	<-stopped
+}
+
+// shutdown stops srv and then, with STATE_FILE_CLEANUP_ON_EXIT, deletes the
+// state file.
+func shutdown(cfg *config.Config, srv *server.Server, store *state.Store) {
+	srv.Stop()
+	if cfg.StateFile == "" || !cfg.StateFileCleanupOnExit {
+		return
+	}
+	if err := store.RemoveFile(); err != nil {
+		slog.Error("failed to remove state file", "path", cfg.StateFile, "error", err)
+		return
+	}
+	slog.Info("removed state file", "path", cfg.StateFile)
+}
+
+// registerJobs syncs the jobs in the jobs config file into store at startup.
+// Like a reload, it replaces config jobs persisted by an earlier run, removes
+// those no longer listed and leaves jobs created through the API alone.
+func registerJobs(store *state.Store, cfg *config.Config) error {
+	jobs, err := configJobs(cfg)
+	if err != nil {
+		return err
+	}
+	changes := store.SyncConfigJobs(jobs)
+	for _, name := range changes.Skipped {
+		slog.Warn("not registering job from config: a job of the same name was created through the API", "name", name)
+	}
+	for _, name := range changes.Removed {
+		slog.Info("removed job no longer in config", "name", name)
+	}
+	for _, job := range jobs {
+		if !slices.Contains(changes.Skipped, job.Name) {
+			slog.Info("registered job", "name", job.Name, "image", job.Image)
+		}
+	}
+	return nil
+}
+
+// configJobs converts every job in the jobs config file.
+func configJobs(cfg *config.Config) ([]*state.Job, error) {
+	var jobs []*state.Job
+	for _, jd := range cfg.Jobs.Jobs {
+		job, err := jobFromDefinition(cfg, jd)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", jd.Name, err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// jobFromDefinition converts a job from the jobs config file, already
+// checked by config.JobsConfig.Validate, into its stored representation
+// under the configured project and region. It only fails if the job's
+// env_file can no longer be read.
+func jobFromDefinition(cfg *config.Config, jd config.JobDefinition) (*state.Job, error) {
+	// Validate rejected any value these would fail to parse.
+	resources, _ := state.ParseResources(jd.Resources.CPU, jd.Resources.Memory)
+	executionResources, _ := state.ParseResources(jd.ExecutionTemplate.Resources.CPU, jd.ExecutionTemplate.Resources.Memory)
+	var timeout, scheduleJitter time.Duration
+	if jd.Timeout != "" {
+		timeout, _ = time.ParseDuration(jd.Timeout)
+	}
+	var stopTimeout *time.Duration
+	if jd.StopTimeout != "" {
+		d, _ := time.ParseDuration(jd.StopTimeout)
+		stopTimeout = &d
+	}
+	if jd.ScheduleJitter != "" {
+		scheduleJitter, _ = time.ParseDuration(jd.ScheduleJitter)
+	}
+
+	configDir := filepath.Dir(cfg.JobsFile)
+	resolve := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(configDir, path)
+	}
+
+	var envFrom []state.EnvSource
+	for _, src := range jd.EnvFrom {
+		envFrom = append(envFrom, state.EnvSource{Path: resolve(src.Path), Optional: src.Optional})
+	}
+
+	env := jd.Env
+	if jd.EnvFile != "" {
+		fileEnv, err := envfile.Read(resolve(jd.EnvFile))
+		if err != nil {
+			return nil, fmt.Errorf("env_file: %w", err)
+		}
+		// Explicit env entries take precedence.
+		maps.Copy(fileEnv, jd.Env)
+		env = fileEnv
+	}
+
+	var volumes []state.Volume
+	for _, v := range jd.Volumes {
+		volumes = append(volumes, state.Volume{HostPath: resolve(v.HostPath), ContainerPath: v.ContainerPath, ReadOnly: v.ReadOnly})
+	}
+
+	var securityOpt []string
+	for _, opt := range jd.SecurityOpt {
+		profile, ok := strings.CutPrefix(opt, "seccomp=")
+		if ok && profile != "unconfined" && profile != "builtin" {
+			opt = "seccomp=" + resolve(profile)
+		}
+		securityOpt = append(securityOpt, opt)
+	}
+
+	var stdin *state.StdinSource
+	switch {
+	case jd.Stdin == nil:
+	case jd.Stdin.File != "":
+		stdin = &state.StdinSource{File: resolve(jd.Stdin.File)}
+	default:
+		stdin = &state.StdinSource{Text: jd.Stdin.Text}
+	}
+
+	job := &state.Job{
+		Name:               fmt.Sprintf("projects/%s/locations/%s/jobs/%s", cfg.ProjectID, cfg.Region, jd.Name),
+		Image:              jd.Image,
+		Command:            jd.Command,
+		Args:               jd.Args,
+		WorkingDir:         jd.WorkingDir,
+		Env:                env,
+		SecretEnv:          jd.SecretEnv,
+		EnvFrom:            envFrom,
+		Stdin:              stdin,
+		TaskCount:          jd.TaskCount,
+		Parallelism:        jd.Parallelism,
+		Timeout:            timeout,
+		StopTimeout:        stopTimeout,
+		Resources:          resources,
+		ExecutionResources: executionResources,
+		NetworkAliases:     jd.NetworkAliases,
+		SecurityOpt:        securityOpt,
+		EnvFilePath:        jd.EnvFilePath,
+		StartupProbe:       probeFromConfig(jd.StartupProbe),
+		FailOnStderr:       jd.FailOnStderr,
+		Hostname:           jd.Hostname,
+		SuccessExitCodes:   jd.SuccessExitCodes,
+		MaxRetries:         jd.MaxRetries,
+		RetryableExitCodes: jd.RetryableExitCodes,
+		ExecutionRetries:   jd.ExecutionRetries,
+		Schedule:           jd.Schedule,
+		ScheduleJitter:     scheduleJitter,
+		ConcurrencyGroup:   jd.ConcurrencyGroup,
+		Volumes:            volumes,
+		ReadOnlyRoot:       jd.ReadOnlyRoot,
+		Platform:           jd.Platform,
+		FromConfig:         true,
+	}
+	if job.Env == nil {
+		job.Env = make(map[string]string)
+	}
+	return job, nil
+}
+
+// probeFromConfig converts a startup probe definition, returning nil if pc
+// is nil.
+func probeFromConfig(pc *config.ProbeConfig) *state.Probe {
+	if pc == nil {
+		return nil
+	}
+	probe := &state.Probe{Command: pc.Command, TCPPort: pc.TCPPort, FailureThreshold: pc.FailureThreshold}
+	if pc.InitialDelay != "" {
+		probe.InitialDelay, _ = time.ParseDuration(pc.InitialDelay)
+	}
+	if pc.Period != "" {
+		probe.Period, _ = time.ParseDuration(pc.Period)
+	}
+	return probe
+}
+
+// newExecutor creates the executor selected by cfg.
+func newExecutor(cfg *config.Config) (executor.Executor, error) {
+	switch cfg.Executor {
+	case "docker":
+		exec, err := executor.NewDockerExecutor(executor.DockerExecutorOpts{
+			ForwardLogs:          cfg.ForwardContainerLogs,
+			LogTimestamps:        cfg.ContainerLogTimestamps,
+			Network:              cfg.DockerNetwork,
+			ExtraHosts:           cfg.DockerExtraHosts,
+			DNS:                  cfg.DockerDNS,
+			DNSSearch:            cfg.DockerDNSSearch,
+			GPU:                  cfg.DockerGPU,
+			Init:                 cfg.DockerInit,
+			MaxConcurrentPulls:   cfg.MaxConcurrentPulls,
+			LogDrainTimeout:      cfg.LogDrainTimeout,
+			CgroupParent:         cfg.CgroupParent,
+			WarmPoolSize:         cfg.WarmPoolSize,
+			AllowEmulation:       cfg.DockerAllowEmulation,
+			Platform:             cfg.DockerPlatform,
+			AutoRemove:           cfg.DockerAutoRemove,
+			KeepFailedContainers: cfg.KeepFailedContainers,
+			CollectStats:         cfg.CollectStats,
+			ImagePullPolicy:      cfg.ImagePullPolicy,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating docker executor: %w", err)
+		}
+		slog.Info("using docker executor", "forward_container_logs", cfg.ForwardContainerLogs, "gpu", cfg.DockerGPU)
+		return exec, nil
+	case "subprocess":
+		slog.Info("using subprocess executor")
+		if cfg.CgroupParent != "" {
+			slog.Warn("CGROUP_PARENT is ignored by the subprocess executor")
+		}
+		return executor.NewSubprocessExecutor(executor.SubprocessExecutorOpts{
+			QuietOutput: cfg.SubprocessQuietOutput,
+			LogOutput:   cfg.LogFormat == "json",
+			CleanEnv:    cfg.SubprocessCleanEnv,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown executor type: %s", cfg.Executor)
+	}
+}
+
+// reload re-reads the configuration, syncs the jobs from the jobs config
+// file into store and swaps in a freshly built executor. Failures are logged
+// and the previous jobs or executor are kept.
+func reload(srv *server.Server, store *state.Store) {
+	slog.Info("reloading configuration")
+	cfg, err := config.Load()
+	if err != nil {
+		slog.Error("reload failed, keeping current configuration", "error", err)
+		return
+	}
+
+	reloadJobs(srv, store, cfg)
+
+	exec, err := newExecutor(cfg)
+	if err != nil {
+		slog.Error("reload failed, keeping current executor", "error", err)
+		return
+	}
+	srv.SetExecutor(exec)
+	srv.SetDebugConfig(cfg.Redacted())
+	slog.Info("executor reloaded", "executor", cfg.Executor, "network", cfg.DockerNetwork)
+}
+
+// reloadJobs replaces the jobs registered from the jobs config file with
+// those in cfg, leaving jobs created through the API alone.
+func reloadJobs(srv *server.Server, store *state.Store, cfg *config.Config) {
+	if cfg.JobsFileMissing {
+		slog.Warn("jobs config file not found, keeping current jobs", "path", cfg.JobsFile)
+		return
+	}
+	jobs, err := configJobs(cfg)
+	if err != nil {
+		slog.Error("jobs reload failed, keeping current jobs", "error", err)
+		return
+	}
+
+	changes := store.SyncConfigJobs(jobs)
+	for _, name := range changes.Skipped {
+		slog.Warn("not reloading job from config: a job of the same name was created through the API", "name", name)
+	}
+	slog.Info("jobs reloaded", "added", changes.Added, "updated", changes.Updated, "removed", changes.Removed)
+	if len(changes.Added)+len(changes.Updated)+len(changes.Removed) > 0 {
+		if err := srv.RestartSchedules(); err != nil {
+			slog.Error("failed to reschedule reloaded jobs", "error", err)
+		}
+	}
 }
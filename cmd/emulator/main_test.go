@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/config"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/executor"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/server"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+func TestShutdownStateFileCleanup(t *testing.T) {
+	for _, cleanup := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "state.json")
+		store, err := state.OpenStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.SaveJob(&state.Job{Name: "projects/p/locations/l/jobs/kept", Image: "alpine:latest"})
+		srv := server.New(store, executor.NewSubprocessExecutor(executor.SubprocessExecutorOpts{}), server.Opts{})
+
+		shutdown(&config.Config{StateFile: path, StateFileCleanupOnExit: cleanup}, srv, store)
+		// Nothing writes the file back once it is removed.
+		store.Sync()
+
+		_, err = os.Stat(path)
+		if cleanup && !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected the state file to be removed, got %v", err)
+		}
+		if !cleanup && err != nil {
+			t.Errorf("expected the state file to be kept, got %v", err)
+		}
+	}
+}
+
+func TestRegisterJobsAcrossRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	jobName := func(name string) string { return "projects/p/locations/l/jobs/" + name }
+	cfg := &config.Config{ProjectID: "p", Region: "l", Jobs: &config.JobsConfig{Jobs: []config.JobDefinition{
+		{Name: "edited", Image: "alpine:3.19"},
+		{Name: "removed", Image: "alpine:latest"},
+		{Name: "shared", Image: "alpine:latest"},
+	}}}
+
+	store, err := state.OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := registerJobs(store, cfg); err != nil {
+		t.Fatal(err)
+	}
+	// A job created through the API of a name the config later defines.
+	store.SaveJob(&state.Job{Name: jobName("shared"), Image: "busybox:latest"})
+	store.Sync()
+
+	// Restart with an edited jobs config.
+	cfg.Jobs.Jobs = []config.JobDefinition{
+		{Name: "edited", Image: "alpine:3.20"},
+		{Name: "shared", Image: "alpine:latest"},
+	}
+	store, err = state.OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := registerJobs(store, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if job, err := store.GetJob(jobName("edited")); err != nil || job.Image != "alpine:3.20" {
+		t.Errorf("expected the edited config job to be replaced, got %+v, %v", job, err)
+	}
+	if _, err := store.GetJob(jobName("removed")); err == nil {
+		t.Error("expected the config job no longer listed to be removed")
+	}
+	if job, err := store.GetJob(jobName("shared")); err != nil || job.Image != "busybox:latest" || job.FromConfig {
+		t.Errorf("expected the API-created job to be kept, got %+v, %v", job, err)
+	}
+}
+
+func TestJobFromDefinitionEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	envFile := "# defaults\n\nGREETING=hello\nNAME=file\n"
+	if err := os.WriteFile(filepath.Join(dir, "job.env"), []byte(envFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{ProjectID: "p", Region: "l", JobsFile: filepath.Join(dir, "jobs.yaml")}
+
+	job, err := jobFromDefinition(cfg, config.JobDefinition{
+		Name:    "env",
+		Image:   "alpine:latest",
+		Env:     map[string]string{"NAME": "env"},
+		EnvFile: "job.env",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The relative path resolves against the jobs config file's directory,
+	// comments and blank lines are skipped, and env takes precedence.
+	want := map[string]string{"GREETING": "hello", "NAME": "env"}
+	if !maps.Equal(job.Env, want) {
+		t.Errorf("expected env %v, got %v", want, job.Env)
+	}
+
+	_, err = jobFromDefinition(cfg, config.JobDefinition{Name: "missing", Image: "alpine:latest", EnvFile: "missing.env"})
+	if err == nil || !strings.HasPrefix(err.Error(), "env_file:") || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected an env_file error for a missing file, got %v", err)
+	}
+}
@@ -1,23 +1,151 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/envfile"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/executor"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+	"github.com/robfig/cron/v3"
 	"gopkg.in/yaml.v3"
 )
 
 type JobDefinition struct {
-	Name    string            `yaml:"name"`
-	Image   string            `yaml:"image"`
-	Command []string          `yaml:"command"`
-	Env     map[string]string `yaml:"env"`
-	Resources struct {
-		CPU    string `yaml:"cpu"`
-		Memory string `yaml:"memory"`
-	} `yaml:"resources"`
-	Timeout string `yaml:"timeout"`
+	Name  string `yaml:"name"`
+	Image string `yaml:"image"`
+	// Command replaces the image's entrypoint, and Args the arguments passed
+	// to it (the image's CMD), like Cloud Run's command and args. Leave
+	// Command empty to keep the image's entrypoint and only set Args. The
+	// subprocess executor runs Command followed by Args.
+	Command []string `yaml:"command"`
+	Args    []string `yaml:"args"`
+	// WorkingDir overrides the image's WORKDIR. With the subprocess executor
+	// it is a host directory, relative to the emulator's own.
+	WorkingDir string            `yaml:"working_dir"`
+	Env        map[string]string `yaml:"env"`
+	// SecretEnv maps environment variables to secret names, resolved from
+	// SECRETS and SECRETS_FILE when the job runs.
+	SecretEnv map[string]string `yaml:"secret_env"`
+	// EnvFrom lists KEY=VALUE files (e.g. Kubernetes configmap dumps) read at
+	// run time. They sit beneath Env, with later files overriding earlier
+	// ones. Relative paths are resolved against the jobs config directory.
+	EnvFrom []EnvFromSource `yaml:"env_from"`
+	// EnvFile is a .env file of KEY=VALUE lines read when the config is
+	// loaded, beneath Env. Unlike EnvFrom it must exist. A relative path is
+	// resolved against the jobs config directory.
+	EnvFile   string          `yaml:"env_file"`
+	Resources ResourcesConfig `yaml:"resources"`
+	// ExecutionTemplate holds per-run settings that take precedence over
+	// the container's.
+	ExecutionTemplate struct {
+		Resources ResourcesConfig `yaml:"resources"`
+	} `yaml:"execution_template"`
+	// TaskCount is the number of tasks each execution runs, each given its
+	// index in CLOUD_RUN_TASK_INDEX. Defaults to 1.
+	TaskCount int32 `yaml:"task_count"`
+	// Parallelism is reported as the number of tasks that may run at once.
+	// Defaults to DEFAULT_PARALLELISM; tasks still run one at a time.
+	Parallelism int32  `yaml:"parallelism"`
+	Timeout     string `yaml:"timeout"`
+	// StopTimeout is how long the job has to exit after SIGTERM when it is
+	// cancelled or times out, before it is killed.
+	StopTimeout string `yaml:"stop_timeout"`
+	// Stdin is piped to the job's standard input, read either from a file
+	// (relative to the jobs config directory) or given inline as text.
+	Stdin *StdinConfig `yaml:"stdin"`
+	// NetworkAliases are DNS aliases for the job's container on the Docker
+	// network. Ignored with host networking.
+	NetworkAliases []string `yaml:"network_aliases"`
+	// SecurityOpt are Docker security options, e.g. seccomp=profile.json
+	// (relative to the jobs config directory) or apparmor=my-profile.
+	SecurityOpt []string `yaml:"security_opt"`
+	// StartupProbe must pass before an execution counts as running.
+	StartupProbe *ProbeConfig `yaml:"startup_probe"`
+	// EnvFilePath writes the job's resolved environment to a file at this
+	// absolute path in the container (e.g. /etc/cloud-run-env), for images
+	// that source an env file.
+	EnvFilePath string `yaml:"env_file_path"`
+	// Hostname is the container's hostname. Defaults to one derived from
+	// the execution, e.g. my-job-abc123.
+	Hostname string `yaml:"hostname"`
+	// FailOnStderr fails a task that writes to stderr, even if it exits 0.
+	FailOnStderr bool `yaml:"fail_on_stderr"`
+	// SuccessExitCodes lists the exit codes that count as a successful run.
+	// Defaults to [0] when empty.
+	SuccessExitCodes []int `yaml:"success_exit_codes"`
+	// MaxRetries is how many times a failed task is retried.
+	MaxRetries int `yaml:"max_retries"`
+	// RetryableExitCodes limits retries to these exit codes (e.g. 137 for
+	// OOM kills). Empty retries any failing exit code.
+	RetryableExitCodes []int `yaml:"retryable_exit_codes"`
+	// ExecutionRetries reruns a failed execution as a new execution up to
+	// this many times.
+	ExecutionRetries int `yaml:"execution_retries"`
+	// Schedule is a cron expression (e.g. "*/5 * * * *" or "@hourly") on
+	// which the job is run automatically when ENABLE_SCHEDULES is set.
+	Schedule string `yaml:"schedule"`
+	// ScheduleJitter is the maximum random delay (e.g. "30s") added before
+	// each scheduled run.
+	ScheduleJitter string `yaml:"schedule_jitter"`
+	// ConcurrencyGroup serializes executions of every job naming the same
+	// group.
+	ConcurrencyGroup string `yaml:"concurrency_group"`
+	// Volumes bind-mount host directories or files into the container.
+	Volumes []VolumeConfig `yaml:"volumes"`
+	// ReadOnlyRoot mounts the container's root filesystem read-only, with a
+	// tmpfs at /tmp unless a volume is mounted there.
+	ReadOnlyRoot bool `yaml:"read_only_root"`
+	// Platform is the os/arch[/variant] the job's images are pulled and run
+	// as, overriding DOCKER_PLATFORM.
+	Platform string `yaml:"platform"`
+}
+
+// VolumeConfig bind-mounts HostPath, which must exist and is relative to the
+// jobs config directory unless absolute, at ContainerPath, which must be
+// absolute.
+type VolumeConfig struct {
+	HostPath      string `yaml:"host_path"`
+	ContainerPath string `yaml:"container_path"`
+	ReadOnly      bool   `yaml:"read_only"`
+}
+
+// ResourcesConfig sets CPU and memory limits as Kubernetes-style quantities
+// (e.g. "500m", "512Mi").
+type ResourcesConfig struct {
+	CPU    string `yaml:"cpu"`
+	Memory string `yaml:"memory"`
+}
+
+// ProbeConfig is a startup probe: a command run in the container or a TCP
+// port to connect to. Durations are Go durations (e.g. "2s").
+type ProbeConfig struct {
+	Command          []string `yaml:"command"`
+	TCPPort          int      `yaml:"tcp_port"`
+	InitialDelay     string   `yaml:"initial_delay"`
+	Period           string   `yaml:"period"`
+	FailureThreshold int      `yaml:"failure_threshold"`
+}
+
+// StdinConfig is the source of a job's standard input. Set exactly one field.
+type StdinConfig struct {
+	File string `yaml:"file"`
+	Text string `yaml:"text"`
+}
+
+// EnvFromSource references an environment file for a job.
+type EnvFromSource struct {
+	Path     string `yaml:"path"`
+	Optional bool   `yaml:"optional"`
 }
 
 type JobsConfig struct {
@@ -25,49 +153,446 @@ type JobsConfig struct {
 }
 
 type Config struct {
-	Port                 string
-	JobsFile             string
-	Executor             string
-	LogLevel             string
-	ProjectID            string
-	Region               string
-	ForwardContainerLogs bool
-	DockerNetwork        string
-	DockerExtraHosts     []string
-	DockerGPU            bool
-	Jobs                 *JobsConfig
+	Port                     string
+	AdminPort                string
+	HTTPPort                 string
+	JobsFile                 string
+	RequireJobsConfig        bool
+	Executor                 string
+	LogLevel                 string
+	LogFormat                string
+	ProjectID                string
+	Region                   string
+	ForwardContainerLogs     bool
+	ContainerLogTimestamps   bool
+	SubprocessQuietOutput    bool
+	SubprocessCleanEnv       bool
+	DockerNetwork            string
+	DockerExtraHosts         []string
+	DockerDNS                []string
+	DockerDNSSearch          []string
+	DockerGPU                bool
+	DockerInit               bool
+	DockerAllowEmulation     bool
+	DockerPlatform           string
+	DockerAutoRemove         bool
+	KeepFailedContainers     bool
+	CollectStats             bool
+	ImagePullPolicy          string
+	MaxConcurrentPulls       int
+	CgroupParent             string
+	WarmPoolSize             int
+	LogDrainTimeout          time.Duration
+	DefaultCPU               string
+	DefaultMemory            string
+	DefaultTaskCount         int
+	DefaultParallelism       int
+	RelaxedNames             bool
+	LabelRunSource           bool
+	InjectTaskEnv            bool
+	ValidateImages           bool
+	RejectDeleteWhileRunning bool
+	ImageCleanup             bool
+	DebugDump                bool
+	MetricsExemplars         bool
+	CrashOnExecutorPanic     bool
+	RunJobSyncWait           time.Duration
+	RequestTimeout           time.Duration
+	OperationRetention       time.Duration
+	ExecutionTTL             time.Duration
+	MaxExecutionsPerJob      int
+	MaxLogLines              int
+	MaxLogBytes              int
+	MaxConcurrentExecutions  int
+	Scheduler                string
+	EnableSchedules          bool
+	// StateFile is the JSON file jobs and executions are persisted to, if
+	// any.
+	StateFile string
+	// StateFileCleanupOnExit deletes StateFile on a clean shutdown.
+	StateFileCleanupOnExit bool
+	// APIKey, if set, is required of API callers in the x-api-key header.
+	APIKey string
+	// CompletionWebhookURL, if set, is POSTed a summary of each finished
+	// execution.
+	CompletionWebhookURL string
+	// Secrets holds secret values by name, from SECRETS_FILE and SECRETS.
+	Secrets map[string]string
+	Jobs    *JobsConfig
+	// JobsFileMissing is set when JobsFile doesn't exist, so no jobs were
+	// loaded from config.
+	JobsFileMissing bool
 }
 
 func Load() (*Config, error) {
+	env, err := loadEnvironment(os.Getenv("SETTINGS_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("loading settings file: %w", err)
+	}
+
 	cfg := &Config{
-		Port:                 getEnv("PORT", "8123"),
-		JobsFile:             getEnv("JOBS_CONFIG", "./jobs.yaml"),
-		Executor:             getEnv("EXECUTOR", "docker"),
-		LogLevel:             getEnv("LOG_LEVEL", "info"),
-		ProjectID:            getEnv("PROJECT_ID", "fake-project"),
-		Region:               getEnv("REGION", "us-central1"),
-		ForwardContainerLogs: getEnvBool("FORWARD_CONTAINER_LOGS", false),
-		DockerNetwork:        getEnv("DOCKER_NETWORK", "auto"),
-		DockerExtraHosts:     parseExtraHosts(os.Getenv("DOCKER_EXTRA_HOSTS")),
-		DockerGPU:            getEnvBool("DOCKER_GPU", false),
+		Port:                     env.getEnv("PORT", "8123"),
+		AdminPort:                env.lookup("ADMIN_PORT"),
+		HTTPPort:                 env.lookup("HTTP_PORT"),
+		JobsFile:                 env.getEnv("JOBS_CONFIG", "./jobs.yaml"),
+		RequireJobsConfig:        env.getEnvBool("REQUIRE_JOBS_CONFIG", false),
+		Executor:                 env.getEnv("EXECUTOR", "docker"),
+		LogLevel:                 env.getEnv("LOG_LEVEL", "info"),
+		LogFormat:                env.getEnv("LOG_FORMAT", "text"),
+		ProjectID:                env.getEnv("PROJECT_ID", "fake-project"),
+		Region:                   env.getEnv("REGION", "us-central1"),
+		ForwardContainerLogs:     env.getEnvBool("FORWARD_CONTAINER_LOGS", false),
+		ContainerLogTimestamps:   env.getEnvBool("CONTAINER_LOG_TIMESTAMPS", false),
+		SubprocessQuietOutput:    env.getEnvBool("SUBPROCESS_QUIET_OUTPUT", false),
+		SubprocessCleanEnv:       env.getEnvBool("SUBPROCESS_CLEAN_ENV", false),
+		DockerNetwork:            env.getEnv("DOCKER_NETWORK", "auto"),
+		DockerExtraHosts:         parseList(env.lookup("DOCKER_EXTRA_HOSTS")),
+		DockerDNS:                parseList(env.lookup("DOCKER_DNS")),
+		DockerDNSSearch:          parseList(env.lookup("DOCKER_DNS_SEARCH")),
+		DockerGPU:                env.getEnvBool("DOCKER_GPU", false),
+		DockerInit:               env.getEnvBool("DOCKER_INIT", false),
+		DockerAllowEmulation:     env.getEnvBool("DOCKER_ALLOW_EMULATION", false),
+		DockerPlatform:           env.getEnv("DOCKER_PLATFORM", ""),
+		DockerAutoRemove:         env.getEnvBool("DOCKER_AUTO_REMOVE", false),
+		KeepFailedContainers:     env.getEnvBool("KEEP_FAILED_CONTAINERS", false),
+		CollectStats:             env.getEnvBool("COLLECT_STATS", false),
+		ImagePullPolicy:          env.getEnv("IMAGE_PULL_POLICY", "if-not-present"),
+		CgroupParent:             env.lookup("CGROUP_PARENT"),
+		DefaultCPU:               env.lookup("DEFAULT_CPU"),
+		DefaultMemory:            env.lookup("DEFAULT_MEMORY"),
+		RelaxedNames:             env.getEnvBool("RELAXED_RESOURCE_NAMES", false),
+		LabelRunSource:           env.getEnvBool("LABEL_RUN_SOURCE", true),
+		InjectTaskEnv:            env.getEnvBool("INJECT_TASK_ENV", true),
+		ValidateImages:           env.getEnvBool("VALIDATE_IMAGES_ON_CREATE", false),
+		RejectDeleteWhileRunning: env.getEnvBool("REJECT_DELETE_WHILE_RUNNING", false),
+		ImageCleanup:             env.getEnvBool("ENABLE_IMAGE_CLEANUP", false),
+		DebugDump:                env.getEnvBool("ENABLE_DEBUG_DUMP", false),
+		MetricsExemplars:         env.getEnvBool("METRICS_EXEMPLARS", false),
+		CrashOnExecutorPanic:     env.getEnvBool("CRASH_ON_EXECUTOR_PANIC", false),
+		Scheduler:                env.getEnv("SCHEDULER", "fifo"),
+		EnableSchedules:          env.getEnvBool("ENABLE_SCHEDULES", false),
+		StateFile:                env.lookup("STATE_FILE"),
+		StateFileCleanupOnExit:   env.getEnvBool("STATE_FILE_CLEANUP_ON_EXIT", false),
+		APIKey:                   env.lookup("API_KEY"),
+		CompletionWebhookURL:     env.lookup("COMPLETION_WEBHOOK_URL"),
+	}
+
+	// None of these counts and limits makes sense negative.
+	for _, n := range []struct {
+		dst      *int
+		key      string
+		fallback int
+	}{
+		{&cfg.MaxConcurrentPulls, "MAX_CONCURRENT_PULLS", 0},
+		{&cfg.WarmPoolSize, "WARM_POOL_SIZE", 0},
+		{&cfg.DefaultTaskCount, "DEFAULT_TASK_COUNT", 1},
+		{&cfg.DefaultParallelism, "DEFAULT_PARALLELISM", 1},
+		{&cfg.MaxLogLines, "MAX_LOG_LINES", 1000},
+		{&cfg.MaxLogBytes, "MAX_LOG_BYTES", 1 << 20},
+		{&cfg.MaxConcurrentExecutions, "MAX_CONCURRENT_EXECUTIONS", 0},
+		{&cfg.MaxExecutionsPerJob, "MAX_EXECUTIONS_PER_JOB", 0},
+	} {
+		if *n.dst, err = env.getEnvInt(n.key, n.fallback); err != nil {
+			return nil, err
+		}
+		if *n.dst < 0 {
+			return nil, fmt.Errorf("invalid %s %d: must not be negative", n.key, *n.dst)
+		}
+	}
+	if cfg.RunJobSyncWait, err = env.getEnvDuration("RUN_JOB_SYNC_WAIT", 0); err != nil {
+		return nil, err
+	}
+	if cfg.RequestTimeout, err = env.getEnvDuration("REQUEST_TIMEOUT", 0); err != nil {
+		return nil, err
+	}
+	if cfg.OperationRetention, err = env.getEnvDuration("OPERATION_RETENTION", 0); err != nil {
+		return nil, err
+	}
+	if cfg.ExecutionTTL, err = env.getEnvDuration("EXECUTION_TTL", 0); err != nil {
+		return nil, err
+	}
+	if cfg.LogDrainTimeout, err = env.getEnvDuration("LOG_DRAIN_TIMEOUT", 5*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.Secrets, err = loadSecrets(env.lookup("SECRETS_FILE"), env.lookup("SECRETS")); err != nil {
+		return nil, err
+	}
+	if path, ok := strings.CutPrefix(cfg.Port, "unix://"); ok && path == "" {
+		return nil, fmt.Errorf("invalid PORT %q: a unix:// address needs a socket path", cfg.Port)
+	}
+	if cfg.CompletionWebhookURL != "" {
+		if u, err := url.Parse(cfg.CompletionWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid COMPLETION_WEBHOOK_URL %q: must be an http or https URL", cfg.CompletionWebhookURL)
+		}
+	}
+	switch cfg.LogFormat {
+	case "text", "json":
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q: must be text or json", cfg.LogFormat)
+	}
+	switch cfg.Scheduler {
+	case "fifo", "fair":
+	default:
+		return nil, fmt.Errorf("invalid SCHEDULER %q: must be fifo or fair", cfg.Scheduler)
+	}
+	if cfg.DefaultTaskCount < 1 {
+		return nil, fmt.Errorf("invalid DEFAULT_TASK_COUNT %d: must be at least 1", cfg.DefaultTaskCount)
+	}
+	if cfg.DefaultParallelism < 1 {
+		return nil, fmt.Errorf("invalid DEFAULT_PARALLELISM %d: must be at least 1", cfg.DefaultParallelism)
+	}
+	switch cfg.ImagePullPolicy {
+	case "always", "if-not-present", "never":
+	default:
+		return nil, fmt.Errorf("invalid IMAGE_PULL_POLICY %q: must be always, if-not-present or never", cfg.ImagePullPolicy)
 	}
 
 	jobs, err := loadJobsConfig(cfg.JobsFile)
 	if err != nil {
-		return nil, fmt.Errorf("loading jobs config: %w", err)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("loading jobs config: %w", err)
+		}
+		if cfg.RequireJobsConfig {
+			return nil, fmt.Errorf("jobs config %s not found and REQUIRE_JOBS_CONFIG is set", cfg.JobsFile)
+		}
+		// No config file is fine - jobs can be created via API
+		cfg.JobsFileMissing = true
+		jobs = &JobsConfig{}
+	}
+	if err := jobs.Validate(cfg.Executor, filepath.Dir(cfg.JobsFile)); err != nil {
+		return nil, fmt.Errorf("invalid jobs config %s:\n%w", cfg.JobsFile, err)
 	}
 	cfg.Jobs = jobs
 
 	return cfg, nil
 }
 
+// networkAliasPattern matches a valid DNS hostname, as accepted by Docker for
+// network aliases.
+var networkAliasPattern = regexp.MustCompile(`^[a-zA-Z0-9]([-a-zA-Z0-9]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([-a-zA-Z0-9]{0,61}[a-zA-Z0-9])?)*$`)
+
+// Validate checks every job definition for problems that would otherwise
+// only surface when the job runs, returning one error listing them all.
+// Images are required for the docker executor and commands for the
+// subprocess executor. Relative paths are resolved against dir, the jobs
+// config directory, and the files they name must exist.
+func (c *JobsConfig) Validate(executorType, dir string) error {
+	var errs []error
+	seen := make(map[string]bool)
+	for i, jd := range c.Jobs {
+		fail := func(format string, args ...any) {
+			label := fmt.Sprintf("jobs[%d]", i)
+			if jd.Name != "" {
+				label += fmt.Sprintf(" (%s)", jd.Name)
+			}
+			errs = append(errs, fmt.Errorf("%s: %s", label, fmt.Sprintf(format, args...)))
+		}
+
+		switch {
+		case jd.Name == "":
+			fail("name is required")
+		case seen[jd.Name]:
+			fail("duplicate job name")
+		}
+		seen[jd.Name] = true
+		if executorType == "docker" && jd.Image == "" {
+			fail("image is required with the docker executor")
+		}
+		if executorType == "subprocess" && len(jd.Command) == 0 {
+			fail("command is required with the subprocess executor")
+		}
+		if jd.TaskCount < 0 {
+			fail("task_count: must not be negative, got %d", jd.TaskCount)
+		}
+		if jd.Parallelism < 0 {
+			fail("parallelism: must not be negative, got %d", jd.Parallelism)
+		}
+		if jd.Timeout != "" {
+			if d, err := time.ParseDuration(jd.Timeout); err != nil || d < 0 {
+				fail("timeout: invalid duration %q", jd.Timeout)
+			}
+		}
+		if jd.StopTimeout != "" {
+			if d, err := time.ParseDuration(jd.StopTimeout); err != nil || d < 0 {
+				fail("stop_timeout: invalid duration %q", jd.StopTimeout)
+			}
+		}
+		if _, err := state.ParseResources(jd.Resources.CPU, jd.Resources.Memory); err != nil {
+			fail("resources: %v", err)
+		}
+		if _, err := state.ParseResources(jd.ExecutionTemplate.Resources.CPU, jd.ExecutionTemplate.Resources.Memory); err != nil {
+			fail("execution_template.resources: %v", err)
+		}
+		if jd.Schedule != "" {
+			if _, err := cron.ParseStandard(jd.Schedule); err != nil {
+				fail("schedule: %v", err)
+			}
+		}
+		if jd.ScheduleJitter != "" {
+			if d, err := time.ParseDuration(jd.ScheduleJitter); err != nil || d < 0 {
+				fail("schedule_jitter: invalid duration %q", jd.ScheduleJitter)
+			}
+		}
+		if jd.Platform != "" {
+			if _, err := executor.ParsePlatform(jd.Platform); err != nil {
+				fail("platform: %v", err)
+			}
+		}
+		for _, alias := range jd.NetworkAliases {
+			if !networkAliasPattern.MatchString(alias) {
+				fail("network_aliases: invalid alias %q", alias)
+			}
+		}
+		if err := validateProbe(jd.StartupProbe); err != nil {
+			fail("startup_probe: %v", err)
+		}
+		if jd.EnvFilePath != "" && (!path.IsAbs(jd.EnvFilePath) || strings.HasSuffix(jd.EnvFilePath, "/")) {
+			fail("env_file_path: must be an absolute file path in the container, got %q", jd.EnvFilePath)
+		}
+		if jd.EnvFile != "" {
+			if _, err := envfile.Read(resolvePath(dir, jd.EnvFile)); err != nil {
+				fail("env_file: %v", err)
+			}
+		}
+		for _, v := range jd.Volumes {
+			if v.HostPath == "" {
+				fail("volumes: host_path is required")
+			} else if _, err := os.Stat(resolvePath(dir, v.HostPath)); err != nil {
+				fail("volumes: %v", err)
+			}
+			if !path.IsAbs(v.ContainerPath) {
+				fail("volumes: container_path must be absolute, got %q", v.ContainerPath)
+			}
+			if jd.ReadOnlyRoot && !v.ReadOnly && path.Clean(v.ContainerPath) == "/" {
+				fail("volumes: a writable volume at / conflicts with read_only_root")
+			}
+		}
+		for _, opt := range jd.SecurityOpt {
+			profile, ok := strings.CutPrefix(opt, "seccomp=")
+			if ok && profile != "unconfined" && profile != "builtin" {
+				if _, err := os.Stat(resolvePath(dir, profile)); err != nil {
+					fail("security_opt: %v", err)
+				}
+			}
+		}
+		if jd.Stdin != nil {
+			switch {
+			case jd.Stdin.File != "" && jd.Stdin.Text != "":
+				fail("stdin: set only one of file or text")
+			case jd.Stdin.File != "":
+				if _, err := os.Stat(resolvePath(dir, jd.Stdin.File)); err != nil {
+					fail("stdin: %v", err)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateProbe checks a startup probe definition, which may be nil.
+func validateProbe(pc *ProbeConfig) error {
+	if pc == nil {
+		return nil
+	}
+	if (len(pc.Command) > 0) == (pc.TCPPort != 0) {
+		return fmt.Errorf("set exactly one of command or tcp_port")
+	}
+	if pc.TCPPort < 0 || pc.TCPPort > 65535 {
+		return fmt.Errorf("invalid tcp_port %d", pc.TCPPort)
+	}
+	if pc.FailureThreshold < 0 {
+		return fmt.Errorf("failure_threshold must not be negative, got %d", pc.FailureThreshold)
+	}
+	if pc.InitialDelay != "" {
+		if d, err := time.ParseDuration(pc.InitialDelay); err != nil || d < 0 {
+			return fmt.Errorf("initial_delay: invalid duration %q", pc.InitialDelay)
+		}
+	}
+	if pc.Period != "" {
+		if d, err := time.ParseDuration(pc.Period); err != nil || d <= 0 {
+			return fmt.Errorf("period: invalid duration %q", pc.Period)
+		}
+	}
+	return nil
+}
+
+// resolvePath resolves p, a path in the jobs config, against dir.
+func resolvePath(dir, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+// loadSecrets reads secret values from a KEY=VALUE file, if path is set, and
+// then from list, a comma-separated list of NAME=value pairs that take
+// precedence.
+func loadSecrets(path, list string) (map[string]string, error) {
+	secrets := make(map[string]string)
+	if path != "" {
+		values, err := envfile.Read(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading SECRETS_FILE: %w", err)
+		}
+		for k, v := range values {
+			secrets[k] = v
+		}
+	}
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid SECRETS entry %q: want NAME=value", pair)
+		}
+		secrets[name] = value
+	}
+	return secrets, nil
+}
+
+// redacted replaces values that may hold secrets in Redacted output.
+const redacted = "[REDACTED]"
+
+// Redacted returns a copy of the config that is safe to attach to bug
+// reports: the API key, secret values, job env values and inline stdin are
+// replaced.
+func (c *Config) Redacted() *Config {
+	out := *c
+	if c.APIKey != "" {
+		out.APIKey = redacted
+	}
+	if c.Secrets != nil {
+		out.Secrets = make(map[string]string, len(c.Secrets))
+		for k := range c.Secrets {
+			out.Secrets[k] = redacted
+		}
+	}
+	if c.Jobs != nil {
+		out.Jobs = &JobsConfig{Jobs: make([]JobDefinition, len(c.Jobs.Jobs))}
+		for i, jd := range c.Jobs.Jobs {
+			if jd.Env != nil {
+				env := make(map[string]string, len(jd.Env))
+				for k := range jd.Env {
+					env[k] = redacted
+				}
+				jd.Env = env
+			}
+			if jd.Stdin != nil && jd.Stdin.Text != "" {
+				stdin := *jd.Stdin
+				stdin.Text = redacted
+				jd.Stdin = &stdin
+			}
+			out.Jobs.Jobs[i] = jd
+		}
+	}
+	return &out
+}
+
 func loadJobsConfig(path string) (*JobsConfig, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// No config file is fine - jobs can be created via API
-			return &JobsConfig{}, nil
-		}
 		return nil, fmt.Errorf("reading %s: %w", path, err)
 	}
 
@@ -79,17 +604,40 @@ func loadJobsConfig(path string) (*JobsConfig, error) {
 	return &cfg, nil
 }
 
-func getEnv(key, fallback string) string {
-	if v := os.Getenv(key); v != "" {
+// environment resolves configuration values, preferring entries from the
+// optional SETTINGS_FILE over the process environment. Because the file is
+// re-read on every Load, settings placed there can change on reload.
+type environment map[string]string
+
+func loadEnvironment(path string) (environment, error) {
+	if path == "" {
+		return environment{}, nil
+	}
+	values, err := envfile.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	return environment(values), nil
+}
+
+func (e environment) lookup(key string) string {
+	if v, ok := e[key]; ok {
+		return v
+	}
+	return os.Getenv(key)
+}
+
+func (e environment) getEnv(key, fallback string) string {
+	if v := e.lookup(key); v != "" {
 		return v
 	}
 	return fallback
 }
 
-// parseExtraHosts splits a comma-separated list of host:ip mappings (e.g.
-// "host.docker.internal:host-gateway,other:1.2.3.4") into a string slice
-// suitable for Docker's HostConfig.ExtraHosts.
-func parseExtraHosts(val string) []string {
+// parseList splits a comma-separated list, such as the host:ip mappings
+// "host.docker.internal:host-gateway,other:1.2.3.4", dropping blank
+// entries.
+func parseList(val string) []string {
 	if val == "" {
 		return nil
 	}
@@ -104,8 +652,8 @@ func parseExtraHosts(val string) []string {
 	return hosts
 }
 
-func getEnvBool(key string, fallback bool) bool {
-	v := os.Getenv(key)
+func (e environment) getEnvBool(key string, fallback bool) bool {
+	v := e.lookup(key)
 	if v == "" {
 		return fallback
 	}
@@ -116,3 +664,27 @@ func getEnvBool(key string, fallback bool) bool {
 		return false
 	}
 }
+
+func (e environment) getEnvInt(key string, fallback int) (int, error) {
+	v := e.lookup(key)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return n, nil
+}
+
+func (e environment) getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	v := e.lookup(key)
+	if v == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
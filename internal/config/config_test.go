@@ -0,0 +1,200 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadMissingJobsConfig(t *testing.T) {
+	t.Setenv("JOBS_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("expected missing jobs config to be allowed, got %v", err)
+	}
+	if !cfg.JobsFileMissing {
+		t.Error("expected JobsFileMissing to be set")
+	}
+	if len(cfg.Jobs.Jobs) != 0 {
+		t.Errorf("expected no jobs, got %d", len(cfg.Jobs.Jobs))
+	}
+
+	t.Setenv("REQUIRE_JOBS_CONFIG", "true")
+	if _, err := Load(); err == nil {
+		t.Error("expected error when REQUIRE_JOBS_CONFIG is set and the file is missing")
+	}
+}
+
+func TestLoadJobsConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.yaml")
+	if err := os.WriteFile(path, []byte("jobs:\n  - name: hello\n    image: alpine\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JOBS_CONFIG", path)
+	t.Setenv("REQUIRE_JOBS_CONFIG", "true")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.JobsFileMissing {
+		t.Error("expected JobsFileMissing to be unset")
+	}
+	if len(cfg.Jobs.Jobs) != 1 || cfg.Jobs.Jobs[0].Name != "hello" {
+		t.Errorf("unexpected jobs: %+v", cfg.Jobs.Jobs)
+	}
+}
+
+func TestLoadValidatesJobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.yaml")
+	yaml := `jobs:
+  - name: ok
+    image: alpine
+  - name: no-image
+  - image: alpine
+  - name: ok
+    image: alpine
+  - name: slow
+    image: alpine
+    timeout: forever
+  - name: greedy
+    image: alpine
+    resources:
+      cpu: lots
+  - name: stubborn
+    image: alpine
+    stop_timeout: -5s
+  - name: never
+    image: alpine
+    schedule: every tuesday
+    schedule_jitter: a bit
+  - name: exotic
+    image: alpine
+    platform: amd64
+    network_aliases: [bad_alias]
+  - name: unready
+    image: alpine
+    startup_probe:
+      tcp_port: 8080
+      period: 0s
+  - name: misplaced
+    image: alpine
+    env_file_path: relative.env
+    env_file: missing.env
+  - name: mounted
+    image: alpine
+    volumes:
+      - host_path: missing
+        container_path: data
+  - name: confined
+    image: alpine
+    security_opt: [seccomp=missing.json]
+    stdin:
+      file: input.txt
+      text: hello
+  - name: negative
+    image: alpine
+    task_count: -1
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JOBS_CONFIG", path)
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("expected invalid jobs to fail the load")
+	}
+	for _, want := range []string{
+		"jobs[1] (no-image): image is required",
+		"jobs[2]: name is required",
+		"jobs[3] (ok): duplicate job name",
+		`jobs[4] (slow): timeout: invalid duration "forever"`,
+		"jobs[5] (greedy): resources:",
+		`jobs[6] (stubborn): stop_timeout: invalid duration "-5s"`,
+		"jobs[7] (never): schedule:",
+		`jobs[7] (never): schedule_jitter: invalid duration "a bit"`,
+		"jobs[8] (exotic): platform:",
+		`jobs[8] (exotic): network_aliases: invalid alias "bad_alias"`,
+		`jobs[9] (unready): startup_probe: period: invalid duration "0s"`,
+		"jobs[10] (misplaced): env_file_path: must be an absolute file path",
+		"jobs[10] (misplaced): env_file:",
+		"jobs[11] (mounted): volumes: stat " + filepath.Join(filepath.Dir(path), "missing"),
+		`jobs[11] (mounted): volumes: container_path must be absolute, got "data"`,
+		"jobs[12] (confined): security_opt: stat " + filepath.Join(filepath.Dir(path), "missing.json"),
+		"jobs[12] (confined): stdin: set only one of file or text",
+		"jobs[13] (negative): task_count: must not be negative",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected the error to report %q, got:\n%v", want, err)
+		}
+	}
+
+	t.Setenv("EXECUTOR", "subprocess")
+	if err := os.WriteFile(path, []byte("jobs:\n  - name: no-command\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "command is required") {
+		t.Errorf("expected a missing command to fail with the subprocess executor, got %v", err)
+	}
+}
+
+func TestLoadRejectsInvalidNumbers(t *testing.T) {
+	t.Setenv("JOBS_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+
+	for _, tc := range []struct {
+		key, value, want string
+	}{
+		{"MAX_LOG_LINES", "lots", "invalid MAX_LOG_LINES: "},
+		{"MAX_CONCURRENT_PULLS", "-1", "invalid MAX_CONCURRENT_PULLS -1: must not be negative"},
+		{"MAX_CONCURRENT_EXECUTIONS", "-2", "invalid MAX_CONCURRENT_EXECUTIONS -2: must not be negative"},
+		{"WARM_POOL_SIZE", "-1", "invalid WARM_POOL_SIZE -1: must not be negative"},
+		{"DEFAULT_TASK_COUNT", "0", "invalid DEFAULT_TASK_COUNT 0: must be at least 1"},
+	} {
+		t.Run(tc.key, func(t *testing.T) {
+			t.Setenv(tc.key, tc.value)
+			if _, err := Load(); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected %s=%s to fail with %q, got %v", tc.key, tc.value, tc.want, err)
+			}
+		})
+	}
+
+	t.Setenv("MAX_LOG_LINES", "0")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("expected MAX_LOG_LINES=0 to mean unbounded, got %v", err)
+	}
+	if cfg.MaxLogLines != 0 {
+		t.Errorf("expected MAX_LOG_LINES 0, got %d", cfg.MaxLogLines)
+	}
+}
+
+func TestLoadSecrets(t *testing.T) {
+	t.Setenv("JOBS_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	path := filepath.Join(t.TempDir(), "secrets.env")
+	if err := os.WriteFile(path, []byte("db-password=from-file\napi-key=abc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SECRETS_FILE", path)
+	t.Setenv("SECRETS", "db-password=hunter2, token=a=b")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	want := map[string]string{"db-password": "hunter2", "api-key": "abc", "token": "a=b"}
+	if !maps.Equal(cfg.Secrets, want) {
+		t.Errorf("secrets = %v, want %v", cfg.Secrets, want)
+	}
+	if v := cfg.Redacted().Secrets["db-password"]; v != redacted {
+		t.Errorf("expected redacted secret, got %q", v)
+	}
+
+	t.Setenv("SECRETS", "no-value")
+	if _, err := Load(); err == nil {
+		t.Error("expected an error for a SECRETS entry without a value")
+	}
+}
@@ -0,0 +1,44 @@
+package envfile_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/envfile"
+)
+
+func TestParse(t *testing.T) {
+	env, err := envfile.Parse(strings.NewReader(`
+# comment
+FOO=bar
+export BAZ = qux
+QUOTED="hello world"
+SINGLE='x=y'
+EMPTY=
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"FOO":    "bar",
+		"BAZ":    "qux",
+		"QUOTED": "hello world",
+		"SINGLE": "x=y",
+		"EMPTY":  "",
+	}
+	if len(env) != len(want) {
+		t.Errorf("expected %d vars, got %v", len(want), env)
+	}
+	for k, v := range want {
+		if env[k] != v {
+			t.Errorf("%s = %q, want %q", k, env[k], v)
+		}
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	if _, err := envfile.Parse(strings.NewReader("NOT_A_PAIR\n")); err == nil {
+		t.Error("expected error for line without '='")
+	}
+}
@@ -1,24 +1,73 @@
 package executor
 
 import (
+	"archive/tar"
 	"bytes"
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+// dockerClient is the subset of the Docker API used by DockerExecutor. It is
+// satisfied by *client.Client and lets tests substitute a fake daemon.
+type dockerClient interface {
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error)
+	ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error
+	ContainerWait(ctx context.Context, containerID string, condition container.WaitCondition) (<-chan container.WaitResponse, <-chan error)
+	ContainerAttach(ctx context.Context, containerID string, options container.AttachOptions) (types.HijackedResponse, error)
+	ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error)
+	ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error
+	ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
+	ContainerStatsOneShot(ctx context.Context, containerID string) (container.StatsResponseReader, error)
+	ContainerExecCreate(ctx context.Context, container string, options container.ExecOptions) (types.IDResponse, error)
+	ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (types.HijackedResponse, error)
+	ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error)
+	CopyToContainer(ctx context.Context, containerID, dstPath string, content io.Reader, options container.CopyToContainerOptions) error
+	ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error)
+	ImagePull(ctx context.Context, refStr string, options image.PullOptions) (io.ReadCloser, error)
+	ImageRemove(ctx context.Context, imageID string, options image.RemoveOptions) ([]image.DeleteResponse, error)
+	DistributionInspect(ctx context.Context, imageRef, encodedRegistryAuth string) (registry.DistributionInspect, error)
+	Ping(ctx context.Context) (types.Ping, error)
+	ServerVersion(ctx context.Context) (types.Version, error)
+}
+
+// cpuPeriod is the CFS scheduler period, in microseconds, used when limiting
+// container CPU.
+const cpuPeriod = 100000
+
 // DockerExecutorOpts configures the Docker executor.
 type DockerExecutorOpts struct {
 	// ForwardLogs streams container stdout/stderr to the emulator logger when true.
 	ForwardLogs bool
+	// LogTimestamps requests Docker's timestamps on forwarded log lines and
+	// attaches them to each line as the container_time attribute.
+	LogTimestamps bool
 	// Network is the Docker network to attach spawned containers to.
 	// "auto" (default) will attempt to detect the network of the emulator's own
 	// container. "host" uses host networking. Any other value is treated as a
@@ -28,17 +77,123 @@ type DockerExecutorOpts struct {
 	// (equivalent to docker run --add-host). Useful for e.g.
 	// "host.docker.internal:host-gateway" so containers can reach the Docker host.
 	ExtraHosts []string
+	// DNS and DNSSearch set spawned containers' DNS servers and search
+	// domains (equivalent to docker run --dns and --dns-search), e.g. to
+	// resolve hostnames on a development network. Empty uses Docker's
+	// defaults.
+	DNS       []string
+	DNSSearch []string
 	// GPU enables GPU passthrough for spawned containers (equivalent to
 	// docker run --gpus all). Requires the NVIDIA Container Toolkit on the host.
 	GPU bool
+	// Init runs an init process as PID 1 in spawned containers (equivalent
+	// to docker run --init), which forwards signals and reaps zombie
+	// processes left by jobs that spawn children.
+	Init bool
+	// MaxConcurrentPulls caps how many image pulls may run at once across all
+	// executions. Zero means unlimited.
+	MaxConcurrentPulls int
+	// CgroupParent places every spawned container under this cgroup, so
+	// their total usage can be capped externally. Either an absolute
+	// cgroupfs path ("/emulator-jobs") or a systemd slice
+	// ("emulator-jobs.slice").
+	CgroupParent string
+	// WarmPoolSize is how many containers to keep created ahead of time for
+	// each distinct job configuration, cutting per-run latency for repeated
+	// runs. Zero disables the pool. Pooled containers don't get per-execution
+	// settings, such as CLOUD_RUN_EXECUTION.
+	WarmPoolSize int
+	// LogDrainTimeout bounds how long a finished container is kept while
+	// its remaining logs are read. Defaults to defaultLogDrainTimeout.
+	LogDrainTimeout time.Duration
+	// AllowEmulation runs images built for a different CPU architecture than
+	// the Docker host, relying on emulation (e.g. qemu binfmt handlers). By
+	// default such runs fail up front with an architecture mismatch.
+	AllowEmulation bool
+	// ImagePullPolicy is when job images are pulled: PullAlways,
+	// PullIfNotPresent (the default) or PullNever.
+	ImagePullPolicy string
+	// AutoRemove has Docker remove containers as soon as they exit, instead
+	// of the executor removing them once their logs are drained and their
+	// exit state inspected. Exited containers can't then be inspected, so
+	// OOM kills go unreported, and output written just before exit may be
+	// lost.
+	AutoRemove bool
+	// KeepFailedContainers leaves the containers of failed attempts in
+	// place, so they can be inspected with docker inspect and docker logs,
+	// rather than removing them. They are recorded in the execution's
+	// KeptContainerIDs. Overrides AutoRemove.
+	KeepFailedContainers bool
+	// CollectStats samples each running container's resource usage every
+	// few seconds, recording the execution's peak memory and CPU time.
+	CollectStats bool
+	// Platform is the default os/arch[/variant] images are pulled and run
+	// as, for jobs that don't set their own, e.g. linux/amd64. Empty lets
+	// Docker choose the host's.
+	Platform string
 }
 
+// Image pull policies, like Kubernetes' imagePullPolicy.
+const (
+	// PullAlways pulls the image before every run, picking up new pushes
+	// to mutable tags.
+	PullAlways = "always"
+	// PullIfNotPresent pulls the image only if the Docker host lacks it.
+	PullIfNotPresent = "if-not-present"
+	// PullNever never pulls, failing runs whose image is missing, for
+	// locally built images or offline use.
+	PullNever = "never"
+)
+
+// defaultLogDrainTimeout is used when DockerExecutorOpts.LogDrainTimeout is
+// unset.
+const defaultLogDrainTimeout = 5 * time.Second
+
 type DockerExecutor struct {
-	client      *client.Client
-	forwardLogs bool
-	network     string // resolved network name (empty means host mode)
-	extraHosts  []string
-	gpu         bool
+	client        dockerClient
+	forwardLogs   bool
+	logTimestamps bool
+	network       string // resolved network name (empty means host mode)
+	extraHosts    []string
+	dns           []string
+	dnsSearch     []string
+	gpu           bool
+	init          bool
+	pullSlots     chan struct{} // semaphore for image pulls; nil means unlimited
+	cgroupParent  string
+	pool          *warmPool // nil when disabled
+	// logDrainTimeout bounds the wait for log streaming after a container
+	// exits. Zero means defaultLogDrainTimeout.
+	logDrainTimeout time.Duration
+	collectStats    bool
+	// statsInterval is how often usage is sampled with collectStats. Zero
+	// means defaultStatsInterval.
+	statsInterval time.Duration
+	// allowEmulation runs images whose architecture differs from the host's.
+	allowEmulation bool
+	// platform is the default platform for jobs without one; nil lets
+	// Docker choose.
+	platform *ocispec.Platform
+	// pullPolicy is one of the Pull* policies. Empty means PullIfNotPresent.
+	pullPolicy string
+	// autoRemove leaves removing exited containers to Docker.
+	autoRemove bool
+	// keepFailed leaves the containers of failed attempts in place.
+	keepFailed bool
+
+	hostArchOnce sync.Once
+	hostArch     string // the Docker host's architecture; empty if unknown
+
+	mu     sync.Mutex
+	pulled map[string]struct{}   // image refs pulled by this executor
+	runs   map[string]*dockerRun // by execution name
+}
+
+// dockerRun tracks an execution being run, so it can be cancelled.
+type dockerRun struct {
+	mu          sync.Mutex
+	cancelled   bool
+	containerID string // the started container of the running attempt, if any
 }
 
 func NewDockerExecutor(opts DockerExecutorOpts) (*DockerExecutor, error) {
@@ -47,13 +202,91 @@ func NewDockerExecutor(opts DockerExecutorOpts) (*DockerExecutor, error) {
 		return nil, fmt.Errorf("creating docker client: %w", err)
 	}
 
+	if err := validateCgroupParent(opts.CgroupParent); err != nil {
+		return nil, err
+	}
+	var platform *ocispec.Platform
+	if opts.Platform != "" {
+		if platform, err = ParsePlatform(opts.Platform); err != nil {
+			return nil, err
+		}
+	}
+	switch opts.ImagePullPolicy {
+	case "", PullAlways, PullIfNotPresent, PullNever:
+	default:
+		return nil, fmt.Errorf("invalid image pull policy %q: want %s, %s or %s", opts.ImagePullPolicy, PullAlways, PullIfNotPresent, PullNever)
+	}
+
 	netName := resolveNetwork(cli, opts.Network)
 
-	return &DockerExecutor{client: cli, forwardLogs: opts.ForwardLogs, network: netName, extraHosts: opts.ExtraHosts, gpu: opts.GPU}, nil
+	e := &DockerExecutor{client: cli, forwardLogs: opts.ForwardLogs, logTimestamps: opts.LogTimestamps, network: netName, extraHosts: opts.ExtraHosts, dns: opts.DNS, dnsSearch: opts.DNSSearch, gpu: opts.GPU, init: opts.Init}
+	if opts.MaxConcurrentPulls > 0 {
+		e.pullSlots = make(chan struct{}, opts.MaxConcurrentPulls)
+	}
+	e.logDrainTimeout = opts.LogDrainTimeout
+	e.cgroupParent = opts.CgroupParent
+	e.allowEmulation = opts.AllowEmulation
+	e.pullPolicy = opts.ImagePullPolicy
+	e.autoRemove = opts.AutoRemove
+	e.keepFailed = opts.KeepFailedContainers
+	e.collectStats = opts.CollectStats
+	e.platform = platform
+	if e.keepFailed && e.autoRemove {
+		slog.Warn("ignoring DOCKER_AUTO_REMOVE: KEEP_FAILED_CONTAINERS needs failed containers to outlive their exit")
+		e.autoRemove = false
+	}
+	if opts.WarmPoolSize > 0 {
+		e.pool = newWarmPool(opts.WarmPoolSize)
+		slog.Warn("WARM_POOL_SIZE is set: containers are created before the execution that runs them, so CLOUD_RUN_EXECUTION and the execution and start-time container labels are not set")
+	}
+	return e, nil
+}
+
+// validateCgroupParent checks that parent is empty, an absolute cgroupfs path,
+// or a systemd slice name.
+func validateCgroupParent(parent string) error {
+	if parent == "" {
+		return nil
+	}
+	switch {
+	case strings.HasSuffix(parent, ".slice") && !strings.Contains(parent, "/"):
+		return nil
+	case strings.HasPrefix(parent, "/") && path.Clean(parent) == parent && parent != "/":
+		return nil
+	default:
+		return fmt.Errorf("invalid cgroup parent %q: want an absolute path like /emulator-jobs or a systemd slice like emulator-jobs.slice", parent)
+	}
+}
+
+// resolveSecurityOpt returns opts in the form the Docker API expects. Like
+// the docker CLI, seccomp profiles given as file paths are replaced by the
+// profile's contents; "unconfined" and "builtin" pass through.
+func resolveSecurityOpt(opts []string) ([]string, error) {
+	if len(opts) == 0 {
+		return nil, nil
+	}
+	resolved := make([]string, 0, len(opts))
+	for _, opt := range opts {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok || key != "seccomp" || value == "unconfined" || value == "builtin" {
+			resolved = append(resolved, opt)
+			continue
+		}
+		profile, err := os.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("reading seccomp profile: %w", err)
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, profile); err != nil {
+			return nil, fmt.Errorf("parsing seccomp profile %s: %w", value, err)
+		}
+		resolved = append(resolved, "seccomp="+compact.String())
+	}
+	return resolved, nil
 }
 
 // resolveNetwork determines which Docker network spawned containers should join.
-func resolveNetwork(cli *client.Client, configured string) string {
+func resolveNetwork(cli dockerClient, configured string) string {
 	switch configured {
 	case "host":
 		return ""
@@ -68,7 +301,7 @@ func resolveNetwork(cli *client.Client, configured string) string {
 // network it belongs to. It uses the hostname (which Docker sets to the
 // container ID by default). Returns "" if detection fails (e.g. not running
 // in Docker).
-func detectOwnNetwork(cli *client.Client) string {
+func detectOwnNetwork(cli dockerClient) string {
 	hostname, err := os.Hostname()
 	if err != nil {
 		slog.Debug("network auto-detect: cannot read hostname", "error", err)
@@ -108,11 +341,23 @@ func detectOwnNetwork(cli *client.Client) string {
 	return fallback
 }
 
-// lineLogWriter buffers writes and logs each complete line to slog.
+// lineLogWriter buffers writes and logs each complete line to slog and the
+// execution's log buffer.
 type lineLogWriter struct {
+	// logger receives each line when non-nil.
 	logger *slog.Logger
-	stream string
-	buf    []byte
+	// capture records each line when non-nil.
+	capture *state.LogBuffer
+	stream  string
+	// task is the index of the task whose output is written.
+	task int
+	buf  []byte
+	// timestamps indicates each line is prefixed with an RFC 3339 timestamp
+	// (as produced by ContainerLogs with Timestamps set), which is logged as
+	// the container_time attribute.
+	timestamps bool
+	// wrote is set once a non-empty line has been written.
+	wrote bool
 }
 
 func (w *lineLogWriter) Write(p []byte) (n int, err error) {
@@ -124,9 +369,7 @@ func (w *lineLogWriter) Write(p []byte) (n int, err error) {
 		}
 		line := string(bytes.TrimSpace(w.buf[:i]))
 		w.buf = w.buf[i+1:]
-		if line != "" {
-			w.logger.Info("container", "stream", w.stream, "line", line)
-		}
+		w.log(line)
 	}
 }
 
@@ -136,8 +379,30 @@ func (w *lineLogWriter) Flush() {
 	}
 	line := string(bytes.TrimSpace(w.buf))
 	w.buf = w.buf[:0]
+	w.log(line)
+}
+
+func (w *lineLogWriter) log(line string) {
+	if w.timestamps {
+		if ts, rest, ok := strings.Cut(line, " "); ok {
+			if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+				if rest = strings.TrimSpace(rest); rest != "" {
+					w.wrote = true
+					w.capture.Append(state.LogLine{Time: t, Stream: w.stream, Task: w.task, Text: rest})
+					if w.logger != nil {
+						w.logger.Info("container", "stream", w.stream, "line", rest, "container_time", t)
+					}
+				}
+				return
+			}
+		}
+	}
 	if line != "" {
-		w.logger.Info("container", "stream", w.stream, "line", line)
+		w.wrote = true
+		w.capture.Append(state.LogLine{Time: time.Now(), Stream: w.stream, Task: w.task, Text: line})
+		if w.logger != nil {
+			w.logger.Info("container", "stream", w.stream, "line", line)
+		}
 	}
 }
 
@@ -149,11 +414,412 @@ func (e *DockerExecutor) Run(exec *state.Execution, env map[string]string) {
 	for k, v := range env {
 		envSlice = append(envSlice, fmt.Sprintf("%s=%s", k, v))
 	}
+	// A stable order lets identical runs share warm containers.
+	sort.Strings(envSlice)
+
+	platform, err := e.platformFor(exec.Job)
+	if err != nil {
+		logger.Error("invalid job platform", "error", err)
+		failExecution(exec, err.Error())
+		return
+	}
+	if err := e.ensureImage(ctx, exec.Job.Image, platform, logger); err != nil {
+		logger.Error("failed to pull image", "error", err)
+		failExecution(exec, err.Error())
+		return
+	}
+	if err := e.checkArchitecture(ctx, exec.Job.Image, platform, logger); err != nil {
+		logger.Error("image cannot run on this host", "error", err)
+		failExecution(exec, err.Error())
+		return
+	}
+	for _, sidecar := range exec.Job.Sidecars {
+		if err := e.ensureImage(ctx, sidecar.Image, platform, logger); err != nil {
+			logger.Error("failed to pull sidecar image", "sidecar", sidecar.Name, "error", err)
+			failExecution(exec, fmt.Sprintf("sidecar %s: %v", sidecar.Name, err))
+			return
+		}
+	}
+	if len(exec.Job.DependsOn) > 0 {
+		logger.Warn("the main container starts before the sidecars it depends on, since they join its network namespace", "depends_on", exec.Job.DependsOn)
+	}
+
+	run := e.track(exec.Name)
+	defer e.untrack(exec.Name)
+
+	// Tasks run one at a time, whatever the execution's reported
+	// parallelism.
+	tasks := int(exec.Tasks())
+	for task := range tasks {
+		taskLogger := logger
+		if tasks > 1 {
+			taskLogger = logger.With("task", task)
+		}
+		if !e.runTask(ctx, exec, run, task, envSlice, taskLogger) || run.isCancelled() {
+			// CancelExecution already recorded the outcome; don't start the
+			// remaining tasks.
+			return
+		}
+	}
+
+	finishExecution(exec)
+}
+
+// runTask runs one task of exec to completion, retrying failed attempts as
+// the job allows, and counts it as succeeded or failed. It returns false if
+// the execution was cancelled.
+func (e *DockerExecutor) runTask(ctx context.Context, exec *state.Execution, run *dockerRun, task int, envSlice []string, logger *slog.Logger) bool {
+	startTask(exec, task)
+	for attempt := 0; ; attempt++ {
+		result, err := e.runContainer(ctx, exec, run, task, attempt, append(slices.Clip(envSlice), e.taskEnv(exec, task, attempt)...), logger)
+		if errors.Is(err, errRunCancelled) || run.isCancelled() {
+			logger.Info("container stopped after cancellation")
+			return false
+		}
+		if err != nil {
+			logger.Error("container run failed", "error", err)
+			failTask(exec, task, "", err.Error())
+			return true
+		}
+
+		// A container stopped for timing out or failing its probe exits with
+		// the stop signal's status, not its own, as the subprocess executor
+		// reports it.
+		stopped := result.timedOut || result.probeFailed
+		if !stopped {
+			recordTaskExitCode(exec, task, result.exitCode)
+		}
+		stderrFailed := exec.Job.FailOnStderr && (result.wroteStderr || result.stderrUnknown)
+		if result.succeeded(exec.Job) {
+			logger.Info("container completed successfully", "exit_code", result.exitCode)
+			succeedTask(exec, task)
+			return true
+		}
+
+		if attempt < exec.Job.MaxRetries && exec.Job.IsRetryableExitCode(result.exitCode) {
+			logger.Warn("container failed, retrying", "exit_code", result.exitCode, "oom_killed", result.oomKilled, "timed_out", result.timedOut, "retry", attempt+1, "max_retries", exec.Job.MaxRetries)
+			retryTask(exec, task)
+			continue
+		}
+
+		switch {
+		case result.probeFailed:
+			failTask(exec, task, state.ReasonStartupProbeFailed, "startup probe failed")
+		case result.timedOut:
+			failTask(exec, task, state.ReasonTimedOut, fmt.Sprintf("task timed out after %s", exec.TaskTimeout()))
+		case result.oomKilled:
+			logger.Warn("container was OOM-killed", "exit_code", result.exitCode)
+			failTask(exec, task, state.ReasonOOMKilled, fmt.Sprintf("OOMKilled: container exceeded its memory limit (exit code %d)", result.exitCode))
+		case stderrFailed && exec.Job.IsSuccessExitCode(result.exitCode) && !result.wroteStderr:
+			logger.Warn("container logs did not drain, so stderr could not be checked", "exit_code", result.exitCode)
+			failTask(exec, task, state.ReasonWroteStderr, fmt.Sprintf("could not check the container's stderr: its logs did not drain in time (exit code %d)", result.exitCode))
+		case stderrFailed && exec.Job.IsSuccessExitCode(result.exitCode):
+			logger.Warn("container wrote to stderr", "exit_code", result.exitCode)
+			failTask(exec, task, state.ReasonWroteStderr, fmt.Sprintf("container wrote to stderr (exit code %d)", result.exitCode))
+		default:
+			logger.Warn("container failed", "exit_code", result.exitCode)
+			failTask(exec, task, "", fmt.Sprintf("container exited with code %d", result.exitCode))
+		}
+		if !stopped {
+			recordExitCode(exec, result.exitCode)
+		}
+		return true
+	}
+}
+
+// checkArchitecture fails if ref was built for a different CPU architecture
+// than the Docker host, unless emulation is allowed, so the run fails with a
+// clear message rather than "exec format error". The check is skipped when
+// either architecture is unknown, and passes when the image is for the
+// job's explicitly requested platform.
+func (e *DockerExecutor) checkArchitecture(ctx context.Context, ref string, platform *ocispec.Platform, logger *slog.Logger) error {
+	e.hostArchOnce.Do(func() {
+		v, err := e.client.ServerVersion(ctx)
+		if err != nil {
+			slog.Warn("cannot determine the Docker host architecture; skipping image architecture checks", "error", err)
+			return
+		}
+		e.hostArch = v.Arch
+	})
+	if e.hostArch == "" {
+		return nil
+	}
+
+	info, _, err := e.client.ImageInspectWithRaw(ctx, ref)
+	if err != nil || info.Architecture == "" || info.Architecture == e.hostArch {
+		return nil
+	}
+	if platform != nil && info.Architecture == platform.Architecture {
+		logger.Info("running image under emulation for its platform", "platform", platformString(platform), "host_arch", e.hostArch)
+		return nil
+	}
+	if e.allowEmulation {
+		logger.Warn("running image under emulation", "image_arch", info.Architecture, "host_arch", e.hostArch)
+		return nil
+	}
+	return fmt.Errorf("architecture mismatch: image %s is built for %s/%s but the Docker host is %s; rebuild the image for %s or enable emulation",
+		ref, info.Os, info.Architecture, e.hostArch, e.hostArch)
+}
+
+// writeEnvFile copies the container's environment into it as a file at dst,
+// before it starts, for images that source an env file. Copying rather than
+// bind-mounting works when the emulator itself runs in a container, and the
+// file goes away with the container.
+func (e *DockerExecutor) writeEnvFile(ctx context.Context, containerID, dst string, envSlice []string) error {
+	content := envFileContent(envSlice)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: path.Base(dst), Mode: 0o644, Size: int64(len(content)), ModTime: time.Now()}); err != nil {
+		return fmt.Errorf("writing env file: %w", err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		return fmt.Errorf("writing env file: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("writing env file: %w", err)
+	}
+	if err := e.client.CopyToContainer(ctx, containerID, path.Dir(dst), &buf, container.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("copying env file to %s: %w", dst, err)
+	}
+	return nil
+}
+
+// envFileContent renders KEY=VALUE pairs as a shell-sourceable file, one
+// variable per line in sorted order with values single-quoted.
+func envFileContent(envSlice []string) []byte {
+	var b bytes.Buffer
+	for _, kv := range slices.Sorted(slices.Values(envSlice)) {
+		k, v, _ := strings.Cut(kv, "=")
+		fmt.Fprintf(&b, "%s='%s'\n", k, strings.ReplaceAll(v, "'", `'\''`))
+	}
+	return b.Bytes()
+}
+
+// taskEnv returns the task metadata env vars for an attempt at task. With a
+// warm pool, CLOUD_RUN_EXECUTION is left out: containers are created before
+// the execution that takes them exists, and can be reused across executions.
+func (e *DockerExecutor) taskEnv(exec *state.Execution, task, attempt int) []string {
+	env := taskEnv(exec, task, attempt)
+	if e.pool != nil {
+		env = slices.DeleteFunc(env, func(kv string) bool { return strings.HasPrefix(kv, "CLOUD_RUN_EXECUTION=") })
+	}
+	return env
+}
+
+// maxHostnameLen is the longest hostname label DNS allows.
+const maxHostnameLen = 63
+
+// hostname returns the container hostname for exec: the job's, or by default
+// the job and execution IDs (e.g. my-job-abc123), cut to a valid DNS label.
+// With a warm pool the default is the job ID alone, since pooled containers
+// are created before the execution that takes them.
+func (e *DockerExecutor) hostname(exec *state.Execution) string {
+	if exec.Job.Hostname != "" {
+		return exec.Job.Hostname
+	}
+	name := path.Base(exec.Job.Name)
+	if e.pool == nil {
+		name += "-" + path.Base(exec.Name)
+	}
+	name = strings.ToLower(name)
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '-'
+	}, name)
+	if len(name) > maxHostnameLen {
+		// Keep the execution ID, which distinguishes runs of the job.
+		name = name[len(name)-maxHostnameLen:]
+	}
+	return strings.Trim(name, "-")
+}
+
+// Labels set on each container, for correlating container stats and events
+// with the execution and attempt it ran.
+const (
+	labelJob         = "cloud-run-jobs-emulator.job"
+	labelExecution   = "cloud-run-jobs-emulator.execution"
+	labelTaskIndex   = "cloud-run-jobs-emulator.task-index"
+	labelTaskAttempt = "cloud-run-jobs-emulator.task-attempt"
+	labelStartTime   = "cloud-run-jobs-emulator.start-time"
+	// labelSidecar is set on sidecar containers, to the sidecar's name.
+	labelSidecar = "cloud-run-jobs-emulator.sidecar"
+)
+
+// containerLabels returns the labels for the container of an attempt at task
+// started at start. With a warm pool the execution and start time are left
+// out: labels are fixed when the container is created, before the execution
+// that takes it exists.
+func (e *DockerExecutor) containerLabels(exec *state.Execution, task, attempt int, start time.Time) map[string]string {
+	labels := map[string]string{
+		labelJob:         exec.Job.Name,
+		labelTaskIndex:   strconv.Itoa(task),
+		labelTaskAttempt: strconv.Itoa(attempt),
+	}
+	if e.pool == nil {
+		labels[labelExecution] = exec.Name
+		labels[labelStartTime] = start.UTC().Format(time.RFC3339)
+	}
+	return labels
+}
+
+// containerResult describes how a container finished.
+type containerResult struct {
+	exitCode int
+	// oomKilled is set when Docker reports the container was killed for
+	// exceeding its memory limit, which is more reliable than exit code 137.
+	oomKilled bool
+	// timedOut is set when the container was stopped for exceeding the
+	// job's timeout.
+	timedOut bool
+	// probeFailed is set when the container was stopped for failing its
+	// startup probe.
+	probeFailed bool
+	// wroteStderr is set when the container wrote to stderr. It is only
+	// tracked for jobs with FailOnStderr.
+	wroteStderr bool
+	// stderrUnknown is set when the logs of a job with FailOnStderr didn't
+	// drain in time, so output written just before exit may have been
+	// missed. It counts as writing to stderr.
+	stderrUnknown bool
+}
+
+// succeeded reports whether the run counts as a success for job.
+func (r containerResult) succeeded(job *state.Job) bool {
+	stderrFailed := job.FailOnStderr && (r.wroteStderr || r.stderrUnknown)
+	return !r.oomKilled && !r.timedOut && !r.probeFailed && !stderrFailed && job.IsSuccessExitCode(r.exitCode)
+}
+
+// pullMessage is a message of an image pull's progress stream.
+type pullMessage struct {
+	Status   string `json:"status"`
+	ID       string `json:"id"`
+	Progress string `json:"progress"`
+	Error    string `json:"error"`
+}
+
+// ensureImage makes sure ref is present on the Docker host according to the
+// pull policy, pulling it if needed, for platform if it isn't nil. Pulls are
+// throttled by the executor's pull semaphore.
+func (e *DockerExecutor) ensureImage(ctx context.Context, ref string, platform *ocispec.Platform, logger *slog.Logger) error {
+	if e.pullPolicy != PullAlways {
+		info, _, err := e.client.ImageInspectWithRaw(ctx, ref)
+		switch {
+		case err == nil && (platform == nil || info.Architecture == "" || info.Architecture == platform.Architecture):
+			return nil
+		case err == nil:
+			// Present, but for another architecture.
+			if e.pullPolicy == PullNever {
+				return fmt.Errorf("image %s is present on the Docker host for %s, not %s, and the image pull policy is %s; pull it for %s first",
+					ref, info.Architecture, platform.Architecture, PullNever, platformString(platform))
+			}
+		case !client.IsErrNotFound(err):
+			return fmt.Errorf("image inspect failed: %w", err)
+		case e.pullPolicy == PullNever:
+			return fmt.Errorf("image %s is not present on the Docker host and the image pull policy is %s; build or pull it first", ref, PullNever)
+		}
+	}
+
+	if e.pullSlots != nil {
+		select {
+		case e.pullSlots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { <-e.pullSlots }()
+	}
+
+	logger.Info("pulling image", "policy", cmp.Or(e.pullPolicy, PullIfNotPresent), "platform", platformString(platform))
+	rc, err := e.client.ImagePull(ctx, ref, image.PullOptions{Platform: platformString(platform)})
+	if err != nil {
+		return fmt.Errorf("pulling image %s: %w", ref, err)
+	}
+	defer rc.Close()
+
+	// The pull only completes once the progress stream is drained. Errors
+	// partway through, such as a missing tag, are reported in the stream.
+	dec := json.NewDecoder(rc)
+	for {
+		var msg pullMessage
+		if err := dec.Decode(&msg); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("pulling image %s: reading progress: %w", ref, err)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("pulling image %s: %s", ref, msg.Error)
+		}
+		logger.Debug("image pull progress", "status", msg.Status, "layer", msg.ID, "progress", msg.Progress)
+	}
+
+	e.mu.Lock()
+	if e.pulled == nil {
+		e.pulled = make(map[string]struct{})
+	}
+	e.pulled[ref] = struct{}{}
+	e.mu.Unlock()
+	return nil
+}
+
+// CleanupImages removes the images this executor pulled. Images still in use
+// by a container fail to remove and are reported with an error.
+func (e *DockerExecutor) CleanupImages(ctx context.Context, dryRun bool) (*ImageCleanupReport, error) {
+	e.mu.Lock()
+	refs := make([]string, 0, len(e.pulled))
+	for ref := range e.pulled {
+		refs = append(refs, ref)
+	}
+	e.mu.Unlock()
+	sort.Strings(refs)
+
+	report := &ImageCleanupReport{DryRun: dryRun, Images: []CleanedImage{}}
+	for _, ref := range refs {
+		info, _, err := e.client.ImageInspectWithRaw(ctx, ref)
+		if err != nil {
+			if client.IsErrNotFound(err) {
+				// Already removed outside the emulator.
+				e.forgetPulled(ref)
+				continue
+			}
+			report.Images = append(report.Images, CleanedImage{Ref: ref, Error: err.Error()})
+			continue
+		}
+
+		img := CleanedImage{Ref: ref, Size: info.Size}
+		if !dryRun {
+			if _, err := e.client.ImageRemove(ctx, ref, image.RemoveOptions{PruneChildren: true}); err != nil {
+				img.Error = err.Error()
+				report.Images = append(report.Images, img)
+				continue
+			}
+			e.forgetPulled(ref)
+			slog.Info("removed image", "image", ref, "size", info.Size)
+		}
+		report.Images = append(report.Images, img)
+		report.BytesReclaimed += info.Size
+	}
+	return report, nil
+}
+
+func (e *DockerExecutor) forgetPulled(ref string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.pulled, ref)
+}
 
+// runContainer creates, starts and waits for a single container for exec,
+// removing it once it exits. It returns how the container finished, or an
+// error if the container could not be run to completion.
+func (e *DockerExecutor) runContainer(ctx context.Context, exec *state.Execution, run *dockerRun, task, attempt int, envSlice []string, logger *slog.Logger) (containerResult, error) {
 	logger.Info("creating container", "network", e.networkDescription())
 
 	hostCfg := &container.HostConfig{
 		ExtraHosts: e.extraHosts,
+		DNS:        e.dns,
+		DNSSearch:  e.dnsSearch,
+		AutoRemove: e.autoRemove,
+		Init:       initFlag(e.init),
 	}
 
 	if e.gpu {
@@ -167,6 +833,35 @@ func (e *DockerExecutor) Run(exec *state.Execution, env map[string]string) {
 		}
 		logger.Info("GPU passthrough enabled for container")
 	}
+	hostCfg.Resources.CgroupParent = e.cgroupParent
+	if exec.Resources.MilliCPU > 0 {
+		// Throttle with an explicit CFS period/quota so the container gets a
+		// hard share of CPU time like Cloud Run's allocated CPU. Docker
+		// rejects NanoCPUs in combination with these, so it is left unset.
+		hostCfg.Resources.CPUPeriod = cpuPeriod
+		hostCfg.Resources.CPUQuota = exec.Resources.MilliCPU * cpuPeriod / 1000
+	}
+	hostCfg.Resources.Memory = exec.Resources.MemoryBytes
+	securityOpt, err := resolveSecurityOpt(exec.Job.SecurityOpt)
+	if err != nil {
+		return containerResult{}, err
+	}
+	hostCfg.SecurityOpt = securityOpt
+	for _, v := range exec.Job.Volumes {
+		hostCfg.Mounts = append(hostCfg.Mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   v.HostPath,
+			Target:   v.ContainerPath,
+			ReadOnly: v.ReadOnly,
+		})
+	}
+	if exec.Job.ReadOnlyRoot {
+		hostCfg.ReadonlyRootfs = true
+		// Most programs need a writable /tmp.
+		if !slices.ContainsFunc(exec.Job.Volumes, func(v state.Volume) bool { return path.Clean(v.ContainerPath) == "/tmp" }) {
+			hostCfg.Tmpfs = map[string]string{"/tmp": ""}
+		}
+	}
 	var netCfg *network.NetworkingConfig
 
 	if e.network != "" {
@@ -175,92 +870,338 @@ func (e *DockerExecutor) Run(exec *state.Execution, env map[string]string) {
 		hostCfg.NetworkMode = container.NetworkMode(e.network)
 		netCfg = &network.NetworkingConfig{
 			EndpointsConfig: map[string]*network.EndpointSettings{
-				e.network: {},
+				e.network: {Aliases: exec.Job.NetworkAliases},
 			},
 		}
 	} else {
 		hostCfg.NetworkMode = "host"
+		if len(exec.Job.NetworkAliases) > 0 {
+			logger.Warn("ignoring network aliases with host networking", "aliases", exec.Job.NetworkAliases)
+		}
 	}
 
-	resp, err := e.client.ContainerCreate(ctx, &container.Config{
-		Image: exec.Job.Image,
-		Cmd:   exec.Job.Command,
-		Env:   envSlice,
-	}, hostCfg, netCfg, nil, "")
+	var stdin io.ReadCloser
+	if exec.Job.Stdin != nil {
+		var err error
+		if stdin, err = exec.Job.Stdin.Open(); err != nil {
+			return containerResult{}, fmt.Errorf("opening stdin: %w", err)
+		}
+		defer stdin.Close()
+	}
+
+	// Run has already rejected an invalid platform.
+	platform, _ := e.platformFor(exec.Job)
+	containerID, err := e.createContainer(ctx, containerSpec{
+		Platform: platform,
+		Config: &container.Config{
+			Image: exec.Job.Image,
+			// Unset, the image's entrypoint and CMD apply.
+			Entrypoint: exec.Job.Command,
+			Cmd:        exec.TaskArgs(),
+			WorkingDir: exec.Job.WorkingDir,
+			Env:        envSlice,
+			Hostname:   e.hostname(exec),
+			Labels:     e.containerLabels(exec, task, attempt, time.Now()),
+			// StdinOnce closes the container's stdin after the attached
+			// client sends EOF, so readers see end of input.
+			AttachStdin: stdin != nil,
+			OpenStdin:   stdin != nil,
+			StdinOnce:   stdin != nil,
+		},
+		Host:    hostCfg,
+		Network: netCfg,
+	}, logger)
 	if err != nil {
-		logger.Error("failed to create container", "error", err)
-		exec.Status = state.StatusFailed
-		exec.ErrorMessage = fmt.Sprintf("container create failed: %v", err)
-		exec.FailedCount = 1
-		exec.CompletionTime = time.Now()
-		return
+		return containerResult{}, fmt.Errorf("container create failed: %w", e.explainGPUError(err, logger))
 	}
 
-	exec.ContainerID = resp.ID
-	logger = logger.With("container_id", resp.ID)
+	exec.Lock()
+	exec.ContainerID = containerID
+	exec.Unlock()
+	logger = logger.With("container_id", containerID)
 
-	logger.Info("starting container")
-	if err := e.client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-		logger.Error("failed to start container", "error", err)
-		exec.Status = state.StatusFailed
-		exec.ErrorMessage = fmt.Sprintf("container start failed: %v", err)
-		exec.FailedCount = 1
-		exec.CompletionTime = time.Now()
-		// Clean up the created container
-		_ = e.client.ContainerRemove(ctx, resp.ID, container.RemoveOptions{})
-		return
+	// Clean up container. Docker only auto-removes containers that ran.
+	started := false
+	succeeded := false
+	defer func() {
+		keep := e.keepFailed && !succeeded && !run.isCancelled()
+		if keep {
+			exec.Lock()
+			exec.KeptContainerIDs = append(exec.KeptContainerIDs, containerID)
+			exec.Unlock()
+		}
+		if keep {
+			logger.Warn("keeping failed container for debugging; remove it with docker rm when done", "container_id", containerID)
+			return
+		}
+		if !started || !e.autoRemove {
+			_ = e.client.ContainerRemove(ctx, containerID, container.RemoveOptions{})
+		}
+	}()
+
+	if exec.Job.EnvFilePath != "" {
+		if err := e.writeEnvFile(ctx, containerID, exec.Job.EnvFilePath, envSlice); err != nil {
+			return containerResult{}, err
+		}
+	}
+
+	if stdin != nil {
+		// Attach before starting so no input is lost to a fast reader.
+		hj, err := e.client.ContainerAttach(ctx, containerID, container.AttachOptions{
+			Stream: true,
+			Stdin:  true,
+		})
+		if err != nil {
+			return containerResult{}, fmt.Errorf("container attach failed: %w", err)
+		}
+		defer hj.Close()
+		go func() {
+			if _, err := io.Copy(hj.Conn, stdin); err != nil {
+				logger.Warn("failed to write container stdin", "error", err)
+			}
+			_ = hj.CloseWrite()
+		}()
+	}
+
+	// An auto-removed container may be gone before it could be waited on
+	// once started, so wait for its removal from the outset.
+	var statusCh <-chan container.WaitResponse
+	var errCh <-chan error
+	if e.autoRemove {
+		statusCh, errCh = e.client.ContainerWait(ctx, containerID, container.WaitConditionRemoved)
 	}
 
-	if e.forwardLogs {
-		go e.streamContainerLogs(ctx, resp.ID, logger)
+	if err := run.start(ctx, e.client, containerID, logger); err != nil {
+		if errors.Is(err, errRunCancelled) {
+			return containerResult{}, err
+		}
+		return containerResult{}, fmt.Errorf("container start failed: %w", e.explainGPUError(err, logger))
 	}
+	defer run.stopped()
+	started = true
 
-	statusCh, errCh := e.client.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
+	if len(exec.Job.Sidecars) > 0 {
+		stopSidecars, err := e.startSidecars(ctx, exec, containerID, task, attempt, logger)
+		defer stopSidecars()
 		if err != nil {
-			logger.Error("error waiting for container", "error", err)
-			exec.Status = state.StatusFailed
-			exec.ErrorMessage = fmt.Sprintf("container wait failed: %v", err)
-			exec.FailedCount = 1
+			if err := e.client.ContainerStop(ctx, containerID, stopOptions(exec.Job)); err != nil {
+				logger.Error("failed to stop container after its sidecars failed to start", "error", err)
+			}
+			return containerResult{}, err
 		}
-	case result := <-statusCh:
-		if result.StatusCode == 0 {
-			logger.Info("container completed successfully")
-			exec.Status = state.StatusSucceeded
-			exec.SucceededCount = 1
-		} else {
-			logger.Warn("container failed", "exit_code", result.StatusCode)
-			exec.Status = state.StatusFailed
-			exec.FailedCount = 1
-			exec.ErrorMessage = fmt.Sprintf("container exited with code %d", result.StatusCode)
+	}
+
+	if e.collectStats {
+		stopSampling := e.sampleUsage(ctx, containerID, logger)
+		defer func() {
+			usage := stopSampling()
+			exec.Lock()
+			exec.PeakMemoryBytes = max(exec.PeakMemoryBytes, usage.peakMemory)
+			exec.CPUTime += usage.cpuTime
+			exec.Unlock()
+			logger.Info("container resource usage", "peak_memory_bytes", usage.peakMemory, "cpu_time", usage.cpuTime)
+		}()
+	}
+
+	var wroteStderr atomic.Bool
+	// drainLogs reports whether the logs were read to the end.
+	drainLogs := func() bool { return true }
+	if e.forwardLogs || exec.Logs != nil || exec.Job.FailOnStderr {
+		logsDone := make(chan struct{})
+		go func() {
+			defer close(logsDone)
+			if e.streamContainerLogs(ctx, containerID, exec.Logs, task, logger) {
+				wroteStderr.Store(true)
+			}
+		}()
+		// Let the streamer drain the container's final output before it is
+		// removed, so the last lines aren't lost.
+		drainLogs = sync.OnceValue(func() bool {
+			timeout := e.logDrainTimeout
+			if timeout <= 0 {
+				timeout = defaultLogDrainTimeout
+			}
+			select {
+			case <-logsDone:
+				return true
+			case <-time.After(timeout):
+				logger.Warn("timed out waiting for container logs to drain", "timeout", timeout)
+				return false
+			}
+		})
+		defer drainLogs()
+	}
+
+	var timedOut atomic.Bool
+	if timeout := exec.TaskTimeout(); timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			timedOut.Store(true)
+			logger.Warn("container timed out, stopping", "timeout", timeout)
+			if err := e.client.ContainerStop(ctx, containerID, stopOptions(exec.Job)); err != nil {
+				logger.Error("failed to stop timed out container", "error", err)
+			}
+		})
+		defer timer.Stop()
+	}
+
+	var probeFailed atomic.Bool
+	// probePassed is closed once the startup probe passes; the execution is
+	// marked running from this goroutine, which owns exec.
+	var probePassed chan struct{}
+	if probe := exec.Job.StartupProbe; probe != nil {
+		probeCtx, stopProbe := context.WithCancel(ctx)
+		defer stopProbe()
+		probePassed = make(chan struct{})
+		go func() {
+			if err := e.runStartupProbe(probeCtx, containerID, probe, logger); err != nil {
+				if probeCtx.Err() != nil {
+					// The container exited first.
+					return
+				}
+				probeFailed.Store(true)
+				logger.Warn("startup probe failed, stopping container", "error", err)
+				if err := e.client.ContainerStop(ctx, containerID, stopOptions(exec.Job)); err != nil {
+					logger.Error("failed to stop container that failed its startup probe", "error", err)
+				}
+				return
+			}
+			close(probePassed)
+		}()
+	}
+
+	if statusCh == nil {
+		statusCh, errCh = e.client.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
+	}
+	var exited *container.WaitResponse
+	for exited == nil {
+		select {
+		case <-probePassed:
+			probePassed = nil
+			markRunning(exec)
+		case err := <-errCh:
+			return containerResult{}, fmt.Errorf("container wait failed: %w", err)
+		case status := <-statusCh:
+			exited = &status
 		}
 	}
 
-	exec.CompletionTime = time.Now()
+	result := containerResult{exitCode: int(exited.StatusCode), timedOut: timedOut.Load(), probeFailed: probeFailed.Load()}
+	if exec.Job.FailOnStderr {
+		result.stderrUnknown = !drainLogs()
+		result.wroteStderr = wroteStderr.Load()
+	}
+	if e.autoRemove {
+		return result, nil
+	}
+	if info, err := e.client.ContainerInspect(ctx, containerID); err != nil {
+		logger.Debug("failed to inspect exited container", "error", err)
+	} else if info.ContainerJSONBase != nil && info.State != nil {
+		result.oomKilled = info.State.OOMKilled
+	}
+	succeeded = result.succeeded(exec.Job)
+	return result, nil
+}
 
-	// Clean up container
-	_ = e.client.ContainerRemove(ctx, resp.ID, container.RemoveOptions{})
+// initFlag returns the HostConfig.Init setting for containers: true when
+// enabled, and otherwise nil, leaving the Docker daemon's default in place.
+func initFlag(enabled bool) *bool {
+	if !enabled {
+		return nil
+	}
+	return &enabled
 }
 
-func (e *DockerExecutor) streamContainerLogs(ctx context.Context, containerID string, logger *slog.Logger) {
+// explainGPUError adds a hint to err, and logs a warning, if it is the Docker
+// daemon rejecting GPU passthrough, which it only reports as a missing device
+// driver.
+func (e *DockerExecutor) explainGPUError(err error, logger *slog.Logger) error {
+	if !e.gpu || !strings.Contains(err.Error(), "could not select device driver") {
+		return err
+	}
+	logger.Warn("Docker daemon rejected the GPU request; is the NVIDIA Container Toolkit installed and configured?", "error", err)
+	return fmt.Errorf("%w (DOCKER_GPU is enabled, but the daemon has no GPU support; install the NVIDIA Container Toolkit or unset DOCKER_GPU)", err)
+}
+
+// createContainer returns a container created from spec, taken from the warm
+// pool when one is available.
+func (e *DockerExecutor) createContainer(ctx context.Context, spec containerSpec, logger *slog.Logger) (string, error) {
+	if e.pool != nil {
+		defer func() { go e.pool.fill(e.client, spec, logger) }()
+		if id, ok := e.pool.take(spec.key()); ok {
+			logger.Info("using warm container", "container_id", id)
+			return id, nil
+		}
+	}
+	resp, err := e.client.ContainerCreate(ctx, spec.Config, spec.Host, spec.Network, spec.Platform, "")
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// Close removes the executor's idle warm containers. Runs in flight are
+// unaffected.
+func (e *DockerExecutor) Close() error {
+	if e.pool != nil {
+		e.pool.drain(context.Background(), e.client)
+	}
+	return nil
+}
+
+// streamContainerLogs follows the container's output into capture, tagged
+// with task, and, when log forwarding is enabled, the emulator's logger. It
+// reports whether the container wrote to stderr.
+func (e *DockerExecutor) streamContainerLogs(ctx context.Context, containerID string, capture *state.LogBuffer, task int, logger *slog.Logger) bool {
 	rc, err := e.client.ContainerLogs(ctx, containerID, container.LogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
 		Follow:     true,
+		Timestamps: e.logTimestamps,
 	})
 	if err != nil {
 		logger.Error("failed to attach container logs", "error", err)
-		return
+		return false
 	}
 	defer rc.Close()
 
-	stdoutWriter := &lineLogWriter{logger: logger, stream: "stdout"}
-	stderrWriter := &lineLogWriter{logger: logger, stream: "stderr"}
+	forward := logger
+	if !e.forwardLogs {
+		forward = nil
+	}
+	wroteStderr, _ := captureOutput(forward, capture, task, e.logTimestamps, func(stdout, stderr io.Writer) error {
+		_, err := stdcopy.StdCopy(stdout, stderr, rc)
+		return err
+	})
+	return wroteStderr
+}
+
+// ValidateImage checks that ref is present locally or can be resolved in its
+// registry. Registries commonly answer unauthorized for repositories that
+// don't exist, so that is reported as not found too.
+func (e *DockerExecutor) ValidateImage(ctx context.Context, ref string) error {
+	_, _, err := e.client.ImageInspectWithRaw(ctx, ref)
+	if err == nil {
+		return nil
+	}
+	if !client.IsErrNotFound(err) {
+		return fmt.Errorf("inspecting image %s: %w", ref, err)
+	}
+
+	if _, err := e.client.DistributionInspect(ctx, ref, ""); err != nil {
+		if client.IsErrNotFound(err) || errdefs.IsUnauthorized(err) {
+			return fmt.Errorf("%w: %s", ErrImageNotFound, ref)
+		}
+		return fmt.Errorf("checking registry for %s: %w", ref, err)
+	}
+	return nil
+}
 
-	_, _ = stdcopy.StdCopy(stdoutWriter, stderrWriter, rc)
-	stdoutWriter.Flush()
-	stderrWriter.Flush()
+// CheckHealth pings the Docker daemon.
+func (e *DockerExecutor) CheckHealth(ctx context.Context) error {
+	if _, err := e.client.Ping(ctx); err != nil {
+		return fmt.Errorf("docker daemon unreachable: %w", err)
+	}
+	return nil
 }
 
 func (e *DockerExecutor) networkDescription() string {
@@ -270,10 +1211,87 @@ func (e *DockerExecutor) networkDescription() string {
 	return e.network
 }
 
+// track registers a run of the named execution for cancellation.
+func (e *DockerExecutor) track(name string) *dockerRun {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.runs == nil {
+		e.runs = make(map[string]*dockerRun)
+	}
+	run := &dockerRun{}
+	e.runs[name] = run
+	return run
+}
+
+func (e *DockerExecutor) untrack(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.runs, name)
+}
+
+// start starts the created container, unless the run was cancelled.
+func (r *dockerRun) start(ctx context.Context, client dockerClient, containerID string, logger *slog.Logger) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancelled {
+		return errRunCancelled
+	}
+	logger.Info("starting container")
+	if err := client.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
+		return err
+	}
+	r.containerID = containerID
+	return nil
+}
+
+// stopped records that the running attempt's container has exited.
+func (r *dockerRun) stopped() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.containerID = ""
+}
+
+func (r *dockerRun) isCancelled() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cancelled
+}
+
+// Cancel stops the execution's running container with the job's stop
+// timeout. No further tasks or attempts are started.
 func (e *DockerExecutor) Cancel(exec *state.Execution) error {
-	if exec.ContainerID == "" {
-		return fmt.Errorf("no container ID for execution %s", exec.Name)
+	e.mu.Lock()
+	run, ok := e.runs[exec.Name]
+	e.mu.Unlock()
+
+	var containerID string
+	if ok {
+		run.mu.Lock()
+		run.cancelled = true
+		containerID = run.containerID
+		run.mu.Unlock()
+		if containerID == "" {
+			// Between attempts; the next won't start.
+			return nil
+		}
+	} else {
+		exec.Lock()
+		containerID = exec.ContainerID
+		exec.Unlock()
+		if containerID == "" {
+			return fmt.Errorf("no container ID for execution %s", exec.Name)
+		}
 	}
 	ctx := context.Background()
-	return e.client.ContainerStop(ctx, exec.ContainerID, container.StopOptions{})
+	return e.client.ContainerStop(ctx, containerID, stopOptions(exec.Job))
+}
+
+// stopOptions stops job's containers with its stop timeout, rounded up to
+// whole seconds, or Docker's default if it has none.
+func stopOptions(job *state.Job) container.StopOptions {
+	if job.StopTimeout == nil {
+		return container.StopOptions{}
+	}
+	secs := int((*job.StopTimeout + time.Second - 1) / time.Second)
+	return container.StopOptions{Timeout: &secs}
 }
@@ -0,0 +1,1480 @@
+package executor
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// fakeDockerClient is an in-memory stand-in for the Docker daemon. Each
+// container exits immediately with the next code from exitCodes; the last
+// code is repeated once the list is exhausted.
+type fakeDockerClient struct {
+	mu        sync.Mutex
+	exitCodes []int64
+	oomKilled bool
+
+	// imagesMissing makes every image absent locally so runs must pull it.
+	imagesMissing bool
+	// pullError is reported in the progress stream of every image pull.
+	pullError     string
+	pullDelay     time.Duration
+	pulls         int
+	activePulls   int
+	maxPulls      int
+	imageSize     int64
+	removedImages []string
+	// pullPlatforms are the platforms requested by image pulls, in order.
+	pullPlatforms []string
+	// registryMissing makes registry lookups of missing images fail.
+	registryMissing bool
+	// imageArch and hostArch are the architectures reported for images and
+	// the daemon. Empty leaves them unknown.
+	imageArch string
+	hostArch  string
+
+	created   []*container.Config
+	hosts     []*container.HostConfig
+	platforms []*ocispec.Platform
+	// copied maps each destination directory to the tar archives copied
+	// there.
+	copied  map[string][][]byte
+	nets    []*network.NetworkingConfig
+	removed []string
+	stopped []string
+	// stopTimeouts are the timeouts ContainerStop was called with, nil for
+	// Docker's default.
+	stopTimeouts []*int
+	// startError is returned by every ContainerStart.
+	startError error
+	// autoRemoved lists containers created with AutoRemove, which the fake
+	// daemon removes itself when they exit.
+	autoRemoved []string
+
+	// logs and stderrLogs are the container output returned by
+	// ContainerLogs, after logDelay, as stdout and stderr.
+	logs       string
+	stderrLogs string
+	logDelay   time.Duration
+
+	// runFor keeps each container running this long, or until stopped,
+	// instead of exiting as soon as it is waited on.
+	runFor time.Duration
+	stop   chan struct{} // closed by ContainerStop when runFor is set
+
+	// probeExitCodes are the exit codes of successive exec'd probe
+	// commands; the last is repeated. onProbe is called before each.
+	probeExitCodes []int
+	probes         int
+	onProbe        func()
+
+	// stdin receives everything written to an attached container's stdin;
+	// stdinDone closes when the client sends EOF.
+	stdin     strings.Builder
+	stdinDone chan struct{}
+
+	// stats are the usage samples returned by successive
+	// ContainerStatsOneShot calls; the last is repeated.
+	stats []container.StatsResponse
+}
+
+// pipeConn adds half-close support to one end of a net.Pipe, as the Docker
+// client's hijacked connection has.
+type pipeConn struct{ net.Conn }
+
+func (c pipeConn) CloseWrite() error { return c.Conn.Close() }
+
+func (f *fakeDockerClient) ContainerAttach(ctx context.Context, containerID string, options container.AttachOptions) (types.HijackedResponse, error) {
+	client, daemon := net.Pipe()
+	f.stdinDone = make(chan struct{})
+	go func() {
+		defer close(f.stdinDone)
+		data, _ := io.ReadAll(daemon)
+		f.mu.Lock()
+		f.stdin.Write(data)
+		f.mu.Unlock()
+	}()
+	return types.NewHijackedResponse(pipeConn{client}, ""), nil
+}
+
+func (f *fakeDockerClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created = append(f.created, config)
+	f.hosts = append(f.hosts, hostConfig)
+	f.platforms = append(f.platforms, platform)
+	f.nets = append(f.nets, networkingConfig)
+	return container.CreateResponse{ID: fmt.Sprintf("container-%d", len(f.created))}, nil
+}
+
+func (f *fakeDockerClient) CopyToContainer(ctx context.Context, containerID, dstPath string, content io.Reader, options container.CopyToContainerOptions) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.copied == nil {
+		f.copied = make(map[string][][]byte)
+	}
+	f.copied[dstPath] = append(f.copied[dstPath], data)
+	return nil
+}
+
+func (f *fakeDockerClient) ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error {
+	return f.startError
+}
+
+func (f *fakeDockerClient) ContainerWait(ctx context.Context, containerID string, condition container.WaitCondition) (<-chan container.WaitResponse, <-chan error) {
+	statusCh := make(chan container.WaitResponse, 1)
+	errCh := make(chan error, 1)
+	if f.stdinDone != nil {
+		<-f.stdinDone
+	}
+	f.mu.Lock()
+	var code int64
+	if len(f.exitCodes) > 0 {
+		code = f.exitCodes[0]
+		if len(f.exitCodes) > 1 {
+			f.exitCodes = f.exitCodes[1:]
+		}
+	}
+	stop := f.stop
+	var n int
+	if _, err := fmt.Sscanf(containerID, "container-%d", &n); err == nil && f.hosts[n-1].AutoRemove {
+		f.autoRemoved = append(f.autoRemoved, containerID)
+	}
+	f.mu.Unlock()
+	if f.runFor > 0 {
+		go func() {
+			select {
+			case <-time.After(f.runFor):
+			case <-stop:
+			}
+			statusCh <- container.WaitResponse{StatusCode: code}
+		}()
+		return statusCh, errCh
+	}
+	statusCh <- container.WaitResponse{StatusCode: code}
+	return statusCh, errCh
+}
+
+func (f *fakeDockerClient) ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		time.Sleep(f.logDelay)
+		_, _ = stdcopy.NewStdWriter(pw, stdcopy.Stdout).Write([]byte(f.logs))
+		if f.stderrLogs != "" {
+			_, _ = stdcopy.NewStdWriter(pw, stdcopy.Stderr).Write([]byte(f.stderrLogs))
+		}
+		pw.Close()
+	}()
+	return pr, nil
+}
+
+func (f *fakeDockerClient) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removed = append(f.removed, containerID)
+	return nil
+}
+
+func (f *fakeDockerClient) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = append(f.stopped, containerID)
+	f.stopTimeouts = append(f.stopTimeouts, options.Timeout)
+	if f.stop != nil {
+		close(f.stop)
+		f.stop = nil
+	}
+	return nil
+}
+
+func (f *fakeDockerClient) ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (types.IDResponse, error) {
+	return types.IDResponse{ID: "exec-" + containerID}, nil
+}
+
+func (f *fakeDockerClient) ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (types.HijackedResponse, error) {
+	client, daemon := net.Pipe()
+	daemon.Close()
+	return types.NewHijackedResponse(client, ""), nil
+}
+
+func (f *fakeDockerClient) ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error) {
+	if f.onProbe != nil {
+		f.onProbe()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.probes++
+	var code int
+	if len(f.probeExitCodes) > 0 {
+		code = f.probeExitCodes[0]
+		if len(f.probeExitCodes) > 1 {
+			f.probeExitCodes = f.probeExitCodes[1:]
+		}
+	}
+	return container.ExecInspect{ExecID: execID, ExitCode: code}, nil
+}
+
+func (f *fakeDockerClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    containerID,
+			State: &types.ContainerState{OOMKilled: f.oomKilled},
+		},
+	}, nil
+}
+
+func (f *fakeDockerClient) ContainerStatsOneShot(ctx context.Context, containerID string) (container.StatsResponseReader, error) {
+	f.mu.Lock()
+	var stats container.StatsResponse
+	if len(f.stats) > 0 {
+		stats = f.stats[0]
+		if len(f.stats) > 1 {
+			f.stats = f.stats[1:]
+		}
+	}
+	f.mu.Unlock()
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return container.StatsResponseReader{}, err
+	}
+	return container.StatsResponseReader{Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (f *fakeDockerClient) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+	if f.imagesMissing {
+		return types.ImageInspect{}, nil, errdefs.NotFound(fmt.Errorf("no such image: %s", imageID))
+	}
+	return types.ImageInspect{ID: imageID, Size: f.imageSize, Os: "linux", Architecture: f.imageArch}, nil, nil
+}
+
+func (f *fakeDockerClient) ImageRemove(ctx context.Context, imageID string, options image.RemoveOptions) ([]image.DeleteResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removedImages = append(f.removedImages, imageID)
+	return []image.DeleteResponse{{Deleted: imageID}}, nil
+}
+
+func (f *fakeDockerClient) DistributionInspect(ctx context.Context, imageRef, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	if f.registryMissing {
+		return registry.DistributionInspect{}, errdefs.Unauthorized(fmt.Errorf("pull access denied for %s", imageRef))
+	}
+	return registry.DistributionInspect{}, nil
+}
+
+func (f *fakeDockerClient) Ping(ctx context.Context) (types.Ping, error) {
+	return types.Ping{}, nil
+}
+
+func (f *fakeDockerClient) ServerVersion(ctx context.Context) (types.Version, error) {
+	return types.Version{Os: "linux", Arch: f.hostArch}, nil
+}
+
+func (f *fakeDockerClient) ImagePull(ctx context.Context, refStr string, options image.PullOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	f.pulls++
+	f.pullPlatforms = append(f.pullPlatforms, options.Platform)
+	f.activePulls++
+	if f.activePulls > f.maxPulls {
+		f.maxPulls = f.activePulls
+	}
+	f.mu.Unlock()
+
+	time.Sleep(f.pullDelay)
+
+	f.mu.Lock()
+	f.activePulls--
+	f.mu.Unlock()
+	if f.pullError != "" {
+		return io.NopCloser(strings.NewReader(`{"status":"Pulling from library/alpine"}` + "\n" + `{"error":"` + f.pullError + `"}`)), nil
+	}
+	return io.NopCloser(strings.NewReader(`{"status":"Downloaded newer image"}`)), nil
+}
+
+func newTestExecution(job *state.Job) *state.Execution {
+	return &state.Execution{
+		Name:   job.Name + "/executions/test",
+		Job:    job,
+		Status: state.StatusRunning,
+	}
+}
+
+func TestDockerRunRunsEveryTask(t *testing.T) {
+	fake := &fakeDockerClient{exitCodes: []int64{0, 1, 0}}
+	e := &DockerExecutor{client: fake}
+	exec := newTestExecution(&state.Job{
+		Name:  "projects/p/locations/l/jobs/fanout",
+		Image: "alpine:latest",
+	})
+	exec.TaskCount = 3
+
+	e.Run(exec, map[string]string{"A": "1"})
+
+	if exec.Status != state.StatusFailed || exec.SucceededCount != 2 || exec.FailedCount != 1 {
+		t.Errorf("expected 2 succeeded and 1 failed task, got %s with %d succeeded, %d failed", exec.Status, exec.SucceededCount, exec.FailedCount)
+	}
+	if exec.ErrorMessage != "task 1: container exited with code 1" {
+		t.Errorf("unexpected error message %q", exec.ErrorMessage)
+	}
+	if len(fake.created) != 3 {
+		t.Fatalf("expected a container per task, got %d", len(fake.created))
+	}
+	for i, cfg := range fake.created {
+		for _, want := range []string{"A=1", fmt.Sprintf("CLOUD_RUN_TASK_INDEX=%d", i), "CLOUD_RUN_TASK_COUNT=3"} {
+			if !slices.Contains(cfg.Env, want) {
+				t.Errorf("task %d: env %v is missing %s", i, cfg.Env, want)
+			}
+		}
+	}
+}
+
+func TestDockerRunCustomSuccessExitCode(t *testing.T) {
+	fake := &fakeDockerClient{exitCodes: []int64{2}}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:             "projects/p/locations/l/jobs/warns",
+		Image:            "alpine:latest",
+		SuccessExitCodes: []int{0, 2},
+	})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusSucceeded {
+		t.Fatalf("expected status SUCCEEDED, got %s (%s)", exec.Status, exec.ErrorMessage)
+	}
+	if exec.SucceededCount != 1 {
+		t.Errorf("expected succeeded count 1, got %d", exec.SucceededCount)
+	}
+}
+
+func TestDockerRunDefaultSuccessExitCode(t *testing.T) {
+	fake := &fakeDockerClient{exitCodes: []int64{2}}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:  "projects/p/locations/l/jobs/fails",
+		Image: "alpine:latest",
+	})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusFailed {
+		t.Fatalf("expected status FAILED, got %s", exec.Status)
+	}
+}
+
+func TestDockerRunRetriesRetryableExitCode(t *testing.T) {
+	fake := &fakeDockerClient{exitCodes: []int64{137, 137, 0}}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:               "projects/p/locations/l/jobs/oom",
+		Image:              "alpine:latest",
+		MaxRetries:         3,
+		RetryableExitCodes: []int{137, 143},
+	})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusSucceeded {
+		t.Fatalf("expected status SUCCEEDED, got %s (%s)", exec.Status, exec.ErrorMessage)
+	}
+	if len(fake.created) != 3 {
+		t.Errorf("expected 3 containers, got %d", len(fake.created))
+	}
+	if exec.SucceededCount != 1 || exec.FailedCount != 0 || exec.RetriedCount != 2 {
+		t.Errorf("expected 1 succeeded task after 2 retries, got %d succeeded, %d failed, %d retried", exec.SucceededCount, exec.FailedCount, exec.RetriedCount)
+	}
+}
+
+func TestDockerRunDoesNotRetryNonRetryableExitCode(t *testing.T) {
+	fake := &fakeDockerClient{exitCodes: []int64{1, 0}}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:               "projects/p/locations/l/jobs/deterministic",
+		Image:              "alpine:latest",
+		MaxRetries:         3,
+		RetryableExitCodes: []int{137, 143},
+	})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusFailed {
+		t.Fatalf("expected status FAILED, got %s", exec.Status)
+	}
+	if len(fake.created) != 1 {
+		t.Errorf("expected 1 container, got %d", len(fake.created))
+	}
+}
+
+func TestDockerRunRecordsExitCode(t *testing.T) {
+	fake := &fakeDockerClient{exitCodes: []int64{42}}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/exiting", Image: "alpine:latest"})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusFailed || exec.ExitCode != 42 {
+		t.Errorf("expected a failure with exit code 42, got %s with exit code %d", exec.Status, exec.ExitCode)
+	}
+}
+
+func TestDockerRunRetriesAnyExitCodeByDefault(t *testing.T) {
+	fake := &fakeDockerClient{exitCodes: []int64{1}}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:       "projects/p/locations/l/jobs/flaky",
+		Image:      "alpine:latest",
+		MaxRetries: 2,
+	})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusFailed {
+		t.Fatalf("expected status FAILED, got %s", exec.Status)
+	}
+	if len(fake.created) != 3 {
+		t.Errorf("expected 3 containers (1 run + 2 retries), got %d", len(fake.created))
+	}
+	if len(fake.removed) != 3 {
+		t.Errorf("expected every attempt's container removed, got %d", len(fake.removed))
+	}
+}
+
+func TestDockerRunCPULimitSetsPeriodAndQuota(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:  "projects/p/locations/l/jobs/cpu",
+		Image: "alpine:latest",
+	})
+	exec.Resources = state.Resources{MilliCPU: 500}
+	e.Run(exec, nil)
+
+	if len(fake.hosts) != 1 {
+		t.Fatalf("expected 1 container, got %d", len(fake.hosts))
+	}
+	res := fake.hosts[0].Resources
+	if res.CPUPeriod != 100000 {
+		t.Errorf("expected CPU period 100000, got %d", res.CPUPeriod)
+	}
+	if res.CPUQuota != 50000 {
+		t.Errorf("expected CPU quota 50000, got %d", res.CPUQuota)
+	}
+	if res.NanoCPUs != 0 {
+		t.Errorf("expected NanoCPUs unset alongside period/quota, got %d", res.NanoCPUs)
+	}
+}
+
+func TestDockerRunMemoryLimit(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:  "projects/p/locations/l/jobs/memory",
+		Image: "alpine:latest",
+	})
+	exec.Resources = state.Resources{MemoryBytes: 512 << 20}
+	e.Run(exec, nil)
+
+	if len(fake.hosts) != 1 {
+		t.Fatalf("expected 1 container, got %d", len(fake.hosts))
+	}
+	if got := fake.hosts[0].Resources.Memory; got != 512<<20 {
+		t.Errorf("expected memory limit %d, got %d", 512<<20, got)
+	}
+}
+
+func TestDockerRunThrottlesImagePulls(t *testing.T) {
+	fake := &fakeDockerClient{imagesMissing: true, pullDelay: 20 * time.Millisecond}
+	e := &DockerExecutor{client: fake, pullSlots: make(chan struct{}, 2)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			e.Run(newTestExecution(&state.Job{
+				Name:  fmt.Sprintf("projects/p/locations/l/jobs/job-%d", i),
+				Image: fmt.Sprintf("example.com/image-%d:latest", i),
+			}), nil)
+		}(i)
+	}
+	wg.Wait()
+
+	if fake.pulls != 6 {
+		t.Errorf("expected 6 pulls, got %d", fake.pulls)
+	}
+	if fake.maxPulls > 2 {
+		t.Errorf("expected at most 2 concurrent pulls, got %d", fake.maxPulls)
+	}
+}
+
+func TestDockerCleanupImages(t *testing.T) {
+	fake := &fakeDockerClient{imagesMissing: true, imageSize: 1000}
+	e := &DockerExecutor{client: fake}
+
+	e.Run(newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/a", Image: "example.com/a:1"}), nil)
+	e.Run(newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/b", Image: "example.com/b:1"}), nil)
+	fake.imagesMissing = false
+
+	report, err := e.CleanupImages(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Images) != 2 || report.BytesReclaimed != 2000 {
+		t.Errorf("unexpected dry-run report: %+v", report)
+	}
+	if len(fake.removedImages) != 0 {
+		t.Fatalf("dry run removed images: %v", fake.removedImages)
+	}
+
+	report, err = e.CleanupImages(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.BytesReclaimed != 2000 {
+		t.Errorf("expected 2000 bytes reclaimed, got %d", report.BytesReclaimed)
+	}
+	if len(fake.removedImages) != 2 {
+		t.Errorf("expected 2 images removed, got %v", fake.removedImages)
+	}
+
+	report, err = e.CleanupImages(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Images) != 0 {
+		t.Errorf("expected nothing left to clean, got %+v", report.Images)
+	}
+}
+
+// recordingHandler captures slog records for assertions.
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *recordingHandler) WithGroup(string) slog.Handler      { return h }
+
+func recordAttrs(r slog.Record) map[string]slog.Value {
+	attrs := make(map[string]slog.Value)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value
+		return true
+	})
+	return attrs
+}
+
+func TestLineLogWriterTimestamps(t *testing.T) {
+	h := &recordingHandler{}
+	w := &lineLogWriter{logger: slog.New(h), stream: "stdout", timestamps: true}
+
+	fmt.Fprint(w, "2024-05-01T12:00:00.123456789Z hello world\n2024-05-01T12:00:01Z tail")
+	w.Flush()
+
+	if len(h.records) != 2 {
+		t.Fatalf("expected 2 log lines, got %d", len(h.records))
+	}
+	attrs := recordAttrs(h.records[0])
+	if got := attrs["line"].String(); got != "hello world" {
+		t.Errorf("expected timestamp stripped from line, got %q", got)
+	}
+	want := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	if got := attrs["container_time"].Time(); !got.Equal(want) {
+		t.Errorf("container_time = %v, want %v", got, want)
+	}
+	if got := recordAttrs(h.records[1])["line"].String(); got != "tail" {
+		t.Errorf("expected flushed line %q, got %q", "tail", got)
+	}
+}
+
+func TestDockerRunReportsOOMKilled(t *testing.T) {
+	// Exit code 0 would normally be a success; OOMKilled takes precedence.
+	fake := &fakeDockerClient{exitCodes: []int64{0}, oomKilled: true}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:  "projects/p/locations/l/jobs/hungry",
+		Image: "alpine:latest",
+	})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusFailed {
+		t.Fatalf("expected status FAILED, got %s", exec.Status)
+	}
+	if exec.FailureReason != state.ReasonOOMKilled {
+		t.Errorf("expected failure reason %q, got %q", state.ReasonOOMKilled, exec.FailureReason)
+	}
+	if !strings.Contains(exec.ErrorMessage, "OOMKilled") {
+		t.Errorf("expected error message to mention OOMKilled, got %q", exec.ErrorMessage)
+	}
+}
+
+func TestDockerRunSetsNetworkAliases(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake, network: "compose_default"}
+
+	exec := newTestExecution(&state.Job{
+		Name:           "projects/p/locations/l/jobs/service",
+		Image:          "alpine:latest",
+		NetworkAliases: []string{"fixture-api"},
+	})
+	e.Run(exec, nil)
+
+	if len(fake.nets) != 1 || fake.nets[0] == nil {
+		t.Fatalf("expected networking config on created container, got %v", fake.nets)
+	}
+	endpoint := fake.nets[0].EndpointsConfig["compose_default"]
+	if endpoint == nil || len(endpoint.Aliases) != 1 || endpoint.Aliases[0] != "fixture-api" {
+		t.Errorf("expected alias fixture-api on compose_default, got %+v", endpoint)
+	}
+}
+
+func TestDockerExecutorPipesStdin(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake}
+	exec := newTestExecution(&state.Job{
+		Name:    "projects/p/locations/l/jobs/cat",
+		Image:   "alpine:latest",
+		Command: []string{"cat"},
+		Stdin:   &state.StdinSource{Text: "hello\n"},
+	})
+
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusSucceeded {
+		t.Fatalf("expected success, got %s: %s", exec.Status, exec.ErrorMessage)
+	}
+	cfg := fake.created[0]
+	if !cfg.AttachStdin || !cfg.OpenStdin || !cfg.StdinOnce {
+		t.Errorf("expected stdin to be attached and closed after EOF, got %+v", cfg)
+	}
+	if got := fake.stdin.String(); got != "hello\n" {
+		t.Errorf("container received stdin %q, want %q", got, "hello\n")
+	}
+}
+
+func TestDockerRunCapturesFinalLogLine(t *testing.T) {
+	// The log stream lags behind the container exiting, as it can with a
+	// real daemon.
+	fake := &fakeDockerClient{logs: "first\nlast\n", logDelay: 50 * time.Millisecond}
+	e := &DockerExecutor{client: fake}
+	exec := newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/quick", Image: "alpine:latest"})
+	exec.Logs = state.NewLogBuffer(0, 0)
+
+	e.Run(exec, nil)
+
+	lines, _ := exec.Logs.Snapshot()
+	if len(lines) != 2 || lines[1].Text != "last" {
+		t.Errorf("expected both lines captured before the container was removed, got %+v", lines)
+	}
+}
+
+func TestDockerRunSetsCgroupParent(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake, cgroupParent: "/emulator-jobs"}
+	exec := newTestExecution(&state.Job{
+		Name:  "projects/p/locations/l/jobs/grouped",
+		Image: "alpine:latest",
+	})
+	exec.Resources = state.Resources{MilliCPU: 500}
+
+	e.Run(exec, nil)
+
+	if got := fake.hosts[0].CgroupParent; got != "/emulator-jobs" {
+		t.Errorf("expected cgroup parent /emulator-jobs, got %q", got)
+	}
+}
+
+func TestDockerRunPassesSecurityOpt(t *testing.T) {
+	profile := filepath.Join(t.TempDir(), "profile.json")
+	if err := os.WriteFile(profile, []byte("{\n  \"defaultAction\": \"SCMP_ACT_ERRNO\"\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake}
+	exec := newTestExecution(&state.Job{
+		Name:        "projects/p/locations/l/jobs/hardened",
+		Image:       "alpine:latest",
+		SecurityOpt: []string{"seccomp=" + profile, "apparmor=emulator-jobs", "no-new-privileges"},
+	})
+
+	e.Run(exec, nil)
+
+	want := []string{`seccomp={"defaultAction":"SCMP_ACT_ERRNO"}`, "apparmor=emulator-jobs", "no-new-privileges"}
+	if got := fake.hosts[0].SecurityOpt; !slices.Equal(got, want) {
+		t.Errorf("expected security options %q, got %q", want, got)
+	}
+}
+
+func TestDockerRunFailsOnMissingSeccompProfile(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake}
+	exec := newTestExecution(&state.Job{
+		Name:        "projects/p/locations/l/jobs/hardened",
+		Image:       "alpine:latest",
+		SecurityOpt: []string{"seccomp=" + filepath.Join(t.TempDir(), "missing.json")},
+	})
+
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusFailed {
+		t.Errorf("expected the run to fail, got %s", exec.Status)
+	}
+	if len(fake.created) != 0 {
+		t.Error("expected no container to be created")
+	}
+}
+
+func TestValidateCgroupParent(t *testing.T) {
+	for _, parent := range []string{"", "/emulator-jobs", "/docker/emulator", "emulator-jobs.slice"} {
+		if err := validateCgroupParent(parent); err != nil {
+			t.Errorf("validateCgroupParent(%q): unexpected error: %v", parent, err)
+		}
+	}
+	for _, parent := range []string{"/", "emulator-jobs", "/a/../b", "/trailing/", "a/b.slice"} {
+		if err := validateCgroupParent(parent); err == nil {
+			t.Errorf("validateCgroupParent(%q): expected error", parent)
+		}
+	}
+}
+
+func TestDockerValidateImage(t *testing.T) {
+	e := &DockerExecutor{client: &fakeDockerClient{imagesMissing: true}}
+	if err := e.ValidateImage(context.Background(), "alpine:latest"); err != nil {
+		t.Errorf("expected an image in the registry to validate, got %v", err)
+	}
+
+	e = &DockerExecutor{client: &fakeDockerClient{imagesMissing: true, registryMissing: true}}
+	err := e.ValidateImage(context.Background(), "alpnie:latest")
+	if !errors.Is(err, ErrImageNotFound) {
+		t.Errorf("expected ErrImageNotFound for a missing image, got %v", err)
+	}
+}
+
+func TestDockerRunFailsOnArchitectureMismatch(t *testing.T) {
+	fake := &fakeDockerClient{imageArch: "amd64", hostArch: "arm64"}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:  "projects/p/locations/l/jobs/amd64-only",
+		Image: "example.com/amd64-only:latest",
+	})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusFailed {
+		t.Fatalf("expected status FAILED, got %s", exec.Status)
+	}
+	if !strings.Contains(exec.ErrorMessage, "architecture mismatch") || !strings.Contains(exec.ErrorMessage, "linux/amd64") {
+		t.Errorf("expected an architecture mismatch error, got %q", exec.ErrorMessage)
+	}
+	if len(fake.created) != 0 {
+		t.Errorf("expected no container to be created, got %d", len(fake.created))
+	}
+}
+
+func TestDockerRunAllowsEmulatedArchitecture(t *testing.T) {
+	fake := &fakeDockerClient{imageArch: "amd64", hostArch: "arm64"}
+	e := &DockerExecutor{client: fake, allowEmulation: true}
+
+	exec := newTestExecution(&state.Job{
+		Name:  "projects/p/locations/l/jobs/amd64-only",
+		Image: "example.com/amd64-only:latest",
+	})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusSucceeded {
+		t.Fatalf("expected status SUCCEEDED, got %s (%s)", exec.Status, exec.ErrorMessage)
+	}
+}
+
+func TestDockerRunUsesPlatform(t *testing.T) {
+	// The local image is for the host's architecture, so running the job as
+	// linux/amd64 must pull the amd64 variant.
+	fake := &fakeDockerClient{imageArch: "arm64", hostArch: "arm64"}
+	defaultPlatform, err := ParsePlatform("linux/arm64/v8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &DockerExecutor{client: fake, platform: defaultPlatform}
+
+	exec := newTestExecution(&state.Job{
+		Name:     "projects/p/locations/l/jobs/amd64-only",
+		Image:    "example.com/amd64-only:latest",
+		Platform: "linux/amd64",
+	})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusSucceeded {
+		t.Fatalf("expected status SUCCEEDED, got %s (%s)", exec.Status, exec.ErrorMessage)
+	}
+	if !slices.Equal(fake.pullPlatforms, []string{"linux/amd64"}) {
+		t.Errorf("expected a linux/amd64 pull, got %q", fake.pullPlatforms)
+	}
+	if len(fake.platforms) != 1 || platformString(fake.platforms[0]) != "linux/amd64" {
+		t.Errorf("expected the container to be created for linux/amd64, got %v", fake.platforms)
+	}
+
+	// Without its own platform, a job uses the executor's default, which the
+	// local image already matches.
+	exec = newTestExecution(&state.Job{
+		Name:  "projects/p/locations/l/jobs/default",
+		Image: "alpine:latest",
+	})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusSucceeded {
+		t.Fatalf("expected status SUCCEEDED, got %s (%s)", exec.Status, exec.ErrorMessage)
+	}
+	if len(fake.pullPlatforms) != 1 {
+		t.Errorf("expected no further pulls, got %q", fake.pullPlatforms)
+	}
+	if len(fake.platforms) != 2 || platformString(fake.platforms[1]) != "linux/arm64/v8" {
+		t.Errorf("expected the container to be created for linux/arm64/v8, got %v", fake.platforms)
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	for _, s := range []string{"linux/amd64", "linux/arm64/v8"} {
+		p, err := ParsePlatform(s)
+		if err != nil {
+			t.Errorf("ParsePlatform(%q): %v", s, err)
+		} else if got := platformString(p); got != s {
+			t.Errorf("ParsePlatform(%q) formats as %q", s, got)
+		}
+	}
+	for _, s := range []string{"", "amd64", "linux/", "/amd64", "linux/arm64/v8/x"} {
+		if _, err := ParsePlatform(s); err == nil {
+			t.Errorf("ParsePlatform(%q): expected an error", s)
+		}
+	}
+}
+
+func TestDockerRunWritesEnvFile(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:        "projects/p/locations/l/jobs/sourcing",
+		Image:       "alpine:latest",
+		EnvFilePath: "/etc/cloud-run-env",
+	})
+	e.Run(exec, map[string]string{"GREETING": "it's here"})
+
+	if exec.Status != state.StatusSucceeded {
+		t.Fatalf("expected status SUCCEEDED, got %s (%s)", exec.Status, exec.ErrorMessage)
+	}
+	archives := fake.copied["/etc"]
+	if len(archives) != 1 {
+		t.Fatalf("expected one archive copied to /etc, got %v", fake.copied)
+	}
+	tr := tar.NewReader(bytes.NewReader(archives[0]))
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatalf("reading archive: %v", err)
+	}
+	if hdr.Name != "cloud-run-env" {
+		t.Errorf("expected file cloud-run-env, got %q", hdr.Name)
+	}
+	content, _ := io.ReadAll(tr)
+	want := "CLOUD_RUN_EXECUTION='test'\nCLOUD_RUN_JOB='sourcing'\nCLOUD_RUN_TASK_ATTEMPT='0'\n" +
+		"CLOUD_RUN_TASK_COUNT='1'\nCLOUD_RUN_TASK_INDEX='0'\nGREETING='it'\\''s here'\n"
+	if string(content) != want {
+		t.Errorf("env file content:\n%s\nwant:\n%s", content, want)
+	}
+	// The file lives only in the container, which is removed after the run.
+	if len(fake.removed) != 1 {
+		t.Errorf("expected the container to be removed, got %v", fake.removed)
+	}
+}
+
+func TestDockerRunStartupProbePasses(t *testing.T) {
+	fake := &fakeDockerClient{runFor: 200 * time.Millisecond, stop: make(chan struct{}), probeExitCodes: []int{1, 0}}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:         "projects/p/locations/l/jobs/slow-start",
+		Image:        "alpine:latest",
+		StartupProbe: &state.Probe{Command: []string{"test", "-f", "/tmp/ready"}, Period: 10 * time.Millisecond},
+	})
+	exec.Status = state.StatusPending
+	var seen []state.ExecutionStatus
+	fake.onProbe = func() { seen = append(seen, exec.Status) }
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusSucceeded {
+		t.Fatalf("expected status SUCCEEDED, got %s (%s)", exec.Status, exec.ErrorMessage)
+	}
+	if fake.probes != 2 {
+		t.Errorf("expected 2 probes, got %d", fake.probes)
+	}
+	if !slices.Equal(seen, []state.ExecutionStatus{state.StatusPending, state.StatusPending}) {
+		t.Errorf("expected the execution to stay pending until the probe passed, saw %v", seen)
+	}
+	if len(fake.stopped) != 0 {
+		t.Errorf("expected the container to run to completion, stopped %v", fake.stopped)
+	}
+}
+
+func TestDockerRunStartupProbeFails(t *testing.T) {
+	fake := &fakeDockerClient{runFor: 5 * time.Second, stop: make(chan struct{}), probeExitCodes: []int{1}}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:         "projects/p/locations/l/jobs/never-ready",
+		Image:        "alpine:latest",
+		StartupProbe: &state.Probe{Command: []string{"false"}, Period: 10 * time.Millisecond, FailureThreshold: 3},
+	})
+	exec.Status = state.StatusPending
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusFailed {
+		t.Fatalf("expected status FAILED, got %s", exec.Status)
+	}
+	if exec.FailureReason != state.ReasonStartupProbeFailed || !strings.Contains(exec.ErrorMessage, "startup probe failed") {
+		t.Errorf("expected a startup probe failure, got reason %q: %q", exec.FailureReason, exec.ErrorMessage)
+	}
+	if fake.probes != 3 {
+		t.Errorf("expected 3 probes, got %d", fake.probes)
+	}
+	if len(fake.stopped) != 1 {
+		t.Errorf("expected the container to be stopped, got %v", fake.stopped)
+	}
+}
+
+func TestDockerCancelDoesNotRetry(t *testing.T) {
+	// The stopped container exits with a retryable code.
+	fake := &fakeDockerClient{runFor: 5 * time.Second, stop: make(chan struct{}), exitCodes: []int64{143}}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:       "projects/p/locations/l/jobs/cancelled",
+		Image:      "alpine:latest",
+		MaxRetries: 3,
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Run(exec, nil)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fake.mu.Lock()
+		created := len(fake.created)
+		fake.mu.Unlock()
+		exec.Lock()
+		started := exec.ContainerID != ""
+		exec.Unlock()
+		if created > 0 && started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("container was never created")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// CancelExecution records the outcome only once Cancel returns, which
+	// may be after the executor has seen the container exit.
+	if err := e.Cancel(exec); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		t.Fatalf("run didn't stop after cancelling; created %d containers", len(fake.created))
+	}
+
+	// The run must leave the outcome to CancelExecution, so its CANCELLED
+	// status stands.
+	if exec.Status != state.StatusRunning || !exec.CompletionTime.IsZero() {
+		t.Errorf("expected the executor not to record an outcome, got status %s completed at %s", exec.Status, exec.CompletionTime)
+	}
+	if len(fake.created) != 1 {
+		t.Errorf("expected no container after cancelling, created %d", len(fake.created))
+	}
+	if exec.RetriedCount != 0 || exec.FailedCount != 0 {
+		t.Errorf("expected the cancelled attempt to be neither retried nor failed, got %d retried, %d failed", exec.RetriedCount, exec.FailedCount)
+	}
+}
+
+func TestDockerRunFailOnStderr(t *testing.T) {
+	job := &state.Job{Name: "projects/p/locations/l/jobs/strict", Image: "alpine:latest", FailOnStderr: true}
+	for _, tc := range []struct {
+		name     string
+		fake     *fakeDockerClient
+		wantFail string
+	}{
+		{name: "stdout only", fake: &fakeDockerClient{logs: "all good\n"}},
+		{name: "stderr", fake: &fakeDockerClient{logs: "all good\n", stderrLogs: "warning: disk almost full\n"}, wantFail: "container wrote to stderr"},
+		// Output that arrives after the drain timeout may include stderr.
+		{name: "undrained", fake: &fakeDockerClient{logs: "all good\n", logDelay: 500 * time.Millisecond}, wantFail: "could not check the container's stderr"},
+	} {
+		e := &DockerExecutor{client: tc.fake, logDrainTimeout: 20 * time.Millisecond}
+		exec := newTestExecution(job)
+		e.Run(exec, nil)
+
+		if tc.wantFail == "" {
+			if exec.Status != state.StatusSucceeded {
+				t.Errorf("%s: expected status SUCCEEDED, got %s (%s)", tc.name, exec.Status, exec.ErrorMessage)
+			}
+			continue
+		}
+		if exec.Status != state.StatusFailed || exec.FailureReason != state.ReasonWroteStderr {
+			t.Errorf("%s: expected a %s failure, got %s (%s)", tc.name, state.ReasonWroteStderr, exec.Status, exec.FailureReason)
+		}
+		if !strings.Contains(exec.ErrorMessage, tc.wantFail) {
+			t.Errorf("%s: expected error %q, got %q", tc.name, tc.wantFail, exec.ErrorMessage)
+		}
+	}
+}
+
+func TestDockerStopTimeout(t *testing.T) {
+	fake := &fakeDockerClient{runFor: 5 * time.Second, stop: make(chan struct{})}
+	e := &DockerExecutor{client: fake}
+
+	// A timed-out container gets the job's stop timeout, rounded up.
+	stopTimeout := 1500 * time.Millisecond
+	exec := newTestExecution(&state.Job{
+		Name:        "projects/p/locations/l/jobs/slow-flush",
+		Image:       "alpine:latest",
+		Timeout:     20 * time.Millisecond,
+		StopTimeout: &stopTimeout,
+	})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusFailed {
+		t.Fatalf("expected status FAILED, got %s", exec.Status)
+	}
+	if exec.ExitCode != 0 || exec.TaskState(0).ExitCode != 0 {
+		t.Errorf("expected no exit code for a stopped container, got %d (task %d)", exec.ExitCode, exec.TaskState(0).ExitCode)
+	}
+	if len(fake.stopTimeouts) != 1 || fake.stopTimeouts[0] == nil || *fake.stopTimeouts[0] != 2 {
+		t.Fatalf("expected the container to be stopped with a 2s timeout, got %v", fake.stopTimeouts)
+	}
+
+	// Zero kills the container straight away when it is cancelled.
+	var kill time.Duration
+	exec = newTestExecution(&state.Job{
+		Name:        "projects/p/locations/l/jobs/disposable",
+		Image:       "alpine:latest",
+		StopTimeout: &kill,
+	})
+	exec.ContainerID = "container-1"
+	if err := e.Cancel(exec); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.stopTimeouts) != 2 || fake.stopTimeouts[1] == nil || *fake.stopTimeouts[1] != 0 {
+		t.Fatalf("expected the container to be stopped with a 0s timeout, got %v", fake.stopTimeouts)
+	}
+
+	// Without one, Docker's default applies.
+	exec = newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/plain", Image: "alpine:latest"})
+	exec.ContainerID = "container-2"
+	if err := e.Cancel(exec); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.stopTimeouts) != 3 || fake.stopTimeouts[2] != nil {
+		t.Errorf("expected the container to be stopped with Docker's default timeout, got %v", fake.stopTimeouts)
+	}
+}
+
+func TestDockerRunLabelsContainers(t *testing.T) {
+	fake := &fakeDockerClient{exitCodes: []int64{1, 0}}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:       "projects/p/locations/l/jobs/labelled",
+		Image:      "alpine:latest",
+		MaxRetries: 1,
+	})
+	exec.TaskCount = 2
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusSucceeded {
+		t.Fatalf("expected status SUCCEEDED, got %s (%s)", exec.Status, exec.ErrorMessage)
+	}
+	want := []struct{ task, attempt string }{{"0", "0"}, {"0", "1"}, {"1", "0"}}
+	if len(fake.created) != len(want) {
+		t.Fatalf("expected %d containers, got %d", len(want), len(fake.created))
+	}
+	for i, cfg := range fake.created {
+		labels := cfg.Labels
+		if labels[labelTaskIndex] != want[i].task || labels[labelTaskAttempt] != want[i].attempt {
+			t.Errorf("container %d: task index %q, attempt %q, want %q, %q", i, labels[labelTaskIndex], labels[labelTaskAttempt], want[i].task, want[i].attempt)
+		}
+		if labels[labelJob] != exec.Job.Name || labels[labelExecution] != exec.Name {
+			t.Errorf("container %d: job %q, execution %q", i, labels[labelJob], labels[labelExecution])
+		}
+		if _, err := time.Parse(time.RFC3339, labels[labelStartTime]); err != nil {
+			t.Errorf("container %d: invalid start time label: %v", i, err)
+		}
+	}
+}
+
+func TestDockerRunSetsHostname(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/Nightly_Report", Image: "alpine:latest"})
+	exec.Name = exec.Job.Name + "/executions/abc123"
+	e.Run(exec, nil)
+
+	named := newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/named", Image: "alpine:latest", Hostname: "db-migrator"})
+	e.Run(named, nil)
+
+	if len(fake.created) != 2 {
+		t.Fatalf("expected 2 containers, got %d", len(fake.created))
+	}
+	if got := fake.created[0].Hostname; got != "nightly-report-abc123" {
+		t.Errorf("expected a hostname derived from the execution, got %q", got)
+	}
+	if got := fake.created[1].Hostname; got != "db-migrator" {
+		t.Errorf("expected the job's hostname, got %q", got)
+	}
+}
+
+func TestDockerRunImagePullPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy  string
+		missing bool
+		pulls   int
+		failed  string
+	}{
+		{policy: "", missing: true, pulls: 1},
+		{policy: PullIfNotPresent, pulls: 0},
+		{policy: PullAlways, pulls: 1},
+		{policy: PullNever, pulls: 0},
+		{policy: PullNever, missing: true, pulls: 0, failed: "image pull policy is never"},
+	} {
+		fake := &fakeDockerClient{imagesMissing: tc.missing}
+		e := &DockerExecutor{client: fake, pullPolicy: tc.policy}
+		exec := newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/pull", Image: "alpine:latest"})
+		e.Run(exec, nil)
+
+		if fake.pulls != tc.pulls {
+			t.Errorf("policy %q, missing %v: expected %d pulls, got %d", tc.policy, tc.missing, tc.pulls, fake.pulls)
+		}
+		if tc.failed == "" && exec.Status != state.StatusSucceeded {
+			t.Errorf("policy %q, missing %v: expected status SUCCEEDED, got %s (%s)", tc.policy, tc.missing, exec.Status, exec.ErrorMessage)
+		}
+		if tc.failed != "" && (exec.Status != state.StatusFailed || !strings.Contains(exec.ErrorMessage, tc.failed)) {
+			t.Errorf("policy %q, missing %v: expected a failure mentioning %q, got %s (%s)", tc.policy, tc.missing, tc.failed, exec.Status, exec.ErrorMessage)
+		}
+	}
+}
+
+func TestDockerRunFailsOnPullStreamError(t *testing.T) {
+	fake := &fakeDockerClient{imagesMissing: true, pullError: "manifest for alpine:nope not found"}
+	e := &DockerExecutor{client: fake}
+	exec := newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/pull", Image: "alpine:nope"})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusFailed || !strings.Contains(exec.ErrorMessage, "manifest for alpine:nope not found") {
+		t.Errorf("expected the pull error to fail the execution, got %s (%s)", exec.Status, exec.ErrorMessage)
+	}
+	if len(fake.created) != 0 {
+		t.Errorf("expected no container to be created, got %d", len(fake.created))
+	}
+}
+
+func TestDockerRunAutoRemove(t *testing.T) {
+	for _, autoRemove := range []bool{false, true} {
+		fake := &fakeDockerClient{}
+		e := &DockerExecutor{client: fake, autoRemove: autoRemove}
+
+		exec := newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/tidy", Image: "alpine:latest"})
+		e.Run(exec, nil)
+
+		if exec.Status != state.StatusSucceeded {
+			t.Fatalf("autoRemove=%v: expected status SUCCEEDED, got %s (%s)", autoRemove, exec.Status, exec.ErrorMessage)
+		}
+		if got := fake.hosts[0].AutoRemove; got != autoRemove {
+			t.Errorf("autoRemove=%v: container created with AutoRemove %v", autoRemove, got)
+		}
+		// Exactly one of Docker and the executor removes the container.
+		removed := append(fake.autoRemoved, fake.removed...)
+		if len(removed) != 1 || removed[0] != exec.ContainerID {
+			t.Errorf("autoRemove=%v: auto-removed %v and removed %v, want %s removed once", autoRemove, fake.autoRemoved, fake.removed, exec.ContainerID)
+		}
+		if autoRemove && len(fake.removed) > 0 {
+			t.Errorf("expected the executor to leave removal to Docker, removed %v", fake.removed)
+		}
+	}
+}
+
+func TestDockerRunStartsSidecars(t *testing.T) {
+	fake := &fakeDockerClient{exitCodes: []int64{3}}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:  "projects/p/locations/l/jobs/proxied",
+		Image: "app:latest",
+		Sidecars: []state.Sidecar{
+			{Name: "proxy", Image: "proxy:latest", DependsOn: []string{"cache"}},
+			{Name: "cache", Image: "redis:latest", Env: map[string]string{"MODE": "cache"}},
+		},
+	})
+	e.Run(exec, nil)
+
+	// The main container's exit code decides the result.
+	if exec.Status != state.StatusFailed || exec.ExitCode != 3 {
+		t.Fatalf("expected the main container's exit code 3 to fail the run, got %s with exit code %d", exec.Status, exec.ExitCode)
+	}
+	var images []string
+	for _, cfg := range fake.created {
+		images = append(images, cfg.Image)
+	}
+	if !slices.Equal(images, []string{"app:latest", "redis:latest", "proxy:latest"}) {
+		t.Fatalf("expected the main container, then sidecars in dependency order, got %v", images)
+	}
+	for i, host := range fake.hosts[1:] {
+		if host.NetworkMode != "container:container-1" {
+			t.Errorf("sidecar %d: expected the main container's network namespace, got %q", i, host.NetworkMode)
+		}
+	}
+	if got := fake.created[1].Labels[labelSidecar]; got != "cache" {
+		t.Errorf("expected sidecar label cache, got %q", got)
+	}
+	if !slices.Contains(fake.created[1].Env, "MODE=cache") || !slices.Contains(fake.created[1].Env, "CLOUD_RUN_TASK_INDEX=0") {
+		t.Errorf("expected the sidecar's own env and task metadata, got %v", fake.created[1].Env)
+	}
+	if !slices.Equal(fake.stopped, []string{"container-3", "container-2"}) {
+		t.Errorf("expected sidecars to be stopped in reverse start order, got %v", fake.stopped)
+	}
+	for _, id := range []string{"container-1", "container-2", "container-3"} {
+		if !slices.Contains(fake.removed, id) {
+			t.Errorf("expected %s to be removed, removed %v", id, fake.removed)
+		}
+	}
+}
+
+func TestDockerRunSetsEntrypointAndArgs(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake}
+
+	for _, job := range []*state.Job{
+		{Name: "projects/p/locations/l/jobs/both", Image: "alpine:latest", Command: []string{"python", "-m", "app"}, Args: []string{"--verbose"}},
+		{Name: "projects/p/locations/l/jobs/args", Image: "alpine:latest", Args: []string{"--verbose"}},
+	} {
+		e.Run(newTestExecution(job), nil)
+	}
+
+	if cfg := fake.created[0]; !slices.Equal(cfg.Entrypoint, []string{"python", "-m", "app"}) || !slices.Equal(cfg.Cmd, []string{"--verbose"}) {
+		t.Errorf("expected the command as entrypoint and args as Cmd, got %v and %v", cfg.Entrypoint, cfg.Cmd)
+	}
+	if cfg := fake.created[1]; cfg.Entrypoint != nil || !slices.Equal(cfg.Cmd, []string{"--verbose"}) {
+		t.Errorf("expected the image's entrypoint to be kept with args only, got %v and %v", cfg.Entrypoint, cfg.Cmd)
+	}
+}
+
+func TestDockerRunSetsWorkingDir(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake}
+
+	e.Run(newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/workdir", Image: "alpine:latest", WorkingDir: "/app"}), nil)
+	e.Run(newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/default", Image: "alpine:latest"}), nil)
+
+	if got := fake.created[0].WorkingDir; got != "/app" {
+		t.Errorf("expected working dir /app, got %q", got)
+	}
+	if got := fake.created[1].WorkingDir; got != "" {
+		t.Errorf("expected the image's working dir to be kept, got %q", got)
+	}
+}
+
+func TestDockerRunSetsDNS(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake, dns: []string{"10.0.0.53"}, dnsSearch: []string{"dev.internal", "corp.example"}}
+
+	e.Run(newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/dns", Image: "alpine:latest"}), nil)
+
+	host := fake.hosts[0]
+	if !slices.Equal(host.DNS, []string{"10.0.0.53"}) || !slices.Equal(host.DNSSearch, []string{"dev.internal", "corp.example"}) {
+		t.Errorf("expected the DNS settings to be applied, got servers %v and search domains %v", host.DNS, host.DNSSearch)
+	}
+}
+
+func TestDockerRunInit(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake}
+	e.Run(newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/default", Image: "alpine:latest"}), nil)
+	e.init = true
+	e.Run(newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/init", Image: "alpine:latest"}), nil)
+
+	if got := fake.hosts[0].Init; got != nil {
+		t.Errorf("expected the daemon's init default to be kept, got %v", *got)
+	}
+	if got := fake.hosts[1].Init; got == nil || !*got {
+		t.Errorf("expected an init process to be requested, got %v", got)
+	}
+}
+
+func TestDockerRunMountsVolumes(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake}
+
+	exec := newTestExecution(&state.Job{
+		Name:  "projects/p/locations/l/jobs/fixtures",
+		Image: "alpine:latest",
+		Volumes: []state.Volume{
+			{HostPath: "/src/fixtures", ContainerPath: "/fixtures", ReadOnly: true},
+			{HostPath: "/tmp/out", ContainerPath: "/out"},
+		},
+	})
+	e.Run(exec, nil)
+
+	want := []mount.Mount{
+		{Type: mount.TypeBind, Source: "/src/fixtures", Target: "/fixtures", ReadOnly: true},
+		{Type: mount.TypeBind, Source: "/tmp/out", Target: "/out"},
+	}
+	if got := fake.hosts[0].Mounts; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected mounts %+v, got %+v", want, got)
+	}
+}
+
+func TestDockerRunReadOnlyRoot(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake}
+
+	e.Run(newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/hardened", Image: "alpine:latest", ReadOnlyRoot: true}), nil)
+	e.Run(newTestExecution(&state.Job{
+		Name:         "projects/p/locations/l/jobs/scratch",
+		Image:        "alpine:latest",
+		ReadOnlyRoot: true,
+		Volumes:      []state.Volume{{HostPath: "/srv/scratch", ContainerPath: "/tmp"}},
+	}), nil)
+	e.Run(newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/writable", Image: "alpine:latest"}), nil)
+
+	if host := fake.hosts[0]; !host.ReadonlyRootfs || !reflect.DeepEqual(host.Tmpfs, map[string]string{"/tmp": ""}) {
+		t.Errorf("expected a read-only root with a tmpfs at /tmp, got %v and %v", host.ReadonlyRootfs, host.Tmpfs)
+	}
+	if host := fake.hosts[1]; !host.ReadonlyRootfs || host.Tmpfs != nil {
+		t.Errorf("expected the /tmp volume to replace the tmpfs, got %v", host.Tmpfs)
+	}
+	if host := fake.hosts[2]; host.ReadonlyRootfs || host.Tmpfs != nil {
+		t.Errorf("expected a writable root by default, got %v and %v", host.ReadonlyRootfs, host.Tmpfs)
+	}
+}
+
+func TestDockerRunCollectsStats(t *testing.T) {
+	sample := func(memory, cpu uint64) container.StatsResponse {
+		var s container.StatsResponse
+		s.MemoryStats.Usage = memory
+		s.CPUStats.CPUUsage.TotalUsage = cpu
+		return s
+	}
+	fake := &fakeDockerClient{
+		runFor: 100 * time.Millisecond,
+		// Memory rises then falls; the last sample is of the stopped
+		// container, which reports nothing.
+		stats: []container.StatsResponse{
+			sample(10<<20, uint64(100*time.Millisecond)),
+			sample(64<<20, uint64(300*time.Millisecond)),
+			sample(32<<20, uint64(500*time.Millisecond)),
+			{},
+		},
+	}
+	e := &DockerExecutor{client: fake, collectStats: true, statsInterval: 10 * time.Millisecond}
+
+	exec := newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/stats", Image: "alpine:latest"})
+	e.Run(exec, nil)
+
+	if exec.PeakMemoryBytes != 64<<20 {
+		t.Errorf("expected a peak of 64MiB, got %d bytes", exec.PeakMemoryBytes)
+	}
+	if exec.CPUTime != 500*time.Millisecond {
+		t.Errorf("expected 500ms of CPU time, got %s", exec.CPUTime)
+	}
+}
+
+func TestDockerRunKeepsFailedContainers(t *testing.T) {
+	// The first attempt fails and is retried; the second succeeds.
+	fake := &fakeDockerClient{exitCodes: []int64{1, 0}}
+	e := &DockerExecutor{client: fake, keepFailed: true}
+
+	exec := newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/flaky", Image: "alpine:latest", MaxRetries: 1})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusSucceeded {
+		t.Fatalf("expected status SUCCEEDED, got %s (%s)", exec.Status, exec.ErrorMessage)
+	}
+	if !slices.Equal(exec.KeptContainerIDs, []string{"container-1"}) {
+		t.Errorf("expected the failed attempt's container to be kept, got %v", exec.KeptContainerIDs)
+	}
+	if !slices.Equal(fake.removed, []string{"container-2"}) {
+		t.Errorf("expected only the successful attempt's container to be removed, got %v", fake.removed)
+	}
+}
+
+func TestDockerRunRequestsGPUs(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake, gpu: true}
+
+	exec := newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/cuda", Image: "nvidia/cuda:12.4.0-base-ubuntu22.04"})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusSucceeded {
+		t.Fatalf("expected status SUCCEEDED, got %s (%s)", exec.Status, exec.ErrorMessage)
+	}
+	reqs := fake.hosts[0].DeviceRequests
+	if len(reqs) != 1 || reqs[0].Count != -1 || len(reqs[0].Capabilities) != 1 || !slices.Equal(reqs[0].Capabilities[0], []string{"gpu"}) {
+		t.Errorf("expected a request for all GPUs, got %+v", reqs)
+	}
+}
+
+func TestDockerRunExplainsRejectedGPURequest(t *testing.T) {
+	fake := &fakeDockerClient{startError: errors.New(`could not select device driver "" with capabilities: [[gpu]]`)}
+	e := &DockerExecutor{client: fake, gpu: true}
+
+	exec := newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/cuda", Image: "alpine:latest"})
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusFailed || !strings.Contains(exec.ErrorMessage, "NVIDIA Container Toolkit") {
+		t.Errorf("expected a failure pointing at the NVIDIA Container Toolkit, got %s: %q", exec.Status, exec.ErrorMessage)
+	}
+	if len(fake.removed) != 1 {
+		t.Errorf("expected the unstarted container to be removed, got %v", fake.removed)
+	}
+}
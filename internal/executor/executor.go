@@ -1,6 +1,16 @@
 package executor
 
-import "github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"time"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
 
 // Executor runs a job execution.
 type Executor interface {
@@ -12,3 +22,174 @@ type Executor interface {
 	// Cancel stops a running execution.
 	Cancel(exec *state.Execution) error
 }
+
+// taskEnv returns the environment variables Cloud Run sets to tell a task
+// its job and execution, its index among the execution's tasks, and which
+// attempt (from 0) at the task it is. It returns nil if the execution opts
+// out of them.
+func taskEnv(exec *state.Execution, task, attempt int) []string {
+	if exec.OmitTaskEnv {
+		return nil
+	}
+	return []string{
+		"CLOUD_RUN_JOB=" + path.Base(exec.Job.Name),
+		"CLOUD_RUN_EXECUTION=" + path.Base(exec.Name),
+		fmt.Sprintf("CLOUD_RUN_TASK_INDEX=%d", task),
+		fmt.Sprintf("CLOUD_RUN_TASK_COUNT=%d", exec.Tasks()),
+		fmt.Sprintf("CLOUD_RUN_TASK_ATTEMPT=%d", attempt),
+	}
+}
+
+// captureOutput runs fn with writers that capture each line a task writes
+// to stdout and stderr into capture and, when non-nil, logger. A final line
+// without a trailing newline is flushed once fn returns, however it returns.
+// It reports whether anything was written to stderr.
+func captureOutput(logger *slog.Logger, capture *state.LogBuffer, task int, timestamps bool, fn func(stdout, stderr io.Writer) error) (wroteStderr bool, err error) {
+	stdout := &lineLogWriter{logger: logger, capture: capture, stream: "stdout", task: task, timestamps: timestamps}
+	stderr := &lineLogWriter{logger: logger, capture: capture, stream: "stderr", task: task, timestamps: timestamps}
+	defer func() {
+		stdout.Flush()
+		stderr.Flush()
+		wroteStderr = stderr.wrote
+	}()
+	return false, fn(stdout, stderr)
+}
+
+// The helpers below update exec under its lock, since the server reads it
+// while it runs.
+
+// startTask marks task of exec as running.
+func startTask(exec *state.Execution, task int) {
+	exec.Lock()
+	defer exec.Unlock()
+	t := exec.TaskState(task)
+	t.Status = state.StatusRunning
+	t.StartTime = time.Now()
+}
+
+// succeedTask counts task of exec as succeeded.
+func succeedTask(exec *state.Execution, task int) {
+	exec.Lock()
+	defer exec.Unlock()
+	t := exec.TaskState(task)
+	t.Status = state.StatusSucceeded
+	t.CompletionTime = time.Now()
+	exec.SucceededCount++
+}
+
+// retryTask counts a failed attempt of task of exec that is being retried.
+func retryTask(exec *state.Execution, task int) {
+	exec.Lock()
+	defer exec.Unlock()
+	exec.TaskState(task).Retried++
+	exec.RetriedCount++
+}
+
+// failTask counts task of exec as failed with the given reason and message.
+// With several tasks, the message names the task.
+func failTask(exec *state.Execution, task int, reason, msg string) {
+	exec.Lock()
+	defer exec.Unlock()
+	t := exec.TaskState(task)
+	t.Status = state.StatusFailed
+	t.ErrorMessage = msg
+	t.CompletionTime = time.Now()
+
+	exec.FailedCount++
+	exec.FailureReason = reason
+	exec.ExitCode = 0
+	if exec.Tasks() > 1 {
+		msg = fmt.Sprintf("task %d: %s", task, msg)
+	}
+	exec.ErrorMessage = msg
+}
+
+// recordTaskExitCode records the exit code of the latest attempt at task of
+// exec.
+func recordTaskExitCode(exec *state.Execution, task, code int) {
+	exec.Lock()
+	defer exec.Unlock()
+	exec.TaskState(task).ExitCode = int32(code)
+}
+
+// recordExitCode records the exit code of the failed task of exec, after
+// failTask.
+func recordExitCode(exec *state.Execution, code int) {
+	exec.Lock()
+	defer exec.Unlock()
+	exec.ExitCode = int32(code)
+}
+
+// markRunning marks exec as running if it is still pending, once its
+// startup probe passes.
+func markRunning(exec *state.Execution) {
+	exec.Lock()
+	defer exec.Unlock()
+	if exec.Status == state.StatusPending {
+		exec.Status = state.StatusRunning
+	}
+}
+
+// failExecution fails every task of exec before any ran, with msg.
+func failExecution(exec *state.Execution, msg string) {
+	exec.Lock()
+	defer exec.Unlock()
+	exec.Status = state.StatusFailed
+	exec.ErrorMessage = msg
+	exec.FailedCount = exec.Tasks()
+	exec.CompletionTime = time.Now()
+}
+
+// finishExecution marks exec as succeeded if all its tasks did, or else
+// failed.
+func finishExecution(exec *state.Execution) {
+	exec.Lock()
+	defer exec.Unlock()
+	if exec.SucceededCount == exec.Tasks() {
+		exec.Status = state.StatusSucceeded
+	} else {
+		exec.Status = state.StatusFailed
+	}
+	exec.CompletionTime = time.Now()
+}
+
+// HealthChecker is implemented by executors that depend on an external
+// service, such as the Docker daemon.
+type HealthChecker interface {
+	// CheckHealth returns an error if the executor can't currently run jobs.
+	CheckHealth(ctx context.Context) error
+}
+
+// ErrImageNotFound is returned by ImageValidator when an image reference
+// doesn't exist.
+var ErrImageNotFound = errors.New("image not found")
+
+// ImageValidator is implemented by executors that can check an image exists
+// before any job using it runs.
+type ImageValidator interface {
+	// ValidateImage returns an error wrapping ErrImageNotFound if ref exists
+	// neither locally nor in its registry.
+	ValidateImage(ctx context.Context, ref string) error
+}
+
+// ImageCleaner is implemented by executors that pull images and can remove
+// them again to reclaim disk space.
+type ImageCleaner interface {
+	// CleanupImages removes images pulled by the executor. When dryRun is
+	// true nothing is removed and the report lists what would be.
+	CleanupImages(ctx context.Context, dryRun bool) (*ImageCleanupReport, error)
+}
+
+// ImageCleanupReport describes the outcome of an image cleanup.
+type ImageCleanupReport struct {
+	DryRun         bool           `json:"dryRun"`
+	Images         []CleanedImage `json:"images"`
+	BytesReclaimed int64          `json:"bytesReclaimed"`
+}
+
+// CleanedImage is a single image considered during cleanup.
+type CleanedImage struct {
+	Ref   string `json:"ref"`
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,45 @@
+package executor
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ParsePlatform parses a platform of the form os/arch[/variant], such as
+// linux/amd64 or linux/arm64/v8.
+func ParsePlatform(s string) (*ocispec.Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || slices.Contains(parts, "") {
+		return nil, fmt.Errorf("invalid platform %q: want os/arch[/variant], e.g. linux/amd64", s)
+	}
+	p := &ocispec.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// platformFor returns the platform job's containers run as: its own, else
+// the executor's default, or nil to let Docker choose.
+func (e *DockerExecutor) platformFor(job *state.Job) (*ocispec.Platform, error) {
+	if job.Platform != "" {
+		return ParsePlatform(job.Platform)
+	}
+	return e.platform, nil
+}
+
+// platformString formats p for image pulls; empty if p is nil.
+func platformString(p *ocispec.Platform) string {
+	if p == nil {
+		return ""
+	}
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
@@ -0,0 +1,117 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+// Defaults for unset startup probe fields, as in Kubernetes.
+const (
+	defaultProbePeriod           = 10 * time.Second
+	defaultProbeFailureThreshold = 3
+)
+
+// runStartupProbe probes the container until the probe passes, returning
+// nil, or fails FailureThreshold times in a row. It stops early with ctx's
+// error once ctx is done.
+func (e *DockerExecutor) runStartupProbe(ctx context.Context, containerID string, probe *state.Probe, logger *slog.Logger) error {
+	period := probe.Period
+	if period <= 0 {
+		period = defaultProbePeriod
+	}
+	threshold := probe.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultProbeFailureThreshold
+	}
+
+	wait := probe.InitialDelay
+	for failures := 0; ; {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wait = period
+
+		err := e.probeOnce(ctx, containerID, probe, period)
+		if err == nil {
+			logger.Info("startup probe passed")
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		failures++
+		logger.Debug("startup probe attempt failed", "error", err, "failures", failures, "threshold", threshold)
+		if failures >= threshold {
+			return fmt.Errorf("%d consecutive failures, last: %w", failures, err)
+		}
+	}
+}
+
+// probeOnce runs a single probe against the container, giving a TCP probe up
+// to timeout to connect.
+func (e *DockerExecutor) probeOnce(ctx context.Context, containerID string, probe *state.Probe, timeout time.Duration) error {
+	if probe.TCPPort > 0 {
+		host, err := e.containerHost(ctx, containerID)
+		if err != nil {
+			return err
+		}
+		dialer := net.Dialer{Timeout: timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(probe.TCPPort)))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	created, err := e.client.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          probe.Command,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("creating probe command: %w", err)
+	}
+	hj, err := e.client.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return fmt.Errorf("starting probe command: %w", err)
+	}
+	// The output isn't needed, but reading it to EOF waits for the command.
+	_, _ = io.Copy(io.Discard, hj.Reader)
+	hj.Close()
+	info, err := e.client.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return fmt.Errorf("inspecting probe command: %w", err)
+	}
+	if info.ExitCode != 0 {
+		return fmt.Errorf("probe command exited with code %d", info.ExitCode)
+	}
+	return nil
+}
+
+// containerHost returns the address the emulator can reach the container at:
+// its IP on the executor's network, or localhost with host networking.
+func (e *DockerExecutor) containerHost(ctx context.Context, containerID string) (string, error) {
+	if e.network == "" {
+		return "127.0.0.1", nil
+	}
+	info, err := e.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return "", fmt.Errorf("inspecting container: %w", err)
+	}
+	if info.NetworkSettings != nil {
+		if ep := info.NetworkSettings.Networks[e.network]; ep != nil && ep.IPAddress != "" {
+			return ep.IPAddress, nil
+		}
+	}
+	return "", fmt.Errorf("container has no address on network %s", e.network)
+}
@@ -0,0 +1,80 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+// startSidecars starts the job's sidecars for an attempt at task, in
+// dependency order, in the network namespace of the running main container.
+// The returned function stops and removes the sidecars that were started;
+// it must be called even if starting them failed.
+func (e *DockerExecutor) startSidecars(ctx context.Context, exec *state.Execution, mainID string, task, attempt int, logger *slog.Logger) (func(), error) {
+	var ids []string
+	stop := func() {
+		// Stop dependents before what they depend on.
+		for _, id := range slices.Backward(ids) {
+			if err := e.client.ContainerStop(ctx, id, stopOptions(exec.Job)); err != nil {
+				logger.Warn("failed to stop sidecar", "container_id", id, "error", err)
+			}
+			_ = e.client.ContainerRemove(ctx, id, container.RemoveOptions{Force: true})
+		}
+	}
+
+	sidecars, err := exec.Job.SidecarStartOrder()
+	if err != nil {
+		return stop, err
+	}
+	securityOpt, err := resolveSecurityOpt(exec.Job.SecurityOpt)
+	if err != nil {
+		return stop, err
+	}
+	platform, err := e.platformFor(exec.Job)
+	if err != nil {
+		return stop, err
+	}
+	for _, sidecar := range sidecars {
+		env := make([]string, 0, len(sidecar.Env))
+		for _, k := range slices.Sorted(maps.Keys(sidecar.Env)) {
+			env = append(env, k+"="+sidecar.Env[k])
+		}
+		labels := e.containerLabels(exec, task, attempt, time.Now())
+		labels[labelSidecar] = sidecar.Name
+
+		resp, err := e.client.ContainerCreate(ctx, &container.Config{
+			Image:      sidecar.Image,
+			Entrypoint: sidecar.Command,
+			Cmd:        sidecar.Args,
+			Env:        append(env, e.taskEnv(exec, task, attempt)...),
+			Labels:     labels,
+		}, &container.HostConfig{
+			NetworkMode: container.NetworkMode("container:" + mainID),
+			SecurityOpt: securityOpt,
+			Resources:   container.Resources{CgroupParent: e.cgroupParent},
+			Init:        initFlag(e.init),
+		}, nil, platform, "")
+		if err != nil {
+			return stop, fmt.Errorf("sidecar %s: container create failed: %w", sidecar.Name, err)
+		}
+		ids = append(ids, resp.ID)
+
+		sidecarLogger := logger.With("sidecar", sidecar.Name, "container_id", resp.ID)
+		sidecarLogger.Info("starting sidecar")
+		if err := e.client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+			return stop, fmt.Errorf("sidecar %s: container start failed: %w", sidecar.Name, err)
+		}
+		if e.forwardLogs {
+			// Sidecar output goes to the emulator's log only; the
+			// execution's logs are the main container's.
+			go e.streamContainerLogs(ctx, resp.ID, nil, task, sidecarLogger)
+		}
+	}
+	return stop, nil
+}
@@ -0,0 +1,83 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// defaultStatsInterval is how often a running container's resource usage is
+// sampled with CollectStats, unless statsInterval is set.
+const defaultStatsInterval = 5 * time.Second
+
+// containerUsage is a container's resource usage as of its last sample.
+type containerUsage struct {
+	// peakMemory is the highest memory usage seen, in bytes.
+	peakMemory int64
+	// cpuTime is the CPU time consumed, which only grows, so it is as of the
+	// last sample and misses at most one interval's worth.
+	cpuTime time.Duration
+}
+
+// sampleUsage samples the container's resource usage straight away and then
+// every statsInterval until the returned function is called, which stops
+// sampling and returns the usage seen.
+func (e *DockerExecutor) sampleUsage(ctx context.Context, containerID string, logger *slog.Logger) func() containerUsage {
+	interval := e.statsInterval
+	if interval <= 0 {
+		interval = defaultStatsInterval
+	}
+
+	var (
+		usage containerUsage
+		wg    sync.WaitGroup
+	)
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if stats, err := e.containerStats(ctx, containerID); err != nil {
+				logger.Debug("failed to sample container stats", "error", err)
+			} else {
+				mem := int64(max(stats.MemoryStats.Usage, stats.MemoryStats.MaxUsage))
+				usage.peakMemory = max(usage.peakMemory, mem)
+				// A stopped container reports zero.
+				if cpu := time.Duration(stats.CPUStats.CPUUsage.TotalUsage); cpu > usage.cpuTime {
+					usage.cpuTime = cpu
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return sync.OnceValue(func() containerUsage {
+		close(done)
+		wg.Wait()
+		return usage
+	})
+}
+
+// containerStats takes a single sample of the container's resource usage.
+func (e *DockerExecutor) containerStats(ctx context.Context, containerID string) (container.StatsResponse, error) {
+	var stats container.StatsResponse
+	resp, err := e.client.ContainerStatsOneShot(ctx, containerID)
+	if err != nil {
+		return stats, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	return stats, err
+}
@@ -1,19 +1,92 @@
 package executor
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/exec"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
 )
 
-type SubprocessExecutor struct{}
+// SubprocessExecutorOpts configures the subprocess executor.
+type SubprocessExecutorOpts struct {
+	// QuietOutput stops commands' stdout and stderr being copied to the
+	// emulator's own. Output is captured in the execution's logs either way.
+	QuietOutput bool
+	// LogOutput forwards commands' output as log records, one per line,
+	// instead of copying it to the emulator's stdout and stderr as is, so
+	// structured (e.g. JSON) logs stay parseable.
+	LogOutput bool
+	// CleanEnv starts commands with only the job's environment, plus the
+	// emulator's cleanEnvAllowlist variables, instead of inheriting the
+	// emulator's whole environment. This is closer to a container, and
+	// surfaces jobs relying on variables they don't set.
+	CleanEnv bool
+}
+
+// cleanEnvAllowlist are the emulator's environment variables commands keep
+// with CleanEnv, without which most commands can't be found or run.
+var cleanEnvAllowlist = []string{"PATH", "HOME", "TMPDIR"}
+
+// defaultCancelGracePeriod is how long a cancelled or timed out command has
+// to exit after SIGTERM before it is killed.
+const defaultCancelGracePeriod = 10 * time.Second
 
-func NewSubprocessExecutor() *SubprocessExecutor {
-	return &SubprocessExecutor{}
+type SubprocessExecutor struct {
+	// stdout and stderr receive a copy of commands' output, if set.
+	stdout io.Writer
+	stderr io.Writer
+	// logOutput forwards output as log records instead.
+	logOutput bool
+	cleanEnv  bool
+	// cancelGracePeriod overrides defaultCancelGracePeriod when set.
+	cancelGracePeriod time.Duration
+
+	mu   sync.Mutex
+	runs map[string]*subprocessRun // by execution name
+}
+
+// subprocessRun tracks an execution being run, so it can be cancelled.
+type subprocessRun struct {
+	mu        sync.Mutex
+	cancelled bool
+	cmd       *exec.Cmd     // the running attempt, if any
+	done      chan struct{} // closed when cmd exits
+}
+
+func NewSubprocessExecutor(opts SubprocessExecutorOpts) *SubprocessExecutor {
+	e := &SubprocessExecutor{cleanEnv: opts.CleanEnv}
+	switch {
+	case opts.QuietOutput:
+	case opts.LogOutput:
+		e.logOutput = true
+	default:
+		e.stdout, e.stderr = os.Stdout, os.Stderr
+	}
+	return e
+}
+
+// baseEnv returns the environment commands start from, before the job's.
+func (e *SubprocessExecutor) baseEnv() []string {
+	if !e.cleanEnv {
+		return os.Environ()
+	}
+	// Non-nil even if empty: a nil Env makes exec.Cmd inherit everything.
+	env := []string{}
+	for _, k := range cleanEnvAllowlist {
+		if v, ok := os.LookupEnv(k); ok {
+			env = append(env, k+"="+v)
+		}
+	}
+	return env
 }
 
 func (e *SubprocessExecutor) Run(execution *state.Execution, env map[string]string) {
@@ -21,36 +94,287 @@ func (e *SubprocessExecutor) Run(execution *state.Execution, env map[string]stri
 
 	if len(execution.Job.Command) == 0 {
 		logger.Error("no command specified for job")
-		execution.Status = state.StatusFailed
-		execution.ErrorMessage = "no command specified"
-		execution.FailedCount = 1
-		execution.CompletionTime = time.Now()
+		failExecution(execution, "no command specified")
 		return
 	}
 
-	cmd := exec.Command(execution.Job.Command[0], execution.Job.Command[1:]...)
-	cmd.Env = os.Environ()
+	if len(execution.Job.SecurityOpt) > 0 {
+		logger.Warn("ignoring security options with the subprocess executor", "security_opt", execution.Job.SecurityOpt)
+	}
+	if execution.Job.StartupProbe != nil {
+		logger.Warn("ignoring startup probe with the subprocess executor")
+		markRunning(execution)
+	}
+	if execution.Job.EnvFilePath != "" {
+		logger.Warn("ignoring env file path with the subprocess executor", "env_file_path", execution.Job.EnvFilePath)
+	}
+	if execution.Job.Hostname != "" {
+		logger.Warn("ignoring hostname with the subprocess executor", "hostname", execution.Job.Hostname)
+	}
+	if len(execution.Job.Volumes) > 0 {
+		logger.Warn("ignoring volumes with the subprocess executor", "volumes", len(execution.Job.Volumes))
+	}
+	if execution.Job.ReadOnlyRoot {
+		logger.Warn("ignoring read_only_root with the subprocess executor")
+	}
+	if len(execution.Job.Sidecars) > 0 {
+		logger.Warn("ignoring sidecar containers with the subprocess executor", "sidecars", len(execution.Job.Sidecars))
+	}
+
+	run := e.track(execution.Name)
+	defer e.untrack(execution.Name)
+
+	// Tasks run one at a time, whatever the execution's reported
+	// parallelism.
+	tasks := int(execution.Tasks())
+	for task := range tasks {
+		taskLogger := logger
+		if tasks > 1 {
+			taskLogger = logger.With("task", task)
+		}
+		if !e.runTask(execution, run, task, env, taskLogger) || run.isCancelled() {
+			// CancelExecution already recorded the outcome; don't start the
+			// remaining tasks.
+			return
+		}
+	}
+
+	finishExecution(execution)
+}
+
+// runTask runs one task of execution to completion, retrying failed attempts
+// as the job allows, and counts it as succeeded or failed. It returns false
+// if the execution was cancelled.
+func (e *SubprocessExecutor) runTask(execution *state.Execution, run *subprocessRun, task int, env map[string]string, logger *slog.Logger) bool {
+	startTask(execution, task)
+	for attempt := 0; ; attempt++ {
+		result, err := e.runAttempt(execution, run, task, attempt, env, logger)
+		if errors.Is(err, errRunCancelled) {
+			logger.Info("subprocess stopped after cancellation")
+			return false
+		}
+		if err != nil {
+			failTask(execution, task, "", err.Error())
+			return true
+		}
+
+		if !result.timedOut {
+			recordTaskExitCode(execution, task, result.exitCode)
+		}
+		stderrFailed := execution.Job.FailOnStderr && result.wroteStderr
+		if result.succeeded(execution.Job) {
+			logger.Info("subprocess completed successfully")
+			succeedTask(execution, task)
+			return true
+		}
+
+		if attempt < execution.Job.MaxRetries && execution.Job.IsRetryableExitCode(result.exitCode) {
+			logger.Warn("subprocess failed, retrying", "exit_code", result.exitCode, "timed_out", result.timedOut, "retry", attempt+1, "max_retries", execution.Job.MaxRetries)
+			retryTask(execution, task)
+			continue
+		}
+
+		switch {
+		case result.timedOut:
+			logger.Warn("subprocess timed out", "timeout", execution.TaskTimeout())
+			failTask(execution, task, state.ReasonTimedOut, fmt.Sprintf("task timed out after %s", execution.TaskTimeout()))
+		case stderrFailed && execution.Job.IsSuccessExitCode(result.exitCode):
+			logger.Error("subprocess wrote to stderr", "exit_code", result.exitCode)
+			failTask(execution, task, state.ReasonWroteStderr, fmt.Sprintf("subprocess wrote to stderr (exit status %d)", result.exitCode))
+		default:
+			logger.Error("subprocess failed", "exit_code", result.exitCode)
+			failTask(execution, task, "", fmt.Sprintf("exit status %d", result.exitCode))
+		}
+		if !result.timedOut {
+			recordExitCode(execution, result.exitCode)
+		}
+		return true
+	}
+}
+
+// runAttempt runs the job's command once. It returns errRunCancelled if the
+// execution was cancelled, or another error if the command couldn't be run at
+// all, which is not retried.
+func (e *SubprocessExecutor) runAttempt(execution *state.Execution, run *subprocessRun, task, attempt int, env map[string]string, logger *slog.Logger) (containerResult, error) {
+	ctx := context.Background()
+	if execution.TaskTimeout() > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, execution.TaskTimeout())
+		defer cancel()
+	}
+
+	argv := append(slices.Clip(execution.Job.Command), execution.TaskArgs()...)
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	// Run the command in its own process group where supported, so
+	// cancelling or timing it out also stops any processes it started. A
+	// timed out command gets SIGTERM, then is killed if it is still running
+	// after the grace period.
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return terminateProcess(cmd)
+	}
+	if grace := e.gracePeriod(execution); grace > 0 {
+		cmd.WaitDelay = grace
+	} else {
+		cmd.Cancel = func() error {
+			return killProcess(cmd)
+		}
+	}
+	cmd.Dir = execution.Job.WorkingDir
+	cmd.Env = e.baseEnv()
 	for k, v := range env {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
 	}
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Env = append(cmd.Env, taskEnv(execution, task, attempt)...)
+	if execution.Job.Stdin != nil {
+		stdin, err := execution.Job.Stdin.Open()
+		if err != nil {
+			logger.Error("failed to open stdin", "error", err)
+			return containerResult{}, fmt.Errorf("opening stdin: %w", err)
+		}
+		defer stdin.Close()
+		cmd.Stdin = stdin
+	}
 
-	logger.Info("starting subprocess", "command", execution.Job.Command)
+	logger.Info("starting subprocess", "command", argv)
 
-	if err := cmd.Run(); err != nil {
+	var forward *slog.Logger
+	if e.logOutput {
+		forward = logger
+	}
+	wroteStderr, err := captureOutput(forward, execution.Logs, task, false, func(stdout, stderr io.Writer) error {
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		if e.stdout != nil {
+			cmd.Stdout = io.MultiWriter(e.stdout, stdout)
+		}
+		if e.stderr != nil {
+			cmd.Stderr = io.MultiWriter(e.stderr, stderr)
+		}
+		return run.wait(cmd)
+	})
+	timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+	if timedOut {
+		// WaitDelay only kills the command itself; stop anything it
+		// started that outlived it.
+		if err := killProcess(cmd); err != nil {
+			logger.Warn("failed to kill timed out subprocess's processes", "error", err)
+		}
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(err, errRunCancelled) || run.isCancelled():
+		return containerResult{}, errRunCancelled
+	case timedOut:
+		// Even if the command exited cleanly after SIGTERM.
+		return containerResult{exitCode: -1, timedOut: true}, nil
+	case err == nil:
+		return containerResult{wroteStderr: wroteStderr}, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		return containerResult{exitCode: exitErr.ExitCode(), wroteStderr: wroteStderr}, nil
+	default:
+		// The command couldn't start or was killed by a signal.
 		logger.Error("subprocess failed", "error", err)
-		execution.Status = state.StatusFailed
-		execution.FailedCount = 1
-		execution.ErrorMessage = err.Error()
-	} else {
-		logger.Info("subprocess completed successfully")
-		execution.Status = state.StatusSucceeded
-		execution.SucceededCount = 1
+		return containerResult{}, err
+	}
+}
+
+// track registers a run of the named execution for cancellation.
+func (e *SubprocessExecutor) track(name string) *subprocessRun {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.runs == nil {
+		e.runs = make(map[string]*subprocessRun)
+	}
+	run := &subprocessRun{}
+	e.runs[name] = run
+	return run
+}
+
+func (e *SubprocessExecutor) untrack(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.runs, name)
+}
+
+// errRunCancelled is returned by subprocessRun.wait and dockerRun.start for
+// runs cancelled before the command or container started.
+var errRunCancelled = errors.New("execution cancelled")
+
+// wait starts cmd and waits for it to exit, unless the run was cancelled.
+func (r *subprocessRun) wait(cmd *exec.Cmd) error {
+	r.mu.Lock()
+	if r.cancelled {
+		r.mu.Unlock()
+		return errRunCancelled
+	}
+	if err := cmd.Start(); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	done := make(chan struct{})
+	r.cmd, r.done = cmd, done
+	r.mu.Unlock()
+
+	err := cmd.Wait()
+	close(done)
+	r.mu.Lock()
+	r.cmd = nil
+	r.mu.Unlock()
+	return err
+}
+
+func (r *subprocessRun) isCancelled() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cancelled
+}
+
+// Cancel stops the execution's running command and every process it
+// started: the process group gets SIGTERM, then SIGKILL if it is still
+// running after the job's stop timeout, or else the grace period. No further
+// tasks or attempts are started.
+// Cancel returns once the command has exited.
+func (e *SubprocessExecutor) Cancel(execution *state.Execution) error {
+	e.mu.Lock()
+	run, ok := e.runs[execution.Name]
+	e.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("execution %s is not running", execution.Name)
 	}
-	execution.CompletionTime = time.Now()
+
+	run.mu.Lock()
+	run.cancelled = true
+	cmd, done := run.cmd, run.done
+	run.mu.Unlock()
+	if cmd == nil {
+		// Between attempts; the next won't start.
+		return nil
+	}
+
+	if err := terminateProcess(cmd); err != nil {
+		return err
+	}
+	grace := e.gracePeriod(execution)
+	select {
+	case <-done:
+		return nil
+	case <-time.After(grace):
+	}
+
+	slog.Warn("cancelled subprocess still running after grace period, killing it", "execution", execution.Name, "grace_period", grace)
+	if err := killProcess(cmd); err != nil {
+		return err
+	}
+	<-done
+	return nil
 }
 
-func (e *SubprocessExecutor) Cancel(exec *state.Execution) error {
-	return fmt.Errorf("cancel not supported for subprocess executor")
+// gracePeriod returns how long execution's command has to exit after SIGTERM
+// before it is killed: the job's stop timeout, or else the executor's grace
+// period.
+func (e *SubprocessExecutor) gracePeriod(execution *state.Execution) time.Duration {
+	if execution.Job.StopTimeout != nil {
+		return *execution.Job.StopTimeout
+	}
+	return cmp.Or(e.cancelGracePeriod, defaultCancelGracePeriod)
 }
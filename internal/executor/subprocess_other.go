@@ -0,0 +1,26 @@
+//go:build !unix
+
+package executor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup does nothing: without process groups, only the command
+// itself is stopped, not any processes it started.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcess kills cmd's process, as there's no SIGTERM to send.
+func terminateProcess(cmd *exec.Cmd) error {
+	return killProcess(cmd)
+}
+
+// killProcess kills cmd's process.
+func killProcess(cmd *exec.Cmd) error {
+	if err := cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,452 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+func TestSubprocessExecutorPipesStdin(t *testing.T) {
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{})
+	exec := newTestExecution(&state.Job{
+		Name:    "projects/p/locations/l/jobs/cat",
+		Command: []string{"sh", "-c", `test "$(cat)" = hello`},
+		Stdin:   &state.StdinSource{Text: "hello"},
+	})
+
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusSucceeded {
+		t.Fatalf("expected stdin to reach the command, got %s: %s", exec.Status, exec.ErrorMessage)
+	}
+}
+
+func TestSubprocessExecutorCapturesBoundedLogs(t *testing.T) {
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{})
+	exec := newTestExecution(&state.Job{
+		Name:    "projects/p/locations/l/jobs/chatty",
+		Command: []string{"sh", "-c", "for i in 1 2 3 4 5; do echo line $i; done"},
+	})
+	exec.Logs = state.NewLogBuffer(2, 0)
+
+	e.Run(exec, nil)
+
+	lines, truncated := exec.Logs.Snapshot()
+	if truncated != 3 {
+		t.Errorf("truncated = %d, want 3", truncated)
+	}
+	if len(lines) != 2 || lines[0].Text != "line 4" || lines[1].Text != "line 5" {
+		t.Errorf("retained %+v, want the last two lines", lines)
+	}
+}
+
+func TestSubprocessExecutorCapturesFinalLineWithoutNewline(t *testing.T) {
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{})
+	exec := newTestExecution(&state.Job{
+		Name:    "projects/p/locations/l/jobs/unterminated",
+		Command: []string{"sh", "-c", `printf 'first\nlast'; printf 'oops' >&2`},
+	})
+	exec.Logs = state.NewLogBuffer(0, 0)
+
+	e.Run(exec, nil)
+
+	lines, _ := exec.Logs.Snapshot()
+	var got []string
+	for _, line := range lines {
+		got = append(got, line.Stream+": "+line.Text)
+	}
+	slices.Sort(got)
+	if want := []string{"stderr: oops", "stdout: first", "stdout: last"}; !slices.Equal(got, want) {
+		t.Errorf("captured %q, want %q", got, want)
+	}
+}
+
+func TestSubprocessExecutorForwardsOutputUnlessQuiet(t *testing.T) {
+	for _, quiet := range []bool{false, true} {
+		e := NewSubprocessExecutor(SubprocessExecutorOpts{QuietOutput: quiet})
+		var stdout, stderr strings.Builder
+		if e.stdout != nil {
+			e.stdout, e.stderr = &stdout, &stderr
+		}
+		exec := newTestExecution(&state.Job{
+			Name:    "projects/p/locations/l/jobs/echo",
+			Command: []string{"sh", "-c", "echo out; echo err >&2"},
+		})
+		exec.Logs = state.NewLogBuffer(0, 0)
+
+		e.Run(exec, nil)
+
+		if lines, _ := exec.Logs.Snapshot(); len(lines) != 2 {
+			t.Errorf("quiet=%v: captured %+v, want both lines", quiet, lines)
+		}
+		wantOut, wantErr := "out\n", "err\n"
+		if quiet {
+			wantOut, wantErr = "", ""
+		}
+		if stdout.String() != wantOut || stderr.String() != wantErr {
+			t.Errorf("quiet=%v: forwarded %q and %q, want %q and %q", quiet, stdout.String(), stderr.String(), wantOut, wantErr)
+		}
+	}
+}
+
+func TestSubprocessExecutorLogOutput(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{LogOutput: true})
+	if e.stdout != nil || e.stderr != nil {
+		t.Fatal("expected output not to be copied as is")
+	}
+	exec := newTestExecution(&state.Job{
+		Name:    "projects/p/locations/l/jobs/echo",
+		Command: []string{"sh", "-c", "echo out; echo err >&2"},
+	})
+	e.Run(exec, nil)
+
+	var lines []string
+	for _, record := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry struct{ Stream, Line string }
+		if err := json.Unmarshal([]byte(record), &entry); err != nil {
+			t.Fatalf("expected only JSON records, got %q: %v", record, err)
+		}
+		if entry.Line != "" {
+			lines = append(lines, entry.Stream+": "+entry.Line)
+		}
+	}
+	slices.Sort(lines)
+	if want := []string{"stderr: err", "stdout: out"}; !slices.Equal(lines, want) {
+		t.Errorf("expected output records %q, got %q", want, lines)
+	}
+}
+
+func TestSubprocessExecutorAppendsArgs(t *testing.T) {
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{QuietOutput: true})
+	exec := newTestExecution(&state.Job{
+		Name:    "projects/p/locations/l/jobs/args",
+		Command: []string{"sh", "-c", `test "$0 $1" = "first second"`},
+		Args:    []string{"first", "second"},
+	})
+
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusSucceeded {
+		t.Errorf("expected args after the command, got %s: %s", exec.Status, exec.ErrorMessage)
+	}
+}
+
+func TestSubprocessExecutorWorkingDir(t *testing.T) {
+	dir := t.TempDir()
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{QuietOutput: true})
+	exec := newTestExecution(&state.Job{
+		Name:       "projects/p/locations/l/jobs/pwd",
+		Command:    []string{"sh", "-c", "touch marker"},
+		WorkingDir: dir,
+	})
+
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusSucceeded {
+		t.Fatalf("expected status SUCCEEDED, got %s: %s", exec.Status, exec.ErrorMessage)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "marker")); err != nil {
+		t.Errorf("expected the command to run in %s: %v", dir, err)
+	}
+}
+
+func TestSubprocessExecutorCleanEnv(t *testing.T) {
+	t.Setenv("EMULATOR_HOST_ONLY", "leaked")
+	for _, clean := range []bool{false, true} {
+		e := NewSubprocessExecutor(SubprocessExecutorOpts{CleanEnv: clean, QuietOutput: true})
+		exec := newTestExecution(&state.Job{
+			Name:    "projects/p/locations/l/jobs/env",
+			Command: []string{"sh", "-c", `echo "host=$EMULATOR_HOST_ONLY job=$JOB_VAR"`},
+		})
+		exec.Logs = state.NewLogBuffer(0, 0)
+
+		e.Run(exec, map[string]string{"JOB_VAR": "set"})
+
+		if exec.Status != state.StatusSucceeded {
+			t.Fatalf("clean=%v: expected the command to run, got %s: %s", clean, exec.Status, exec.ErrorMessage)
+		}
+		want := "host=leaked job=set"
+		if clean {
+			want = "host= job=set"
+		}
+		if lines, _ := exec.Logs.Snapshot(); len(lines) != 1 || lines[0].Text != want {
+			t.Errorf("clean=%v: got %+v, want %q", clean, lines, want)
+		}
+	}
+}
+
+func TestSubprocessExecutorCleanEnvWithoutAllowlistedVars(t *testing.T) {
+	t.Setenv("EMULATOR_HOST_ONLY", "leaked")
+	for _, k := range cleanEnvAllowlist {
+		t.Setenv(k, "")
+		os.Unsetenv(k)
+	}
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{CleanEnv: true, QuietOutput: true})
+	exec := newTestExecution(&state.Job{
+		Name:    "projects/p/locations/l/jobs/bare",
+		Command: []string{"/bin/sh", "-c", `echo "host=$EMULATOR_HOST_ONLY"`},
+	})
+	exec.OmitTaskEnv = true
+	exec.Logs = state.NewLogBuffer(0, 0)
+
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusSucceeded {
+		t.Fatalf("expected the command to run, got %s: %s", exec.Status, exec.ErrorMessage)
+	}
+	if lines, _ := exec.Logs.Snapshot(); len(lines) != 1 || lines[0].Text != "host=" {
+		t.Errorf("expected none of the emulator's environment, got %+v", lines)
+	}
+}
+
+func TestSubprocessExecutorRunsEveryTask(t *testing.T) {
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{})
+	exec := newTestExecution(&state.Job{
+		Name:    "projects/p/locations/l/jobs/fanout",
+		Command: []string{"sh", "-c", `echo "task $CLOUD_RUN_TASK_INDEX of $CLOUD_RUN_TASK_COUNT"`},
+	})
+	exec.TaskCount = 3
+	exec.Logs = state.NewLogBuffer(0, 0)
+
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusSucceeded || exec.SucceededCount != 3 || exec.FailedCount != 0 {
+		t.Fatalf("expected 3 succeeded tasks, got %s with %d succeeded, %d failed: %s", exec.Status, exec.SucceededCount, exec.FailedCount, exec.ErrorMessage)
+	}
+	lines, _ := exec.Logs.Snapshot()
+	if len(lines) != 3 {
+		t.Fatalf("expected a line per task, got %+v", lines)
+	}
+	for i, line := range lines {
+		if want := fmt.Sprintf("task %d of 3", i); line.Task != i || line.Text != want {
+			t.Errorf("line %d: got task %d %q, want task %d %q", i, line.Task, line.Text, i, want)
+		}
+	}
+}
+
+func TestSubprocessExecutorFailsIfAnyTaskFails(t *testing.T) {
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{})
+	exec := newTestExecution(&state.Job{
+		Name:    "projects/p/locations/l/jobs/fanout",
+		Command: []string{"sh", "-c", `test "$CLOUD_RUN_TASK_INDEX" != 1`},
+	})
+	exec.TaskCount = 3
+
+	e.Run(exec, nil)
+
+	if exec.Status != state.StatusFailed || exec.SucceededCount != 2 || exec.FailedCount != 1 {
+		t.Errorf("expected 2 succeeded and 1 failed task, got %s with %d succeeded, %d failed", exec.Status, exec.SucceededCount, exec.FailedCount)
+	}
+	if !strings.HasPrefix(exec.ErrorMessage, "task 1: ") {
+		t.Errorf("expected the error to name the failed task, got %q", exec.ErrorMessage)
+	}
+}
+
+func TestSubprocessExecutorRetriesFailedTask(t *testing.T) {
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{})
+	// Each attempt appends to the file; the third succeeds.
+	attempts := filepath.Join(t.TempDir(), "attempts")
+	exec := newTestExecution(&state.Job{
+		Name:       "projects/p/locations/l/jobs/flaky",
+		Command:    []string{"sh", "-c", `echo x >> "$ATTEMPTS"; test "$(wc -l < "$ATTEMPTS")" -ge 3`},
+		MaxRetries: 3,
+	})
+
+	e.Run(exec, map[string]string{"ATTEMPTS": attempts})
+
+	if exec.Status != state.StatusSucceeded || exec.SucceededCount != 1 || exec.FailedCount != 0 {
+		t.Fatalf("expected the task to succeed on retry, got %s with %d succeeded, %d failed: %s", exec.Status, exec.SucceededCount, exec.FailedCount, exec.ErrorMessage)
+	}
+	if exec.RetriedCount != 2 {
+		t.Errorf("RetriedCount = %d, want 2", exec.RetriedCount)
+	}
+}
+
+func TestSubprocessExecutorFailOnStderr(t *testing.T) {
+	command := []string{"sh", "-c", "echo something went wrong >&2; exit 0"}
+
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{})
+	lenient := newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/noisy", Command: command})
+	e.Run(lenient, nil)
+	if lenient.Status != state.StatusSucceeded {
+		t.Fatalf("expected stderr to be ignored by default, got %s: %s", lenient.Status, lenient.ErrorMessage)
+	}
+
+	strict := newTestExecution(&state.Job{Name: "projects/p/locations/l/jobs/noisy", Command: command, FailOnStderr: true})
+	e.Run(strict, nil)
+	if strict.Status != state.StatusFailed || strict.FailedCount != 1 {
+		t.Fatalf("expected the task to fail for writing to stderr, got %s with %d failed", strict.Status, strict.FailedCount)
+	}
+	if strict.FailureReason != state.ReasonWroteStderr {
+		t.Errorf("FailureReason = %q, want %q", strict.FailureReason, state.ReasonWroteStderr)
+	}
+}
+
+func TestSubprocessExecutorInjectsTaskMetadata(t *testing.T) {
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{})
+	attempts := filepath.Join(t.TempDir(), "attempts")
+	exec := newTestExecution(&state.Job{
+		Name: "projects/p/locations/l/jobs/sharded",
+		// Fail the first attempt so the retry reports attempt 1.
+		Command:    []string{"sh", "-c", `echo "$CLOUD_RUN_JOB $CLOUD_RUN_EXECUTION $CLOUD_RUN_TASK_INDEX/$CLOUD_RUN_TASK_COUNT $CLOUD_RUN_TASK_ATTEMPT"; echo x >> "$ATTEMPTS"; test "$CLOUD_RUN_TASK_ATTEMPT" = 1`},
+		MaxRetries: 1,
+	})
+	exec.Logs = state.NewLogBuffer(0, 0)
+
+	e.Run(exec, map[string]string{"ATTEMPTS": attempts})
+
+	lines, _ := exec.Logs.Snapshot()
+	var got []string
+	for _, line := range lines {
+		got = append(got, line.Text)
+	}
+	if want := []string{"sharded test 0/1 0", "sharded test 0/1 1"}; !slices.Equal(got, want) {
+		t.Errorf("task metadata %q, want %q", got, want)
+	}
+
+	exec = newTestExecution(&state.Job{
+		Name:    "projects/p/locations/l/jobs/own-env",
+		Command: []string{"sh", "-c", `test -z "$CLOUD_RUN_TASK_INDEX$CLOUD_RUN_JOB"`},
+	})
+	exec.OmitTaskEnv = true
+	e.Run(exec, nil)
+	if exec.Status != state.StatusSucceeded {
+		t.Errorf("expected no task metadata with OmitTaskEnv, got %s: %s", exec.Status, exec.ErrorMessage)
+	}
+}
+
+// startCancellable runs exec on e in the background, returning once its
+// command has started, and a channel closed when Run returns.
+func startCancellable(t *testing.T, e *SubprocessExecutor, exec *state.Execution) <-chan struct{} {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Run(exec, nil)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		e.mu.Lock()
+		run := e.runs[exec.Name]
+		e.mu.Unlock()
+		if run != nil {
+			run.mu.Lock()
+			started := run.cmd != nil
+			run.mu.Unlock()
+			if started {
+				return done
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("command never started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubprocessExecutorCancel(t *testing.T) {
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{})
+	exec := newTestExecution(&state.Job{
+		Name:       "projects/p/locations/l/jobs/sleep",
+		Command:    []string{"sleep", "30"},
+		MaxRetries: 3,
+	})
+	exec.TaskCount = 2
+	done := startCancellable(t, e, exec)
+
+	start := time.Now()
+	if err := e.Cancel(exec); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected SIGTERM to stop sleep promptly, took %s", elapsed)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after Cancel")
+	}
+	if exec.SucceededCount != 0 || exec.FailedCount != 0 || exec.RetriedCount != 0 {
+		t.Errorf("expected no task to be counted or retried, got succeeded=%d failed=%d retried=%d",
+			exec.SucceededCount, exec.FailedCount, exec.RetriedCount)
+	}
+
+	if err := e.Cancel(exec); err == nil {
+		t.Error("expected cancelling a finished execution to fail")
+	}
+}
+
+func TestSubprocessExecutorTimeoutSendsSIGTERM(t *testing.T) {
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{})
+	marker := filepath.Join(t.TempDir(), "terminated")
+	stopTimeout := 5 * time.Second
+	exec := newTestExecution(&state.Job{
+		Name:        "projects/p/locations/l/jobs/graceful",
+		Command:     []string{"sh", "-c", `trap 'touch "$0"; exit 0' TERM; while :; do sleep 0.1; done`, marker},
+		Timeout:     200 * time.Millisecond,
+		StopTimeout: &stopTimeout,
+	})
+
+	start := time.Now()
+	e.Run(exec, nil)
+	if elapsed := time.Since(start); elapsed >= stopTimeout {
+		t.Errorf("expected the command to exit on SIGTERM before the stop timeout, took %s", elapsed)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("expected the command to handle SIGTERM: %v", err)
+	}
+	if exec.Status != state.StatusFailed || exec.FailureReason != state.ReasonTimedOut {
+		t.Errorf("expected a timed out execution, got status %v, reason %q", exec.Status, exec.FailureReason)
+	}
+}
+
+func TestSubprocessExecutorTimeoutKillsAfterGracePeriod(t *testing.T) {
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{})
+	e.cancelGracePeriod = 100 * time.Millisecond
+	exec := newTestExecution(&state.Job{
+		Name:    "projects/p/locations/l/jobs/stubborn",
+		Command: []string{"sh", "-c", `trap "" TERM; while :; do sleep 0.1; done`},
+		Timeout: 200 * time.Millisecond,
+	})
+
+	start := time.Now()
+	e.Run(exec, nil)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected a command ignoring SIGTERM to be killed, took %s", elapsed)
+	}
+	if exec.Status != state.StatusFailed || exec.FailureReason != state.ReasonTimedOut {
+		t.Errorf("expected a timed out execution, got status %v, reason %q", exec.Status, exec.FailureReason)
+	}
+}
+
+func TestSubprocessExecutorCancelKillsAfterGracePeriod(t *testing.T) {
+	e := NewSubprocessExecutor(SubprocessExecutorOpts{})
+	e.cancelGracePeriod = 100 * time.Millisecond
+	exec := newTestExecution(&state.Job{
+		Name:    "projects/p/locations/l/jobs/stubborn",
+		Command: []string{"sh", "-c", `trap "" TERM; while :; do sleep 0.1; done`},
+	})
+	done := startCancellable(t, e, exec)
+
+	if err := e.Cancel(exec); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a command ignoring SIGTERM to be killed")
+	}
+}
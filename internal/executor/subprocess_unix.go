@@ -0,0 +1,34 @@
+//go:build unix
+
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup runs cmd in its own process group, so signalling it also
+// reaches any processes it started.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminateProcess sends SIGTERM to cmd's process group.
+func terminateProcess(cmd *exec.Cmd) error {
+	return signalProcessGroup(cmd, syscall.SIGTERM)
+}
+
+// killProcess sends SIGKILL to cmd's process group.
+func killProcess(cmd *exec.Cmd) error {
+	return signalProcessGroup(cmd, syscall.SIGKILL)
+}
+
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	pgid := cmd.Process.Pid
+	if err := syscall.Kill(-pgid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("signalling process group %d: %w", pgid, err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package executor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// containerSpec is everything a container is created from.
+type containerSpec struct {
+	Config  *container.Config
+	Host    *container.HostConfig
+	Network *network.NetworkingConfig
+	// Platform is nil to let Docker choose.
+	Platform *ocispec.Platform
+}
+
+// key identifies containers created from identical specs.
+func (s containerSpec) key() string {
+	b, _ := json.Marshal(s)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// warmPool keeps containers created ahead of time, so a run can start one
+// instead of waiting on ContainerCreate. A container is never reused once
+// started: each run takes a fresh one and the pool is refilled in the
+// background, so no state carries over between runs. Containers are pooled
+// by their full spec, so a run only takes one created exactly as its own
+// would have been; the pool for a job therefore warms after its first run.
+type warmPool struct {
+	size int
+
+	mu      sync.Mutex
+	idle    map[string][]string // spec key -> created container IDs
+	filling map[string]bool
+	closed  bool
+}
+
+func newWarmPool(size int) *warmPool {
+	return &warmPool{
+		size:    size,
+		idle:    make(map[string][]string),
+		filling: make(map[string]bool),
+	}
+}
+
+// take removes and returns a pooled container created from the spec with the
+// given key.
+func (p *warmPool) take(key string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := p.idle[key]
+	if len(ids) == 0 {
+		return "", false
+	}
+	p.idle[key] = ids[1:]
+	return ids[0], true
+}
+
+// fill creates containers from spec until the pool for it is full. Only one
+// fill per spec runs at a time.
+func (p *warmPool) fill(cli dockerClient, spec containerSpec, logger *slog.Logger) {
+	key := spec.key()
+	p.mu.Lock()
+	if p.filling[key] || p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.filling[key] = true
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.filling, key)
+		p.mu.Unlock()
+	}()
+
+	ctx := context.Background()
+	for {
+		p.mu.Lock()
+		full := p.closed || len(p.idle[key]) >= p.size
+		p.mu.Unlock()
+		if full {
+			return
+		}
+
+		resp, err := cli.ContainerCreate(ctx, spec.Config, spec.Host, spec.Network, spec.Platform, "")
+		if err != nil {
+			logger.Warn("failed to create warm container", "error", err)
+			return
+		}
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			_ = cli.ContainerRemove(ctx, resp.ID, container.RemoveOptions{})
+			return
+		}
+		p.idle[key] = append(p.idle[key], resp.ID)
+		p.mu.Unlock()
+	}
+}
+
+// drain removes every pooled container and stops further refills.
+func (p *warmPool) drain(ctx context.Context, cli dockerClient) {
+	p.mu.Lock()
+	p.closed = true
+	idle := p.idle
+	p.idle = make(map[string][]string)
+	p.mu.Unlock()
+
+	for _, ids := range idle {
+		for _, id := range ids {
+			if err := cli.ContainerRemove(ctx, id, container.RemoveOptions{Force: true}); err != nil {
+				slog.Warn("failed to remove warm container", "container_id", id, "error", err)
+			}
+		}
+	}
+}
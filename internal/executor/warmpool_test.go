@@ -0,0 +1,102 @@
+package executor
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+// waitForIdle waits until the pool holds n idle containers in total.
+func waitForIdle(t *testing.T, p *warmPool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		p.mu.Lock()
+		total := 0
+		for _, ids := range p.idle {
+			total += len(ids)
+		}
+		p.mu.Unlock()
+		if total == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d idle warm containers, have %d", n, total)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// createdConfigEnv returns the env a fake container was created with.
+func createdConfigEnv(f *fakeDockerClient, id string) []string {
+	n, _ := strconv.Atoi(strings.TrimPrefix(id, "container-"))
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.created[n-1].Env
+}
+
+func TestWarmPoolReusesPrecreatedContainers(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake, pool: newWarmPool(1)}
+	job := &state.Job{Name: "projects/p/locations/l/jobs/warm", Image: "alpine:latest"}
+
+	first := newTestExecution(job)
+	e.Run(first, map[string]string{"A": "1"})
+	waitForIdle(t, e.pool, 1)
+
+	second := newTestExecution(job)
+	e.Run(second, map[string]string{"A": "1"})
+	if second.ContainerID != "container-2" {
+		t.Errorf("expected the second run to start the warm container-2, got %s", second.ContainerID)
+	}
+	if second.ContainerID == first.ContainerID {
+		t.Error("expected each run to get a fresh container")
+	}
+	fake.mu.Lock()
+	labels, hostname := fake.created[1].Labels, fake.created[1].Hostname
+	fake.mu.Unlock()
+	if labels[labelJob] != job.Name {
+		t.Errorf("expected the warm container to be labelled with its job, got %v", labels)
+	}
+	if hostname != "warm" {
+		t.Errorf("expected the warm container's hostname to be the job ID, got %q", hostname)
+	}
+	fake.mu.Lock()
+	removed := slices.Contains(fake.removed, first.ContainerID)
+	fake.mu.Unlock()
+	if !removed {
+		t.Errorf("expected the first run's container %s to be removed", first.ContainerID)
+	}
+	waitForIdle(t, e.pool, 1)
+
+	// A run with a different configuration must not take a container
+	// created for another.
+	third := newTestExecution(job)
+	e.Run(third, map[string]string{"A": "2"})
+	if env := createdConfigEnv(fake, third.ContainerID); !slices.Contains(env, "A=2") {
+		t.Errorf("third run used a container created with env %v", env)
+	}
+}
+
+func TestWarmPoolCloseRemovesIdleContainers(t *testing.T) {
+	fake := &fakeDockerClient{}
+	e := &DockerExecutor{client: fake, pool: newWarmPool(2)}
+	job := &state.Job{Name: "projects/p/locations/l/jobs/warm", Image: "alpine:latest"}
+
+	e.Run(newTestExecution(job), nil)
+	waitForIdle(t, e.pool, 2)
+
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitForIdle(t, e.pool, 0)
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.removed) != 3 {
+		t.Errorf("expected the run's container and 2 warm containers removed, got %v", fake.removed)
+	}
+}
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/executor"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+// AdminHandler returns the HTTP handler for the emulator's admin API. These
+// endpoints expose emulator-specific functionality that has no equivalent in
+// the Cloud Run API. Resource names are passed via the "name" query parameter
+// since they contain slashes. With an API key, every request must carry it.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jobs/effective", s.handleEffectiveJob)
+	mux.HandleFunc("POST /jobs/trigger", s.handleTriggerScheduled)
+	mux.HandleFunc("GET /executions/logs", s.handleExecutionLogs)
+	mux.HandleFunc("GET /executions/env", s.handleExecutionEnv)
+	mux.HandleFunc("GET /executions/stats", s.handleExecutionStats)
+	mux.HandleFunc("POST /executions/delete", s.handleDeleteExecutions)
+	mux.HandleFunc("POST /executions/rerun", s.handleRerunExecution)
+	mux.HandleFunc("GET /executions/junit", s.handleJUnitReport)
+	mux.HandleFunc("POST /images/cleanup", s.handleImageCleanup)
+	mux.HandleFunc("POST /projects/reset", s.handleResetProject)
+	mux.HandleFunc("GET /debug/dump", s.handleDebugDump)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	mux.HandleFunc("GET /status", s.handleStatus)
+	if s.opts.APIKey != "" {
+		return apiKeyHandler(s.opts.APIKey, mux)
+	}
+	return mux
+}
+
+// handleStatus reports the concurrent execution limit, how many executions
+// are running and queued, and which concurrency groups have executions
+// waiting on another in the group. It only reads scheduler counters, so it is
+// cheap enough to poll.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.jobs.scheduler.status())
+}
+
+// handleEffectiveJob returns the resolved configuration the next run of a job
+// would use, without actually running it. Secret values are redacted.
+func (s *Server) handleEffectiveJob(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "missing required query parameter: name")
+		return
+	}
+
+	job, err := s.store.GetJob(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	spec, err := resolveRun(job, nil, s.opts.DefaultResources, s.opts.Secrets)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	spec.Env = redactSecretEnv(spec.Env, job)
+	writeJSON(w, http.StatusOK, spec)
+}
+
+// executionEnv is the response of the execution env endpoint.
+type executionEnv struct {
+	Execution string `json:"execution"`
+	// Env is the resolved environment, or null if it isn't known because
+	// the execution was loaded from the state file.
+	Env map[string]string `json:"env"`
+	// Overrides are the env overrides of the RunJob request.
+	Overrides map[string]string `json:"overrides"`
+}
+
+// handleExecutionEnv returns the environment an execution was started
+// with, and which of it came from RunJob overrides, to check how env_from
+// files, the job's env, secrets and overrides were layered. Secret values
+// are redacted.
+func (s *Server) handleExecutionEnv(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "missing required query parameter: name")
+		return
+	}
+
+	exec, err := s.store.GetExecution(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	resp := executionEnv{Execution: exec.Name, Env: redactSecretEnv(exec.Env, exec.Job), Overrides: map[string]string{}}
+	if exec.Overrides != nil && exec.Overrides.Env != nil {
+		resp.Overrides = exec.Overrides.Env
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// executionStats is the response of the execution stats endpoint.
+type executionStats struct {
+	Execution       string `json:"execution"`
+	PeakMemoryBytes int64  `json:"peakMemoryBytes"`
+	// CPUTime is a Go duration string, e.g. "1.5s".
+	CPUTime string `json:"cpuTime"`
+}
+
+// handleExecutionStats returns the resource usage sampled from an
+// execution's containers with the Docker executor's CollectStats.
+func (s *Server) handleExecutionStats(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "missing required query parameter: name")
+		return
+	}
+
+	exec, err := s.store.GetExecution(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	exec = exec.Snapshot()
+	writeJSON(w, http.StatusOK, executionStats{
+		Execution:       exec.Name,
+		PeakMemoryBytes: exec.PeakMemoryBytes,
+		CPUTime:         exec.CPUTime.String(),
+	})
+}
+
+// executionLogs is the response of the execution logs endpoint.
+type executionLogs struct {
+	Execution string          `json:"execution"`
+	Lines     []state.LogLine `json:"lines"`
+	// Truncated counts the oldest lines dropped to keep the buffer bounded.
+	Truncated int `json:"truncated"`
+}
+
+// handleExecutionLogs returns the captured output of an execution, or of one
+// of its tasks with task_index. With follow=true the lines are streamed as
+// newline-delimited JSON instead, including new ones as they are written,
+// until the execution finishes.
+func (s *Server) handleExecutionLogs(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "missing required query parameter: name")
+		return
+	}
+
+	exec, err := s.store.GetExecution(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	task := -1
+	if v := r.URL.Query().Get("task_index"); v != "" {
+		task, err = strconv.Atoi(v)
+		if err != nil || task < 0 || task >= int(exec.Tasks()) {
+			writeError(w, http.StatusBadRequest, "invalid task_index: "+v)
+			return
+		}
+	}
+	filter := func(lines []state.LogLine) []state.LogLine {
+		if task < 0 {
+			return lines
+		}
+		return slices.DeleteFunc(lines, func(l state.LogLine) bool { return l.Task != task })
+	}
+
+	if follow, _ := strconv.ParseBool(r.URL.Query().Get("follow")); follow {
+		followLogs(w, r, exec, filter)
+		return
+	}
+
+	lines, truncated := exec.Logs.Snapshot()
+	lines = filter(lines)
+	if lines == nil {
+		lines = []state.LogLine{}
+	}
+	writeJSON(w, http.StatusOK, executionLogs{
+		Execution: exec.Name,
+		Lines:     lines,
+		Truncated: truncated,
+	})
+}
+
+// followLogsPoll is how often a followed execution is checked for having
+// finished while it writes nothing.
+const followLogsPoll = 100 * time.Millisecond
+
+// followLogs streams exec's log lines, passed through filter, as
+// newline-delimited JSON until the execution finishes or the client goes
+// away.
+func followLogs(w http.ResponseWriter, r *http.Request, exec *state.Execution, filter func([]state.LogLine) []state.LogLine) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	ticker := time.NewTicker(followLogsPoll)
+	defer ticker.Stop()
+	next := 0
+	for {
+		// Check before reading, so the lines written up to the finish are
+		// sent before returning.
+		exec.Lock()
+		done := exec.Status.IsTerminal()
+		exec.Unlock()
+		changed := exec.Logs.Changed()
+		var lines []state.LogLine
+		lines, next = exec.Logs.Since(next)
+		for _, line := range filter(lines) {
+			if err := enc.Encode(line); err != nil {
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if done {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleImageCleanup removes images pulled by the executor, reporting the
+// bytes reclaimed. Pass dry_run=true to list what would be removed.
+func (s *Server) handleImageCleanup(w http.ResponseWriter, r *http.Request) {
+	if !s.opts.ImageCleanup {
+		writeError(w, http.StatusForbidden, "image cleanup is disabled")
+		return
+	}
+	cleaner, ok := s.executors.active().(executor.ImageCleaner)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "executor does not pull images")
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	report, err := cleaner.CleanupImages(r.Context(), dryRun)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// executionsDeleted is the response of the bulk execution delete endpoint.
+type executionsDeleted struct {
+	Job        string `json:"job"`
+	Executions int    `json:"executions"`
+}
+
+// handleDeleteExecutions removes every finished execution of a job, e.g. to
+// clear its history between test runs. Unfinished executions are kept. The
+// job itself need not still exist.
+func (s *Server) handleDeleteExecutions(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "missing required query parameter: name")
+		return
+	}
+
+	n := s.store.DeleteExecutionsForJob(name)
+	slog.Info("deleted executions", "job", name, "executions", n)
+	writeJSON(w, http.StatusOK, executionsDeleted{Job: name, Executions: n})
+}
+
+// projectReset is the response of the project reset endpoint.
+type projectReset struct {
+	Project    string `json:"project"`
+	Jobs       int    `json:"jobs"`
+	Executions int    `json:"executions"`
+}
+
+// handleResetProject removes every job and execution in a project, so
+// parallel test suites can each use their own project as a namespace and
+// clear it without affecting the others. Unfinished executions are cancelled
+// first.
+func (s *Server) handleResetProject(w http.ResponseWriter, r *http.Request) {
+	project := r.URL.Query().Get("project")
+	if project == "" || strings.Contains(project, "/") {
+		writeError(w, http.StatusBadRequest, "missing or invalid query parameter: project")
+		return
+	}
+
+	prefix := "projects/" + project + "/"
+	for _, exec := range s.store.ListExecutions("") {
+		if strings.HasPrefix(exec.Name, prefix) && !exec.Snapshot().Status.IsTerminal() {
+			cancelExecution(s.executors, exec)
+		}
+	}
+	jobs, executions := s.store.ResetProject(project)
+	slog.Info("reset project", "project", project, "jobs", jobs, "executions", executions)
+	writeJSON(w, http.StatusOK, projectReset{Project: project, Jobs: jobs, Executions: executions})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("failed to write admin response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
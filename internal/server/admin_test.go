@@ -0,0 +1,987 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	runpb "cloud.google.com/go/run/apiv2/runpb"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/executor"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/server"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+func startAdminServer(t *testing.T, store *state.Store) *httptest.Server {
+	t.Helper()
+	srv := server.New(store, executor.NewSubprocessExecutor(executor.SubprocessExecutorOpts{}), server.Opts{ProjectID: "test-project", Region: "us-central1"})
+	ts := httptest.NewServer(srv.AdminHandler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestAdminEffectiveJob(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:    "projects/test-project/locations/us-central1/jobs/effective",
+		Image:   "alpine:latest",
+		Command: []string{"echo", "hi"},
+		Env:     map[string]string{"FOO": "bar"},
+		Timeout: 90 * time.Second,
+	})
+	ts := startAdminServer(t, store)
+
+	resp, err := http.Get(ts.URL + "/jobs/effective?name=" + url.QueryEscape("projects/test-project/locations/us-central1/jobs/effective"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var spec struct {
+		Image   string            `json:"image"`
+		Command []string          `json:"command"`
+		Env     map[string]string `json:"env"`
+		Timeout string            `json:"timeout"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Timeout != "1m30s" {
+		t.Errorf("expected timeout 1m30s, got %q", spec.Timeout)
+	}
+	if spec.Image != "alpine:latest" {
+		t.Errorf("unexpected image: %s", spec.Image)
+	}
+	if len(spec.Command) != 2 || spec.Command[0] != "echo" {
+		t.Errorf("unexpected command: %v", spec.Command)
+	}
+	if spec.Env["FOO"] != "bar" {
+		t.Errorf("expected FOO=bar in env, got %v", spec.Env)
+	}
+}
+
+func TestAdminEffectiveJobRedactsSecrets(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:      "projects/test-project/locations/us-central1/jobs/secretive",
+		Image:     "alpine:latest",
+		Env:       map[string]string{"MODE": "batch"},
+		SecretEnv: map[string]string{"TOKEN": "api-token"},
+	})
+	srv := server.New(store, &blockingExecutor{}, server.Opts{Secrets: map[string]string{"api-token": "s3cret"}})
+	ts := httptest.NewServer(srv.AdminHandler())
+	defer ts.Close()
+
+	env, code := getEffectiveEnv(t, ts, "projects/test-project/locations/us-central1/jobs/secretive")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if want := map[string]string{"MODE": "batch", "TOKEN": "[REDACTED]"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("expected env %v, got %v", want, env)
+	}
+}
+
+func TestAdminRequiresAPIKey(t *testing.T) {
+	srv := server.New(state.NewStore(), &blockingExecutor{}, server.Opts{APIKey: "letmein"})
+	ts := httptest.NewServer(srv.AdminHandler())
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		key  string
+		want int
+	}{
+		{key: "", want: http.StatusUnauthorized},
+		{key: "guess", want: http.StatusUnauthorized},
+		{key: "letmein", want: http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/status", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.key != "" {
+			req.Header.Set("x-api-key", tc.key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("key %q: expected %d, got %d", tc.key, tc.want, resp.StatusCode)
+		}
+	}
+}
+
+func TestAdminEffectiveJobNotFound(t *testing.T) {
+	ts := startAdminServer(t, state.NewStore())
+
+	resp, err := http.Get(ts.URL + "/jobs/effective?name=projects/test-project/locations/us-central1/jobs/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func getEffectiveEnv(t *testing.T, ts *httptest.Server, name string) (map[string]string, int) {
+	t.Helper()
+	resp, err := http.Get(ts.URL + "/jobs/effective?name=" + url.QueryEscape(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var spec struct {
+		Env map[string]string `json:"env"`
+	}
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return spec.Env, resp.StatusCode
+}
+
+func TestEnvFromPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.env")
+	overlay := filepath.Join(dir, "overlay.env")
+	if err := os.WriteFile(base, []byte("A=base\nB=base\nC=base\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(overlay, []byte("B=overlay\nC=overlay\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:  "projects/test-project/locations/us-central1/jobs/layered",
+		Image: "alpine:latest",
+		Env:   map[string]string{"C": "explicit"},
+		EnvFrom: []state.EnvSource{
+			{Path: base},
+			{Path: overlay},
+			{Path: filepath.Join(dir, "missing.env"), Optional: true},
+		},
+	})
+	store.SaveJob(&state.Job{
+		Name:    "projects/test-project/locations/us-central1/jobs/required-missing",
+		Image:   "alpine:latest",
+		EnvFrom: []state.EnvSource{{Path: filepath.Join(dir, "missing.env")}},
+	})
+	ts := startAdminServer(t, store)
+
+	env, code := getEffectiveEnv(t, ts, "projects/test-project/locations/us-central1/jobs/layered")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	want := map[string]string{"A": "base", "B": "overlay", "C": "explicit"}
+	for k, v := range want {
+		if env[k] != v {
+			t.Errorf("%s = %q, want %q", k, env[k], v)
+		}
+	}
+
+	if _, code := getEffectiveEnv(t, ts, "projects/test-project/locations/us-central1/jobs/required-missing"); code == http.StatusOK {
+		t.Error("expected error for missing required env_from file")
+	}
+}
+
+func TestAdminExecutionLogs(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{Name: "projects/test-project/locations/us-central1/jobs/logs", Image: "alpine:latest"}
+	store.SaveJob(job)
+	logs := state.NewLogBuffer(2, 0)
+	for _, text := range []string{"one", "two", "three"} {
+		logs.Append(state.LogLine{Stream: "stdout", Text: text})
+	}
+	store.SaveExecution(&state.Execution{Name: job.Name + "/executions/abc", Job: job, Logs: logs})
+	ts := startAdminServer(t, store)
+
+	resp, err := http.Get(ts.URL + "/executions/logs?name=" + url.QueryEscape(job.Name+"/executions/abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var body struct {
+		Lines     []state.LogLine `json:"lines"`
+		Truncated int             `json:"truncated"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Truncated != 1 || len(body.Lines) != 2 || body.Lines[1].Text != "three" {
+		t.Errorf("got %+v, want [two three] with 1 truncated", body)
+	}
+}
+
+func TestAdminExecutionLogsByTask(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{Name: "projects/test-project/locations/us-central1/jobs/fanout", Image: "alpine:latest"}
+	store.SaveJob(job)
+	logs := state.NewLogBuffer(0, 0)
+	for _, line := range []state.LogLine{
+		{Task: 0, Text: "task0 start"},
+		{Task: 1, Text: "task1 start"},
+		{Task: 1, Text: "task1 failed"},
+		{Task: 0, Text: "task0 done"},
+	} {
+		line.Stream = "stdout"
+		logs.Append(line)
+	}
+	name := job.Name + "/executions/abc"
+	store.SaveExecution(&state.Execution{Name: name, Job: job, TaskCount: 2, Logs: logs})
+	ts := startAdminServer(t, store)
+
+	get := func(query string) (int, []string) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/executions/logs?name=" + url.QueryEscape(name) + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Lines []state.LogLine `json:"lines"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		var texts []string
+		for _, l := range body.Lines {
+			texts = append(texts, l.Text)
+		}
+		return resp.StatusCode, texts
+	}
+
+	for query, want := range map[string][]string{
+		"":              {"task0 start", "task1 start", "task1 failed", "task0 done"},
+		"&task_index=0": {"task0 start", "task0 done"},
+		"&task_index=1": {"task1 start", "task1 failed"},
+	} {
+		code, got := get(query)
+		if code != http.StatusOK || !slices.Equal(got, want) {
+			t.Errorf("%q: got %d %v, want %v", query, code, got, want)
+		}
+	}
+	for _, query := range []string{"&task_index=2", "&task_index=-1", "&task_index=x"} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, code)
+		}
+	}
+}
+
+func TestAdminExecutionLogsFollow(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{Name: "projects/test-project/locations/us-central1/jobs/tail", Image: "alpine:latest"}
+	store.SaveJob(job)
+	logs := state.NewLogBuffer(0, 0)
+	logs.Append(state.LogLine{Stream: "stdout", Text: "before"})
+	exec := &state.Execution{Name: job.Name + "/executions/abc", Job: job, Status: state.StatusRunning, Logs: logs}
+	store.SaveExecution(exec)
+	ts := startAdminServer(t, store)
+
+	resp, err := http.Get(ts.URL + "/executions/logs?follow=true&name=" + url.QueryEscape(exec.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected newline-delimited JSON, got %q", ct)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	var line state.LogLine
+	if err := dec.Decode(&line); err != nil || line.Text != "before" {
+		t.Fatalf("expected the existing line first, got %+v (%v)", line, err)
+	}
+
+	logs.Append(state.LogLine{Stream: "stderr", Text: "after"})
+	if err := dec.Decode(&line); err != nil || line.Text != "after" || line.Stream != "stderr" {
+		t.Fatalf("expected the new line to be streamed, got %+v (%v)", line, err)
+	}
+
+	exec.Lock()
+	exec.Status = state.StatusSucceeded
+	exec.Unlock()
+	if err := dec.Decode(&line); err != io.EOF {
+		t.Errorf("expected the stream to end with the execution, got %+v (%v)", line, err)
+	}
+}
+
+func TestAdminDebugDump(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{
+		Name:  "projects/test-project/locations/us-central1/jobs/dumped",
+		Image: "alpine:latest",
+		Env:   map[string]string{"API_TOKEN": "s3cret"},
+	}
+	store.SaveJob(job)
+	store.SaveExecution(&state.Execution{
+		Name:         job.Name + "/executions/abc",
+		Job:          job,
+		Status:       state.StatusFailed,
+		ErrorMessage: "container exited with code 1",
+	})
+	srv := server.New(store, executor.NewSubprocessExecutor(executor.SubprocessExecutorOpts{}), server.Opts{DebugDump: true, Version: "1.2.3"})
+	ts := httptest.NewServer(srv.AdminHandler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/debug/dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body), "s3cret") {
+		t.Errorf("dump leaks an env value: %s", body)
+	}
+
+	var dump struct {
+		Version  string `json:"version"`
+		Executor struct {
+			Healthy bool `json:"healthy"`
+		} `json:"executor"`
+		Jobs []struct {
+			Env map[string]string `json:"env"`
+		} `json:"jobs"`
+		Executions []struct {
+			Status string `json:"status"`
+		} `json:"executions"`
+	}
+	if err := json.Unmarshal(body, &dump); err != nil {
+		t.Fatal(err)
+	}
+	if dump.Version != "1.2.3" || !dump.Executor.Healthy {
+		t.Errorf("unexpected version or executor health: %+v", dump)
+	}
+	if len(dump.Jobs) != 1 || dump.Jobs[0].Env["API_TOKEN"] == "" {
+		t.Errorf("expected the job with its env keys, got %+v", dump.Jobs)
+	}
+	if len(dump.Executions) != 1 || dump.Executions[0].Status != "FAILED" {
+		t.Errorf("expected the failed execution, got %+v", dump.Executions)
+	}
+}
+
+func TestAdminDebugDumpDisabled(t *testing.T) {
+	ts := startAdminServer(t, state.NewStore())
+
+	resp, err := http.Get(ts.URL + "/debug/dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminMetrics(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{
+		Name:    "projects/test-project/locations/us-central1/jobs/measured",
+		Command: []string{"true"},
+		Env:     map[string]string{},
+	}
+	store.SaveJob(job)
+
+	srv := server.New(store, executor.NewSubprocessExecutor(executor.SubprocessExecutorOpts{}), server.Opts{
+		RunJobSyncWait:   5 * time.Second,
+		MetricsExemplars: true,
+	})
+	addr, cleanup := serve(t, srv)
+	defer cleanup()
+	conn := dial(t, addr)
+	defer conn.Close()
+	ts := httptest.NewServer(srv.AdminHandler())
+	defer ts.Close()
+
+	op, err := runpb.NewJobsClient(conn).RunJob(context.Background(), &runpb.RunJobRequest{Name: job.Name})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	if !op.Done {
+		t.Fatal("expected the execution to finish within the sync wait")
+	}
+	var meta runpb.Execution
+	if err := op.Metadata.UnmarshalTo(&meta); err != nil {
+		t.Fatal(err)
+	}
+
+	scrape := func(accept string) (string, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/metrics", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Header.Get("Content-Type"), string(body)
+	}
+
+	counter := `emulator_executions_total{job="` + job.Name + `",status="SUCCEEDED"} 1`
+	count := `emulator_execution_duration_seconds_count{job="` + job.Name + `"} 1`
+	exemplar := ` # {execution="` + meta.Name + `"} `
+
+	contentType, body := scrape("")
+	if !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("expected the Prometheus text format by default, got %q", contentType)
+	}
+	if !strings.Contains(body, counter) || !strings.Contains(body, count) {
+		t.Errorf("missing execution metrics:\n%s", body)
+	}
+	if strings.Contains(body, exemplar) || strings.Contains(body, "# EOF") {
+		t.Errorf("plain format must not include exemplars or EOF:\n%s", body)
+	}
+
+	contentType, body = scrape("application/openmetrics-text; version=1.0.0")
+	if !strings.HasPrefix(contentType, "application/openmetrics-text") {
+		t.Errorf("expected the OpenMetrics format, got %q", contentType)
+	}
+	for _, want := range []string{"# TYPE emulator_executions counter", counter, count, exemplar} {
+		if !strings.Contains(body, want) {
+			t.Errorf("OpenMetrics output missing %q:\n%s", want, body)
+		}
+	}
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Errorf("OpenMetrics output must end with # EOF:\n%s", body)
+	}
+}
+
+func TestAdminMetricsExemplarsDisabled(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{
+		Name:    "projects/test-project/locations/us-central1/jobs/measured",
+		Command: []string{"true"},
+		Env:     map[string]string{},
+	}
+	store.SaveJob(job)
+
+	srv := server.New(store, executor.NewSubprocessExecutor(executor.SubprocessExecutorOpts{}), server.Opts{RunJobSyncWait: 5 * time.Second})
+	addr, cleanup := serve(t, srv)
+	defer cleanup()
+	conn := dial(t, addr)
+	defer conn.Close()
+	ts := httptest.NewServer(srv.AdminHandler())
+	defer ts.Close()
+
+	if _, err := runpb.NewJobsClient(conn).RunJob(context.Background(), &runpb.RunJobRequest{Name: job.Name}); err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/openmetrics-text")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "emulator_execution_duration_seconds_bucket") {
+		t.Fatalf("missing duration histogram:\n%s", body)
+	}
+	if strings.Contains(string(body), " # {") {
+		t.Errorf("expected no exemplars when disabled:\n%s", body)
+	}
+}
+
+func TestAdminTriggerScheduled(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{
+		Name:     "projects/test-project/locations/us-central1/jobs/nightly",
+		Command:  []string{"true"},
+		Env:      map[string]string{},
+		Schedule: "0 3 * * *",
+	}
+	store.SaveJob(job)
+	store.SaveJob(&state.Job{
+		Name:    "projects/test-project/locations/us-central1/jobs/manual",
+		Command: []string{"true"},
+		Env:     map[string]string{},
+	})
+	srv := server.New(store, executor.NewSubprocessExecutor(executor.SubprocessExecutorOpts{}), server.Opts{LabelRunSource: true})
+	ts := httptest.NewServer(srv.AdminHandler())
+	defer ts.Close()
+
+	trigger := func(name string) *http.Response {
+		t.Helper()
+		resp, err := http.Post(ts.URL+"/jobs/trigger?name="+url.QueryEscape(name), "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := trigger(job.Name)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var got struct {
+		Execution string `json:"execution"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	exec, err := store.GetExecution(got.Execution)
+	if err != nil {
+		t.Fatalf("triggered execution not found: %v", err)
+	}
+	if src := exec.Labels[server.RunSourceLabel]; src != "schedule" {
+		t.Errorf("expected run source schedule, got %q", src)
+	}
+
+	for name, want := range map[string]int{
+		"projects/test-project/locations/us-central1/jobs/manual":  http.StatusBadRequest,
+		"projects/test-project/locations/us-central1/jobs/missing": http.StatusNotFound,
+	} {
+		resp := trigger(name)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: expected %d, got %d", name, want, resp.StatusCode)
+		}
+	}
+}
+
+func TestAdminDeleteExecutions(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{Name: "projects/test-project/locations/us-central1/jobs/history", Env: map[string]string{}}
+	other := &state.Job{Name: "projects/test-project/locations/us-central1/jobs/other", Env: map[string]string{}}
+	store.SaveJob(job)
+	store.SaveJob(other)
+	for _, exec := range []*state.Execution{
+		{Name: job.Name + "/executions/succeeded", Job: job, Status: state.StatusSucceeded},
+		{Name: job.Name + "/executions/failed", Job: job, Status: state.StatusFailed},
+		{Name: job.Name + "/executions/running", Job: job, Status: state.StatusRunning},
+		{Name: other.Name + "/executions/done", Job: other, Status: state.StatusSucceeded},
+	} {
+		store.SaveExecution(exec)
+	}
+	ts := startAdminServer(t, store)
+
+	resp, err := http.Post(ts.URL+"/executions/delete?name="+job.Name, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var got struct {
+		Executions int `json:"executions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Executions != 2 {
+		t.Errorf("expected 2 executions deleted, got %d", got.Executions)
+	}
+	if execs := store.ListExecutions(job.Name); len(execs) != 1 || execs[0].Status != state.StatusRunning {
+		t.Errorf("expected only the running execution to remain, got %v", execs)
+	}
+	if len(store.ListExecutions(other.Name)) != 1 {
+		t.Error("expected the other job's execution to remain")
+	}
+
+	resp, err = http.Post(ts.URL+"/executions/delete", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 without a name, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminExecutionEnv(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{
+		Name:      "projects/test-project/locations/us-central1/jobs/layered",
+		Image:     "alpine:latest",
+		Env:       map[string]string{"MODE": "batch", "LEVEL": "info"},
+		SecretEnv: map[string]string{"TOKEN": "api-token"},
+	}
+	store.SaveJob(job)
+	exec := &blockingExecutor{release: make(chan struct{})}
+	close(exec.release)
+	srv := server.New(store, exec, server.Opts{Secrets: map[string]string{"api-token": "s3cret"}})
+	addr, cleanup := serve(t, srv)
+	defer cleanup()
+	ts := httptest.NewServer(srv.AdminHandler())
+	defer ts.Close()
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	op, err := runpb.NewJobsClient(conn).RunJob(context.Background(), &runpb.RunJobRequest{
+		Name: job.Name,
+		Overrides: &runpb.RunJobRequest_Overrides{
+			ContainerOverrides: []*runpb.RunJobRequest_Overrides_ContainerOverride{{
+				Env: []*runpb.EnvVar{{Name: "LEVEL", Values: &runpb.EnvVar_Value{Value: "debug"}}},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	var started runpb.Execution
+	if err := op.GetMetadata().UnmarshalTo(&started); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(ts.URL + "/executions/env?name=" + url.QueryEscape(started.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var got struct {
+		Env       map[string]string `json:"env"`
+		Overrides map[string]string `json:"overrides"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"MODE": "batch", "LEVEL": "debug", "TOKEN": "[REDACTED]"}; !reflect.DeepEqual(got.Env, want) {
+		t.Errorf("expected env %v, got %v", want, got.Env)
+	}
+	if want := map[string]string{"LEVEL": "debug"}; !reflect.DeepEqual(got.Overrides, want) {
+		t.Errorf("expected overrides %v, got %v", want, got.Overrides)
+	}
+}
+
+func TestAdminExecutionStats(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{Name: "projects/test-project/locations/us-central1/jobs/hungry", Env: map[string]string{}}
+	store.SaveJob(job)
+	store.SaveExecution(&state.Execution{
+		Name:            job.Name + "/executions/e",
+		Job:             job,
+		Status:          state.StatusSucceeded,
+		PeakMemoryBytes: 256 << 20,
+		CPUTime:         1500 * time.Millisecond,
+	})
+	ts := startAdminServer(t, store)
+
+	resp, err := http.Get(ts.URL + "/executions/stats?name=" + url.QueryEscape(job.Name+"/executions/e"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got struct {
+		PeakMemoryBytes int64  `json:"peakMemoryBytes"`
+		CPUTime         string `json:"cpuTime"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || got.PeakMemoryBytes != 256<<20 || got.CPUTime != "1.5s" {
+		t.Errorf("unexpected response %d: %+v", resp.StatusCode, got)
+	}
+}
+
+func TestAdminRerunExecution(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{Name: "projects/test-project/locations/us-central1/jobs/rerun", Image: "alpine:latest", Env: map[string]string{}}
+	store.SaveJob(job)
+	exec := &blockingExecutor{release: make(chan struct{})}
+	close(exec.release)
+	srv := server.New(store, exec, server.Opts{LabelRunSource: true})
+	addr, cleanup := serve(t, srv)
+	defer cleanup()
+	ts := httptest.NewServer(srv.AdminHandler())
+	defer ts.Close()
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	op, err := runpb.NewJobsClient(conn).RunJob(context.Background(), &runpb.RunJobRequest{
+		Name: job.Name,
+		Overrides: &runpb.RunJobRequest_Overrides{
+			TaskCount: 2,
+			ContainerOverrides: []*runpb.RunJobRequest_Overrides_ContainerOverride{{
+				Args: []string{"--day", "7"},
+				Env:  []*runpb.EnvVar{{Name: "PAYLOAD", Values: &runpb.EnvVar_Value{Value: `{"id": 7}`}}},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	var started runpb.Execution
+	if err := op.GetMetadata().UnmarshalTo(&started); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(ts.URL+"/executions/rerun?name="+url.QueryEscape(started.Name), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var got struct {
+		Execution string `json:"execution"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Execution == started.Name {
+		t.Fatal("expected a new execution")
+	}
+	rerun, err := store.GetExecution(got.Execution)
+	if err != nil {
+		t.Fatalf("rerun execution not found: %v", err)
+	}
+	orig, _ := store.GetExecution(started.Name)
+	if rerun.TaskCount != 2 || !reflect.DeepEqual(rerun.Overrides, orig.Overrides) || rerun.Overrides.Env["PAYLOAD"] != `{"id": 7}` {
+		t.Errorf("expected the overrides to be replayed, got %+v (task count %d)", rerun.Overrides, rerun.TaskCount)
+	}
+	if args := rerun.TaskArgs(); !reflect.DeepEqual(args, []string{"--day", "7"}) {
+		t.Errorf("expected the args override to be replayed, got %q", args)
+	}
+	if src := rerun.Labels[server.RunSourceLabel]; src != "rerun" {
+		t.Errorf("expected run source rerun, got %q", src)
+	}
+
+	resp, err = http.Post(ts.URL+"/executions/rerun?name="+url.QueryEscape(job.Name+"/executions/missing"), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing execution, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminResetProject(t *testing.T) {
+	store := state.NewStore()
+	jobA := &state.Job{Name: "projects/suite-a/locations/us-central1/jobs/j", Env: map[string]string{}}
+	jobB := &state.Job{Name: "projects/suite-b/locations/us-central1/jobs/j", Env: map[string]string{}}
+	store.SaveJob(jobA)
+	store.SaveJob(jobB)
+	pending := &state.Execution{Name: jobA.Name + "/executions/pending", Job: jobA, Status: state.StatusPending}
+	store.SaveExecution(pending)
+	store.SaveExecution(&state.Execution{Name: jobB.Name + "/executions/done", Job: jobB, Status: state.StatusSucceeded})
+	ts := startAdminServer(t, store)
+
+	resp, err := http.Post(ts.URL+"/projects/reset?project=suite-a", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var got struct {
+		Jobs       int `json:"jobs"`
+		Executions int `json:"executions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Jobs != 1 || got.Executions != 1 {
+		t.Errorf("expected 1 job and 1 execution removed, got %+v", got)
+	}
+	if pending.Status != state.StatusCancelled {
+		t.Errorf("expected the unfinished execution to be cancelled, got %s", pending.Status)
+	}
+	if _, err := store.GetJob(jobB.Name); err != nil {
+		t.Errorf("expected the other project's job to remain: %v", err)
+	}
+	if len(store.ListExecutions(jobB.Name)) != 1 {
+		t.Error("expected the other project's execution to remain")
+	}
+
+	for _, query := range []string{"", "?project=a/b"} {
+		resp, err := http.Post(ts.URL+"/projects/reset"+query, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestAdminStatusReportsCapacity(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:  "projects/test-project/locations/us-central1/jobs/busy",
+		Image: "alpine:latest",
+		Env:   map[string]string{},
+	})
+	exec := &blockingExecutor{release: make(chan struct{})}
+	srv := server.New(store, exec, server.Opts{MaxConcurrentExecutions: 2})
+	addr, cleanup := serve(t, srv)
+	defer cleanup()
+	ts := httptest.NewServer(srv.AdminHandler())
+	defer ts.Close()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+	client := runpb.NewJobsClient(conn)
+	for range 3 {
+		if _, err := client.RunJob(context.Background(), &runpb.RunJobRequest{Name: "projects/test-project/locations/us-central1/jobs/busy"}); err != nil {
+			t.Fatalf("RunJob failed: %v", err)
+		}
+	}
+
+	type capacity struct {
+		Limit   int `json:"limit"`
+		Running int `json:"running"`
+		Queued  int `json:"queued"`
+	}
+	getStatus := func() capacity {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+		var got capacity
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if got, want := getStatus(), (capacity{Limit: 2, Running: 2, Queued: 1}); got != want {
+		t.Errorf("status with the limit reached: got %+v, want %+v", got, want)
+	}
+
+	close(exec.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for getStatus() != (capacity{Limit: 2}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected all executions to finish, status %+v", getStatus())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := exec.runCount(); n != 3 {
+		t.Errorf("expected 3 executions to run, got %d", n)
+	}
+}
+
+func TestAdminJUnitReport(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{Name: "projects/test-project/locations/us-central1/jobs/nightly", Env: map[string]string{}}
+	other := &state.Job{Name: "projects/test-project/locations/us-central1/jobs/other", Env: map[string]string{}}
+	store.SaveJob(job)
+	store.SaveJob(other)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.SaveExecution(&state.Execution{
+		Name: job.Name + "/executions/ok", Job: job, Status: state.StatusSucceeded,
+		StartTime: start, CompletionTime: start.Add(1500 * time.Millisecond), SucceededCount: 1,
+	})
+	store.SaveExecution(&state.Execution{
+		Name: job.Name + "/executions/bad", Job: job, Status: state.StatusFailed,
+		StartTime: start.Add(time.Minute), CompletionTime: start.Add(time.Minute + time.Second), FailedCount: 1,
+		ErrorMessage: "container exited with code 3 & <stopped>",
+	})
+	store.SaveExecution(&state.Execution{
+		Name: job.Name + "/executions/stopped", Job: job, Status: state.StatusCancelled,
+		StartTime: start.Add(2 * time.Minute), CompletionTime: start.Add(2 * time.Minute),
+	})
+	store.SaveExecution(&state.Execution{
+		Name: other.Name + "/executions/elsewhere", Job: other, Status: state.StatusSucceeded,
+		StartTime: start, CompletionTime: start,
+	})
+	ts := startAdminServer(t, store)
+
+	resp, err := http.Get(ts.URL + "/executions/junit?name=" + url.QueryEscape(job.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var report struct {
+		Tests    int `xml:"tests,attr"`
+		Failures int `xml:"failures,attr"`
+		Skipped  int `xml:"skipped,attr"`
+		Suites   []struct {
+			Name  string `xml:"name,attr"`
+			Cases []struct {
+				Name    string `xml:"name,attr"`
+				Time    string `xml:"time,attr"`
+				Failure *struct {
+					Message string `xml:"message,attr"`
+				} `xml:"failure"`
+				Skipped *struct{} `xml:"skipped"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("report is not well-formed XML: %v", err)
+	}
+	if report.Tests != 3 || report.Failures != 1 || report.Skipped != 1 {
+		t.Errorf("expected 3 tests with 1 failure and 1 skipped, got %+v", report)
+	}
+	if len(report.Suites) != 1 || report.Suites[0].Name != job.Name || len(report.Suites[0].Cases) != 3 {
+		t.Fatalf("expected one suite for the job with 3 cases, got %+v", report.Suites)
+	}
+	cases := report.Suites[0].Cases
+	if cases[0].Name != "ok" || cases[0].Time != "1.500" || cases[0].Failure != nil || cases[0].Skipped != nil {
+		t.Errorf("unexpected passing case %+v", cases[0])
+	}
+	if cases[1].Name != "bad" || cases[1].Failure == nil || cases[1].Failure.Message != "container exited with code 3 & <stopped>" {
+		t.Errorf("unexpected failing case %+v", cases[1])
+	}
+	if cases[2].Name != "stopped" || cases[2].Skipped == nil {
+		t.Errorf("unexpected cancelled case %+v", cases[2])
+	}
+
+	resp, err = http.Get(ts.URL + "/executions/junit?name=" + url.QueryEscape("projects/test-project/locations/us-central1/jobs/missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown job, got %d", resp.StatusCode)
+	}
+}
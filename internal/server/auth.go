@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// apiKeyHeader is the metadata header, and REST request header, carrying the
+// API key.
+const apiKeyHeader = "x-api-key"
+
+// apiKeyInterceptor rejects calls to the Cloud Run and operations services
+// that don't carry key in the x-api-key header. Other services, such as
+// reflection, stay open.
+func apiKeyInterceptor(key string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if requiresAPIKey(info.FullMethod) {
+			if err := checkAPIKey(ctx, key); err != nil {
+				return nil, err
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// requiresAPIKey reports whether the gRPC method is one the API key guards.
+func requiresAPIKey(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/google.cloud.run.v2.") ||
+		strings.HasPrefix(fullMethod, "/google.longrunning.Operations/")
+}
+
+// checkAPIKey returns Unauthenticated unless the incoming metadata of ctx
+// carries key.
+func checkAPIKey(ctx context.Context, key string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	got := md.Get(apiKeyHeader)
+	if len(got) == 0 {
+		return status.Error(codes.Unauthenticated, "missing API key: set the x-api-key header")
+	}
+	if subtle.ConstantTimeCompare([]byte(got[0]), []byte(key)) != 1 {
+		return status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	return nil
+}
+
+// apiKeyHandler rejects HTTP requests that don't carry key in the x-api-key
+// header with 401 Unauthorized.
+func apiKeyHandler(key string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := r.Header.Get(apiKeyHeader)
+		if got == "" {
+			writeError(w, http.StatusUnauthorized, "missing API key: set the x-api-key header")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(key)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
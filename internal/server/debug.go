@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/executor"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+// debugDumpExecutions is how many of the most recent executions the debug
+// dump includes.
+const debugDumpExecutions = 50
+
+// redacted replaces values that may hold secrets in the debug dump.
+const redacted = "[REDACTED]"
+
+// debugDump is a snapshot of the emulator for attaching to bug reports.
+type debugDump struct {
+	Version    string           `json:"version"`
+	GoVersion  string           `json:"goVersion"`
+	Time       time.Time        `json:"time"`
+	Config     any              `json:"config,omitempty"`
+	Executor   debugExecutor    `json:"executor"`
+	Jobs       []debugJob       `json:"jobs"`
+	Executions []debugExecution `json:"executions"`
+}
+
+type debugExecutor struct {
+	Type    string `json:"type"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+type debugJob struct {
+	Name    string            `json:"name"`
+	Image   string            `json:"image"`
+	Command []string          `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+}
+
+type debugExecution struct {
+	Name           string    `json:"name"`
+	Status         string    `json:"status"`
+	StartTime      time.Time `json:"startTime"`
+	CompletionTime time.Time `json:"completionTime,omitzero"`
+	ErrorMessage   string    `json:"errorMessage,omitempty"`
+	FailureReason  string    `json:"failureReason,omitempty"`
+	ExitCode       int32     `json:"exitCode,omitempty"`
+	// KeptContainers are failed attempts' containers left for inspection.
+	KeptContainers []string `json:"keptContainers,omitempty"`
+}
+
+// handleDebugDump returns the emulator's configuration, jobs, recent
+// executions, and executor health as one JSON document. Env values are
+// redacted.
+func (s *Server) handleDebugDump(w http.ResponseWriter, r *http.Request) {
+	if !s.opts.DebugDump {
+		writeError(w, http.StatusForbidden, "debug dump is disabled")
+		return
+	}
+
+	s.mu.RLock()
+	cfg := s.debugConfig
+	s.mu.RUnlock()
+
+	exec := s.executors.active()
+	dump := debugDump{
+		Version:    s.opts.Version,
+		GoVersion:  runtime.Version(),
+		Time:       time.Now(),
+		Config:     cfg,
+		Executor:   debugExecutor{Type: fmt.Sprintf("%T", exec), Healthy: true},
+		Jobs:       []debugJob{},
+		Executions: []debugExecution{},
+	}
+	if hc, ok := exec.(executor.HealthChecker); ok {
+		if err := hc.CheckHealth(r.Context()); err != nil {
+			dump.Executor.Healthy = false
+			dump.Executor.Error = err.Error()
+		}
+	}
+
+	jobs := s.store.ListJobs("")
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	for _, j := range jobs {
+		dump.Jobs = append(dump.Jobs, debugJob{
+			Name:    j.Name,
+			Image:   j.Image,
+			Command: j.Command,
+			Args:    j.Args,
+			Env:     redactEnv(j.Env),
+		})
+	}
+
+	execs := s.store.ListExecutions("")
+	sort.Slice(execs, func(i, j int) bool { return execs[i].StartTime.After(execs[j].StartTime) })
+	for _, e := range execs[:min(len(execs), debugDumpExecutions)] {
+		e = e.Snapshot()
+		dump.Executions = append(dump.Executions, debugExecution{
+			Name:           e.Name,
+			Status:         e.Status.String(),
+			StartTime:      e.StartTime,
+			CompletionTime: e.CompletionTime,
+			ErrorMessage:   e.ErrorMessage,
+			FailureReason:  e.FailureReason,
+			ExitCode:       e.ExitCode,
+			KeptContainers: e.KeptContainerIDs,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, dump)
+}
+
+// redactSecretEnv returns a copy of env with the values of job's
+// secret-backed variables replaced.
+func redactSecretEnv(env map[string]string, job *state.Job) map[string]string {
+	if env == nil {
+		return nil
+	}
+	out := maps.Clone(env)
+	for k := range job.SecretEnv {
+		if _, ok := out[k]; ok {
+			out[k] = redacted
+		}
+	}
+	return out
+}
+
+// redactEnv returns env with every value replaced, keeping the keys.
+func redactEnv(env map[string]string) map[string]string {
+	if len(env) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(env))
+	for k := range env {
+		out[k] = redacted
+	}
+	return out
+}
@@ -3,26 +3,34 @@ package server
 import (
 	"context"
 	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	runpb "cloud.google.com/go/run/apiv2/runpb"
-	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/executor"
 	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
 	longrunningpb "google.golang.org/genproto/googleapis/longrunning"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/anypb"
 )
 
 type ExecutionsServer struct {
 	runpb.UnimplementedExecutionsServer
-	store    *state.Store
-	executor executor.Executor
+	store     *state.Store
+	executors *executorSet
+	names     nameValidator
 }
 
 func (s *ExecutionsServer) GetExecution(ctx context.Context, req *runpb.GetExecutionRequest) (*runpb.Execution, error) {
 	slog.Info("GetExecution called", "name", req.Name)
 
+	if err := s.names.execution(req.Name); err != nil {
+		return nil, err
+	}
+
 	exec, err := s.store.GetExecution(req.Name)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "execution not found: %s", req.Name)
@@ -31,23 +39,180 @@ func (s *ExecutionsServer) GetExecution(ctx context.Context, req *runpb.GetExecu
 	return executionToProto(exec), nil
 }
 
+// ListExecutions lists a job's executions, newest first. The request has no
+// filter field, so a filter is read from the x-emulator-filter metadata
+// header, applied before pagination:
+//
+//	filter    = condition { "AND" condition }
+//	condition = field "=" value
+//	field     = "status" | "labels." key
+//
+// status is an execution state such as RUNNING, SUCCEEDED or FAILED, and
+// values may be double-quoted. Any other field or operator is rejected with
+// InvalidArgument.
 func (s *ExecutionsServer) ListExecutions(ctx context.Context, req *runpb.ListExecutionsRequest) (*runpb.ListExecutionsResponse, error) {
 	slog.Info("ListExecutions called", "parent", req.Parent)
 
-	execs := s.store.ListExecutions(req.Parent)
+	if err := s.names.job(req.Parent); err != nil {
+		return nil, err
+	}
+
+	after, before, err := startTimeRange(ctx)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := labelSelector(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f, err := requestFilter(ctx, executionFilterField)
+	if err != nil {
+		return nil, err
+	}
+
+	var execs []*state.Execution
+	for _, live := range s.store.ListExecutions(req.Parent) {
+		e := live.Snapshot()
+		if !after.IsZero() && e.StartTime.Before(after) {
+			continue
+		}
+		if !before.IsZero() && !e.StartTime.Before(before) {
+			continue
+		}
+		if !hasLabels(e.Labels, labels) {
+			continue
+		}
+		if !f.matches(func(field string) string { return executionField(e, field) }) {
+			continue
+		}
+		execs = append(execs, e)
+	}
+
+	// Newest first, like Cloud Run, with a stable order for paging.
+	sort.Slice(execs, func(i, j int) bool {
+		if !execs[i].StartTime.Equal(execs[j].StartTime) {
+			return execs[i].StartTime.After(execs[j].StartTime)
+		}
+		return execs[i].Name < execs[j].Name
+	})
+
+	start, end, nextToken, err := page(len(execs), req.PageSize, req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+
 	var pbExecs []*runpb.Execution
-	for _, e := range execs {
+	for _, e := range execs[start:end] {
 		pbExecs = append(pbExecs, executionToProto(e))
 	}
 
 	return &runpb.ListExecutionsResponse{
-		Executions: pbExecs,
+		Executions:    pbExecs,
+		NextPageToken: nextToken,
 	}, nil
 }
 
+// Request metadata keys that restrict ListExecutions to executions started
+// in [after, before), as RFC 3339 timestamps. The Cloud Run API has no field
+// for this.
+const (
+	startTimeAfterHeader  = "x-emulator-start-time-after"
+	startTimeBeforeHeader = "x-emulator-start-time-before"
+)
+
+// startTimeRange reads the optional start time bounds from the request
+// metadata. Unset bounds are returned as the zero time.
+func startTimeRange(ctx context.Context) (after, before time.Time, err error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	parse := func(key string) (time.Time, error) {
+		vals := md.Get(key)
+		if len(vals) == 0 || vals[0] == "" {
+			return time.Time{}, nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, vals[0])
+		if err != nil {
+			return time.Time{}, status.Errorf(codes.InvalidArgument, "invalid %s: %v", key, err)
+		}
+		return t, nil
+	}
+	if after, err = parse(startTimeAfterHeader); err != nil {
+		return
+	}
+	before, err = parse(startTimeBeforeHeader)
+	return
+}
+
+// labelHeader is a request metadata key restricting ListExecutions to
+// executions with the given label, as key=value. Repeat it to require several
+// labels.
+const labelHeader = "x-emulator-label"
+
+// labelSelector reads the required labels from the request metadata.
+func labelSelector(ctx context.Context) (map[string]string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var labels map[string]string
+	for _, v := range md.Get(labelHeader) {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: want key=value", labelHeader, v)
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// hasLabels reports whether labels contains every entry of want.
+func hasLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// executionFilterField reports whether executions can be filtered on field.
+func executionFilterField(field string) bool {
+	_, isLabel := labelField(field)
+	return field == "status" || isLabel
+}
+
+// executionField returns the value of a filter field for e.
+func executionField(e *state.Execution, field string) string {
+	if key, ok := labelField(field); ok {
+		return e.Labels[key]
+	}
+	return e.Status.String()
+}
+
+// page returns the bounds of the requested page within n results and the
+// token for the next page. Page tokens are result offsets; a page size of
+// zero or less returns everything after the token.
+func page(n int, size int32, token string) (start, end int, next string, err error) {
+	if token != "" {
+		start, err = strconv.Atoi(token)
+		if err != nil || start < 0 || start > n {
+			return 0, 0, "", status.Errorf(codes.InvalidArgument, "invalid page token: %q", token)
+		}
+	}
+	end = n
+	if size > 0 && start+int(size) < n {
+		end = start + int(size)
+		next = strconv.Itoa(end)
+	}
+	return start, end, next, nil
+}
+
 func (s *ExecutionsServer) DeleteExecution(ctx context.Context, req *runpb.DeleteExecutionRequest) (*longrunningpb.Operation, error) {
 	slog.Info("DeleteExecution called", "name", req.Name)
 
+	if err := s.names.execution(req.Name); err != nil {
+		return nil, err
+	}
+
 	exec, err := s.store.GetExecution(req.Name)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "execution not found: %s", req.Name)
@@ -73,21 +238,20 @@ func (s *ExecutionsServer) DeleteExecution(ctx context.Context, req *runpb.Delet
 func (s *ExecutionsServer) CancelExecution(ctx context.Context, req *runpb.CancelExecutionRequest) (*longrunningpb.Operation, error) {
 	slog.Info("CancelExecution called", "name", req.Name)
 
+	if err := s.names.execution(req.Name); err != nil {
+		return nil, err
+	}
+
 	exec, err := s.store.GetExecution(req.Name)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "execution not found: %s", req.Name)
 	}
 
-	if exec.Status != state.StatusRunning {
-		return nil, status.Errorf(codes.FailedPrecondition, "execution is not running: %s", exec.Status)
+	if current := exec.Snapshot().Status; current.IsTerminal() {
+		return nil, status.Errorf(codes.FailedPrecondition, "execution is not running: %s", current)
 	}
 
-	if err := s.executor.Cancel(exec); err != nil {
-		slog.Warn("failed to cancel execution", "error", err)
-	}
-
-	exec.Status = state.StatusCancelled
-	exec.CompletionTime = time.Now()
+	cancelExecution(s.executors, exec)
 
 	execProto := executionToProto(exec)
 	respAny, err := anypb.New(execProto)
@@ -101,3 +265,22 @@ func (s *ExecutionsServer) CancelExecution(ctx context.Context, req *runpb.Cance
 		Result: &longrunningpb.Operation_Response{Response: respAny},
 	}, nil
 }
+
+// cancelExecution stops an unfinished execution and marks it cancelled.
+func cancelExecution(executors *executorSet, exec *state.Execution) {
+	// Pending executions usually haven't started, so there's nothing to
+	// stop; the scheduler skips them once cancelled. Those waiting on a
+	// startup probe have, though.
+	if exec.Snapshot().Status == state.StatusRunning || executors.running(exec.Name) {
+		if err := executors.forExecution(exec.Name).Cancel(exec); err != nil {
+			slog.Warn("failed to cancel execution", "error", err)
+		}
+	}
+
+	// Tasks that already finished keep their result; the rest are cancelled.
+	exec.Lock()
+	defer exec.Unlock()
+	exec.Status = state.StatusCancelled
+	exec.CancelledCount = max(exec.Tasks()-exec.SucceededCount-exec.FailedCount, 0)
+	exec.CompletionTime = time.Now()
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/executor"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+// executorSet holds the active executor, which can be swapped at runtime
+// (e.g. on SIGHUP). Executions already running stay on the executor that
+// launched them, so it is remembered for cancellation.
+type executorSet struct {
+	mu       sync.RWMutex
+	current  executor.Executor
+	inflight map[string]executor.Executor // keyed by execution name
+	// crashOnPanic re-panics after recording a panicking run, instead of
+	// keeping the emulator up.
+	crashOnPanic bool
+}
+
+func newExecutorSet(exec executor.Executor, crashOnPanic bool) *executorSet {
+	return &executorSet{
+		current:      exec,
+		inflight:     make(map[string]executor.Executor),
+		crashOnPanic: crashOnPanic,
+	}
+}
+
+// active returns the executor new executions are launched on.
+func (x *executorSet) active() executor.Executor {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.current
+}
+
+// swap replaces the executor used for new executions, releasing the idle
+// resources of the old one.
+func (x *executorSet) swap(exec executor.Executor) {
+	x.mu.Lock()
+	old := x.current
+	x.current = exec
+	x.mu.Unlock()
+
+	closeExecutor(old)
+}
+
+// closeExecutor releases an executor's idle resources, such as warm
+// containers, if it holds any.
+func closeExecutor(exec executor.Executor) {
+	if c, ok := exec.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			slog.Warn("failed to close executor", "error", err)
+		}
+	}
+}
+
+// run executes exec on the active executor, blocking until it finishes. A
+// panic in the executor fails the execution rather than the whole emulator.
+func (x *executorSet) run(exec *state.Execution, env map[string]string) {
+	x.mu.Lock()
+	e := x.current
+	x.inflight[exec.Name] = e
+	x.mu.Unlock()
+
+	defer func() {
+		x.mu.Lock()
+		delete(x.inflight, exec.Name)
+		x.mu.Unlock()
+	}()
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		slog.Error("executor panicked", "execution", exec.Name, "panic", r, "stack", string(debug.Stack()))
+		exec.Lock()
+		exec.Status = state.StatusFailed
+		exec.FailedCount = max(exec.Tasks()-exec.SucceededCount, 1)
+		exec.FailureReason = state.ReasonInternalError
+		exec.ErrorMessage = fmt.Sprintf("internal error: executor panicked: %v", r)
+		exec.CompletionTime = time.Now()
+		exec.Unlock()
+		if x.crashOnPanic {
+			panic(r)
+		}
+	}()
+
+	e.Run(exec, env)
+}
+
+// running reports whether the named execution has been launched on an
+// executor and not yet finished.
+func (x *executorSet) running(name string) bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	_, ok := x.inflight[name]
+	return ok
+}
+
+// forExecution returns the executor running the named execution, or the
+// active executor if it isn't running.
+func (x *executorSet) forExecution(name string) executor.Executor {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if e, ok := x.inflight[name]; ok {
+		return e
+	}
+	return x.current
+}
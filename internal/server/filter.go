@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// filterHeader is a request metadata key holding a filter expression for
+// ListJobs and ListExecutions, since the Cloud Run API has no filter field.
+// Only a conjunction of equality conditions is supported, e.g.
+// `status=FAILED AND labels.team=payments`.
+const filterHeader = "x-emulator-filter"
+
+// filterCondition is a single field=value term of a filter expression.
+type filterCondition struct {
+	field string
+	value string
+}
+
+// filter is a parsed filter expression: every condition must hold.
+type filter []filterCondition
+
+// matches reports whether every condition holds, looking up field values
+// with get.
+func (f filter) matches(get func(field string) string) bool {
+	for _, c := range f {
+		if get(c.field) != c.value {
+			return false
+		}
+	}
+	return true
+}
+
+// requestFilter parses the filter expression in the request metadata, if
+// any. known reports whether a field can be filtered on.
+func requestFilter(ctx context.Context, known func(field string) bool) (filter, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	vals := md.Get(filterHeader)
+	if len(vals) == 0 {
+		return nil, nil
+	}
+	return parseFilter(vals[0], known)
+}
+
+// parseFilter parses expr, a list of field=value conditions joined by AND.
+// Values may be double-quoted to include spaces or operator characters.
+func parseFilter(expr string, known func(field string) bool) (filter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	var f filter
+	for i := 0; i < len(tokens); {
+		if len(f) > 0 {
+			if tokens[i].text != "AND" || tokens[i].kind != tokenWord {
+				return nil, filterError(expr, "expected AND, got %q", tokens[i].text)
+			}
+			i++
+		}
+		if i+3 > len(tokens) {
+			return nil, filterError(expr, "incomplete condition")
+		}
+		field, op, value := tokens[i], tokens[i+1], tokens[i+2]
+		i += 3
+		if field.kind != tokenWord {
+			return nil, filterError(expr, "expected a field name, got %q", field.text)
+		}
+		if op.kind != tokenOperator {
+			return nil, filterError(expr, "expected an operator after %q, got %q", field.text, op.text)
+		}
+		if op.text != "=" {
+			return nil, filterError(expr, "unsupported operator %q: only = is supported", op.text)
+		}
+		if !known(field.text) {
+			return nil, filterError(expr, "unsupported field %q", field.text)
+		}
+		f = append(f, filterCondition{field: field.text, value: value.text})
+	}
+	if len(f) == 0 {
+		return nil, filterError(expr, "empty expression")
+	}
+	return f, nil
+}
+
+func filterError(expr, format string, args ...any) error {
+	args = append([]any{filterHeader, expr}, args...)
+	return status.Errorf(codes.InvalidArgument, "invalid %s %q: "+format, args...)
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOperator
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+}
+
+// lexFilter splits a filter expression into words, quoted strings and
+// operators.
+func lexFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			end := strings.IndexByte(expr[i+1:], '"')
+			if end < 0 {
+				return nil, filterError(expr, "unterminated string")
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: expr[i+1 : i+1+end]})
+			i += end + 2
+		case len(tokens) > 0 && tokens[len(tokens)-1].kind == tokenOperator:
+			// Unquoted values run to the next space, so they may contain
+			// operator characters, as in image=alpine:latest.
+			end := i
+			for end < len(expr) && expr[end] != ' ' && expr[end] != '\t' {
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: tokenWord, text: expr[i:end]})
+			i = end
+		case strings.IndexByte("=!<>:~", c) >= 0:
+			n := 1
+			if i+1 < len(expr) && strings.IndexByte("=~", expr[i+1]) >= 0 {
+				n = 2
+			}
+			tokens = append(tokens, filterToken{kind: tokenOperator, text: expr[i : i+n]})
+			i += n
+		default:
+			end := i
+			for end < len(expr) && strings.IndexByte(" \t\"=!<>:~", expr[end]) < 0 {
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: tokenWord, text: expr[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// labelField returns the label key of a labels.<key> filter field.
+func labelField(field string) (string, bool) {
+	key, ok := strings.CutPrefix(field, "labels.")
+	return key, ok && key != ""
+}
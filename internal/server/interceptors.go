@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// loggingInterceptor logs every unary call with its duration and resulting
+// code: successful calls at debug level, failed ones at info, so they show
+// with the default LOG_LEVEL.
+func loggingInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	code := status.Code(err)
+	level := slog.LevelDebug
+	if code != codes.OK {
+		level = slog.LevelInfo
+	}
+	slog.Log(ctx, level, "request", "method", info.FullMethod, "code", code.String(), "duration", time.Since(start))
+	return resp, err
+}
+
+// recoveryInterceptor turns a panic in a unary handler into an Internal
+// error, logging its stack, instead of crashing the emulator. It must run
+// in the handler's goroutine, so it goes last in the chain.
+func recoveryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("panic in request handler", "method", info.FullMethod, "panic", r, "stack", string(debug.Stack()))
+			resp, err = nil, status.Errorf(codes.Internal, "internal error handling %s: %v", info.FullMethod, r)
+		}
+	}()
+	return handler(ctx, req)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	runpb "cloud.google.com/go/run/apiv2/runpb"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecoveryInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Panic"}
+	_, err := recoveryInterceptor(context.Background(), nil, info, func(context.Context, any) (any, error) {
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected an Internal error mentioning the panic, got %v", err)
+	}
+
+	resp, err := recoveryInterceptor(context.Background(), nil, info, func(context.Context, any) (any, error) {
+		return "ok", nil
+	})
+	if resp != "ok" || err != nil {
+		t.Errorf("expected the handler's result to pass through, got %v, %v", resp, err)
+	}
+}
+
+func TestLoggingInterceptor(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	defer slog.SetDefault(prev)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	_, _ = loggingInterceptor(context.Background(), nil, info, func(context.Context, any) (any, error) {
+		return nil, nil
+	})
+	if buf.Len() != 0 {
+		t.Errorf("expected successful calls to log at debug level, got %q", buf.String())
+	}
+
+	_, _ = loggingInterceptor(context.Background(), nil, info, func(context.Context, any) (any, error) {
+		return nil, status.Error(codes.NotFound, "missing")
+	})
+	out := buf.String()
+	if !strings.Contains(out, "method=/test.Service/Method") || !strings.Contains(out, "code=NotFound") || !strings.Contains(out, "duration=") {
+		t.Errorf("expected the failed call to be logged with its method, code and duration, got %q", out)
+	}
+}
+
+func TestTimeoutInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Slow"}
+	handlerErr := make(chan error, 1)
+	_, err := timeoutInterceptor(20*time.Millisecond)(context.Background(), nil, info, func(ctx context.Context, _ any) (any, error) {
+		<-ctx.Done()
+		handlerErr <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	select {
+	case err := <-handlerErr:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the handler's context to pass its deadline, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler's context wasn't cancelled at the deadline")
+	}
+}
+
+// lateValidator reports every image as found, but only once the call's
+// deadline has passed.
+type lateValidator struct{}
+
+func (lateValidator) Run(exec *state.Execution, env map[string]string) {}
+func (lateValidator) Cancel(exec *state.Execution) error               { return nil }
+func (lateValidator) ValidateImage(ctx context.Context, ref string) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestCreateJobAfterDeadline(t *testing.T) {
+	store := state.NewStore()
+	s := New(store, lateValidator{}, Opts{ValidateImages: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := s.jobs.CreateJob(ctx, &runpb.CreateJobRequest{
+		Parent: "projects/test-project/locations/us-central1",
+		JobId:  "late",
+		Job: &runpb.Job{
+			Template: &runpb.ExecutionTemplate{
+				Template: &runpb.TaskTemplate{
+					Containers: []*runpb.Container{{Image: "alpine:latest"}},
+				},
+			},
+		},
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if _, err := store.GetJob("projects/test-project/locations/us-central1/jobs/late"); err == nil {
+		t.Error("expected the job not to be created once the call timed out")
+	}
+}
@@ -1,95 +1,262 @@
 package server
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	runpb "cloud.google.com/go/run/apiv2/runpb"
 	"github.com/google/uuid"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/envfile"
 	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/executor"
 	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
 	longrunningpb "google.golang.org/genproto/googleapis/longrunning"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type JobsServer struct {
 	runpb.UnimplementedJobsServer
 	store     *state.Store
-	executor  executor.Executor
-	projectID string
-	region    string
+	executors *executorSet
+	scheduler *scheduler
+	metrics   *metrics
+	names     nameValidator
+	// defaultSyncWait is how long RunJob waits for an execution to finish
+	// before returning an incomplete operation.
+	defaultSyncWait time.Duration
+	// defaultResources apply beneath a job's own limits.
+	defaultResources state.Resources
+	// defaults fill in the task count and parallelism of jobs that set none.
+	defaults jobDefaults
+	// rejectDeleteWhileRunning makes DeleteJob fail while the job has
+	// pending or running executions.
+	rejectDeleteWhileRunning bool
+	// validateImages rejects CreateJob for images that don't exist.
+	validateImages bool
+	// labelRunSource sets RunSourceLabel on each execution.
+	labelRunSource bool
+	// secrets holds secret values by name, for jobs' secret env vars.
+	secrets map[string]string
+	// omitTaskEnv sets OmitTaskEnv on each execution.
+	omitTaskEnv bool
+	// maxLogLines and maxLogBytes bound each execution's captured logs.
+	maxLogLines int
+	maxLogBytes int
+	// webhook is notified as each execution finishes. It may be nil.
+	webhook *completionWebhook
 }
 
 func (s *JobsServer) RunJob(ctx context.Context, req *runpb.RunJobRequest) (*longrunningpb.Operation, error) {
 	slog.Info("RunJob called", "name", req.Name)
 
+	if err := s.names.job(req.Name); err != nil {
+		return nil, err
+	}
+
 	job, err := s.store.GetJob(req.Name)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "job not found: %s", req.Name)
 	}
 
+	exec, done, err := s.startExecution(job, req.Overrides, runSourceAPI)
+	if err != nil {
+		return nil, err
+	}
+
+	// Give fast jobs a chance to finish so the caller gets a completed
+	// operation without polling.
+	if wait := s.syncWait(ctx); wait > 0 {
+		select {
+		case <-done:
+			slog.Info("execution finished within sync wait", "execution", exec.Name, "status", exec.Snapshot().Status)
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+	}
+
+	s.store.SaveOperation(exec.Name, exec)
+	return executionOperation(exec.Name, exec)
+}
+
+// RunSourceLabel is the execution label recording how an execution was
+// started, when run source labelling is enabled.
+const RunSourceLabel = "run.source"
+
+// runSourceAPI is the run source of executions started with RunJob.
+const runSourceAPI = "api"
+
+// Execution labels linking a retry of a failed execution to the chain of
+// executions before it.
+const (
+	// RetryOfLabel holds the ID of the failed execution a retry reruns.
+	RetryOfLabel = "retry.of"
+	// RetryAttemptLabel holds the number of the retry in its chain, from 1.
+	RetryAttemptLabel = "retry.attempt"
+)
+
+// startExecution creates an execution of job and submits it to the scheduler.
+// The returned channel is closed once the execution finishes. source records
+// what started it (e.g. runSourceAPI).
+func (s *JobsServer) startExecution(job *state.Job, overrides *runpb.RunJobRequest_Overrides, source string) (*state.Execution, <-chan struct{}, error) {
+	return s.startRun(job, overrides, source, nil)
+}
+
+// startRun is startExecution for a run that retries the failed execution
+// retryOf, or for a first run if retryOf is nil. A run that fails is retried
+// with the same overrides while the job allows.
+func (s *JobsServer) startRun(job *state.Job, overrides *runpb.RunJobRequest_Overrides, source string, retryOf *state.Execution) (*state.Execution, <-chan struct{}, error) {
 	executionID := uuid.New().String()[:8]
 	exec := &state.Execution{
-		Name:      fmt.Sprintf("%s/executions/%s", req.Name, executionID),
-		Job:       job,
-		Status:    state.StatusRunning,
-		StartTime: time.Now(),
+		Name:        fmt.Sprintf("%s/executions/%s", job.Name, executionID),
+		Job:         job,
+		Labels:      copyLabels(job.ExecutionLabels),
+		Status:      state.StatusPending,
+		StartTime:   time.Now(),
+		TaskCount:   cmp.Or(job.TaskCount, s.defaults.TaskCount),
+		Parallelism: cmp.Or(job.Parallelism, s.defaults.Parallelism),
+		OmitTaskEnv: s.omitTaskEnv,
+		Logs:        state.NewLogBuffer(s.maxLogLines, s.maxLogBytes),
+		Overrides:   overridesFromProto(overrides),
+	}
+	if overrides.GetTaskCount() > 0 {
+		exec.TaskCount = overrides.GetTaskCount()
 	}
+	if timeout := overrides.GetTimeout(); timeout != nil {
+		if err := timeout.CheckValid(); err != nil || timeout.AsDuration() < 0 {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "invalid timeout override %v", timeout)
+		}
+		exec.Timeout = timeout.AsDuration()
+	}
+	exec.Args = argsOverride(overrides)
 
-	// Merge environment: start with job defaults, then apply overrides
-	env := make(map[string]string)
-	for k, v := range job.Env {
-		env[k] = v
+	if s.labelRunSource {
+		if exec.Labels == nil {
+			exec.Labels = make(map[string]string)
+		}
+		exec.Labels[RunSourceLabel] = source
 	}
-	if req.Overrides != nil {
-		for _, co := range req.Overrides.ContainerOverrides {
-			for _, ev := range co.Env {
-				env[ev.Name] = ev.GetValue()
-			}
+	if retryOf != nil {
+		exec.RetryAttempt = retryOf.RetryAttempt + 1
+		if exec.Labels == nil {
+			exec.Labels = make(map[string]string)
 		}
+		exec.Labels[RetryOfLabel] = path.Base(retryOf.Name)
+		exec.Labels[RetryAttemptLabel] = strconv.Itoa(exec.RetryAttempt)
 	}
 
+	spec, err := resolveRun(job, overrides, s.defaultResources, s.secrets)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.FailedPrecondition, "resolving job configuration: %v", err)
+	}
+	exec.Resources = spec.Resources
+	exec.Env = spec.Env
+
 	s.store.SaveExecution(exec)
 
-	// Run asynchronously
-	go s.executor.Run(exec, env)
+	// Run asynchronously once the scheduler frees a slot.
+	done := make(chan struct{})
+	s.scheduler.submit(job.Name, job.ConcurrencyGroup, func() {
+		defer close(done)
+		defer s.store.Sync()
+		defer s.webhook.notify(exec)
+		exec.Lock()
+		if exec.Status == state.StatusCancelled {
+			// Cancelled while pending.
+			exec.Unlock()
+			return
+		}
+		// With a startup probe, the executor marks the execution running
+		// once the probe passes.
+		if job.StartupProbe == nil {
+			exec.Status = state.StatusRunning
+		}
+		exec.Unlock()
+		slog.Info("execution started", "execution", exec.Name)
+		s.executors.run(exec, spec.Env)
+		s.metrics.observe(exec)
+		if exec.Snapshot().Status == state.StatusFailed && exec.RetryAttempt < job.ExecutionRetries {
+			s.retryExecution(exec, overrides, source)
+		}
+	})
 
-	slog.Info("execution started", "execution", exec.Name)
+	return exec, done, nil
+}
 
-	// Build the Execution proto for the operation metadata
-	execProto := executionToProto(exec)
-	metaAny, err := anypb.New(execProto)
+// retryExecution starts a new execution rerunning the failed execution exec,
+// unless its job has since been deleted.
+func (s *JobsServer) retryExecution(exec *state.Execution, overrides *runpb.RunJobRequest_Overrides, source string) {
+	job, err := s.store.GetJob(exec.Job.Name)
+	if err != nil {
+		slog.Warn("not retrying execution of deleted job", "execution", exec.Name)
+		return
+	}
+	retry, _, err := s.startRun(job, overrides, source, exec)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to marshal metadata: %v", err)
+		slog.Error("failed to retry execution", "execution", exec.Name, "error", err)
+		return
 	}
+	slog.Info("retrying failed execution", "execution", exec.Name, "retry", retry.Name,
+		"attempt", retry.RetryAttempt, "max_retries", job.ExecutionRetries)
+}
 
-	return &longrunningpb.Operation{
-		Name:     exec.Name,
-		Metadata: metaAny,
-		Done:     false,
-	}, nil
+// syncWaitHeader is the request metadata key that overrides the configured
+// RunJob sync wait for a single call, as a Go duration (e.g. "500ms").
+const syncWaitHeader = "x-emulator-sync-wait"
+
+// syncWait returns how long RunJob should wait for the execution to finish
+// before returning.
+func (s *JobsServer) syncWait(ctx context.Context) time.Duration {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(syncWaitHeader); len(vals) > 0 {
+			d, err := time.ParseDuration(vals[0])
+			if err == nil {
+				return d
+			}
+			slog.Warn("ignoring invalid sync wait", "header", syncWaitHeader, "value", vals[0])
+		}
+	}
+	return s.defaultSyncWait
 }
 
 func (s *JobsServer) GetJob(ctx context.Context, req *runpb.GetJobRequest) (*runpb.Job, error) {
 	slog.Info("GetJob called", "name", req.Name)
 
+	if err := s.names.job(req.Name); err != nil {
+		return nil, err
+	}
+
 	job, err := s.store.GetJob(req.Name)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "job not found: %s", req.Name)
 	}
 
-	return jobToProto(job), nil
+	return jobToProto(job, s.defaults), nil
 }
 
 func (s *JobsServer) CreateJob(ctx context.Context, req *runpb.CreateJobRequest) (*longrunningpb.Operation, error) {
 	slog.Info("CreateJob called", "parent", req.Parent, "job_id", req.JobId)
 
+	if err := s.names.parent(req.Parent); err != nil {
+		return nil, err
+	}
+	if err := s.names.jobID(req.JobId); err != nil {
+		return nil, err
+	}
+
 	name := fmt.Sprintf("%s/jobs/%s", req.Parent, req.JobId)
 
 	// Check if job already exists
@@ -97,31 +264,81 @@ func (s *JobsServer) CreateJob(ctx context.Context, req *runpb.CreateJobRequest)
 		return nil, status.Errorf(codes.AlreadyExists, "job already exists: %s", name)
 	}
 
-	job := protoToJob(name, req.Job)
+	job, err := protoToJob(name, req.Job)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid job: %v", err)
+	}
+	if s.validateImages {
+		if err := s.validateImage(ctx, job.Image); err != nil {
+			return nil, err
+		}
+		for _, sidecar := range job.Sidecars {
+			if err := s.validateImage(ctx, sidecar.Image); err != nil {
+				return nil, err
+			}
+		}
+		// The caller has been told the call failed if validation outlasted
+		// the request timeout, so don't create the job behind its back.
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+	}
 	s.store.SaveJob(job)
 
-	jobProto := jobToProto(job)
+	jobProto := jobToProto(job, s.defaults)
 	respAny, err := anypb.New(jobProto)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to marshal response: %v", err)
 	}
 
 	return &longrunningpb.Operation{
-		Name:     name,
-		Done:     true,
-		Result:   &longrunningpb.Operation_Response{Response: respAny},
+		Name:   name,
+		Done:   true,
+		Result: &longrunningpb.Operation_Response{Response: respAny},
 	}, nil
 }
 
+// validateImage checks that ref exists, if the active executor can tell.
+func (s *JobsServer) validateImage(ctx context.Context, ref string) error {
+	v, ok := s.executors.active().(executor.ImageValidator)
+	if !ok {
+		return nil
+	}
+	if err := v.ValidateImage(ctx, ref); err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		if errors.Is(err, executor.ErrImageNotFound) {
+			return status.Errorf(codes.InvalidArgument, "image not found: %s", ref)
+		}
+		return status.Errorf(codes.Unavailable, "validating image: %v", err)
+	}
+	return nil
+}
+
 func (s *JobsServer) DeleteJob(ctx context.Context, req *runpb.DeleteJobRequest) (*longrunningpb.Operation, error) {
 	slog.Info("DeleteJob called", "name", req.Name)
 
+	if err := s.names.job(req.Name); err != nil {
+		return nil, err
+	}
+
 	job, err := s.store.GetJob(req.Name)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "job not found: %s", req.Name)
 	}
 
-	jobProto := jobToProto(job)
+	// Executions keep the job they were started from, so by default they
+	// run to completion and stay resolvable after the job is deleted.
+	if s.rejectDeleteWhileRunning {
+		for _, e := range s.store.ListExecutions(req.Name) {
+			if !e.Snapshot().Status.IsTerminal() {
+				return nil, status.Errorf(codes.FailedPrecondition, "job has unfinished execution %s", e.Name)
+			}
+		}
+	}
+
+	jobProto := jobToProto(job, s.defaults)
 	if err := s.store.DeleteJob(req.Name); err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to delete job: %v", err)
 	}
@@ -141,10 +358,24 @@ func (s *JobsServer) DeleteJob(ctx context.Context, req *runpb.DeleteJobRequest)
 func (s *JobsServer) ListJobs(ctx context.Context, req *runpb.ListJobsRequest) (*runpb.ListJobsResponse, error) {
 	slog.Info("ListJobs called", "parent", req.Parent)
 
+	if req.Parent != "" {
+		if err := s.names.parent(req.Parent); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := requestFilter(ctx, jobFilterField)
+	if err != nil {
+		return nil, err
+	}
+
 	jobs := s.store.ListJobs(req.Parent)
 	var pbJobs []*runpb.Job
 	for _, j := range jobs {
-		pbJobs = append(pbJobs, jobToProto(j))
+		if !f.matches(func(field string) string { return jobField(j, field) }) {
+			continue
+		}
+		pbJobs = append(pbJobs, jobToProto(j, s.defaults))
 	}
 
 	return &runpb.ListJobsResponse{
@@ -152,8 +383,145 @@ func (s *JobsServer) ListJobs(ctx context.Context, req *runpb.ListJobsRequest) (
 	}, nil
 }
 
-// jobToProto converts an internal Job to its protobuf representation.
-func jobToProto(j *state.Job) *runpb.Job {
+// jobFilterField reports whether jobs can be filtered on field. Labels are
+// the job's execution template labels.
+func jobFilterField(field string) bool {
+	_, isLabel := labelField(field)
+	return field == "name" || field == "image" || isLabel
+}
+
+// jobField returns the value of a filter field for j.
+func jobField(j *state.Job, field string) string {
+	if key, ok := labelField(field); ok {
+		return j.ExecutionLabels[key]
+	}
+	switch field {
+	case "name":
+		return j.Name
+	case "image":
+		return j.Image
+	}
+	return ""
+}
+
+// runSpec is the fully-resolved configuration a run of a job will use, after
+// layering request overrides on top of the stored job definition.
+type runSpec struct {
+	Job       string            `json:"job"`
+	Image     string            `json:"image"`
+	Command   []string          `json:"command,omitempty"`
+	Args      []string          `json:"args,omitempty"`
+	Env       map[string]string `json:"env"`
+	Resources state.Resources   `json:"-"`
+	CPU       string            `json:"cpu,omitempty"`
+	Memory    string            `json:"memory,omitempty"`
+	// Timeout is each attempt's limit, e.g. "1h0m0s"; empty means none.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// resolveRun computes the effective run configuration for job. Environment
+// variables are layered, lowest precedence first: EnvFrom files in order, the
+// job's own env, then any container overrides on the request. overrides may
+// be nil. Each resource limit comes from the job's execution template, else
+// its container, else defaults, and args and timeout overrides replace the
+// job's.
+func resolveRun(job *state.Job, overrides *runpb.RunJobRequest_Overrides, defaults state.Resources, secrets map[string]string) (*runSpec, error) {
+	env := make(map[string]string)
+	for _, src := range job.EnvFrom {
+		fileEnv, err := envfile.Read(src.Path)
+		if err != nil {
+			if src.Optional && errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("env_from: %w", err)
+		}
+		for k, v := range fileEnv {
+			env[k] = v
+		}
+	}
+	for k, v := range job.Env {
+		env[k] = v
+	}
+	for k, secret := range job.SecretEnv {
+		v, ok := secrets[secret]
+		if !ok {
+			return nil, fmt.Errorf("env %s: secret %q not found", k, secret)
+		}
+		env[k] = v
+	}
+	if overrides != nil {
+		for _, co := range overrides.ContainerOverrides {
+			for _, ev := range co.Env {
+				env[ev.Name] = ev.GetValue()
+			}
+		}
+	}
+
+	spec := &runSpec{
+		Job:       job.Name,
+		Image:     job.Image,
+		Command:   job.Command,
+		Args:      job.Args,
+		Env:       env,
+		Resources: job.ExecutionResources.Or(job.Resources).Or(defaults),
+	}
+	if args := argsOverride(overrides); len(args) > 0 {
+		spec.Args = args
+	}
+	if spec.Resources.MilliCPU > 0 {
+		spec.CPU = state.FormatCPU(spec.Resources.MilliCPU)
+	}
+	if spec.Resources.MemoryBytes > 0 {
+		spec.Memory = state.FormatMemory(spec.Resources.MemoryBytes)
+	}
+	timeout := job.Timeout
+	if t := overrides.GetTimeout(); t.IsValid() && t.AsDuration() > 0 {
+		timeout = t.AsDuration()
+	}
+	if timeout > 0 {
+		spec.Timeout = timeout.String()
+	}
+	return spec, nil
+}
+
+// argsOverride returns the container args overrides, or nil if there are
+// none. Like env, later container overrides take precedence.
+func argsOverride(overrides *runpb.RunJobRequest_Overrides) []string {
+	var args []string
+	for _, co := range overrides.GetContainerOverrides() {
+		if len(co.Args) > 0 {
+			args = co.Args
+		}
+	}
+	return args
+}
+
+// resourcesToProto converts limits to their protobuf representation, or nil
+// if none are set.
+func resourcesToProto(r state.Resources) *runpb.ResourceRequirements {
+	limits := make(map[string]string)
+	if r.MilliCPU > 0 {
+		limits["cpu"] = state.FormatCPU(r.MilliCPU)
+	}
+	if r.MemoryBytes > 0 {
+		limits["memory"] = state.FormatMemory(r.MemoryBytes)
+	}
+	if len(limits) == 0 {
+		return nil
+	}
+	return &runpb.ResourceRequirements{Limits: limits}
+}
+
+// jobDefaults are the settings reported and used for jobs that leave them
+// unset.
+type jobDefaults struct {
+	TaskCount   int32
+	Parallelism int32
+}
+
+// jobToProto converts an internal Job to its protobuf representation, with
+// defaults filling in unset settings.
+func jobToProto(j *state.Job, defaults jobDefaults) *runpb.Job {
 	var envVars []*runpb.EnvVar
 	for k, v := range j.Env {
 		envVars = append(envVars, &runpb.EnvVar{
@@ -161,20 +529,41 @@ func jobToProto(j *state.Job) *runpb.Job {
 			Values: &runpb.EnvVar_Value{Value: v},
 		})
 	}
+	for k, secret := range j.SecretEnv {
+		envVars = append(envVars, &runpb.EnvVar{
+			Name: k,
+			Values: &runpb.EnvVar_ValueSource{ValueSource: &runpb.EnvVarSource{
+				SecretKeyRef: &runpb.SecretKeySelector{Secret: secret, Version: "latest"},
+			}},
+		})
+	}
+
+	var timeout *durationpb.Duration
+	if j.Timeout > 0 {
+		timeout = durationpb.New(j.Timeout)
+	}
 
 	return &runpb.Job{
 		Name: j.Name,
 		Template: &runpb.ExecutionTemplate{
-			TaskCount:   1,
-			Parallelism: 1,
+			Labels:      copyLabels(j.ExecutionLabels),
+			TaskCount:   max(cmp.Or(j.TaskCount, defaults.TaskCount), 1),
+			Parallelism: max(cmp.Or(j.Parallelism, defaults.Parallelism), 1),
 			Template: &runpb.TaskTemplate{
-				Containers: []*runpb.Container{
+				Timeout: timeout,
+				Retries: &runpb.TaskTemplate_MaxRetries{MaxRetries: int32(j.MaxRetries)},
+				Containers: append([]*runpb.Container{
 					{
-						Image:   j.Image,
-						Command: j.Command,
-						Env:     envVars,
+						Name:       j.ContainerName,
+						Image:      j.Image,
+						Command:    j.Command,
+						Args:       j.Args,
+						WorkingDir: j.WorkingDir,
+						Env:        envVars,
+						Resources:  resourcesToProto(j.Resources),
+						DependsOn:  j.DependsOn,
 					},
-				},
+				}, sidecarsToProto(j.Sidecars, true)...),
 			},
 		},
 		CreateTime: timestamppb.Now(),
@@ -182,44 +571,167 @@ func jobToProto(j *state.Job) *runpb.Job {
 }
 
 // protoToJob converts a protobuf Job to the internal representation.
-func protoToJob(name string, pb *runpb.Job) *state.Job {
+func protoToJob(name string, pb *runpb.Job) (*state.Job, error) {
 	job := &state.Job{
 		Name: name,
 		Env:  make(map[string]string),
 	}
 
+	if pb.Template != nil {
+		job.ExecutionLabels = copyLabels(pb.Template.Labels)
+		if pb.Template.TaskCount < 0 {
+			return nil, fmt.Errorf("invalid task count %d", pb.Template.TaskCount)
+		}
+		job.TaskCount = pb.Template.TaskCount
+		if pb.Template.Parallelism < 0 {
+			return nil, fmt.Errorf("invalid parallelism %d", pb.Template.Parallelism)
+		}
+		job.Parallelism = pb.Template.Parallelism
+	}
+	if timeout := pb.GetTemplate().GetTemplate().GetTimeout(); timeout != nil {
+		if err := timeout.CheckValid(); err != nil || timeout.AsDuration() < 0 {
+			return nil, fmt.Errorf("invalid timeout %v", timeout)
+		}
+		job.Timeout = timeout.AsDuration()
+	}
+	maxRetries := pb.GetTemplate().GetTemplate().GetMaxRetries()
+	if maxRetries < 0 {
+		return nil, fmt.Errorf("invalid max retries %d", maxRetries)
+	}
+	job.MaxRetries = int(maxRetries)
 	if pb.Template != nil && pb.Template.Template != nil && len(pb.Template.Template.Containers) > 0 {
+		// The first container is the main one; the rest are sidecars.
 		c := pb.Template.Template.Containers[0]
+		job.ContainerName = c.Name
 		job.Image = c.Image
 		job.Command = c.Command
+		job.Args = c.Args
+		job.WorkingDir = c.WorkingDir
+		job.DependsOn = c.DependsOn
 		for _, ev := range c.Env {
-			if v := ev.GetValue(); v != "" {
+			if ref := ev.GetValueSource().GetSecretKeyRef(); ref != nil {
+				// The secret store keeps one value per secret.
+				if ref.Version != "" && ref.Version != "latest" {
+					return nil, fmt.Errorf("env %s: secret version %q is not supported, only latest", ev.Name, ref.Version)
+				}
+				if job.SecretEnv == nil {
+					job.SecretEnv = make(map[string]string)
+				}
+				job.SecretEnv[ev.Name] = secretName(ref.Secret)
+			} else if v := ev.GetValue(); v != "" {
 				job.Env[ev.Name] = v
 			}
 		}
+		if c.Resources != nil {
+			resources, err := state.ParseResources(c.Resources.Limits["cpu"], c.Resources.Limits["memory"])
+			if err != nil {
+				return nil, err
+			}
+			job.Resources = resources
+		}
+		for _, c := range pb.Template.Template.Containers[1:] {
+			sidecar := state.Sidecar{
+				Name:      c.Name,
+				Image:     c.Image,
+				Command:   c.Command,
+				Args:      c.Args,
+				DependsOn: c.DependsOn,
+			}
+			for _, ev := range c.Env {
+				if ev.GetValueSource() != nil {
+					return nil, fmt.Errorf("sidecar %s: env %s: secrets are only supported in the main container", c.Name, ev.Name)
+				}
+				if sidecar.Env == nil {
+					sidecar.Env = make(map[string]string)
+				}
+				sidecar.Env[ev.Name] = ev.GetValue()
+			}
+			job.Sidecars = append(job.Sidecars, sidecar)
+		}
+		if _, err := job.SidecarStartOrder(); err != nil {
+			return nil, err
+		}
+	}
+
+	return job, nil
+}
+
+// sidecarsToProto converts sidecars to containers, with their env vars if
+// withEnv is set.
+func sidecarsToProto(sidecars []state.Sidecar, withEnv bool) []*runpb.Container {
+	var containers []*runpb.Container
+	for _, s := range sidecars {
+		c := &runpb.Container{
+			Name:      s.Name,
+			Image:     s.Image,
+			Command:   s.Command,
+			Args:      s.Args,
+			DependsOn: s.DependsOn,
+		}
+		if withEnv {
+			for _, k := range slices.Sorted(maps.Keys(s.Env)) {
+				c.Env = append(c.Env, &runpb.EnvVar{Name: k, Values: &runpb.EnvVar_Value{Value: s.Env[k]}})
+			}
+		}
+		containers = append(containers, c)
 	}
+	return containers
+}
 
-	return job
+// secretName returns the name of a secret referenced either by name or as
+// projects/{project}/secrets/{secret}.
+func secretName(ref string) string {
+	if i := strings.LastIndex(ref, "/secrets/"); i >= 0 {
+		return ref[i+len("/secrets/"):]
+	}
+	return ref
 }
 
 // executionToProto converts an internal Execution to its protobuf representation.
 func executionToProto(e *state.Execution) *runpb.Execution {
+	e = e.Snapshot()
 	exec := &runpb.Execution{
 		Name:           e.Name,
 		Job:            e.Job.Name,
+		Labels:         copyLabels(e.Labels),
 		Reconciling:    e.Status == state.StatusRunning,
 		SucceededCount: e.SucceededCount,
 		FailedCount:    e.FailedCount,
+		CancelledCount: e.CancelledCount,
+		RetriedCount:   e.RetriedCount,
 		StartTime:      timestamppb.New(e.StartTime),
-		TaskCount:      1,
-		Parallelism:    1,
+		TaskCount:      e.Tasks(),
+		Parallelism:    max(e.Parallelism, 1),
 	}
 	if !e.CompletionTime.IsZero() {
 		exec.CompletionTime = timestamppb.New(e.CompletionTime)
 	}
+	// The template reports what the execution actually runs with, after
+	// overrides and defaults. Env vars are left out: they may hold secrets.
+	exec.Template = &runpb.TaskTemplate{
+		Retries: &runpb.TaskTemplate_MaxRetries{MaxRetries: int32(e.Job.MaxRetries)},
+		Containers: append([]*runpb.Container{{
+			Name:      e.Job.ContainerName,
+			Image:     e.Job.Image,
+			Command:   e.Job.Command,
+			Args:      e.TaskArgs(),
+			Resources: resourcesToProto(e.Resources),
+		}}, sidecarsToProto(e.Job.Sidecars, false)...),
+	}
+	if timeout := e.TaskTimeout(); timeout > 0 {
+		exec.Template.Timeout = durationpb.New(timeout)
+	}
 
 	// Map internal status to condition
 	switch e.Status {
+	case state.StatusPending:
+		exec.Conditions = []*runpb.Condition{
+			{
+				Type:    "Started",
+				State:   runpb.Condition_CONDITION_PENDING,
+				Message: "waiting for a free execution slot",
+			},
+		}
 	case state.StatusRunning:
 		exec.RunningCount = 1
 	case state.StatusSucceeded:
@@ -232,12 +744,15 @@ func executionToProto(e *state.Execution) *runpb.Execution {
 	case state.StatusFailed:
 		exec.Conditions = []*runpb.Condition{
 			{
-				Type:  "Completed",
-				State: runpb.Condition_CONDITION_FAILED,
+				Type:    "Completed",
+				State:   runpb.Condition_CONDITION_FAILED,
+				Message: e.ErrorMessage,
 			},
 		}
+		if e.ExitCode != 0 {
+			exec.Conditions[0].Reasons = &runpb.Condition_ExecutionReason_{ExecutionReason: runpb.Condition_NON_ZERO_EXIT_CODE}
+		}
 	case state.StatusCancelled:
-		exec.CancelledCount = 1
 		exec.Conditions = []*runpb.Condition{
 			{
 				Type:  "Completed",
@@ -249,11 +764,14 @@ func executionToProto(e *state.Execution) *runpb.Execution {
 	return exec
 }
 
-// parseJobName extracts the short job name from a full resource name.
-func parseJobName(fullName string) string {
-	parts := strings.Split(fullName, "/")
-	if len(parts) >= 6 {
-		return parts[5]
+// copyLabels returns a copy of labels, or nil if there are none.
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
 	}
-	return fullName
+	return out
 }
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+// junitTestSuites is the root of a JUnit XML report.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite holds the executions of one job.
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase is a single execution.
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Skipped   *junitMessage `xml:"skipped"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// handleJUnitReport renders executions as a JUnit XML report for CI
+// dashboards: a test suite per job and a test case per execution, oldest
+// first. Failed executions are failures; cancelled and unfinished ones are
+// skipped. The name query parameter limits the report to one job.
+func (s *Server) handleJUnitReport(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name != "" {
+		if _, err := s.store.GetJob(name); err != nil && len(s.store.ListExecutions(name)) == 0 {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitReport(s.store.ListExecutions(name), time.Now())); err != nil {
+		slog.Warn("failed to write JUnit report", "error", err)
+	}
+}
+
+// junitReport builds the report for execs, timing unfinished executions up
+// to now.
+func junitReport(execs []*state.Execution, now time.Time) *junitTestSuites {
+	sort.Slice(execs, func(i, j int) bool {
+		if !execs[i].StartTime.Equal(execs[j].StartTime) {
+			return execs[i].StartTime.Before(execs[j].StartTime)
+		}
+		return execs[i].Name < execs[j].Name
+	})
+
+	report := &junitTestSuites{}
+	suites := make(map[string]*junitTestSuite)
+	var order []string
+	durations := make(map[string]time.Duration)
+	for _, exec := range execs {
+		exec = exec.Snapshot()
+		job := exec.Job.Name
+		suite, ok := suites[job]
+		if !ok {
+			suite = &junitTestSuite{Name: job}
+			suites[job] = suite
+			order = append(order, job)
+		}
+
+		end := exec.CompletionTime
+		if end.IsZero() {
+			end = now
+		}
+		elapsed := end.Sub(exec.StartTime)
+		durations[job] += elapsed
+
+		tc := junitTestCase{
+			Name:      path.Base(exec.Name),
+			ClassName: job,
+			Time:      junitSeconds(elapsed),
+		}
+		switch exec.Status {
+		case state.StatusSucceeded:
+		case state.StatusFailed:
+			reason := exec.FailureReason
+			if reason == "" {
+				reason = "Failed"
+			}
+			tc.Failure = &junitMessage{
+				Message: exec.ErrorMessage,
+				Type:    reason,
+				Text:    exec.ErrorMessage + "\n\n" + taskCounts(exec),
+			}
+			suite.Failures++
+		case state.StatusCancelled:
+			tc.Skipped = &junitMessage{Message: "execution was cancelled"}
+			suite.Skipped++
+		default:
+			tc.Skipped = &junitMessage{Message: "execution is still " + exec.Status.String()}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, tc)
+		suite.Tests++
+	}
+
+	sort.Strings(order)
+	for _, job := range order {
+		suite := suites[job]
+		suite.Time = junitSeconds(durations[job])
+		report.Suites = append(report.Suites, *suite)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Skipped += suite.Skipped
+	}
+	return report
+}
+
+// taskCounts summarizes an execution's task results.
+func taskCounts(exec *state.Execution) string {
+	return fmt.Sprintf("tasks: %d succeeded, %d failed, %d total", exec.SucceededCount, exec.FailedCount, exec.Tasks())
+}
+
+// junitSeconds formats a duration as JUnit's decimal seconds.
+func junitSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
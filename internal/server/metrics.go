@@ -0,0 +1,190 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the execution duration
+// histogram.
+var durationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// maxExemplarLabelRunes is the OpenMetrics limit on the combined length of an
+// exemplar's label names and values.
+const maxExemplarLabelRunes = 128
+
+// metrics records execution counts and durations for the metrics endpoint.
+type metrics struct {
+	// exemplars attaches the execution behind the latest observation in each
+	// duration bucket, in OpenMetrics output.
+	exemplars bool
+
+	mu         sync.Mutex
+	executions map[executionKey]uint64
+	durations  map[string]*histogram // keyed by job name
+}
+
+type executionKey struct {
+	job    string
+	status string
+}
+
+type histogram struct {
+	counts    []uint64 // per bucket, not cumulative; the last is +Inf
+	exemplars []*exemplar
+	sum       float64
+	count     uint64
+}
+
+type exemplar struct {
+	execution string
+	value     float64
+	time      time.Time
+}
+
+func newMetrics(exemplars bool) *metrics {
+	return &metrics{
+		exemplars:  exemplars,
+		executions: make(map[executionKey]uint64),
+		durations:  make(map[string]*histogram),
+	}
+}
+
+// observe records a finished execution.
+func (m *metrics) observe(exec *state.Execution) {
+	exec = exec.Snapshot()
+	seconds := exec.CompletionTime.Sub(exec.StartTime).Seconds()
+	job := exec.Job.Name
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.executions[executionKey{job: job, status: exec.Status.String()}]++
+
+	h, ok := m.durations[job]
+	if !ok {
+		h = &histogram{
+			counts:    make([]uint64, len(durationBuckets)+1),
+			exemplars: make([]*exemplar, len(durationBuckets)+1),
+		}
+		m.durations[job] = h
+	}
+	i := sort.SearchFloat64s(durationBuckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+	if m.exemplars {
+		h.exemplars[i] = &exemplar{execution: exec.Name, value: seconds, time: exec.CompletionTime}
+	}
+}
+
+// Content types of the two exposition formats served by the metrics
+// endpoint.
+const (
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	prometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// handleMetrics serves metrics in the OpenMetrics format to clients that
+// accept it, and in the plain Prometheus text format otherwise. Exemplars are
+// only included in OpenMetrics output.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", openMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", prometheusContentType)
+	}
+	s.metrics.write(w, openMetrics)
+}
+
+// write renders the metrics in the OpenMetrics or Prometheus text format.
+func (m *metrics) write(w io.Writer, openMetrics bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// OpenMetrics names counter families without the _total suffix.
+	family := "emulator_executions_total"
+	if openMetrics {
+		family = "emulator_executions"
+	}
+	fmt.Fprintf(w, "# HELP %s Finished executions by job and final status.\n", family)
+	fmt.Fprintf(w, "# TYPE %s counter\n", family)
+	keys := make([]executionKey, 0, len(m.executions))
+	for k := range m.executions {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].job != keys[j].job {
+			return keys[i].job < keys[j].job
+		}
+		return keys[i].status < keys[j].status
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "emulator_executions_total{job=%s,status=%s} %d\n", quoteLabel(k.job), quoteLabel(k.status), m.executions[k])
+	}
+
+	fmt.Fprintln(w, "# HELP emulator_execution_duration_seconds Execution duration from creation to completion.")
+	fmt.Fprintln(w, "# TYPE emulator_execution_duration_seconds histogram")
+	jobs := make([]string, 0, len(m.durations))
+	for job := range m.durations {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+	for _, job := range jobs {
+		h := m.durations[job]
+		var cumulative uint64
+		for i, count := range h.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(durationBuckets) {
+				le = formatFloat(durationBuckets[i])
+			}
+			fmt.Fprintf(w, "emulator_execution_duration_seconds_bucket{job=%s,le=%s} %d", quoteLabel(job), quoteLabel(le), cumulative)
+			if ex := h.exemplars[i]; openMetrics && ex != nil {
+				fmt.Fprintf(w, " # {execution=%s} %s %s", quoteLabel(exemplarExecution(ex.execution)), formatFloat(ex.value), formatFloat(float64(ex.time.UnixMilli())/1000))
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "emulator_execution_duration_seconds_sum{job=%s} %s\n", quoteLabel(job), formatFloat(h.sum))
+		fmt.Fprintf(w, "emulator_execution_duration_seconds_count{job=%s} %d\n", quoteLabel(job), h.count)
+	}
+
+	if openMetrics {
+		fmt.Fprintln(w, "# EOF")
+	}
+}
+
+// exemplarExecution returns the execution label of an exemplar: the full
+// execution name, or just its ID if the name exceeds the OpenMetrics limit.
+func exemplarExecution(name string) string {
+	if len("execution")+len([]rune(name)) <= maxExemplarLabelRunes {
+		return name
+	}
+	return path.Base(name)
+}
+
+// quoteLabel quotes a label value, escaping backslashes, quotes and newlines.
+func quoteLabel(v string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(v) + `"`
+}
+
+// formatFloat formats a sample value, keeping a decimal point on whole
+// numbers as OpenMetrics canonical values require.
+func formatFloat(v float64) string {
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	if !math.IsInf(v, 0) && !math.IsNaN(v) && !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"regexp"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	parentPattern        = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+$`)
+	jobIDPattern         = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	jobNamePattern       = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/jobs/[^/]+$`)
+	executionNamePattern = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/jobs/[^/]+/executions/[^/]+$`)
+)
+
+// nameValidator checks resource names against the Cloud Run naming scheme.
+// In relaxed mode any non-empty name is accepted so the emulator can be
+// wrapped by tools with their own naming.
+type nameValidator struct {
+	relaxed bool
+}
+
+// parent validates a jobs collection parent (projects/{p}/locations/{l}).
+func (v nameValidator) parent(parent string) error {
+	return v.check("parent", parent, parentPattern)
+}
+
+// jobID validates the ID of a job being created.
+func (v nameValidator) jobID(id string) error {
+	return v.check("job_id", id, jobIDPattern)
+}
+
+// job validates a full job resource name.
+func (v nameValidator) job(name string) error {
+	return v.check("name", name, jobNamePattern)
+}
+
+// execution validates a full execution resource name.
+func (v nameValidator) execution(name string) error {
+	return v.check("name", name, executionNamePattern)
+}
+
+func (v nameValidator) check(field, value string, pattern *regexp.Regexp) error {
+	if value == "" {
+		return status.Errorf(codes.InvalidArgument, "%s is required", field)
+	}
+	if v.relaxed || pattern.MatchString(value) {
+		return nil
+	}
+	return status.Errorf(codes.InvalidArgument, "invalid %s: %q", field, value)
+}
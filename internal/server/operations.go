@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+	longrunningpb "google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// OperationsServer serves the long-running operations returned by RunJob, so
+// clients can poll them with GetOperation.
+type OperationsServer struct {
+	longrunningpb.UnimplementedOperationsServer
+	store *state.Store
+}
+
+func (s *OperationsServer) GetOperation(ctx context.Context, req *longrunningpb.GetOperationRequest) (*longrunningpb.Operation, error) {
+	slog.Info("GetOperation called", "name", req.Name)
+
+	exec, err := s.store.GetOperation(req.Name)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "operation not found: %s", req.Name)
+	}
+	return executionOperation(req.Name, exec)
+}
+
+// executionOperation builds the operation tracking exec, done once the
+// execution has finished.
+func executionOperation(name string, exec *state.Execution) (*longrunningpb.Operation, error) {
+	exec = exec.Snapshot()
+	metaAny, err := anypb.New(executionToProto(exec))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to marshal metadata: %v", err)
+	}
+
+	op := &longrunningpb.Operation{
+		Name:     name,
+		Metadata: metaAny,
+		Done:     false,
+	}
+	if exec.Status.IsTerminal() {
+		op.Done = true
+		op.Result = &longrunningpb.Operation_Response{Response: metaAny}
+	}
+	return op, nil
+}
+
+// runJanitor prunes operations whose execution finished more than retention
+// ago, checking every interval until stop is closed.
+func (s *Server) runJanitor(retention, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if n := s.store.PruneOperations(time.Now().Add(-retention)); n > 0 {
+				slog.Debug("pruned completed operations", "count", n)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// runExecutionGC prunes finished executions older than ttl, or beyond the
+// newest maxPerJob of their job, checking every interval until stop is
+// closed. A zero ttl or maxPerJob disables that limit.
+func (s *Server) runExecutionGC(ttl time.Duration, maxPerJob int, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			var cutoff time.Time
+			if ttl > 0 {
+				cutoff = time.Now().Add(-ttl)
+			}
+			if n := s.store.PruneExecutions(cutoff, maxPerJob); n > 0 {
+				slog.Debug("pruned finished executions", "count", n)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+
+	runpb "cloud.google.com/go/run/apiv2/runpb"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// runSourceRerun is the run source of executions started by rerunning an
+// earlier one.
+const runSourceRerun = "rerun"
+
+// handleRerunExecution starts a new execution of an execution's job with the
+// same overrides, e.g. to reproduce a failure without rebuilding the RunJob
+// request. The job's current definition is used, so the job must still
+// exist.
+func (s *Server) handleRerunExecution(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "missing required query parameter: name")
+		return
+	}
+
+	orig, err := s.store.GetExecution(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	job, err := s.store.GetJob(orig.Job.Name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	exec, _, err := s.jobs.startExecution(job, overridesToProto(orig.Overrides), runSourceRerun)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	slog.Info("execution rerun", "execution", orig.Name, "rerun", exec.Name)
+	writeJSON(w, http.StatusOK, triggeredRun{Execution: exec.Name})
+}
+
+// overridesFromProto records the overrides of a RunJob request, or returns
+// nil if there are none.
+func overridesFromProto(o *runpb.RunJobRequest_Overrides) *state.RunOverrides {
+	if o == nil {
+		return nil
+	}
+	out := &state.RunOverrides{TaskCount: o.GetTaskCount(), Timeout: o.GetTimeout().AsDuration(), Args: argsOverride(o)}
+	for _, co := range o.ContainerOverrides {
+		for _, ev := range co.Env {
+			if out.Env == nil {
+				out.Env = make(map[string]string)
+			}
+			out.Env[ev.Name] = ev.GetValue()
+		}
+	}
+	return out
+}
+
+// overridesToProto converts recorded overrides back into RunJob overrides.
+// o may be nil.
+func overridesToProto(o *state.RunOverrides) *runpb.RunJobRequest_Overrides {
+	if o == nil {
+		return nil
+	}
+	out := &runpb.RunJobRequest_Overrides{TaskCount: o.TaskCount}
+	if o.Timeout > 0 {
+		out.Timeout = durationpb.New(o.Timeout)
+	}
+	if len(o.Env) > 0 || len(o.Args) > 0 {
+		co := &runpb.RunJobRequest_Overrides_ContainerOverride{Args: o.Args}
+		for k, v := range o.Env {
+			co.Env = append(co.Env, &runpb.EnvVar{Name: k, Values: &runpb.EnvVar_Value{Value: v}})
+		}
+		out.ContainerOverrides = []*runpb.RunJobRequest_Overrides_ContainerOverride{co}
+	}
+	return out
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	runpb "cloud.google.com/go/run/apiv2/runpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// RESTHandler returns an HTTP handler serving the jobs, executions and tasks
+// methods of the Cloud Run Admin API v2 as REST/JSON, at the same paths as
+// run.googleapis.com (e.g. POST /v2/projects/p/locations/l/jobs/j:run), for
+// plain HTTP clients such as curl. Calls are translated into the gRPC
+// handlers, so they behave identically; bodies use the API's JSON encoding
+// and errors Google's {"error": {...}} shape. Request headers starting with
+// x-emulator-, and x-api-key, are passed on as request metadata.
+func (s *Server) RESTHandler() http.Handler {
+	const jobs = "/v2/projects/{project}/locations/{location}/jobs"
+	const executions = jobs + "/{job}/executions"
+	const tasks = executions + "/{execution}/tasks"
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+jobs, s.restListJobs)
+	mux.HandleFunc("POST "+jobs, s.restCreateJob)
+	mux.HandleFunc("GET "+jobs+"/{job}", s.restGetJob)
+	mux.HandleFunc("DELETE "+jobs+"/{job}", s.restDeleteJob)
+	// Custom methods are suffixed to the resource's last segment, as in
+	// jobs/j:run, which can't be matched as a pattern of its own.
+	mux.HandleFunc("POST "+jobs+"/{job}", s.restRunJob)
+	mux.HandleFunc("GET "+executions, s.restListExecutions)
+	mux.HandleFunc("GET "+executions+"/{execution}", s.restGetExecution)
+	mux.HandleFunc("DELETE "+executions+"/{execution}", s.restDeleteExecution)
+	mux.HandleFunc("POST "+executions+"/{execution}", s.restCancelExecution)
+	mux.HandleFunc("GET "+tasks, s.restListTasks)
+	mux.HandleFunc("GET "+tasks+"/{task}", s.restGetTask)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeRESTError(w, status.Errorf(codes.NotFound, "no such method: %s %s", r.Method, r.URL.Path))
+	})
+	return mux
+}
+
+// StartREST serves the REST/JSON API on the given port. It blocks until the
+// REST server is shut down by Stop.
+func (s *Server) StartREST(port string) error {
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%s", port),
+		Handler: s.RESTHandler(),
+	}
+	s.mu.Lock()
+	s.restServer = srv
+	s.mu.Unlock()
+
+	slog.Info("starting REST server", "port", port)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("REST server on port %s: %w", port, err)
+	}
+	return nil
+}
+
+func (s *Server) restListJobs(w http.ResponseWriter, r *http.Request) {
+	req := &runpb.ListJobsRequest{Parent: restLocation(r), PageToken: r.URL.Query().Get("pageToken")}
+	s.serveREST(w, r, nil, func(ctx context.Context) (proto.Message, error) {
+		var err error
+		if req.PageSize, err = restPageSize(r); err != nil {
+			return nil, err
+		}
+		return s.jobs.ListJobs(ctx, req)
+	})
+}
+
+func (s *Server) restCreateJob(w http.ResponseWriter, r *http.Request) {
+	req := &runpb.CreateJobRequest{Parent: restLocation(r), Job: &runpb.Job{}, JobId: r.URL.Query().Get("jobId")}
+	s.serveREST(w, r, req.Job, func(ctx context.Context) (proto.Message, error) {
+		return s.jobs.CreateJob(ctx, req)
+	})
+}
+
+func (s *Server) restGetJob(w http.ResponseWriter, r *http.Request) {
+	req := &runpb.GetJobRequest{Name: restJob(r)}
+	s.serveREST(w, r, nil, func(ctx context.Context) (proto.Message, error) {
+		return s.jobs.GetJob(ctx, req)
+	})
+}
+
+func (s *Server) restDeleteJob(w http.ResponseWriter, r *http.Request) {
+	req := &runpb.DeleteJobRequest{Name: restJob(r)}
+	s.serveREST(w, r, nil, func(ctx context.Context) (proto.Message, error) {
+		return s.jobs.DeleteJob(ctx, req)
+	})
+}
+
+func (s *Server) restRunJob(w http.ResponseWriter, r *http.Request) {
+	job, method, _ := strings.Cut(r.PathValue("job"), ":")
+	if method != "run" {
+		writeRESTError(w, status.Errorf(codes.NotFound, "no such method: %s %s", r.Method, r.URL.Path))
+		return
+	}
+	req := &runpb.RunJobRequest{}
+	s.serveREST(w, r, req, func(ctx context.Context) (proto.Message, error) {
+		req.Name = restLocation(r) + "/jobs/" + job
+		return s.jobs.RunJob(ctx, req)
+	})
+}
+
+func (s *Server) restListExecutions(w http.ResponseWriter, r *http.Request) {
+	req := &runpb.ListExecutionsRequest{Parent: restJob(r), PageToken: r.URL.Query().Get("pageToken")}
+	s.serveREST(w, r, nil, func(ctx context.Context) (proto.Message, error) {
+		var err error
+		if req.PageSize, err = restPageSize(r); err != nil {
+			return nil, err
+		}
+		return s.execs.ListExecutions(ctx, req)
+	})
+}
+
+func (s *Server) restGetExecution(w http.ResponseWriter, r *http.Request) {
+	req := &runpb.GetExecutionRequest{Name: restExecution(r)}
+	s.serveREST(w, r, nil, func(ctx context.Context) (proto.Message, error) {
+		return s.execs.GetExecution(ctx, req)
+	})
+}
+
+func (s *Server) restDeleteExecution(w http.ResponseWriter, r *http.Request) {
+	req := &runpb.DeleteExecutionRequest{Name: restExecution(r)}
+	s.serveREST(w, r, nil, func(ctx context.Context) (proto.Message, error) {
+		return s.execs.DeleteExecution(ctx, req)
+	})
+}
+
+func (s *Server) restCancelExecution(w http.ResponseWriter, r *http.Request) {
+	execution, method, _ := strings.Cut(r.PathValue("execution"), ":")
+	if method != "cancel" {
+		writeRESTError(w, status.Errorf(codes.NotFound, "no such method: %s %s", r.Method, r.URL.Path))
+		return
+	}
+	req := &runpb.CancelExecutionRequest{}
+	s.serveREST(w, r, req, func(ctx context.Context) (proto.Message, error) {
+		req.Name = restJob(r) + "/executions/" + execution
+		return s.execs.CancelExecution(ctx, req)
+	})
+}
+
+func (s *Server) restListTasks(w http.ResponseWriter, r *http.Request) {
+	req := &runpb.ListTasksRequest{Parent: restExecution(r), PageToken: r.URL.Query().Get("pageToken")}
+	s.serveREST(w, r, nil, func(ctx context.Context) (proto.Message, error) {
+		var err error
+		if req.PageSize, err = restPageSize(r); err != nil {
+			return nil, err
+		}
+		return s.tasks.ListTasks(ctx, req)
+	})
+}
+
+func (s *Server) restGetTask(w http.ResponseWriter, r *http.Request) {
+	req := &runpb.GetTaskRequest{Name: restExecution(r) + "/tasks/" + r.PathValue("task")}
+	s.serveREST(w, r, nil, func(ctx context.Context) (proto.Message, error) {
+		return s.tasks.GetTask(ctx, req)
+	})
+}
+
+// serveREST decodes the request body, if any, into body and writes the
+// result of call as JSON. Like gRPC calls, call is subject to the request
+// timeout.
+func (s *Server) serveREST(w http.ResponseWriter, r *http.Request, body proto.Message, call func(ctx context.Context) (proto.Message, error)) {
+	if body != nil {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeRESTError(w, status.Errorf(codes.InvalidArgument, "reading request body: %v", err))
+			return
+		}
+		if len(data) > 0 {
+			if err := protojson.Unmarshal(data, body); err != nil {
+				writeRESTError(w, status.Errorf(codes.InvalidArgument, "invalid request body: %v", err))
+				return
+			}
+		}
+	}
+
+	md := metadata.MD{}
+	for key, vals := range r.Header {
+		if key = strings.ToLower(key); strings.HasPrefix(key, "x-emulator-") || key == apiKeyHeader {
+			md.Append(key, vals...)
+		}
+	}
+	ctx := metadata.NewIncomingContext(r.Context(), md)
+	if s.opts.APIKey != "" {
+		if err := checkAPIKey(ctx, s.opts.APIKey); err != nil {
+			writeRESTError(w, err)
+			return
+		}
+	}
+
+	handler := func(ctx context.Context, _ any) (any, error) { return call(ctx) }
+	var resp any
+	var err error
+	if s.opts.RequestTimeout > 0 {
+		info := &grpc.UnaryServerInfo{FullMethod: r.Method + " " + r.URL.Path}
+		resp, err = timeoutInterceptor(s.opts.RequestTimeout)(ctx, nil, info, handler)
+	} else {
+		resp, err = handler(ctx, nil)
+	}
+	if err != nil {
+		writeRESTError(w, err)
+		return
+	}
+
+	data, err := protojson.Marshal(resp.(proto.Message))
+	if err != nil {
+		writeRESTError(w, status.Errorf(codes.Internal, "encoding response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// restLocation returns the projects/{project}/locations/{location} name of
+// a request's path.
+func restLocation(r *http.Request) string {
+	return "projects/" + r.PathValue("project") + "/locations/" + r.PathValue("location")
+}
+
+// restJob returns the full name of the job in a request's path.
+func restJob(r *http.Request) string {
+	return restLocation(r) + "/jobs/" + r.PathValue("job")
+}
+
+// restExecution returns the full name of the execution in a request's path.
+func restExecution(r *http.Request) string {
+	return restJob(r) + "/executions/" + r.PathValue("execution")
+}
+
+// restPageSize parses the pageSize query parameter, if present.
+func restPageSize(r *http.Request) (int32, error) {
+	v := r.URL.Query().Get("pageSize")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 32)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid pageSize %q", v)
+	}
+	return int32(n), nil
+}
+
+// restError is the JSON error body of Google REST APIs.
+type restError struct {
+	Error restErrorBody `json:"error"`
+}
+
+type restErrorBody struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// writeRESTError writes err, a gRPC status error, with the HTTP status its
+// code maps to.
+func writeRESTError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	code := httpStatus(st.Code())
+	writeJSON(w, code, restError{Error: restErrorBody{
+		Code:    code,
+		Message: st.Message(),
+		Status:  codeName(st.Code()),
+	}})
+}
+
+// codeName returns the canonical name of a gRPC code, e.g. NOT_FOUND.
+func codeName(c codes.Code) string {
+	switch c {
+	case codes.OK:
+		return "OK"
+	case codes.Canceled:
+		return "CANCELLED"
+	case codes.InvalidArgument:
+		return "INVALID_ARGUMENT"
+	case codes.DeadlineExceeded:
+		return "DEADLINE_EXCEEDED"
+	case codes.NotFound:
+		return "NOT_FOUND"
+	case codes.AlreadyExists:
+		return "ALREADY_EXISTS"
+	case codes.PermissionDenied:
+		return "PERMISSION_DENIED"
+	case codes.ResourceExhausted:
+		return "RESOURCE_EXHAUSTED"
+	case codes.FailedPrecondition:
+		return "FAILED_PRECONDITION"
+	case codes.Aborted:
+		return "ABORTED"
+	case codes.OutOfRange:
+		return "OUT_OF_RANGE"
+	case codes.Unimplemented:
+		return "UNIMPLEMENTED"
+	case codes.Unavailable:
+		return "UNAVAILABLE"
+	case codes.DataLoss:
+		return "DATA_LOSS"
+	case codes.Unauthenticated:
+		return "UNAUTHENTICATED"
+	case codes.Internal:
+		return "INTERNAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// httpStatus maps a gRPC code to its HTTP status, as Google's REST APIs do.
+func httpStatus(c codes.Code) int {
+	switch c {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,167 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	runpb "cloud.google.com/go/run/apiv2/runpb"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/server"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+	longrunningpb "google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// restCall sends a JSON request to the REST gateway and returns the response
+// status and body.
+func restCall(t *testing.T, method, url, body string, headers ...string) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+func decodeREST(t *testing.T, data []byte, m proto.Message) {
+	t.Helper()
+	if err := protojson.Unmarshal(data, m); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+}
+
+func TestRESTGateway(t *testing.T) {
+	exec := &blockingExecutor{release: make(chan struct{})}
+	close(exec.release)
+	srv := server.New(state.NewStore(), exec, server.Opts{ProjectID: "test-project", Region: "us-central1"})
+	ts := httptest.NewServer(srv.RESTHandler())
+	defer ts.Close()
+	base := ts.URL + "/v2/projects/test-project/locations/us-central1/jobs"
+
+	code, data := restCall(t, "POST", base+"?jobId=rest",
+		`{"template": {"template": {"containers": [{"image": "alpine:latest", "env": [{"name": "FOO", "value": "bar"}]}]}}}`)
+	if code != http.StatusOK {
+		t.Fatalf("CreateJob: expected 200, got %d: %s", code, data)
+	}
+
+	code, data = restCall(t, "GET", base+"/rest", "")
+	if code != http.StatusOK {
+		t.Fatalf("GetJob: expected 200, got %d: %s", code, data)
+	}
+	var job runpb.Job
+	decodeREST(t, data, &job)
+	if job.Name != "projects/test-project/locations/us-central1/jobs/rest" || job.Template.Template.Containers[0].Image != "alpine:latest" {
+		t.Errorf("unexpected job %v", &job)
+	}
+
+	code, data = restCall(t, "GET", base, "")
+	if code != http.StatusOK {
+		t.Fatalf("ListJobs: expected 200, got %d: %s", code, data)
+	}
+	var jobs runpb.ListJobsResponse
+	decodeREST(t, data, &jobs)
+	if len(jobs.Jobs) != 1 {
+		t.Errorf("expected one job, got %v", jobs.Jobs)
+	}
+
+	code, data = restCall(t, "POST", base+"/rest:run", `{"overrides": {"taskCount": 2}}`, "X-Emulator-Sync-Wait", "5s")
+	if code != http.StatusOK {
+		t.Fatalf("RunJob: expected 200, got %d: %s", code, data)
+	}
+	var op longrunningpb.Operation
+	decodeREST(t, data, &op)
+	if !op.Done {
+		t.Fatalf("expected the sync wait header to be honoured, got %v", &op)
+	}
+	var started runpb.Execution
+	if err := op.GetResponse().UnmarshalTo(&started); err != nil {
+		t.Fatal(err)
+	}
+
+	code, data = restCall(t, "GET", ts.URL+"/v2/"+started.Name, "")
+	if code != http.StatusOK {
+		t.Fatalf("GetExecution: expected 200, got %d: %s", code, data)
+	}
+	var got runpb.Execution
+	decodeREST(t, data, &got)
+	if got.TaskCount != 2 || got.SucceededCount != 1 {
+		t.Errorf("unexpected execution %v", &got)
+	}
+
+	code, data = restCall(t, "GET", ts.URL+"/v2/"+started.Name+"/tasks", "")
+	if code != http.StatusOK {
+		t.Fatalf("ListTasks: expected 200, got %d: %s", code, data)
+	}
+	var tasks runpb.ListTasksResponse
+	decodeREST(t, data, &tasks)
+	if len(tasks.Tasks) != 2 || tasks.Tasks[1].Name != started.Name+"/tasks/1" {
+		t.Errorf("expected tasks 0 and 1, got %v", tasks.Tasks)
+	}
+
+	code, data = restCall(t, "GET", base+"/rest/executions", "")
+	if code != http.StatusOK {
+		t.Fatalf("ListExecutions: expected 200, got %d: %s", code, data)
+	}
+	var execs runpb.ListExecutionsResponse
+	decodeREST(t, data, &execs)
+	if len(execs.Executions) != 1 || execs.Executions[0].Name != started.Name {
+		t.Errorf("expected %s, got %v", started.Name, execs.Executions)
+	}
+
+	code, data = restCall(t, "DELETE", base+"/rest", "")
+	if code != http.StatusOK {
+		t.Fatalf("DeleteJob: expected 200, got %d: %s", code, data)
+	}
+
+	code, data = restCall(t, "GET", base+"/rest", "")
+	var apiErr struct {
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+			Status  string `json:"status"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &apiErr); err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusNotFound || apiErr.Error.Code != http.StatusNotFound || apiErr.Error.Status != "NOT_FOUND" {
+		t.Errorf("expected a NOT_FOUND error for a deleted job, got %d: %s", code, data)
+	}
+
+	if code, data := restCall(t, "POST", base+"/rest:explode", ""); code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown custom method, got %d: %s", code, data)
+	}
+	if code, data := restCall(t, "POST", base+"?jobId=bad", "{not json"); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid body, got %d: %s", code, data)
+	}
+}
+
+func TestRESTGatewayRequiresAPIKey(t *testing.T) {
+	srv := server.New(state.NewStore(), &blockingExecutor{}, server.Opts{APIKey: "letmein"})
+	ts := httptest.NewServer(srv.RESTHandler())
+	defer ts.Close()
+	url := ts.URL + "/v2/projects/test-project/locations/us-central1/jobs"
+
+	if code, data := restCall(t, "GET", url, ""); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a key, got %d: %s", code, data)
+	}
+	if code, data := restCall(t, "GET", url, "", "X-Api-Key", "letmein"); code != http.StatusOK {
+		t.Errorf("expected 200 with the key, got %d: %s", code, data)
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import "sync"
+
+// Scheduling policies for executions waiting on a free slot.
+const (
+	// SchedulerFIFO starts queued executions in submission order.
+	SchedulerFIFO = "fifo"
+	// SchedulerFair interleaves queued executions across jobs round-robin,
+	// so a burst from one job can't starve the others.
+	SchedulerFair = "fair"
+)
+
+// scheduler bounds how many executions run at once. Submissions beyond the
+// limit wait in a queue and are started, according to the policy, as running
+// executions finish. Executions in the same concurrency group run one at a
+// time, even across jobs.
+type scheduler struct {
+	mu      sync.Mutex
+	limit   int // zero means unlimited
+	fair    bool
+	running int
+	queue   []queuedRun
+	// busyGroups holds the concurrency groups with a running execution.
+	busyGroups map[string]bool
+	// lastStart records, per job, the sequence number of its most recently
+	// started execution. The fair policy serves the least recently started
+	// job next.
+	lastStart map[string]uint64
+	seq       uint64
+}
+
+type queuedRun struct {
+	job   string
+	group string // empty if the job is in no concurrency group
+	start func()
+}
+
+func newScheduler(limit int, policy string) *scheduler {
+	return &scheduler{
+		limit:      limit,
+		fair:       policy == SchedulerFair,
+		busyGroups: make(map[string]bool),
+		lastStart:  make(map[string]uint64),
+	}
+}
+
+// submit queues start to run once a slot is free and no other execution in
+// group is running. start runs on its own goroutine and holds the slot until
+// it returns.
+func (s *scheduler) submit(job, group string, start func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, queuedRun{job: job, group: group, start: start})
+	s.dispatchLocked()
+}
+
+func (s *scheduler) dispatchLocked() {
+	for s.limit <= 0 || s.running < s.limit {
+		i := s.nextLocked()
+		if i < 0 {
+			return
+		}
+		run := s.queue[i]
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+
+		s.seq++
+		s.lastStart[run.job] = s.seq
+		s.running++
+		if run.group != "" {
+			s.busyGroups[run.group] = true
+		}
+		go func() {
+			defer s.release(run.group)
+			run.start()
+		}()
+	}
+}
+
+// nextLocked returns the index of the queued run to start next, or -1 if
+// every queued run is waiting on its concurrency group.
+func (s *scheduler) nextLocked() int {
+	next := -1
+	for i, run := range s.queue {
+		if s.busyGroups[run.group] {
+			continue
+		}
+		if !s.fair {
+			return i
+		}
+		// The queue is in submission order, so the first run seen for the
+		// least recently started job is that job's oldest.
+		if next < 0 || s.lastStart[run.job] < s.lastStart[s.queue[next].job] {
+			next = i
+		}
+	}
+	return next
+}
+
+func (s *scheduler) release(group string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+	delete(s.busyGroups, group)
+	s.dispatchLocked()
+}
+
+// schedulerStatus is a snapshot of the scheduler's capacity and load.
+type schedulerStatus struct {
+	// Limit is the configured maximum of concurrent executions; zero means
+	// unlimited.
+	Limit   int `json:"limit"`
+	Running int `json:"running"`
+	Queued  int `json:"queued"`
+	// ConcurrencyGroups reports each group with a running or queued
+	// execution.
+	ConcurrencyGroups map[string]groupStatus `json:"concurrency_groups"`
+}
+
+type groupStatus struct {
+	Running bool `json:"running"`
+	// Waiting counts queued executions in the group.
+	Waiting int `json:"waiting"`
+}
+
+func (s *scheduler) status() schedulerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := schedulerStatus{
+		Limit:             s.limit,
+		Running:           s.running,
+		Queued:            len(s.queue),
+		ConcurrencyGroups: make(map[string]groupStatus),
+	}
+	for group := range s.busyGroups {
+		st.ConcurrencyGroups[group] = groupStatus{Running: true}
+	}
+	for _, run := range s.queue {
+		if run.group == "" {
+			continue
+		}
+		g := st.ConcurrencyGroups[run.group]
+		g.Waiting++
+		st.ConcurrencyGroups[run.group] = g
+	}
+	return st
+}
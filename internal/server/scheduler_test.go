@@ -0,0 +1,91 @@
+package server
+
+import (
+	"slices"
+	"sync"
+	"testing"
+)
+
+// schedulerStartOrder submits a burst of three runs each from jobs a and b
+// behind a run holding the only slot, and returns the order they start in.
+func schedulerStartOrder(t *testing.T, policy string) []string {
+	t.Helper()
+	s := newScheduler(1, policy)
+
+	var (
+		mu      sync.Mutex
+		started []string
+		wg      sync.WaitGroup
+	)
+	gate := make(chan struct{})
+	wg.Add(1)
+	s.submit("gate", "", func() {
+		defer wg.Done()
+		<-gate
+	})
+	for _, run := range []string{"a1", "a2", "a3", "b1", "b2", "b3"} {
+		wg.Add(1)
+		s.submit(run[:1], "", func() {
+			defer wg.Done()
+			mu.Lock()
+			started = append(started, run)
+			mu.Unlock()
+		})
+	}
+	close(gate)
+	wg.Wait()
+	return started
+}
+
+func TestSchedulerFIFO(t *testing.T) {
+	got := schedulerStartOrder(t, SchedulerFIFO)
+	if want := []string{"a1", "a2", "a3", "b1", "b2", "b3"}; !slices.Equal(got, want) {
+		t.Errorf("start order %v, want %v", got, want)
+	}
+}
+
+func TestSchedulerFairInterleavesJobs(t *testing.T) {
+	got := schedulerStartOrder(t, SchedulerFair)
+	if want := []string{"a1", "b1", "a2", "b2", "a3", "b3"}; !slices.Equal(got, want) {
+		t.Errorf("start order %v, want %v", got, want)
+	}
+}
+
+func TestSchedulerSerializesConcurrencyGroup(t *testing.T) {
+	s := newScheduler(0, SchedulerFIFO)
+
+	gate := make(chan struct{})
+	started := make(chan string, 3)
+	var wg sync.WaitGroup
+	for _, run := range []struct{ job, group string }{{"a", "db"}, {"b", "db"}, {"c", ""}} {
+		wg.Add(1)
+		s.submit(run.job, run.group, func() {
+			defer wg.Done()
+			started <- run.job
+			<-gate
+		})
+	}
+
+	// a holds the group, so b waits even though slots are unlimited; c is in
+	// no group and starts at once.
+	got := []string{<-started, <-started}
+	slices.Sort(got)
+	if want := []string{"a", "c"}; !slices.Equal(got, want) {
+		t.Fatalf("started %v, want %v", got, want)
+	}
+	st := s.status()
+	if g := st.ConcurrencyGroups["db"]; !g.Running || g.Waiting != 1 {
+		t.Errorf("group db status %+v, want running with 1 waiting", g)
+	}
+	select {
+	case job := <-started:
+		t.Fatalf("%s started while its group was busy", job)
+	default:
+	}
+
+	close(gate)
+	if job := <-started; job != "b" {
+		t.Errorf("started %s, want b", job)
+	}
+	wg.Wait()
+}
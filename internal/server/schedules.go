@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// runSourceSchedule is the run source of executions started by a job's
+// schedule.
+const runSourceSchedule = "schedule"
+
+// StartSchedules runs every job that has a schedule on its cadence, until
+// Stop. Schedules are standard five-field cron expressions or descriptors
+// such as "@hourly" or "@every 5m", in local time. Scheduled runs go through
+// the same concurrency limit as RunJob, each delayed by a random amount up
+// to the job's ScheduleJitter. Jobs are read from the store when this is
+// called; jobs created later are not scheduled.
+func (s *Server) StartSchedules() error {
+	c := cron.New()
+	stop := make(chan struct{})
+	for _, job := range s.store.ListJobs("") {
+		if job.Schedule == "" {
+			continue
+		}
+		name, jitter := job.Name, job.ScheduleJitter
+		if _, err := c.AddFunc(job.Schedule, s.scheduledRun(name, jitter, stop)); err != nil {
+			return fmt.Errorf("job %s: invalid schedule %q: %w", name, job.Schedule, err)
+		}
+		slog.Info("scheduled job", "name", name, "schedule", job.Schedule, "jitter", jitter)
+	}
+
+	s.mu.Lock()
+	s.cron = c
+	s.cronStop = stop
+	s.mu.Unlock()
+	c.Start()
+	return nil
+}
+
+// RestartSchedules reschedules jobs from the store, if schedules are running,
+// so changes to jobs' schedules take effect.
+func (s *Server) RestartSchedules() error {
+	s.mu.RLock()
+	running := s.cron != nil
+	s.mu.RUnlock()
+	if !running {
+		return nil
+	}
+	s.stopSchedules()
+	return s.StartSchedules()
+}
+
+// scheduledRun returns the function the schedule calls to run the named
+// job. It first waits a random delay up to jitter, drawn by s.jitterDelay,
+// and drops the run if stop is closed meanwhile.
+func (s *Server) scheduledRun(name string, jitter time.Duration, stop <-chan struct{}) func() {
+	return func() {
+		if jitter > 0 {
+			select {
+			case <-s.after(s.jitterDelay(jitter)):
+			case <-stop:
+				return
+			}
+		}
+		s.runScheduled(name)
+	}
+}
+
+// runScheduled starts a scheduled run of the named job.
+func (s *Server) runScheduled(name string) {
+	job, err := s.store.GetJob(name)
+	if err != nil {
+		slog.Warn("skipping scheduled run of deleted job", "name", name)
+		return
+	}
+	exec, _, err := s.jobs.startExecution(job, nil, runSourceSchedule)
+	if err != nil {
+		slog.Error("scheduled run failed to start", "name", name, "error", err)
+		return
+	}
+	slog.Info("scheduled run started", "execution", exec.Name)
+}
+
+// triggeredRun is the response of the endpoints that start a run, such as
+// the trigger scheduled job endpoint.
+type triggeredRun struct {
+	Execution string `json:"execution"`
+}
+
+// handleTriggerScheduled runs a scheduled job immediately, as if its schedule
+// had fired, so tests needn't wait for the cron time. Jitter is not applied.
+// It works whether or not schedules are enabled.
+func (s *Server) handleTriggerScheduled(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "missing required query parameter: name")
+		return
+	}
+
+	job, err := s.store.GetJob(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if job.Schedule == "" {
+		writeError(w, http.StatusBadRequest, "job has no schedule: "+name)
+		return
+	}
+
+	exec, _, err := s.jobs.startExecution(job, nil, runSourceSchedule)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	slog.Info("scheduled run triggered", "execution", exec.Name)
+	writeJSON(w, http.StatusOK, triggeredRun{Execution: exec.Name})
+}
+
+// stopSchedules stops triggering scheduled runs, dropping runs still waiting
+// out their jitter and waiting for any trigger in progress to finish
+// submitting its execution.
+func (s *Server) stopSchedules() {
+	s.mu.Lock()
+	c, stop := s.cron, s.cronStop
+	s.cron, s.cronStop = nil, nil
+	s.mu.Unlock()
+	if c != nil {
+		close(stop)
+		<-c.Stop().Done()
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+// nopExecutor finishes every run straight away.
+type nopExecutor struct{}
+
+func (nopExecutor) Run(exec *state.Execution, env map[string]string) {}
+func (nopExecutor) Cancel(exec *state.Execution) error               { return nil }
+
+func TestScheduledRunJitter(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{
+		Name:    "projects/test-project/locations/us-central1/jobs/jittery",
+		Command: []string{"true"},
+		Env:     map[string]string{},
+	}
+	store.SaveJob(job)
+
+	s := New(store, nopExecutor{}, Opts{})
+	const jitter = 400 * time.Millisecond
+	var drawnFrom time.Duration
+	s.jitterDelay = func(limit time.Duration) time.Duration {
+		drawnFrom = limit
+		return limit / 4
+	}
+	waited := make(chan time.Duration, 1)
+	fire := make(chan time.Time)
+	s.after = func(d time.Duration) <-chan time.Time {
+		waited <- d
+		return fire
+	}
+	runs := func() int { return len(store.ListExecutions(job.Name)) }
+	start := func(jitter time.Duration, stop <-chan struct{}) <-chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.scheduledRun(job.Name, jitter, stop)()
+		}()
+		return done
+	}
+
+	// The run waits out a delay drawn up to the jitter before starting.
+	stop := make(chan struct{})
+	done := start(jitter, stop)
+	if d := <-waited; d != jitter/4 || drawnFrom != jitter {
+		t.Fatalf("expected a wait of %v drawn up to %v, got %v drawn up to %v", jitter/4, jitter, d, drawnFrom)
+	}
+	if n := runs(); n != 0 {
+		t.Fatalf("expected no run before the delay, got %d", n)
+	}
+	fire <- time.Now()
+	<-done
+	if n := runs(); n != 1 {
+		t.Fatalf("expected a run after the delay, got %d", n)
+	}
+
+	// Stopping the schedules drops a run still waiting.
+	done = start(jitter, stop)
+	<-waited
+	close(stop)
+	<-done
+	if n := runs(); n != 1 {
+		t.Errorf("expected the waiting run to be dropped, got %d runs", n)
+	}
+
+	// Without jitter, the run starts straight away.
+	<-start(0, nil)
+	if n := runs(); n != 2 {
+		t.Errorf("expected an immediate run, got %d runs", n)
+	}
+	select {
+	case d := <-waited:
+		t.Errorf("expected no wait without jitter, waited %v", d)
+	default:
+	}
+}
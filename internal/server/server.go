@@ -1,72 +1,386 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"math/rand/v2"
 	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 
 	runpb "cloud.google.com/go/run/apiv2/runpb"
 	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/executor"
 	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+	"github.com/robfig/cron/v3"
+	longrunningpb "google.golang.org/genproto/googleapis/longrunning"
 )
 
+// Opts configures the emulator server.
+type Opts struct {
+	// ProjectID and Region are the defaults used for jobs registered from config.
+	ProjectID string
+	Region    string
+	// RelaxedNames accepts resource names that don't follow the Cloud Run
+	// projects/{project}/locations/{location}/jobs/{job} scheme and stores
+	// them verbatim. By default such names are rejected with InvalidArgument.
+	RelaxedNames bool
+	// ImageCleanup enables the admin endpoint that removes images pulled by
+	// the executor.
+	ImageCleanup bool
+	// RunJobSyncWait makes RunJob wait up to this long for the execution to
+	// finish, returning a completed operation if it does. Callers can
+	// override it per request with the x-emulator-sync-wait metadata header.
+	RunJobSyncWait time.Duration
+	// RejectDeleteWhileRunning makes DeleteJob fail with FailedPrecondition
+	// while the job has pending or running executions. By default the job is
+	// deleted and its executions finish and remain available.
+	RejectDeleteWhileRunning bool
+	// ValidateImages makes CreateJob check that the job's image exists,
+	// rejecting it with InvalidArgument if not. It adds latency and needs
+	// registry access, so it is off by default.
+	ValidateImages bool
+	// LabelRunSource labels each execution with how it was started (e.g.
+	// run.source=api), under RunSourceLabel.
+	LabelRunSource bool
+	// DefaultResources are the limits for jobs that set none, beneath both
+	// the container's and the execution template's.
+	DefaultResources state.Resources
+	// DefaultTaskCount and DefaultParallelism apply to jobs that don't set
+	// their own. Zero means 1.
+	DefaultTaskCount   int32
+	DefaultParallelism int32
+	// OmitTaskEnv stops executors injecting the CLOUD_RUN_* task metadata
+	// environment variables (CLOUD_RUN_JOB, CLOUD_RUN_TASK_INDEX, ...).
+	OmitTaskEnv bool
+	// Secrets are the values of secrets, by name, that jobs' secret-backed
+	// env vars resolve to. Runs referencing a missing secret fail to start
+	// with FailedPrecondition.
+	Secrets map[string]string
+	// MaxLogLines and MaxLogBytes bound the output kept in memory for each
+	// execution; the oldest lines are dropped first. Zero means unbounded.
+	MaxLogLines int
+	MaxLogBytes int
+	// DebugDump enables the admin endpoint returning a snapshot of the
+	// emulator's state for bug reports.
+	DebugDump bool
+	// MetricsExemplars attaches the execution behind each duration histogram
+	// bucket's latest observation as an exemplar in OpenMetrics output.
+	MetricsExemplars bool
+	// OperationRetention is how long operations are kept after their
+	// execution finishes; GetOperation returns NotFound for pruned ones.
+	// Zero keeps them forever.
+	OperationRetention time.Duration
+	// ExecutionTTL is how long finished executions are kept before they are
+	// deleted, and MaxExecutionsPerJob how many of each job's finished
+	// executions are kept, oldest deleted first. Pending and running
+	// executions are never deleted. Zero disables either limit.
+	ExecutionTTL        time.Duration
+	MaxExecutionsPerJob int
+	// Version is reported in the debug dump.
+	Version string
+	// DebugConfig is the (redacted) configuration reported in the debug
+	// dump. Update it with SetDebugConfig.
+	DebugConfig any
+	// CrashOnExecutorPanic lets a panic in an executor crash the emulator,
+	// for debugging. By default the execution is failed and the emulator
+	// keeps running.
+	CrashOnExecutorPanic bool
+	// MaxConcurrentExecutions limits how many executions run at once; the
+	// rest wait as pending. Zero means unlimited.
+	MaxConcurrentExecutions int
+	// Scheduler is the policy for starting pending executions: SchedulerFIFO
+	// (the default) or SchedulerFair.
+	Scheduler string
+	// RequestTimeout bounds how long a unary RPC handler may run before the
+	// call fails with DeadlineExceeded, including any RunJob sync wait.
+	// Streaming RPCs are exempt. Zero means no limit.
+	RequestTimeout time.Duration
+	// APIKey, if set, must be sent in the x-api-key header of every call to
+	// the Cloud Run and operations services, over gRPC or REST, and of every
+	// admin API request; other calls fail with Unauthenticated, or 401.
+	APIKey string
+	// CompletionWebhookURL, if set, is sent a JSON summary of every
+	// execution that finishes, in a POST request.
+	CompletionWebhookURL string
+}
+
 type Server struct {
-	grpcServer *grpc.Server
-	store      *state.Store
-	executor   executor.Executor
-	projectID  string
-	region     string
+	grpcServer  *grpc.Server
+	health      *health.Server
+	adminServer *http.Server
+	restServer  *http.Server
+	store       *state.Store
+	executors   *executorSet
+	metrics     *metrics
+	jobs        *JobsServer
+	webhook     *completionWebhook
+	execs       *ExecutionsServer
+	tasks       *TasksServer
+	opts        Opts
+
+	// jitterDelay draws the delay of a scheduled run from [0, jitter), and
+	// after waits it out. Tests replace them.
+	jitterDelay func(jitter time.Duration) time.Duration
+	after       func(d time.Duration) <-chan time.Time
+
+	mu          sync.RWMutex
+	debugConfig any
+	cron        *cron.Cron
+	cronStop    chan struct{}
+	stopJanitor chan struct{}
+	stopGC      chan struct{}
 }
 
-func New(store *state.Store, exec executor.Executor, projectID, region string) *Server {
+func New(store *state.Store, exec executor.Executor, opts Opts) *Server {
 	s := &Server{
-		store:     store,
-		executor:  exec,
-		projectID: projectID,
-		region:    region,
+		store:       store,
+		executors:   newExecutorSet(exec, opts.CrashOnExecutorPanic),
+		metrics:     newMetrics(opts.MetricsExemplars),
+		webhook:     newCompletionWebhook(opts.CompletionWebhookURL),
+		opts:        opts,
+		debugConfig: opts.DebugConfig,
+		jitterDelay: rand.N[time.Duration],
+		after:       time.After,
+	}
+
+	interceptors := []grpc.UnaryServerInterceptor{loggingInterceptor}
+	if opts.APIKey != "" {
+		interceptors = append(interceptors, apiKeyInterceptor(opts.APIKey))
+	}
+	if opts.RequestTimeout > 0 {
+		interceptors = append(interceptors, timeoutInterceptor(opts.RequestTimeout))
 	}
+	// The timeout interceptor runs handlers in their own goroutine, so
+	// panics can only be recovered after it.
+	interceptors = append(interceptors, recoveryInterceptor)
+	gs := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
 
-	gs := grpc.NewServer()
+	names := nameValidator{relaxed: opts.RelaxedNames}
 
 	jobsSvc := &JobsServer{
-		store:     store,
-		executor:  exec,
-		projectID: projectID,
-		region:    region,
+		store:                    store,
+		executors:                s.executors,
+		metrics:                  s.metrics,
+		webhook:                  s.webhook,
+		names:                    names,
+		scheduler:                newScheduler(opts.MaxConcurrentExecutions, opts.Scheduler),
+		defaultSyncWait:          opts.RunJobSyncWait,
+		defaultResources:         opts.DefaultResources,
+		defaults:                 jobDefaults{TaskCount: max(opts.DefaultTaskCount, 1), Parallelism: max(opts.DefaultParallelism, 1)},
+		labelRunSource:           opts.LabelRunSource,
+		validateImages:           opts.ValidateImages,
+		rejectDeleteWhileRunning: opts.RejectDeleteWhileRunning,
+		secrets:                  opts.Secrets,
+		omitTaskEnv:              opts.OmitTaskEnv,
+		maxLogLines:              opts.MaxLogLines,
+		maxLogBytes:              opts.MaxLogBytes,
 	}
 	runpb.RegisterJobsServer(gs, jobsSvc)
+	s.jobs = jobsSvc
 
 	execSvc := &ExecutionsServer{
-		store:    store,
-		executor: exec,
+		store:     store,
+		executors: s.executors,
+		names:     names,
 	}
 	runpb.RegisterExecutionsServer(gs, execSvc)
+	s.execs = execSvc
+
+	tasksSvc := &TasksServer{store: store, names: names}
+	runpb.RegisterTasksServer(gs, tasksSvc)
+	s.tasks = tasksSvc
+
+	longrunningpb.RegisterOperationsServer(gs, &OperationsServer{store: store})
+
+	// Report NOT_SERVING until Start or Serve has a listener.
+	s.health = health.NewServer()
+	for _, svc := range healthServices {
+		s.health.SetServingStatus(svc, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	healthpb.RegisterHealthServer(gs, s.health)
 
 	// Enable gRPC reflection for grpcurl and debugging
 	reflection.Register(gs)
 
 	s.grpcServer = gs
+
+	if opts.OperationRetention > 0 {
+		s.stopJanitor = make(chan struct{})
+		go s.runJanitor(opts.OperationRetention, min(opts.OperationRetention, time.Minute), s.stopJanitor)
+	}
+	if opts.ExecutionTTL > 0 || opts.MaxExecutionsPerJob > 0 {
+		interval := time.Minute
+		if opts.ExecutionTTL > 0 {
+			interval = min(opts.ExecutionTTL, interval)
+		}
+		s.stopGC = make(chan struct{})
+		go s.runExecutionGC(opts.ExecutionTTL, opts.MaxExecutionsPerJob, interval, s.stopGC)
+	}
 	return s
 }
 
+// timeoutInterceptor gives each unary call a deadline of timeout. A handler
+// still running at the deadline is abandoned and the call fails with
+// DeadlineExceeded, so a handler that ignores its context can't hold the
+// call open. The handler's context is cancelled at the deadline, so its slow
+// paths, the RunJob sync wait and image validation, stop early, and CreateJob
+// doesn't save a job whose validation ran past it. Side effects already
+// under way still complete: a RunJob cut off during its sync wait leaves the
+// execution running, and a cancellation waits for the execution to stop.
+func timeoutInterceptor(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		type result struct {
+			resp any
+			err  error
+		}
+		done := make(chan result, 1)
+		go func() {
+			resp, err := handler(ctx, req)
+			done <- result{resp, err}
+		}()
+
+		select {
+		case r := <-done:
+			return r.resp, r.err
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				slog.Warn("request timed out", "method", info.FullMethod, "timeout", timeout)
+				return nil, status.Errorf(codes.DeadlineExceeded, "%s exceeded the request timeout of %s", info.FullMethod, timeout)
+			}
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// healthServices are the services whose status the gRPC health service
+// reports; "" is the server as a whole.
+var healthServices = []string{
+	"",
+	runpb.Jobs_ServiceDesc.ServiceName,
+	runpb.Executions_ServiceDesc.ServiceName,
+	runpb.Tasks_ServiceDesc.ServiceName,
+}
+
+// Start serves the gRPC API on a TCP port or, given an address of the form
+// unix:///path/to.sock, on a Unix domain socket. It blocks until Stop.
 func (s *Server) Start(port string) error {
+	if path, ok := strings.CutPrefix(port, "unix://"); ok {
+		lis, err := listenUnix(path)
+		if err != nil {
+			return err
+		}
+		slog.Info("starting gRPC server", "socket", path)
+		return s.Serve(lis)
+	}
+
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", port))
 	if err != nil {
 		return fmt.Errorf("failed to listen on port %s: %w", port, err)
 	}
 
 	slog.Info("starting gRPC server", "port", port)
-	return s.grpcServer.Serve(lis)
+	return s.Serve(lis)
+}
+
+// listenUnix listens on the Unix socket at path, first removing a socket
+// file left behind by an emulator that didn't shut down cleanly. A socket
+// something is still listening on is left alone. The listener removes the
+// socket file when closed.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode().Type() == fs.ModeSocket {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale socket %s: %w", path, err)
+		}
+	}
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on socket %s: %w", path, err)
+	}
+	return lis, nil
 }
 
 // Serve starts the server on an existing listener.
 func (s *Server) Serve(lis net.Listener) error {
+	for _, svc := range healthServices {
+		s.health.SetServingStatus(svc, healthpb.HealthCheckResponse_SERVING)
+	}
 	return s.grpcServer.Serve(lis)
 }
 
+// SetExecutor swaps the executor used for new executions. Executions already
+// running complete on the executor that started them.
+func (s *Server) SetExecutor(exec executor.Executor) {
+	s.executors.swap(exec)
+}
+
+// SetDebugConfig replaces the configuration reported in the debug dump, e.g.
+// after a reload.
+func (s *Server) SetDebugConfig(cfg any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.debugConfig = cfg
+}
+
+// StartAdmin serves the admin HTTP API on the given port. It blocks until the
+// admin server is shut down by Stop.
+func (s *Server) StartAdmin(port string) error {
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%s", port),
+		Handler: s.AdminHandler(),
+	}
+	s.mu.Lock()
+	s.adminServer = srv
+	s.mu.Unlock()
+
+	slog.Info("starting admin HTTP server", "port", port)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("admin server on port %s: %w", port, err)
+	}
+	return nil
+}
+
 func (s *Server) Stop() {
+	// Fail health checks first, so clients stop sending new work.
+	s.health.Shutdown()
+	s.stopSchedules()
+	if s.stopJanitor != nil {
+		close(s.stopJanitor)
+	}
+	if s.stopGC != nil {
+		close(s.stopGC)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.mu.RLock()
+	adminServer, restServer := s.adminServer, s.restServer
+	s.mu.RUnlock()
+	if adminServer != nil {
+		_ = adminServer.Shutdown(ctx)
+	}
+	if restServer != nil {
+		_ = restServer.Shutdown(ctx)
+	}
 	s.grpcServer.GracefulStop()
+	closeExecutor(s.executors.active())
+	s.webhook.wait()
 }
@@ -1,8 +1,19 @@
 package server_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -10,15 +21,29 @@ import (
 	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/executor"
 	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/server"
 	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+	longrunningpb "google.golang.org/genproto/googleapis/longrunning"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 func startTestServer(t *testing.T, store *state.Store) (string, func()) {
 	t.Helper()
+	return startTestServerWithOpts(t, store, server.Opts{ProjectID: "test-project", Region: "us-central1"})
+}
+
+func startTestServerWithOpts(t *testing.T, store *state.Store, opts server.Opts) (string, func()) {
+	t.Helper()
+	return serve(t, server.New(store, executor.NewSubprocessExecutor(executor.SubprocessExecutorOpts{}), opts))
+}
 
-	exec := executor.NewSubprocessExecutor()
-	srv := server.New(store, exec, "test-project", "us-central1")
+func serve(t *testing.T, srv *server.Server) (string, func()) {
+	t.Helper()
 
 	lis, err := net.Listen("tcp", "localhost:0")
 	if err != nil {
@@ -66,7 +91,8 @@ func TestCreateAndGetJob(t *testing.T) {
 					Containers: []*runpb.Container{
 						{
 							Image:   "alpine:latest",
-							Command: []string{"echo", "hello"},
+							Command: []string{"echo"},
+							Args:    []string{"hello"},
 							Env: []*runpb.EnvVar{
 								{Name: "FOO", Values: &runpb.EnvVar_Value{Value: "bar"}},
 							},
@@ -93,6 +119,9 @@ func TestCreateAndGetJob(t *testing.T) {
 	if job.Name != "projects/test-project/locations/us-central1/jobs/test-job" {
 		t.Errorf("unexpected job name: %s", job.Name)
 	}
+	if c := job.Template.Template.Containers[0]; !slices.Equal(c.Command, []string{"echo"}) || !slices.Equal(c.Args, []string{"hello"}) {
+		t.Errorf("expected command [echo] and args [hello], got %v and %v", c.Command, c.Args)
+	}
 }
 
 func TestListJobs(t *testing.T) {
@@ -236,3 +265,1919 @@ func TestDeleteJob(t *testing.T) {
 		t.Error("expected error for deleted job")
 	}
 }
+
+func TestExecutionTemplateLabelsPropagate(t *testing.T) {
+	store := state.NewStore()
+	addr, cleanup := startTestServer(t, store)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	jobsClient := runpb.NewJobsClient(conn)
+	execClient := runpb.NewExecutionsClient(conn)
+	ctx := context.Background()
+
+	_, err := jobsClient.CreateJob(ctx, &runpb.CreateJobRequest{
+		Parent: "projects/test-project/locations/us-central1",
+		JobId:  "labelled",
+		Job: &runpb.Job{
+			Template: &runpb.ExecutionTemplate{
+				Labels: map[string]string{"team": "payments"},
+				Template: &runpb.TaskTemplate{
+					Containers: []*runpb.Container{
+						{Image: "alpine:latest", Command: []string{"true"}},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateJob failed: %v", err)
+	}
+
+	job, err := jobsClient.GetJob(ctx, &runpb.GetJobRequest{
+		Name: "projects/test-project/locations/us-central1/jobs/labelled",
+	})
+	if err != nil {
+		t.Fatalf("GetJob failed: %v", err)
+	}
+	if job.Template.Labels["team"] != "payments" {
+		t.Errorf("expected template label team=payments, got %v", job.Template.Labels)
+	}
+
+	op, err := jobsClient.RunJob(ctx, &runpb.RunJobRequest{
+		Name: "projects/test-project/locations/us-central1/jobs/labelled",
+	})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+
+	exec, err := execClient.GetExecution(ctx, &runpb.GetExecutionRequest{Name: op.Name})
+	if err != nil {
+		t.Fatalf("GetExecution failed: %v", err)
+	}
+	if exec.Labels["team"] != "payments" {
+		t.Errorf("expected execution label team=payments, got %v", exec.Labels)
+	}
+}
+
+func TestCreateJobRejectsNonStandardNames(t *testing.T) {
+	store := state.NewStore()
+	addr, cleanup := startTestServer(t, store)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	client := runpb.NewJobsClient(conn)
+	ctx := context.Background()
+
+	for _, req := range []*runpb.CreateJobRequest{
+		{Parent: "teams/payments", JobId: "nightly"},
+		{Parent: "projects/test-project/locations/us-central1", JobId: "Not_Valid"},
+	} {
+		req.Job = &runpb.Job{}
+		_, err := client.CreateJob(ctx, req)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("CreateJob(%s, %s): expected InvalidArgument, got %v", req.Parent, req.JobId, err)
+		}
+	}
+}
+
+func TestRelaxedNames(t *testing.T) {
+	store := state.NewStore()
+	addr, cleanup := startTestServerWithOpts(t, store, server.Opts{RelaxedNames: true})
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	client := runpb.NewJobsClient(conn)
+	ctx := context.Background()
+
+	_, err := client.CreateJob(ctx, &runpb.CreateJobRequest{
+		Parent: "teams/payments",
+		JobId:  "Nightly_Export",
+		Job:    &runpb.Job{},
+	})
+	if err != nil {
+		t.Fatalf("CreateJob failed: %v", err)
+	}
+
+	job, err := store.GetJob("teams/payments/jobs/Nightly_Export")
+	if err != nil {
+		t.Fatalf("expected job stored verbatim: %v", err)
+	}
+	if job.ShortName() != "Nightly_Export" {
+		t.Errorf("unexpected short name: %s", job.ShortName())
+	}
+
+	resp, err := client.ListJobs(ctx, &runpb.ListJobsRequest{Parent: "teams/payments"})
+	if err != nil {
+		t.Fatalf("ListJobs failed: %v", err)
+	}
+	if len(resp.Jobs) != 1 {
+		t.Errorf("expected 1 job, got %d", len(resp.Jobs))
+	}
+}
+
+// blockingExecutor succeeds every execution once release is closed, recording
+// which executions it ran.
+type blockingExecutor struct {
+	release chan struct{}
+	mu      sync.Mutex
+	ran     []string
+}
+
+func (e *blockingExecutor) Run(exec *state.Execution, env map[string]string) {
+	<-e.release
+	e.mu.Lock()
+	e.ran = append(e.ran, exec.Name)
+	e.mu.Unlock()
+	exec.Lock()
+	defer exec.Unlock()
+	exec.Status = state.StatusSucceeded
+	exec.SucceededCount = 1
+	exec.CompletionTime = time.Now()
+}
+
+func (e *blockingExecutor) Cancel(exec *state.Execution) error { return nil }
+
+func (e *blockingExecutor) runCount() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.ran)
+}
+
+func TestSetExecutorKeepsInFlightRuns(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:  "projects/test-project/locations/us-central1/jobs/swap",
+		Image: "alpine:latest",
+		Env:   map[string]string{},
+	})
+
+	oldExec := &blockingExecutor{release: make(chan struct{})}
+	newExec := &blockingExecutor{release: make(chan struct{})}
+	close(newExec.release)
+
+	srv := server.New(store, oldExec, server.Opts{})
+	addr, cleanup := serve(t, srv)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	client := runpb.NewJobsClient(conn)
+	ctx := context.Background()
+	req := &runpb.RunJobRequest{Name: "projects/test-project/locations/us-central1/jobs/swap"}
+
+	first, err := client.RunJob(ctx, req)
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+
+	srv.SetExecutor(newExec)
+
+	if _, err := client.RunJob(ctx, req); err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+
+	close(oldExec.release)
+	deadline := time.Now().Add(2 * time.Second)
+	for (oldExec.runCount() < 1 || newExec.runCount() < 1) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if oldExec.runCount() != 1 || oldExec.ran[0] != first.Name {
+		t.Errorf("expected in-flight execution to finish on the old executor, got %v", oldExec.ran)
+	}
+	if newExec.runCount() != 1 {
+		t.Errorf("expected new execution on the new executor, got %v", newExec.ran)
+	}
+}
+
+func TestRunJobSyncWait(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:    "projects/test-project/locations/us-central1/jobs/fast",
+		Command: []string{"true"},
+		Env:     map[string]string{},
+	})
+	store.SaveJob(&state.Job{
+		Name:    "projects/test-project/locations/us-central1/jobs/slow",
+		Command: []string{"sleep", "1"},
+		Env:     map[string]string{},
+	})
+
+	addr, cleanup := startTestServer(t, store)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	client := runpb.NewJobsClient(conn)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-emulator-sync-wait", "2s")
+	op, err := client.RunJob(ctx, &runpb.RunJobRequest{
+		Name: "projects/test-project/locations/us-central1/jobs/fast",
+	})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	if !op.Done {
+		t.Fatal("expected fast job to return a completed operation")
+	}
+	var exec runpb.Execution
+	if err := op.GetResponse().UnmarshalTo(&exec); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if exec.SucceededCount != 1 {
+		t.Errorf("expected succeeded count 1, got %d", exec.SucceededCount)
+	}
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-emulator-sync-wait", "50ms")
+	op, err = client.RunJob(ctx, &runpb.RunJobRequest{
+		Name: "projects/test-project/locations/us-central1/jobs/slow",
+	})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	if op.Done {
+		t.Error("expected slow job to return an incomplete operation")
+	}
+}
+
+func TestCancelExecutionPreservesPartialResults(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{
+		Name:  "projects/test-project/locations/us-central1/jobs/fanout",
+		Image: "alpine:latest",
+		Env:   map[string]string{},
+	}
+	store.SaveJob(job)
+	store.SaveExecution(&state.Execution{
+		Name:           job.Name + "/executions/partial",
+		Job:            job,
+		Status:         state.StatusRunning,
+		StartTime:      time.Now(),
+		TaskCount:      5,
+		SucceededCount: 2,
+		FailedCount:    1,
+	})
+
+	addr, cleanup := startTestServer(t, store)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	client := runpb.NewExecutionsClient(conn)
+	ctx := context.Background()
+
+	if _, err := client.CancelExecution(ctx, &runpb.CancelExecutionRequest{
+		Name: job.Name + "/executions/partial",
+	}); err != nil {
+		t.Fatalf("CancelExecution failed: %v", err)
+	}
+
+	exec, err := client.GetExecution(ctx, &runpb.GetExecutionRequest{Name: job.Name + "/executions/partial"})
+	if err != nil {
+		t.Fatalf("GetExecution failed: %v", err)
+	}
+	if exec.TaskCount != 5 || exec.SucceededCount != 2 || exec.FailedCount != 1 || exec.CancelledCount != 2 {
+		t.Errorf("expected 5 tasks with 2 succeeded, 1 failed, 2 cancelled; got %d/%d/%d/%d",
+			exec.TaskCount, exec.SucceededCount, exec.FailedCount, exec.CancelledCount)
+	}
+}
+
+func TestListExecutionsStartTimeRange(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{
+		Name:  "projects/test-project/locations/us-central1/jobs/ranged",
+		Image: "alpine:latest",
+		Env:   map[string]string{},
+	}
+	store.SaveJob(job)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, id := range []string{"a", "b", "c", "d"} {
+		store.SaveExecution(&state.Execution{
+			Name:      job.Name + "/executions/" + id,
+			Job:       job,
+			Status:    state.StatusSucceeded,
+			StartTime: base.Add(time.Duration(i) * time.Hour),
+		})
+	}
+
+	addr, cleanup := startTestServer(t, store)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	client := runpb.NewExecutionsClient(conn)
+
+	list := func(ctx context.Context, req *runpb.ListExecutionsRequest) []string {
+		t.Helper()
+		req.Parent = job.Name
+		resp, err := client.ListExecutions(ctx, req)
+		if err != nil {
+			t.Fatalf("ListExecutions failed: %v", err)
+		}
+		var ids []string
+		for _, e := range resp.Executions {
+			ids = append(ids, e.Name[len(job.Name+"/executions/"):])
+		}
+		return ids
+	}
+	rangeCtx := func(after, before time.Time) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(),
+			"x-emulator-start-time-after", after.Format(time.RFC3339),
+			"x-emulator-start-time-before", before.Format(time.RFC3339))
+	}
+
+	// The lower bound is inclusive and the upper bound exclusive.
+	got := list(rangeCtx(base.Add(time.Hour), base.Add(3*time.Hour)), &runpb.ListExecutionsRequest{})
+	if want := []string{"c", "b"}; !slices.Equal(got, want) {
+		t.Errorf("range: got %v, want %v", got, want)
+	}
+
+	// Filtering applies before paging.
+	ctx := rangeCtx(base.Add(time.Hour), base.Add(4*time.Hour))
+	resp, err := client.ListExecutions(ctx, &runpb.ListExecutionsRequest{Parent: job.Name, PageSize: 2})
+	if err != nil {
+		t.Fatalf("ListExecutions failed: %v", err)
+	}
+	if len(resp.Executions) != 2 || resp.NextPageToken == "" {
+		t.Fatalf("expected a full first page and a next token, got %d executions, token %q", len(resp.Executions), resp.NextPageToken)
+	}
+	got = list(ctx, &runpb.ListExecutionsRequest{PageSize: 2, PageToken: resp.NextPageToken})
+	if want := []string{"b"}; !slices.Equal(got, want) {
+		t.Errorf("second page: got %v, want %v", got, want)
+	}
+
+	badCtx := metadata.AppendToOutgoingContext(context.Background(), "x-emulator-start-time-after", "yesterday")
+	_, err = client.ListExecutions(badCtx, &runpb.ListExecutionsRequest{Parent: job.Name})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a malformed bound, got %v", err)
+	}
+}
+
+func TestListFilterExpressions(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{
+		Name:            "projects/test-project/locations/us-central1/jobs/payments",
+		Image:           "alpine:latest",
+		Env:             map[string]string{},
+		ExecutionLabels: map[string]string{"team": "payments"},
+	}
+	store.SaveJob(job)
+	store.SaveJob(&state.Job{
+		Name:  "projects/test-project/locations/us-central1/jobs/search",
+		Image: "busybox:latest",
+		Env:   map[string]string{},
+	})
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, e := range []struct {
+		id     string
+		status state.ExecutionStatus
+		team   string
+	}{
+		{"a", state.StatusFailed, "payments"},
+		{"b", state.StatusSucceeded, "payments"},
+		{"c", state.StatusFailed, "search"},
+		{"d", state.StatusFailed, "payments ops"},
+		{"e", state.StatusRunning, "payments"},
+	} {
+		store.SaveExecution(&state.Execution{
+			Name:      job.Name + "/executions/" + e.id,
+			Job:       job,
+			Labels:    map[string]string{"team": e.team},
+			Status:    e.status,
+			StartTime: base.Add(time.Duration(i) * time.Hour),
+		})
+	}
+
+	addr, cleanup := startTestServer(t, store)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	execClient := runpb.NewExecutionsClient(conn)
+	jobsClient := runpb.NewJobsClient(conn)
+	filterCtx := func(expr string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "x-emulator-filter", expr)
+	}
+	listExecs := func(expr string) ([]string, error) {
+		resp, err := execClient.ListExecutions(filterCtx(expr), &runpb.ListExecutionsRequest{Parent: job.Name})
+		if err != nil {
+			return nil, err
+		}
+		var ids []string
+		for _, e := range resp.Executions {
+			ids = append(ids, e.Name[len(job.Name+"/executions/"):])
+		}
+		return ids, nil
+	}
+
+	for expr, want := range map[string][]string{
+		"status=FAILED":    {"d", "c", "a"},
+		"status = RUNNING": {"e"},
+		"status=RUNNING AND labels.team=payments":          {"e"},
+		"status=FAILED AND labels.team=payments":           {"a"},
+		`status = FAILED AND labels.team = "payments ops"`: {"d"},
+		"labels.team=payments AND status=SUCCEEDED":        {"b"},
+		"labels.missing=x":                                 nil,
+	} {
+		got, err := listExecs(expr)
+		if err != nil {
+			t.Errorf("%q: ListExecutions failed: %v", expr, err)
+			continue
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%q: got %v, want %v", expr, got, want)
+		}
+	}
+
+	for _, expr := range []string{
+		"status!=FAILED",
+		"status>FAILED",
+		"labels.team:payments",
+		"status=FAILED OR status=SUCCEEDED",
+		"status=FAILED AND",
+		"status=",
+		"=FAILED",
+		`labels.team="payments`,
+		"exit_code=1",
+		"labels.=x",
+		" ",
+	} {
+		if _, err := listExecs(expr); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%q: expected InvalidArgument, got %v", expr, err)
+		}
+	}
+
+	resp, err := jobsClient.ListJobs(filterCtx("image=alpine:latest AND labels.team=payments"), &runpb.ListJobsRequest{
+		Parent: "projects/test-project/locations/us-central1",
+	})
+	if err != nil {
+		t.Fatalf("ListJobs failed: %v", err)
+	}
+	if len(resp.Jobs) != 1 || resp.Jobs[0].Name != job.Name {
+		t.Errorf("expected only %s, got %v", job.Name, resp.Jobs)
+	}
+	_, err = jobsClient.ListJobs(filterCtx("status=FAILED"), &runpb.ListJobsRequest{
+		Parent: "projects/test-project/locations/us-central1",
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for an unsupported job field, got %v", err)
+	}
+}
+
+func TestRunJobResourcePrecedence(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:               "projects/test-project/locations/us-central1/jobs/sized",
+		Image:              "alpine:latest",
+		Env:                map[string]string{},
+		Resources:          state.Resources{MilliCPU: 2000, MemoryBytes: 1 << 30},
+		ExecutionResources: state.Resources{MilliCPU: 500},
+	})
+	store.SaveJob(&state.Job{
+		Name:  "projects/test-project/locations/us-central1/jobs/unsized",
+		Image: "alpine:latest",
+		Env:   map[string]string{},
+	})
+
+	exec := &blockingExecutor{release: make(chan struct{})}
+	close(exec.release)
+	srv := server.New(store, exec, server.Opts{
+		DefaultResources: state.Resources{MilliCPU: 1000, MemoryBytes: 512 << 20},
+	})
+	addr, cleanup := serve(t, srv)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	jobsClient := runpb.NewJobsClient(conn)
+	execClient := runpb.NewExecutionsClient(conn)
+	ctx := context.Background()
+
+	tests := []struct {
+		job         string
+		cpu, memory string
+	}{
+		// The execution template's CPU wins over the container's; memory
+		// falls back to the container.
+		{job: "sized", cpu: "500m", memory: "1Gi"},
+		{job: "unsized", cpu: "1", memory: "512Mi"},
+	}
+	for _, tt := range tests {
+		op, err := jobsClient.RunJob(ctx, &runpb.RunJobRequest{Name: "projects/test-project/locations/us-central1/jobs/" + tt.job})
+		if err != nil {
+			t.Fatalf("RunJob(%s) failed: %v", tt.job, err)
+		}
+		e, err := execClient.GetExecution(ctx, &runpb.GetExecutionRequest{Name: op.Name})
+		if err != nil {
+			t.Fatalf("GetExecution failed: %v", err)
+		}
+		limits := e.GetTemplate().GetContainers()[0].GetResources().GetLimits()
+		if limits["cpu"] != tt.cpu || limits["memory"] != tt.memory {
+			t.Errorf("%s: effective limits %v, want cpu=%s memory=%s", tt.job, limits, tt.cpu, tt.memory)
+		}
+	}
+}
+
+func TestJobDefaultsApplyToUnsetJobs(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:  "projects/test-project/locations/us-central1/jobs/unset",
+		Image: "alpine:latest",
+		Env:   map[string]string{},
+	})
+	store.SaveJob(&state.Job{
+		Name:        "projects/test-project/locations/us-central1/jobs/explicit",
+		Image:       "alpine:latest",
+		Env:         map[string]string{},
+		TaskCount:   5,
+		Parallelism: 4,
+	})
+
+	exec := &blockingExecutor{release: make(chan struct{})}
+	close(exec.release)
+	srv := server.New(store, exec, server.Opts{DefaultTaskCount: 3, DefaultParallelism: 2})
+	addr, cleanup := serve(t, srv)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	jobsClient := runpb.NewJobsClient(conn)
+	execClient := runpb.NewExecutionsClient(conn)
+	ctx := context.Background()
+
+	tests := []struct {
+		job                    string
+		taskCount, parallelism int32
+	}{
+		{job: "unset", taskCount: 3, parallelism: 2},
+		{job: "explicit", taskCount: 5, parallelism: 4},
+	}
+	for _, tt := range tests {
+		name := "projects/test-project/locations/us-central1/jobs/" + tt.job
+		job, err := jobsClient.GetJob(ctx, &runpb.GetJobRequest{Name: name})
+		if err != nil {
+			t.Fatalf("GetJob(%s) failed: %v", tt.job, err)
+		}
+		if tmpl := job.GetTemplate(); tmpl.TaskCount != tt.taskCount || tmpl.Parallelism != tt.parallelism {
+			t.Errorf("%s: job reports task count %d and parallelism %d, want %d and %d",
+				tt.job, tmpl.TaskCount, tmpl.Parallelism, tt.taskCount, tt.parallelism)
+		}
+
+		op, err := jobsClient.RunJob(ctx, &runpb.RunJobRequest{Name: name})
+		if err != nil {
+			t.Fatalf("RunJob(%s) failed: %v", tt.job, err)
+		}
+		e, err := execClient.GetExecution(ctx, &runpb.GetExecutionRequest{Name: op.Name})
+		if err != nil {
+			t.Fatalf("GetExecution failed: %v", err)
+		}
+		if e.TaskCount != tt.taskCount || e.Parallelism != tt.parallelism {
+			t.Errorf("%s: execution has task count %d and parallelism %d, want %d and %d",
+				tt.job, e.TaskCount, e.Parallelism, tt.taskCount, tt.parallelism)
+		}
+	}
+}
+
+// panickingExecutor panics on every run.
+type panickingExecutor struct{}
+
+func (panickingExecutor) Run(exec *state.Execution, env map[string]string) {
+	var job *state.Job
+	_ = job.Name // nil pointer dereference
+}
+
+func (panickingExecutor) Cancel(exec *state.Execution) error { return nil }
+
+func TestExecutorPanicFailsExecution(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:  "projects/test-project/locations/us-central1/jobs/buggy",
+		Image: "alpine:latest",
+		Env:   map[string]string{},
+	})
+
+	addr, cleanup := serve(t, server.New(store, panickingExecutor{}, server.Opts{}))
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	jobsClient := runpb.NewJobsClient(conn)
+	execClient := runpb.NewExecutionsClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-emulator-sync-wait", "2s")
+
+	op, err := jobsClient.RunJob(ctx, &runpb.RunJobRequest{Name: "projects/test-project/locations/us-central1/jobs/buggy"})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	if !op.Done {
+		t.Fatal("expected the panicking run to finish")
+	}
+
+	exec, err := execClient.GetExecution(context.Background(), &runpb.GetExecutionRequest{Name: op.Name})
+	if err != nil {
+		t.Fatalf("server did not survive the panic: %v", err)
+	}
+	if exec.FailedCount != 1 || len(exec.Conditions) == 0 || exec.Conditions[0].State != runpb.Condition_CONDITION_FAILED {
+		t.Errorf("expected a failed execution, got %+v", exec)
+	}
+	if msg := exec.Conditions[0].Message; !strings.Contains(msg, "internal error") {
+		t.Errorf("expected an internal error message, got %q", msg)
+	}
+}
+
+func TestCreateJobTimeoutAppliesToRuns(t *testing.T) {
+	store := state.NewStore()
+	addr, cleanup := startTestServer(t, store)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	jobsClient := runpb.NewJobsClient(conn)
+	execClient := runpb.NewExecutionsClient(conn)
+	ctx := context.Background()
+
+	_, err := jobsClient.CreateJob(ctx, &runpb.CreateJobRequest{
+		Parent: "projects/test-project/locations/us-central1",
+		JobId:  "slow",
+		Job: &runpb.Job{
+			Template: &runpb.ExecutionTemplate{
+				Template: &runpb.TaskTemplate{
+					Timeout:    durationpb.New(100 * time.Millisecond),
+					Containers: []*runpb.Container{{Image: "alpine:latest", Command: []string{"sleep", "5"}}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateJob failed: %v", err)
+	}
+
+	job, err := jobsClient.GetJob(ctx, &runpb.GetJobRequest{Name: "projects/test-project/locations/us-central1/jobs/slow"})
+	if err != nil {
+		t.Fatalf("GetJob failed: %v", err)
+	}
+	if got := job.Template.Template.Timeout.AsDuration(); got != 100*time.Millisecond {
+		t.Errorf("expected timeout 100ms on the job, got %s", got)
+	}
+
+	waitCtx := metadata.AppendToOutgoingContext(ctx, "x-emulator-sync-wait", "3s")
+	op, err := jobsClient.RunJob(waitCtx, &runpb.RunJobRequest{Name: "projects/test-project/locations/us-central1/jobs/slow"})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	if !op.Done {
+		t.Fatal("expected the run to be stopped by its timeout")
+	}
+
+	exec, err := execClient.GetExecution(ctx, &runpb.GetExecutionRequest{Name: op.Name})
+	if err != nil {
+		t.Fatalf("GetExecution failed: %v", err)
+	}
+	if exec.FailedCount != 1 || !strings.Contains(exec.Conditions[0].Message, "timed out") {
+		t.Errorf("expected a timed out failure, got %+v", exec)
+	}
+}
+
+func TestRunSourceLabel(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{
+		Name:            "projects/test-project/locations/us-central1/jobs/sourced",
+		Image:           "alpine:latest",
+		Env:             map[string]string{},
+		ExecutionLabels: map[string]string{"team": "data"},
+	}
+	store.SaveJob(job)
+	store.SaveExecution(&state.Execution{
+		Name:   job.Name + "/executions/unlabelled",
+		Job:    job,
+		Status: state.StatusSucceeded,
+	})
+
+	exec := &blockingExecutor{release: make(chan struct{})}
+	close(exec.release)
+	addr, cleanup := serve(t, server.New(store, exec, server.Opts{LabelRunSource: true}))
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	jobsClient := runpb.NewJobsClient(conn)
+	execClient := runpb.NewExecutionsClient(conn)
+	ctx := context.Background()
+
+	op, err := jobsClient.RunJob(ctx, &runpb.RunJobRequest{Name: job.Name})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	e, err := execClient.GetExecution(ctx, &runpb.GetExecutionRequest{Name: op.Name})
+	if err != nil {
+		t.Fatalf("GetExecution failed: %v", err)
+	}
+	if e.Labels[server.RunSourceLabel] != "api" || e.Labels["team"] != "data" {
+		t.Errorf("expected run source and template labels, got %v", e.Labels)
+	}
+
+	filterCtx := metadata.AppendToOutgoingContext(ctx, "x-emulator-label", server.RunSourceLabel+"=api")
+	resp, err := execClient.ListExecutions(filterCtx, &runpb.ListExecutionsRequest{Parent: job.Name})
+	if err != nil {
+		t.Fatalf("ListExecutions failed: %v", err)
+	}
+	if len(resp.Executions) != 1 || resp.Executions[0].Name != op.Name {
+		t.Errorf("expected only the API-started execution, got %v", resp.Executions)
+	}
+}
+
+// validatingExecutor knows only the images in known.
+type validatingExecutor struct {
+	blockingExecutor
+	known map[string]bool
+}
+
+func (e *validatingExecutor) ValidateImage(ctx context.Context, ref string) error {
+	if !e.known[ref] {
+		return fmt.Errorf("%w: %s", executor.ErrImageNotFound, ref)
+	}
+	return nil
+}
+
+func TestCreateJobValidatesImages(t *testing.T) {
+	exec := &validatingExecutor{known: map[string]bool{"alpine:latest": true}}
+	addr, cleanup := serve(t, server.New(state.NewStore(), exec, server.Opts{ValidateImages: true}))
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	client := runpb.NewJobsClient(conn)
+	create := func(id, image string) error {
+		_, err := client.CreateJob(context.Background(), &runpb.CreateJobRequest{
+			Parent: "projects/test-project/locations/us-central1",
+			JobId:  id,
+			Job: &runpb.Job{
+				Template: &runpb.ExecutionTemplate{
+					Template: &runpb.TaskTemplate{
+						Containers: []*runpb.Container{{Image: image}},
+					},
+				},
+			},
+		})
+		return err
+	}
+
+	err := create("typo", "alpnie:latest")
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "image not found") {
+		t.Errorf("expected InvalidArgument image not found, got %v", err)
+	}
+	if err := create("valid", "alpine:latest"); err != nil {
+		t.Errorf("expected a known image to be accepted, got %v", err)
+	}
+}
+
+func TestDeleteJobMidRun(t *testing.T) {
+	for _, reject := range []bool{false, true} {
+		store := state.NewStore()
+		store.SaveJob(&state.Job{
+			Name:  "projects/test-project/locations/us-central1/jobs/doomed",
+			Image: "alpine:latest",
+			Env:   map[string]string{},
+		})
+
+		exec := &blockingExecutor{release: make(chan struct{})}
+		addr, cleanup := serve(t, server.New(store, exec, server.Opts{RejectDeleteWhileRunning: reject}))
+
+		conn := dial(t, addr)
+		jobsClient := runpb.NewJobsClient(conn)
+		execClient := runpb.NewExecutionsClient(conn)
+		ctx := context.Background()
+
+		op, err := jobsClient.RunJob(ctx, &runpb.RunJobRequest{Name: "projects/test-project/locations/us-central1/jobs/doomed"})
+		if err != nil {
+			t.Fatalf("RunJob failed: %v", err)
+		}
+
+		_, err = jobsClient.DeleteJob(ctx, &runpb.DeleteJobRequest{Name: "projects/test-project/locations/us-central1/jobs/doomed"})
+		if reject {
+			if status.Code(err) != codes.FailedPrecondition {
+				t.Errorf("expected FailedPrecondition deleting a running job, got %v", err)
+			}
+		} else if err != nil {
+			t.Fatalf("DeleteJob failed: %v", err)
+		}
+
+		close(exec.release)
+		var e *runpb.Execution
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			e, err = execClient.GetExecution(ctx, &runpb.GetExecutionRequest{Name: op.Name})
+			if err != nil {
+				t.Fatalf("execution not resolvable after deleting its job (reject=%v): %v", reject, err)
+			}
+			if e.CompletionTime != nil || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if e.Job != "projects/test-project/locations/us-central1/jobs/doomed" || e.SucceededCount != 1 {
+			t.Errorf("expected the execution to finish against its job (reject=%v), got %+v", reject, e)
+		}
+
+		conn.Close()
+		cleanup()
+	}
+}
+
+func TestScheduledRuns(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{
+		Name:     "projects/test-project/locations/us-central1/jobs/nightly",
+		Command:  []string{"true"},
+		Env:      map[string]string{},
+		Schedule: "@every 1s",
+	}
+	store.SaveJob(job)
+	store.SaveJob(&state.Job{
+		Name:    "projects/test-project/locations/us-central1/jobs/manual",
+		Command: []string{"true"},
+		Env:     map[string]string{},
+	})
+
+	srv := server.New(store, executor.NewSubprocessExecutor(executor.SubprocessExecutorOpts{}), server.Opts{LabelRunSource: true})
+	_, cleanup := serve(t, srv)
+	defer cleanup()
+	if err := srv.StartSchedules(); err != nil {
+		t.Fatalf("StartSchedules failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(store.ListExecutions(job.Name)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for a scheduled run")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	exec := store.ListExecutions(job.Name)[0]
+	if got := exec.Labels[server.RunSourceLabel]; got != "schedule" {
+		t.Errorf("expected run source schedule, got %q", got)
+	}
+	if n := len(store.ListExecutions("projects/test-project/locations/us-central1/jobs/manual")); n != 0 {
+		t.Errorf("expected the unscheduled job not to run, got %d executions", n)
+	}
+}
+
+func TestStartSchedulesRejectsInvalidSchedule(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:     "projects/test-project/locations/us-central1/jobs/broken",
+		Command:  []string{"true"},
+		Env:      map[string]string{},
+		Schedule: "every tuesday",
+	})
+
+	srv := server.New(store, executor.NewSubprocessExecutor(executor.SubprocessExecutorOpts{}), server.Opts{})
+	defer srv.Stop()
+	if err := srv.StartSchedules(); err == nil {
+		t.Error("expected an error for an invalid schedule")
+	}
+}
+
+func TestGetOperationRetention(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{
+		Name:    "projects/test-project/locations/us-central1/jobs/retained",
+		Command: []string{"true"},
+		Env:     map[string]string{},
+	}
+	store.SaveJob(job)
+
+	exec := &blockingExecutor{release: make(chan struct{})}
+	srv := server.New(store, exec, server.Opts{OperationRetention: 100 * time.Millisecond})
+	addr, cleanup := serve(t, srv)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+	jobs := runpb.NewJobsClient(conn)
+	ops := longrunningpb.NewOperationsClient(conn)
+	ctx := context.Background()
+
+	op, err := jobs.RunJob(ctx, &runpb.RunJobRequest{Name: job.Name})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+
+	// Operations for running executions are kept past the retention.
+	time.Sleep(300 * time.Millisecond)
+	got, err := ops.GetOperation(ctx, &longrunningpb.GetOperationRequest{Name: op.Name})
+	if err != nil {
+		t.Fatalf("GetOperation for a running execution failed: %v", err)
+	}
+	if got.Done {
+		t.Error("expected the operation to be in progress")
+	}
+
+	close(exec.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err = ops.GetOperation(ctx, &longrunningpb.GetOperationRequest{Name: op.Name})
+		if status.Code(err) == codes.NotFound {
+			break
+		}
+		if err != nil {
+			t.Fatalf("GetOperation failed: %v", err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the completed operation to be pruned, still have done=%v", got.Done)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Pruning the operation leaves the execution itself.
+	if _, err := store.GetExecution(op.Name); err != nil {
+		t.Errorf("expected the execution to remain: %v", err)
+	}
+}
+
+func TestGetOperationPollsUntilDone(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{
+		Name:  "projects/test-project/locations/us-central1/jobs/polled",
+		Image: "alpine:latest",
+		Env:   map[string]string{},
+	}
+	store.SaveJob(job)
+
+	exec := &blockingExecutor{release: make(chan struct{})}
+	addr, cleanup := serve(t, server.New(store, exec, server.Opts{}))
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+	ops := longrunningpb.NewOperationsClient(conn)
+	ctx := context.Background()
+
+	op, err := runpb.NewJobsClient(conn).RunJob(ctx, &runpb.RunJobRequest{Name: job.Name})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+
+	got, err := ops.GetOperation(ctx, &longrunningpb.GetOperationRequest{Name: op.Name})
+	if err != nil {
+		t.Fatalf("GetOperation failed: %v", err)
+	}
+	if got.Done || got.GetResponse() != nil {
+		t.Fatal("expected the operation to be in progress")
+	}
+
+	close(exec.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for !got.Done {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the operation to complete")
+		}
+		time.Sleep(10 * time.Millisecond)
+		if got, err = ops.GetOperation(ctx, &longrunningpb.GetOperationRequest{Name: op.Name}); err != nil {
+			t.Fatalf("GetOperation failed: %v", err)
+		}
+	}
+
+	var result runpb.Execution
+	if err := got.GetResponse().UnmarshalTo(&result); err != nil {
+		t.Fatalf("unmarshalling the operation result: %v", err)
+	}
+	if result.Name != op.Name || result.SucceededCount != 1 || result.CompletionTime == nil {
+		t.Errorf("expected the finished execution as the result, got %+v", &result)
+	}
+
+	_, err = ops.GetOperation(ctx, &longrunningpb.GetOperationRequest{Name: job.Name + "/executions/missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for an unknown operation, got %v", err)
+	}
+}
+
+// TestRunJobOperationWait follows the client library's RunJob(...).Wait flow:
+// poll the returned operation until it is done, then read the execution from
+// its response.
+func TestRunJobOperationWait(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{
+		Name:    "projects/test-project/locations/us-central1/jobs/waited",
+		Command: []string{"true"},
+		Env:     map[string]string{},
+	}
+	store.SaveJob(job)
+
+	addr, cleanup := startTestServer(t, store)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+	ops := longrunningpb.NewOperationsClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	op, err := runpb.NewJobsClient(conn).RunJob(ctx, &runpb.RunJobRequest{Name: job.Name})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	for !op.Done {
+		time.Sleep(10 * time.Millisecond)
+		if op, err = ops.GetOperation(ctx, &longrunningpb.GetOperationRequest{Name: op.Name}); err != nil {
+			t.Fatalf("GetOperation failed: %v", err)
+		}
+	}
+
+	var exec runpb.Execution
+	if err := op.GetResponse().UnmarshalTo(&exec); err != nil {
+		t.Fatalf("unmarshalling the operation result: %v", err)
+	}
+	if exec.SucceededCount != 1 {
+		t.Errorf("expected SucceededCount 1, got %d", exec.SucceededCount)
+	}
+	if len(exec.Conditions) != 1 || exec.Conditions[0].Type != "Completed" || exec.Conditions[0].State != runpb.Condition_CONDITION_SUCCEEDED {
+		t.Errorf("expected a succeeded Completed condition, got %v", exec.Conditions)
+	}
+}
+
+func TestCreateJobTaskCount(t *testing.T) {
+	store := state.NewStore()
+	addr, cleanup := startTestServer(t, store)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	jobsClient := runpb.NewJobsClient(conn)
+	ctx := context.Background()
+	name := "projects/test-project/locations/us-central1/jobs/fanout"
+
+	_, err := jobsClient.CreateJob(ctx, &runpb.CreateJobRequest{
+		Parent: "projects/test-project/locations/us-central1",
+		JobId:  "fanout",
+		Job: &runpb.Job{
+			Template: &runpb.ExecutionTemplate{
+				TaskCount: 3,
+				Template: &runpb.TaskTemplate{
+					Containers: []*runpb.Container{{Command: []string{"true"}}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateJob failed: %v", err)
+	}
+
+	job, err := jobsClient.GetJob(ctx, &runpb.GetJobRequest{Name: name})
+	if err != nil {
+		t.Fatalf("GetJob failed: %v", err)
+	}
+	if job.Template.TaskCount != 3 {
+		t.Errorf("expected task count 3 on the job, got %d", job.Template.TaskCount)
+	}
+
+	waitCtx := metadata.AppendToOutgoingContext(ctx, "x-emulator-sync-wait", "5s")
+	op, err := jobsClient.RunJob(waitCtx, &runpb.RunJobRequest{Name: name})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	var exec runpb.Execution
+	if err := op.GetResponse().UnmarshalTo(&exec); err != nil {
+		t.Fatalf("expected a finished execution: %v", err)
+	}
+	if exec.TaskCount != 3 || exec.SucceededCount != 3 || exec.Conditions[0].State != runpb.Condition_CONDITION_SUCCEEDED {
+		t.Errorf("expected all 3 tasks to succeed, got %+v", &exec)
+	}
+
+	// A task count override applies to a single run.
+	op, err = jobsClient.RunJob(waitCtx, &runpb.RunJobRequest{
+		Name:      name,
+		Overrides: &runpb.RunJobRequest_Overrides{TaskCount: 2},
+	})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	if err := op.GetResponse().UnmarshalTo(&exec); err != nil {
+		t.Fatalf("expected a finished execution: %v", err)
+	}
+	if exec.TaskCount != 2 || exec.SucceededCount != 2 {
+		t.Errorf("expected the override's 2 tasks to run, got %+v", &exec)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:  "projects/test-project/locations/us-central1/jobs/hang",
+		Image: "alpine:latest",
+		Env:   map[string]string{},
+	})
+
+	exec := &blockingExecutor{release: make(chan struct{})}
+	defer close(exec.release)
+	srv := server.New(store, exec, server.Opts{RequestTimeout: 200 * time.Millisecond})
+	addr, cleanup := serve(t, srv)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	client := runpb.NewJobsClient(conn)
+
+	// A sync wait longer than the timeout keeps the handler busy past it.
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-emulator-sync-wait", "30s")
+	start := time.Now()
+	_, err := client.RunJob(ctx, &runpb.RunJobRequest{
+		Name: "projects/test-project/locations/us-central1/jobs/hang",
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the call to be cut off at the timeout, took %s", elapsed)
+	}
+
+	// Fast calls are unaffected.
+	if _, err := client.GetJob(context.Background(), &runpb.GetJobRequest{
+		Name: "projects/test-project/locations/us-central1/jobs/hang",
+	}); err != nil {
+		t.Fatalf("GetJob failed: %v", err)
+	}
+}
+
+func TestCreateJobMaxRetries(t *testing.T) {
+	store := state.NewStore()
+	addr, cleanup := startTestServer(t, store)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	jobsClient := runpb.NewJobsClient(conn)
+	ctx := context.Background()
+	name := "projects/test-project/locations/us-central1/jobs/failing"
+
+	_, err := jobsClient.CreateJob(ctx, &runpb.CreateJobRequest{
+		Parent: "projects/test-project/locations/us-central1",
+		JobId:  "failing",
+		Job: &runpb.Job{
+			Template: &runpb.ExecutionTemplate{
+				Template: &runpb.TaskTemplate{
+					Retries:    &runpb.TaskTemplate_MaxRetries{MaxRetries: 2},
+					Containers: []*runpb.Container{{Command: []string{"false"}}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateJob failed: %v", err)
+	}
+
+	job, err := jobsClient.GetJob(ctx, &runpb.GetJobRequest{Name: name})
+	if err != nil {
+		t.Fatalf("GetJob failed: %v", err)
+	}
+	if got := job.Template.Template.GetMaxRetries(); got != 2 {
+		t.Errorf("expected max retries 2 on the job, got %d", got)
+	}
+
+	waitCtx := metadata.AppendToOutgoingContext(ctx, "x-emulator-sync-wait", "5s")
+	op, err := jobsClient.RunJob(waitCtx, &runpb.RunJobRequest{Name: name})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	var exec runpb.Execution
+	if err := op.GetResponse().UnmarshalTo(&exec); err != nil {
+		t.Fatalf("expected a finished execution: %v", err)
+	}
+	if exec.FailedCount != 1 || exec.RetriedCount != 2 {
+		t.Errorf("expected 1 failed task after 2 retries, got %d failed, %d retried", exec.FailedCount, exec.RetriedCount)
+	}
+
+	_, err = jobsClient.CreateJob(ctx, &runpb.CreateJobRequest{
+		Parent: "projects/test-project/locations/us-central1",
+		JobId:  "negative",
+		Job: &runpb.Job{
+			Template: &runpb.ExecutionTemplate{
+				Template: &runpb.TaskTemplate{
+					Retries:    &runpb.TaskTemplate_MaxRetries{MaxRetries: -1},
+					Containers: []*runpb.Container{{Command: []string{"true"}}},
+				},
+			},
+		},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for negative max retries, got %v", err)
+	}
+}
+
+func TestCreateJobKeepsSidecars(t *testing.T) {
+	store := state.NewStore()
+	addr, cleanup := startTestServer(t, store)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	jobsClient := runpb.NewJobsClient(conn)
+	ctx := context.Background()
+	containers := []*runpb.Container{
+		{Name: "app", Image: "app:latest", DependsOn: []string{"proxy"}},
+		{
+			Name:  "proxy",
+			Image: "proxy:latest",
+			Env:   []*runpb.EnvVar{{Name: "PORT", Values: &runpb.EnvVar_Value{Value: "5432"}}},
+		},
+	}
+	_, err := jobsClient.CreateJob(ctx, &runpb.CreateJobRequest{
+		Parent: "projects/test-project/locations/us-central1",
+		JobId:  "proxied",
+		Job:    &runpb.Job{Template: &runpb.ExecutionTemplate{Template: &runpb.TaskTemplate{Containers: containers}}},
+	})
+	if err != nil {
+		t.Fatalf("CreateJob failed: %v", err)
+	}
+
+	job, err := jobsClient.GetJob(ctx, &runpb.GetJobRequest{Name: "projects/test-project/locations/us-central1/jobs/proxied"})
+	if err != nil {
+		t.Fatalf("GetJob failed: %v", err)
+	}
+	got := job.Template.Template.Containers
+	if len(got) != 2 || got[0].Name != "app" || got[0].DependsOn[0] != "proxy" || got[1].Image != "proxy:latest" || got[1].Env[0].GetValue() != "5432" {
+		t.Errorf("expected both containers back, got %v", got)
+	}
+	if stored, _ := store.GetJob(job.Name); len(stored.Sidecars) != 1 || stored.Sidecars[0].Name != "proxy" {
+		t.Errorf("expected the proxy sidecar to be stored, got %+v", stored.Sidecars)
+	}
+
+	containers[1].DependsOn = []string{"missing"}
+	_, err = jobsClient.CreateJob(ctx, &runpb.CreateJobRequest{
+		Parent: "projects/test-project/locations/us-central1",
+		JobId:  "broken",
+		Job:    &runpb.Job{Template: &runpb.ExecutionTemplate{Template: &runpb.TaskTemplate{Containers: containers}}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a dependency on an unknown container, got %v", err)
+	}
+}
+
+func TestRunJobTimeoutOverride(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:    "projects/test-project/locations/us-central1/jobs/sleepy",
+		Command: []string{"sleep", "5"},
+		Env:     map[string]string{},
+		Timeout: time.Minute,
+	})
+
+	addr, cleanup := startTestServer(t, store)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	client := runpb.NewJobsClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-emulator-sync-wait", "5s")
+	op, err := client.RunJob(ctx, &runpb.RunJobRequest{
+		Name:      "projects/test-project/locations/us-central1/jobs/sleepy",
+		Overrides: &runpb.RunJobRequest_Overrides{Timeout: durationpb.New(100 * time.Millisecond)},
+	})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	var exec runpb.Execution
+	if err := op.GetResponse().UnmarshalTo(&exec); err != nil {
+		t.Fatalf("expected the execution to time out within the sync wait: %v", err)
+	}
+	if exec.FailedCount != 1 || exec.Conditions[0].State != runpb.Condition_CONDITION_FAILED {
+		t.Fatalf("expected a failed execution, got %+v", &exec)
+	}
+	if msg := exec.Conditions[0].Message; !strings.Contains(msg, "timed out after 100ms") {
+		t.Errorf("expected the error to mention the deadline, got %q", msg)
+	}
+
+	_, err = client.RunJob(context.Background(), &runpb.RunJobRequest{
+		Name:      "projects/test-project/locations/us-central1/jobs/sleepy",
+		Overrides: &runpb.RunJobRequest_Overrides{Timeout: durationpb.New(-time.Second)},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a negative timeout, got %v", err)
+	}
+}
+
+func TestRunJobArgsOverride(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:    "projects/test-project/locations/us-central1/jobs/args",
+		Command: []string{"sh", "-c", `test "$1" = override`, "sh"},
+		Args:    []string{"job"},
+		Env:     map[string]string{},
+	})
+
+	addr, cleanup := startTestServer(t, store)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	client := runpb.NewJobsClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-emulator-sync-wait", "5s")
+	op, err := client.RunJob(ctx, &runpb.RunJobRequest{
+		Name: "projects/test-project/locations/us-central1/jobs/args",
+		Overrides: &runpb.RunJobRequest_Overrides{
+			ContainerOverrides: []*runpb.RunJobRequest_Overrides_ContainerOverride{{Args: []string{"override"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	var exec runpb.Execution
+	if err := op.GetResponse().UnmarshalTo(&exec); err != nil {
+		t.Fatalf("expected the execution to finish within the sync wait: %v", err)
+	}
+	if exec.SucceededCount != 1 {
+		t.Fatalf("expected the command to run with the overridden args, got %+v", &exec)
+	}
+	if args := exec.Template.Containers[0].Args; !slices.Equal(args, []string{"override"}) {
+		t.Errorf("expected the template to report the overridden args, got %q", args)
+	}
+}
+
+func TestRunJobResolvesSecretEnv(t *testing.T) {
+	store := state.NewStore()
+	addr, cleanup := startTestServerWithOpts(t, store, server.Opts{
+		Secrets: map[string]string{"db-password": "hunter2"},
+	})
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	client := runpb.NewJobsClient(conn)
+	ctx := context.Background()
+	secretJob := func(id, secret string) string {
+		t.Helper()
+		_, err := client.CreateJob(ctx, &runpb.CreateJobRequest{
+			Parent: "projects/test-project/locations/us-central1",
+			JobId:  id,
+			Job: &runpb.Job{
+				Template: &runpb.ExecutionTemplate{
+					Template: &runpb.TaskTemplate{
+						Containers: []*runpb.Container{{
+							Command: []string{"sh", "-c", `test "$DB_PASSWORD" = hunter2`},
+							Env: []*runpb.EnvVar{{
+								Name: "DB_PASSWORD",
+								Values: &runpb.EnvVar_ValueSource{ValueSource: &runpb.EnvVarSource{
+									SecretKeyRef: &runpb.SecretKeySelector{Secret: secret, Version: "latest"},
+								}},
+							}},
+						}},
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("CreateJob failed: %v", err)
+		}
+		return "projects/test-project/locations/us-central1/jobs/" + id
+	}
+
+	name := secretJob("with-secret", "projects/test-project/secrets/db-password")
+	job, err := client.GetJob(ctx, &runpb.GetJobRequest{Name: name})
+	if err != nil {
+		t.Fatalf("GetJob failed: %v", err)
+	}
+	env := job.Template.Template.Containers[0].Env
+	if len(env) != 1 || env[0].GetValueSource().GetSecretKeyRef().GetSecret() != "db-password" {
+		t.Errorf("expected the secret reference to round-trip, got %v", env)
+	}
+
+	waitCtx := metadata.AppendToOutgoingContext(ctx, "x-emulator-sync-wait", "5s")
+	op, err := client.RunJob(waitCtx, &runpb.RunJobRequest{Name: name})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	var exec runpb.Execution
+	if err := op.GetResponse().UnmarshalTo(&exec); err != nil {
+		t.Fatalf("expected a finished execution: %v", err)
+	}
+	if exec.SucceededCount != 1 {
+		t.Errorf("expected the secret to reach the job, got %+v", &exec)
+	}
+
+	_, err = client.CreateJob(ctx, &runpb.CreateJobRequest{
+		Parent: "projects/test-project/locations/us-central1",
+		JobId:  "pinned-secret",
+		Job: &runpb.Job{Template: &runpb.ExecutionTemplate{Template: &runpb.TaskTemplate{
+			Containers: []*runpb.Container{{
+				Image: "alpine:latest",
+				Env: []*runpb.EnvVar{{
+					Name: "DB_PASSWORD",
+					Values: &runpb.EnvVar_ValueSource{ValueSource: &runpb.EnvVarSource{
+						SecretKeyRef: &runpb.SecretKeySelector{Secret: "db-password", Version: "3"},
+					}},
+				}},
+			}},
+		}}},
+	})
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), `secret version "3"`) {
+		t.Errorf("expected InvalidArgument for a pinned secret version, got %v", err)
+	}
+
+	name = secretJob("missing-secret", "api-key")
+	_, err = client.RunJob(ctx, &runpb.RunJobRequest{Name: name})
+	if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), `secret "api-key" not found`) {
+		t.Errorf("expected FailedPrecondition for a missing secret, got %v", err)
+	}
+}
+
+// failingExecutor fails every execution it runs.
+type failingExecutor struct{}
+
+func (failingExecutor) Run(exec *state.Execution, env map[string]string) {
+	exec.Lock()
+	defer exec.Unlock()
+	exec.Status = state.StatusFailed
+	exec.FailedCount = 1
+	exec.ErrorMessage = "flaky infrastructure"
+	exec.CompletionTime = time.Now()
+}
+
+func (failingExecutor) Cancel(exec *state.Execution) error { return nil }
+
+func TestRunJobRetriesFailedExecution(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{
+		Name:             "projects/test-project/locations/us-central1/jobs/flaky",
+		Image:            "alpine:latest",
+		Env:              map[string]string{},
+		ExecutionRetries: 2,
+	}
+	store.SaveJob(job)
+
+	addr, cleanup := serve(t, server.New(store, failingExecutor{}, server.Opts{}))
+	defer cleanup()
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	op, err := runpb.NewJobsClient(conn).RunJob(context.Background(), &runpb.RunJobRequest{Name: job.Name})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	var first runpb.Execution
+	if err := op.GetMetadata().UnmarshalTo(&first); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var execs []*state.Execution
+	for {
+		execs = store.ListExecutions(job.Name)
+		finished := 0
+		for _, e := range execs {
+			if e.Status.IsTerminal() {
+				finished++
+			}
+		}
+		if finished == 3 {
+			break
+		}
+		if len(execs) > 3 || time.Now().After(deadline) {
+			t.Fatalf("expected 3 finished executions, got %d of %d", finished, len(execs))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Give a spurious fourth execution a chance to appear.
+	time.Sleep(50 * time.Millisecond)
+	if n := len(store.ListExecutions(job.Name)); n != 3 {
+		t.Fatalf("expected the original execution and 2 retries, got %d executions", n)
+	}
+
+	// Follow the chain back from the last retry.
+	byID := make(map[string]*state.Execution)
+	for _, e := range execs {
+		byID[path.Base(e.Name)] = e
+	}
+	var chain []string
+	for _, e := range execs {
+		if e.Labels[server.RetryAttemptLabel] != "2" {
+			continue
+		}
+		for e != nil {
+			chain = append(chain, e.Name)
+			e = byID[e.Labels[server.RetryOfLabel]]
+		}
+	}
+	if len(chain) != 3 || chain[2] != first.Name {
+		t.Errorf("expected a chain of 3 executions back to %s, got %v", first.Name, chain)
+	}
+}
+
+func TestRunJobOperationMetadataReportsEffectiveRun(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:      "projects/test-project/locations/us-central1/jobs/report",
+		Image:     "alpine:3.20",
+		Command:   []string{"true"},
+		Env:       map[string]string{"API_TOKEN": "plain-secret"},
+		SecretEnv: map[string]string{"DB_PASSWORD": "db-password"},
+		Timeout:   time.Hour,
+	})
+
+	exec := &blockingExecutor{release: make(chan struct{})}
+	defer close(exec.release)
+	srv := server.New(store, exec, server.Opts{Secrets: map[string]string{"db-password": "hunter2"}})
+	addr, cleanup := serve(t, srv)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	op, err := runpb.NewJobsClient(conn).RunJob(context.Background(), &runpb.RunJobRequest{
+		Name: "projects/test-project/locations/us-central1/jobs/report",
+		Overrides: &runpb.RunJobRequest_Overrides{
+			TaskCount: 4,
+			Timeout:   durationpb.New(10 * time.Minute),
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+
+	var meta runpb.Execution
+	if err := op.GetMetadata().UnmarshalTo(&meta); err != nil {
+		t.Fatalf("unpacking metadata: %v", err)
+	}
+	if meta.TaskCount != 4 {
+		t.Errorf("expected task count 4, got %d", meta.TaskCount)
+	}
+	tmpl := meta.GetTemplate()
+	if got := tmpl.GetTimeout().AsDuration(); got != 10*time.Minute {
+		t.Errorf("expected the overridden timeout of 10m, got %s", got)
+	}
+	containers := tmpl.GetContainers()
+	if len(containers) != 1 || containers[0].Image != "alpine:3.20" {
+		t.Fatalf("expected the job's image, got %v", containers)
+	}
+	if len(containers[0].Env) > 0 {
+		t.Errorf("expected no env vars in the metadata, got %v", containers[0].Env)
+	}
+	if data, _ := proto.Marshal(op.GetMetadata()); bytes.Contains(data, []byte("hunter2")) || bytes.Contains(data, []byte("plain-secret")) {
+		t.Error("operation metadata leaks env var values")
+	}
+}
+
+func TestExecutionReportsExitCode(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:    "projects/test-project/locations/us-central1/jobs/exit-three",
+		Command: []string{"sh", "-c", "exit 3"},
+		Env:     map[string]string{},
+	})
+	addr, cleanup := startTestServer(t, store)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-emulator-sync-wait", "5s")
+	op, err := runpb.NewJobsClient(conn).RunJob(ctx, &runpb.RunJobRequest{Name: "projects/test-project/locations/us-central1/jobs/exit-three"})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	var exec runpb.Execution
+	if err := op.GetResponse().UnmarshalTo(&exec); err != nil {
+		t.Fatalf("expected a finished execution: %v", err)
+	}
+	if len(exec.Conditions) != 1 || exec.Conditions[0].GetExecutionReason() != runpb.Condition_NON_ZERO_EXIT_CODE {
+		t.Fatalf("expected a NON_ZERO_EXIT_CODE condition, got %v", exec.Conditions)
+	}
+	if msg := exec.Conditions[0].Message; !strings.Contains(msg, "exit status 3") {
+		t.Errorf("expected the condition message to report exit status 3, got %q", msg)
+	}
+	tasks, err := runpb.NewTasksClient(conn).ListTasks(context.Background(), &runpb.ListTasksRequest{Parent: exec.Name})
+	if err != nil {
+		t.Fatalf("ListTasks failed: %v", err)
+	}
+	if len(tasks.Tasks) != 1 || tasks.Tasks[0].GetLastAttemptResult().GetExitCode() != 3 {
+		t.Errorf("expected the task's last attempt to report exit code 3, got %v", tasks.Tasks)
+	}
+
+	stored, err := store.GetExecution(exec.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %d", stored.ExitCode)
+	}
+}
+
+func TestAPIKeyRequired(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:  "projects/test-project/locations/us-central1/jobs/guarded",
+		Image: "alpine:latest",
+		Env:   map[string]string{},
+	})
+	addr, cleanup := startTestServerWithOpts(t, store, server.Opts{APIKey: "letmein"})
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	jobsClient := runpb.NewJobsClient(conn)
+	execClient := runpb.NewExecutionsClient(conn)
+	jobReq := &runpb.GetJobRequest{Name: "projects/test-project/locations/us-central1/jobs/guarded"}
+	execReq := &runpb.ListExecutionsRequest{Parent: jobReq.Name}
+
+	for key, want := range map[string]codes.Code{
+		"":        codes.Unauthenticated,
+		"wrong":   codes.Unauthenticated,
+		"letmein": codes.OK,
+	} {
+		ctx := context.Background()
+		if key != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", key)
+		}
+		if _, err := jobsClient.GetJob(ctx, jobReq); status.Code(err) != want {
+			t.Errorf("GetJob with key %q: got %v, want %s", key, err, want)
+		}
+		if _, err := execClient.ListExecutions(ctx, execReq); status.Code(err) != want {
+			t.Errorf("ListExecutions with key %q: got %v, want %s", key, err, want)
+		}
+	}
+}
+
+func TestMaxConcurrentExecutionsQueuesRuns(t *testing.T) {
+	store := state.NewStore()
+	for _, id := range []string{"first", "second"} {
+		store.SaveJob(&state.Job{
+			Name:  "projects/test-project/locations/us-central1/jobs/" + id,
+			Image: "alpine:latest",
+			Env:   map[string]string{},
+		})
+	}
+	exec := &blockingExecutor{release: make(chan struct{})}
+	addr, cleanup := serve(t, server.New(store, exec, server.Opts{MaxConcurrentExecutions: 1}))
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	jobsClient := runpb.NewJobsClient(conn)
+	execClient := runpb.NewExecutionsClient(conn)
+	ctx := context.Background()
+	run := func(id string) string {
+		t.Helper()
+		op, err := jobsClient.RunJob(ctx, &runpb.RunJobRequest{Name: "projects/test-project/locations/us-central1/jobs/" + id})
+		if err != nil {
+			t.Fatalf("RunJob(%s) failed: %v", id, err)
+		}
+		return op.Name
+	}
+	get := func(name string) *runpb.Execution {
+		t.Helper()
+		e, err := execClient.GetExecution(ctx, &runpb.GetExecutionRequest{Name: name})
+		if err != nil {
+			t.Fatalf("GetExecution failed: %v", err)
+		}
+		return e
+	}
+	first, second := run("first"), run("second")
+
+	time.Sleep(50 * time.Millisecond)
+	if e := get(first); e.RunningCount != 1 {
+		t.Errorf("expected the first execution to be running, got %v", e)
+	}
+	e := get(second)
+	if e.RunningCount != 0 || len(e.Conditions) != 1 || e.Conditions[0].State != runpb.Condition_CONDITION_PENDING {
+		t.Errorf("expected the second execution to be pending, got %v", e)
+	}
+	if n := exec.runCount(); n != 0 {
+		t.Errorf("expected no execution to have finished, got %d", n)
+	}
+
+	close(exec.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for get(second).SucceededCount != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the second execution to run once the first finished, got %v", get(second))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	exec.mu.Lock()
+	defer exec.mu.Unlock()
+	if !slices.Equal(exec.ran, []string{first, second}) {
+		t.Errorf("expected the executions to run in order, got %v", exec.ran)
+	}
+}
+
+func TestTasksAPI(t *testing.T) {
+	store := state.NewStore()
+	store.SaveJob(&state.Job{
+		Name:       "projects/test-project/locations/us-central1/jobs/shards",
+		Command:    []string{"sh", "-c", `test "$CLOUD_RUN_TASK_INDEX" != 1`},
+		Env:        map[string]string{},
+		TaskCount:  3,
+		MaxRetries: 1,
+	})
+	addr, cleanup := startTestServer(t, store)
+	defer cleanup()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-emulator-sync-wait", "5s")
+	op, err := runpb.NewJobsClient(conn).RunJob(ctx, &runpb.RunJobRequest{Name: "projects/test-project/locations/us-central1/jobs/shards"})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	if !op.Done {
+		t.Fatal("expected the execution to finish within the sync wait")
+	}
+
+	tasksClient := runpb.NewTasksClient(conn)
+	resp, err := tasksClient.ListTasks(ctx, &runpb.ListTasksRequest{Parent: op.Name})
+	if err != nil {
+		t.Fatalf("ListTasks failed: %v", err)
+	}
+	if len(resp.Tasks) != 3 {
+		t.Fatalf("expected 3 tasks, got %d", len(resp.Tasks))
+	}
+	for i, task := range resp.Tasks {
+		wantState, wantExit, wantRetried := runpb.Condition_CONDITION_SUCCEEDED, int32(0), int32(0)
+		if i == 1 {
+			wantState, wantExit, wantRetried = runpb.Condition_CONDITION_FAILED, 1, 1
+		}
+		if task.Name != fmt.Sprintf("%s/tasks/%d", op.Name, i) || task.Index != int32(i) || task.Execution != op.Name {
+			t.Errorf("task %d: unexpected identity %s (index %d, execution %s)", i, task.Name, task.Index, task.Execution)
+		}
+		if task.Conditions[0].State != wantState || task.GetLastAttemptResult().GetExitCode() != wantExit || task.Retried != wantRetried {
+			t.Errorf("task %d: got %s, exit code %d, retried %d; want %s, %d, %d",
+				i, task.Conditions[0].State, task.GetLastAttemptResult().GetExitCode(), task.Retried, wantState, wantExit, wantRetried)
+		}
+		if task.StartTime == nil || task.CompletionTime == nil {
+			t.Errorf("task %d: expected start and completion times", i)
+		}
+	}
+
+	task, err := tasksClient.GetTask(ctx, &runpb.GetTaskRequest{Name: op.Name + "/tasks/1"})
+	if err != nil {
+		t.Fatalf("GetTask failed: %v", err)
+	}
+	if !strings.Contains(task.Conditions[0].Message, "exit status 1") {
+		t.Errorf("expected the failure message, got %q", task.Conditions[0].Message)
+	}
+	for _, name := range []string{op.Name + "/tasks/3", op.Name + "/tasks/x"} {
+		if _, err := tasksClient.GetTask(ctx, &runpb.GetTaskRequest{Name: name}); status.Code(err) != codes.NotFound {
+			t.Errorf("GetTask(%s): expected NotFound, got %v", name, err)
+		}
+	}
+}
+
+func TestHealthService(t *testing.T) {
+	srv := server.New(state.NewStore(), &blockingExecutor{}, server.Opts{})
+	addr, _ := serve(t, srv)
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, svc := range []string{"", "google.cloud.run.v2.Jobs", "google.cloud.run.v2.Executions"} {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: svc})
+		if err != nil {
+			t.Fatalf("Check(%q): %v", svc, err)
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("Check(%q): expected SERVING, got %s", svc, resp.Status)
+		}
+	}
+
+	watch, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected SERVING before Stop, got %v, %v", resp, err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		srv.Stop()
+	}()
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("expected NOT_SERVING once stopping, got %v, %v", resp, err)
+	}
+	// Stop waits for open streams, such as this watch, to finish.
+	cancel()
+	<-stopped
+}
+
+func TestStartOnUnixSocket(t *testing.T) {
+	// t.TempDir paths can exceed the Unix socket path limit.
+	dir, err := os.MkdirTemp("", "emulator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "emulator.sock")
+
+	// Leave a stale socket behind, as a crashed emulator would.
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	srv := server.New(state.NewStore(), &blockingExecutor{}, server.Opts{ProjectID: "test-project", Region: "us-central1"})
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Start("unix://" + sock) }()
+
+	conn := dial(t, "unix://"+sock)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := runpb.NewJobsClient(conn)
+	if _, err := client.ListJobs(ctx, &runpb.ListJobsRequest{Parent: "projects/test-project/locations/us-central1"}, grpc.WaitForReady(true)); err != nil {
+		t.Fatalf("ListJobs over the socket: %v", err)
+	}
+
+	srv.Stop()
+	if err := <-errc; err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("expected the socket to be removed on Stop, got %v", err)
+	}
+}
+
+func TestCompletionWebhook(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+	received := make(chan map[string]any, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		first := attempts == 1
+		mu.Unlock()
+		// Fail the first delivery, to check it is retried.
+		if first {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decoding webhook payload: %v", err)
+		}
+		received <- payload
+	}))
+	defer hook.Close()
+
+	store := state.NewStore()
+	job := &state.Job{Name: "projects/test-project/locations/us-central1/jobs/notify", Image: "alpine:latest", Env: map[string]string{}}
+	store.SaveJob(job)
+	srv := server.New(store, failingExecutor{}, server.Opts{CompletionWebhookURL: hook.URL})
+	addr, cleanup := serve(t, srv)
+	defer cleanup()
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	op, err := runpb.NewJobsClient(conn).RunJob(context.Background(), &runpb.RunJobRequest{Name: job.Name})
+	if err != nil {
+		t.Fatalf("RunJob failed: %v", err)
+	}
+	var meta runpb.Execution
+	if err := op.GetMetadata().UnmarshalTo(&meta); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case payload := <-received:
+		if payload["execution"] != meta.Name || payload["job"] != job.Name || payload["status"] != "FAILED" {
+			t.Errorf("unexpected webhook payload %v", payload)
+		}
+		if _, ok := payload["completionTime"]; !ok {
+			t.Errorf("expected a completion time in %v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the completion webhook")
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	runpb "cloud.google.com/go/run/apiv2/runpb"
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TasksServer serves the tasks of executions. Tasks are named by their
+// index: {execution}/tasks/0, {execution}/tasks/1, ...
+type TasksServer struct {
+	runpb.UnimplementedTasksServer
+	store *state.Store
+	names nameValidator
+}
+
+func (s *TasksServer) GetTask(ctx context.Context, req *runpb.GetTaskRequest) (*runpb.Task, error) {
+	slog.Info("GetTask called", "name", req.Name)
+
+	execName, id, ok := cutLast(req.Name, "/tasks/")
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid name: %q", req.Name)
+	}
+	if err := s.names.execution(execName); err != nil {
+		return nil, err
+	}
+
+	exec, err := s.store.GetExecution(execName)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "execution not found: %s", execName)
+	}
+	index, err := strconv.Atoi(id)
+	if err != nil || index < 0 || index >= int(exec.Tasks()) {
+		return nil, status.Errorf(codes.NotFound, "task not found: %s", req.Name)
+	}
+	return taskToProto(exec.Snapshot(), index), nil
+}
+
+// ListTasks lists an execution's tasks in index order.
+func (s *TasksServer) ListTasks(ctx context.Context, req *runpb.ListTasksRequest) (*runpb.ListTasksResponse, error) {
+	slog.Info("ListTasks called", "parent", req.Parent)
+
+	if err := s.names.execution(req.Parent); err != nil {
+		return nil, err
+	}
+
+	exec, err := s.store.GetExecution(req.Parent)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "execution not found: %s", req.Parent)
+	}
+
+	start, end, nextToken, err := page(int(exec.Tasks()), req.PageSize, req.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	resp := &runpb.ListTasksResponse{NextPageToken: nextToken}
+	exec = exec.Snapshot()
+	for i := start; i < end; i++ {
+		resp.Tasks = append(resp.Tasks, taskToProto(exec, i))
+	}
+	return resp, nil
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// taskToProto converts the task of e, a snapshot, with the given index to its
+// protobuf representation.
+func taskToProto(e *state.Execution, index int) *runpb.Task {
+	var t state.TaskState
+	if index < len(e.TaskStates) && e.TaskStates[index] != nil {
+		t = *e.TaskStates[index]
+	}
+	// A task left unfinished by a finished execution never will finish:
+	// it was cancelled, or the emulator restarted under it.
+	if !t.Status.IsTerminal() && e.Status.IsTerminal() {
+		t.Status = state.StatusFailed
+		if e.Status == state.StatusCancelled {
+			t.Status = state.StatusCancelled
+		}
+	}
+
+	task := &runpb.Task{
+		Name:       e.Name + "/tasks/" + strconv.Itoa(index),
+		Job:        e.Job.Name,
+		Execution:  e.Name,
+		Labels:     copyLabels(e.Labels),
+		Index:      int32(index),
+		Retried:    t.Retried,
+		MaxRetries: int32(e.Job.MaxRetries),
+		CreateTime: timestamppb.New(e.StartTime),
+		Containers: append([]*runpb.Container{{
+			Name:      e.Job.ContainerName,
+			Image:     e.Job.Image,
+			Command:   e.Job.Command,
+			Args:      e.TaskArgs(),
+			Resources: resourcesToProto(e.Resources),
+		}}, sidecarsToProto(e.Job.Sidecars, false)...),
+		Reconciling: t.Status == state.StatusRunning,
+	}
+	if timeout := e.TaskTimeout(); timeout > 0 {
+		task.Timeout = durationpb.New(timeout)
+	}
+	if !t.StartTime.IsZero() {
+		task.StartTime = timestamppb.New(t.StartTime)
+	}
+	if !t.CompletionTime.IsZero() {
+		task.CompletionTime = timestamppb.New(t.CompletionTime)
+	}
+
+	condition := &runpb.Condition{Type: "Completed"}
+	switch t.Status {
+	case state.StatusPending:
+		condition.State = runpb.Condition_CONDITION_PENDING
+	case state.StatusRunning:
+		condition.State = runpb.Condition_CONDITION_RECONCILING
+	case state.StatusSucceeded:
+		condition.State = runpb.Condition_CONDITION_SUCCEEDED
+	case state.StatusFailed, state.StatusCancelled:
+		condition.State = runpb.Condition_CONDITION_FAILED
+		condition.Message = t.ErrorMessage
+	}
+	task.Conditions = []*runpb.Condition{condition}
+
+	if t.Status == state.StatusSucceeded || t.Status == state.StatusFailed {
+		result := &runpb.TaskAttemptResult{ExitCode: t.ExitCode, Status: &spb.Status{}}
+		if t.Status == state.StatusFailed {
+			result.Status = &spb.Status{Code: int32(codes.Unknown), Message: t.ErrorMessage}
+		}
+		task.LastAttemptResult = result
+	}
+	return task
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+const (
+	// webhookAttempts is how many times a completion notification is sent
+	// before giving up, each attempt bounded by webhookTimeout.
+	webhookAttempts = 3
+	webhookTimeout  = 5 * time.Second
+	// webhookBackoff is the wait before the first retry, doubling after.
+	webhookBackoff = 500 * time.Millisecond
+)
+
+// completionPayload is the JSON body POSTed to the completion webhook.
+type completionPayload struct {
+	Execution      string    `json:"execution"`
+	Job            string    `json:"job"`
+	Status         string    `json:"status"`
+	ExitCode       int32     `json:"exitCode"`
+	StartTime      time.Time `json:"startTime"`
+	CompletionTime time.Time `json:"completionTime"`
+}
+
+// completionWebhook POSTs a completionPayload to url whenever an execution
+// finishes. Delivery is at least once: failed attempts are retried, and a
+// notification that still fails is logged and dropped. A nil
+// completionWebhook does nothing.
+type completionWebhook struct {
+	url    string
+	client *http.Client
+	// pending tracks notifications still being delivered, for Stop.
+	pending sync.WaitGroup
+}
+
+// newCompletionWebhook returns a webhook notifying url, or nil if url is
+// empty.
+func newCompletionWebhook(url string) *completionWebhook {
+	if url == "" {
+		return nil
+	}
+	return &completionWebhook{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// notify sends exec's completion in the background. Call it once exec is
+// terminal.
+func (w *completionWebhook) notify(exec *state.Execution) {
+	if w == nil {
+		return
+	}
+	exec = exec.Snapshot()
+	payload := completionPayload{
+		Execution:      exec.Name,
+		Job:            exec.Job.Name,
+		Status:         exec.Status.String(),
+		ExitCode:       exec.ExitCode,
+		StartTime:      exec.StartTime,
+		CompletionTime: exec.CompletionTime,
+	}
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+		w.deliver(payload)
+	}()
+}
+
+// deliver POSTs payload, retrying with backoff until it is accepted or the
+// attempts run out.
+func (w *completionWebhook) deliver(payload completionPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("failed to encode completion webhook", "execution", payload.Execution, "error", err)
+		return
+	}
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err := w.post(body)
+		if err == nil {
+			slog.Debug("sent completion webhook", "execution", payload.Execution)
+			return
+		}
+		if attempt == webhookAttempts {
+			slog.Warn("failed to send completion webhook", "execution", payload.Execution, "url", w.url, "attempts", attempt, "error", err)
+			return
+		}
+		slog.Debug("retrying completion webhook", "execution", payload.Execution, "attempt", attempt, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (w *completionWebhook) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// wait blocks until notifications already started have been delivered or
+// given up on.
+func (w *completionWebhook) wait() {
+	if w == nil {
+		return
+	}
+	w.pending.Wait()
+}
@@ -1,6 +1,11 @@
 package state
 
-import "time"
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
 
 type ExecutionStatus int
 
@@ -29,16 +34,195 @@ func (s ExecutionStatus) String() string {
 	}
 }
 
+// ReasonOOMKilled is the FailureReason for executions whose container was
+// killed for exceeding its memory limit.
+const ReasonOOMKilled = "OOMKilled"
+
+// ReasonTimedOut is the FailureReason for executions stopped for exceeding
+// the job's timeout.
+const ReasonTimedOut = "TimedOut"
+
+// ReasonStartupProbeFailed is the FailureReason for executions whose
+// container never passed its startup probe.
+const ReasonStartupProbeFailed = "StartupProbeFailed"
+
+// ReasonWroteStderr is the FailureReason for executions of jobs with
+// FailOnStderr whose container wrote to stderr.
+const ReasonWroteStderr = "WroteStderr"
+
+// ReasonInternalError is the FailureReason for executions that failed due to
+// a bug in the emulator rather than the job.
+const ReasonInternalError = "InternalError"
+
+// IsTerminal reports whether the status is final.
+func (s ExecutionStatus) IsTerminal() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCancelled
+}
+
 // Execution represents a single job execution.
+//
+// Once an execution is started, its executor updates it while the server
+// reads it. Code updating a stored execution must hold its lock (see Lock),
+// and code reading one should read a Snapshot.
 type Execution struct {
+	mu sync.Mutex
 	// Full resource name: projects/{project}/locations/{location}/jobs/{job}/executions/{execution}
 	Name           string
 	Job            *Job
+	Labels         map[string]string
 	Status         ExecutionStatus
 	StartTime      time.Time
 	CompletionTime time.Time
+	// TaskCount is the number of tasks in the execution. Zero is treated as 1.
+	TaskCount int32
+	// Parallelism is the execution's reported parallelism. Zero is treated
+	// as 1.
+	Parallelism    int32
 	SucceededCount int32
 	FailedCount    int32
-	ErrorMessage   string
-	ContainerID    string // Docker container ID, used for cancellation
+	CancelledCount int32
+	// RetriedCount is the number of failed task attempts that were retried.
+	RetriedCount int32
+	// RetryAttempt counts the executions before this one in its chain of
+	// execution retries: 0 for a run that isn't a retry.
+	RetryAttempt  int
+	ErrorMessage  string
+	FailureReason string // machine-readable failure cause, e.g. ReasonOOMKilled
+	// ExitCode is the exit code of the failed task reported in
+	// ErrorMessage. Zero if no task failed, or the failure wasn't an exit.
+	ExitCode    int32
+	ContainerID string // Docker container ID, used for cancellation
+	// KeptContainerIDs are the containers of failed attempts left in place
+	// for debugging, with the Docker executor's KeepFailedContainers.
+	KeptContainerIDs []string
+	// PeakMemoryBytes and CPUTime are the highest memory usage of any of
+	// the execution's containers and their total CPU time, sampled with the
+	// Docker executor's CollectStats. Zero if not collected.
+	PeakMemoryBytes int64
+	CPUTime         time.Duration
+	// OmitTaskEnv stops executors injecting the CLOUD_RUN_* task metadata
+	// environment variables, for jobs that set their own.
+	OmitTaskEnv bool
+	// Timeout overrides the job's per-task timeout for this execution. Zero
+	// means the job's applies.
+	Timeout time.Duration
+	// Args overrides the job's args for this execution. Empty means the
+	// job's apply.
+	Args []string
+	// Overrides are the overrides the execution was started with, kept so
+	// it can be rerun. Nil if there were none.
+	Overrides *RunOverrides
+	// Env is the resolved environment the execution was started with:
+	// env_from files, the job's env and secrets, then overrides, without
+	// the CLOUD_RUN_* task metadata. It may hold secret values, so it is not
+	// persisted.
+	Env map[string]string `json:"-"`
+	// Resources are the effective limits the execution runs with.
+	Resources Resources
+	// TaskStates are the states of the execution's tasks, by index, once
+	// an executor has started on them. Use TaskState to access them.
+	TaskStates []*TaskState
+	// Logs captures the execution's most recent output. May be nil. Logs
+	// are not persisted.
+	Logs *LogBuffer `json:"-"`
+}
+
+// RunOverrides are the per-run settings of a RunJob request that take
+// precedence over the job's.
+type RunOverrides struct {
+	// Env holds the container env overrides, merged in request order.
+	Env map[string]string
+	// Args are the container args overrides, or empty if not overridden.
+	Args []string
+	// TaskCount and Timeout are zero if not overridden.
+	TaskCount int32
+	Timeout   time.Duration
+}
+
+// Lock locks the execution for updating its fields.
+func (e *Execution) Lock() {
+	e.mu.Lock()
+}
+
+// Unlock unlocks the execution.
+func (e *Execution) Unlock() {
+	e.mu.Unlock()
+}
+
+// Snapshot returns a copy of the execution, taken under its lock, that
+// shares nothing with it that executors update. The job, overrides and logs
+// are shared.
+func (e *Execution) Snapshot() *Execution {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c := &Execution{
+		Name:             e.Name,
+		Job:              e.Job,
+		Labels:           maps.Clone(e.Labels),
+		Status:           e.Status,
+		StartTime:        e.StartTime,
+		CompletionTime:   e.CompletionTime,
+		TaskCount:        e.TaskCount,
+		Parallelism:      e.Parallelism,
+		SucceededCount:   e.SucceededCount,
+		FailedCount:      e.FailedCount,
+		CancelledCount:   e.CancelledCount,
+		RetriedCount:     e.RetriedCount,
+		RetryAttempt:     e.RetryAttempt,
+		ErrorMessage:     e.ErrorMessage,
+		FailureReason:    e.FailureReason,
+		ExitCode:         e.ExitCode,
+		ContainerID:      e.ContainerID,
+		KeptContainerIDs: slices.Clone(e.KeptContainerIDs),
+		PeakMemoryBytes:  e.PeakMemoryBytes,
+		CPUTime:          e.CPUTime,
+		OmitTaskEnv:      e.OmitTaskEnv,
+		Timeout:          e.Timeout,
+		Args:             e.Args,
+		Overrides:        e.Overrides,
+		Env:              e.Env,
+		Resources:        e.Resources,
+		Logs:             e.Logs,
+	}
+	if e.TaskStates != nil {
+		c.TaskStates = make([]*TaskState, len(e.TaskStates))
+		for i, t := range e.TaskStates {
+			if t != nil {
+				copied := *t
+				c.TaskStates[i] = &copied
+			}
+		}
+	}
+	return c
+}
+
+// finished returns when the execution completed, and whether it has.
+func (e *Execution) finished() (time.Time, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.CompletionTime, e.Status.IsTerminal()
+}
+
+// TaskTimeout returns how long each task may run, or zero for no limit.
+func (e *Execution) TaskTimeout() time.Duration {
+	if e.Timeout > 0 {
+		return e.Timeout
+	}
+	return e.Job.Timeout
+}
+
+// TaskArgs returns the args each task's command is run with.
+func (e *Execution) TaskArgs() []string {
+	if len(e.Args) > 0 {
+		return e.Args
+	}
+	return e.Job.Args
+}
+
+// Tasks returns the number of tasks in the execution.
+func (e *Execution) Tasks() int32 {
+	if e.TaskCount <= 0 {
+		return 1
+	}
+	return e.TaskCount
 }
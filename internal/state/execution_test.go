@@ -0,0 +1,58 @@
+package state_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+func TestExecutionSnapshot(t *testing.T) {
+	exec := &state.Execution{Name: "projects/p/locations/l/jobs/j/executions/e", Job: &state.Job{Name: "projects/p/locations/l/jobs/j"}}
+	// Set every exported field, so a field Snapshot doesn't copy fails the
+	// comparison below.
+	v := reflect.ValueOf(exec).Elem()
+	for i := range v.NumField() {
+		f := v.Field(i)
+		if !v.Type().Field(i).IsExported() || !f.IsZero() {
+			continue
+		}
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString("set")
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			f.SetInt(1)
+		case reflect.Bool:
+			f.SetBool(true)
+		}
+	}
+	exec.Labels = map[string]string{"env": "test"}
+	exec.StartTime = time.Now()
+	exec.CompletionTime = exec.StartTime.Add(time.Second)
+	exec.KeptContainerIDs = []string{"abc"}
+	exec.Args = []string{"--day", "7"}
+	exec.Overrides = &state.RunOverrides{TaskCount: 2}
+	exec.Env = map[string]string{"MODE": "batch"}
+	exec.Resources = state.Resources{MilliCPU: 1000}
+	exec.Logs = state.NewLogBuffer(10, 0)
+	exec.TaskState(0).ExitCode = 3
+
+	snap := exec.Snapshot()
+	sv := reflect.ValueOf(snap).Elem()
+	for i := range v.NumField() {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if !reflect.DeepEqual(sv.Field(i).Interface(), v.Field(i).Interface()) {
+			t.Errorf("Snapshot didn't copy %s: got %v, want %v", field.Name, sv.Field(i).Interface(), v.Field(i).Interface())
+		}
+	}
+
+	exec.TaskState(0).ExitCode = 4
+	exec.KeptContainerIDs[0] = "def"
+	if snap.TaskStates[0].ExitCode != 3 || snap.KeptContainerIDs[0] != "abc" {
+		t.Error("expected the snapshot not to share task states or kept containers with the execution")
+	}
+}
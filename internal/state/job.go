@@ -1,15 +1,194 @@
 package state
 
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
 // Job represents a registered Cloud Run job.
 type Job struct {
 	// Full resource name: projects/{project}/locations/{location}/jobs/{job}
-	Name    string
-	Image   string
+	Name  string
+	Image string
+	// Command replaces the image's entrypoint and Args its default
+	// arguments (CMD), as in Cloud Run; either left empty keeps the image's.
+	// The subprocess executor runs Command followed by Args.
+	Command []string
+	Args    []string
+	// WorkingDir is the directory the job runs in: in the container with
+	// the Docker executor, on the host with the subprocess executor. Empty
+	// keeps the image's WORKDIR, or the emulator's own directory.
+	WorkingDir string
+	Env        map[string]string
+	// EnvFrom lists KEY=VALUE files loaded at run time, beneath Env. Later
+	// files override earlier ones.
+	EnvFrom []EnvSource
+	// ExecutionLabels are applied to every execution created from the job
+	// (from the job's ExecutionTemplate).
+	ExecutionLabels map[string]string
+	// Resources are the container's limits.
+	Resources Resources
+	// ExecutionResources override Resources for each run, like limits set on
+	// the job's ExecutionTemplate.
+	ExecutionResources Resources
+	// TaskCount is the number of tasks each execution runs. Zero means the
+	// emulator's default.
+	TaskCount int32
+	// Parallelism is the number of tasks reported as running at once. Zero
+	// means the emulator's default. Tasks still run one at a time.
+	Parallelism int32
+	// Timeout limits how long each attempt may run before it is stopped and
+	// failed. Zero means no limit.
+	Timeout time.Duration
+	// StopTimeout is how long the job's container has to exit after SIGTERM
+	// when it is cancelled or times out, before it is killed. Nil uses the
+	// executor's default; zero kills it straight away.
+	StopTimeout *time.Duration
+	// Stdin, when set, is piped to the job's standard input.
+	Stdin *StdinSource
+	// NetworkAliases are DNS names other containers on the job's network can
+	// use to reach it. Ignored with host networking.
+	NetworkAliases []string
+	// SecurityOpt are Docker security options for the job's container, such
+	// as seccomp=/path/profile.json or apparmor=profile. Ignored by the
+	// subprocess executor.
+	SecurityOpt []string
+	// SecretEnv maps environment variable names to the secrets whose values
+	// they take, resolved at run time from the emulator's secret store.
+	SecretEnv map[string]string
+	// StartupProbe, if set, must pass before the execution counts as
+	// running. Docker executor only.
+	StartupProbe *Probe
+	// EnvFilePath, if set, is an absolute path in the container where the
+	// run's environment is also written as a shell-sourceable file. Ignored
+	// by the subprocess executor.
+	EnvFilePath string
+	// Hostname is the container's hostname. Empty means one derived from
+	// the execution name. Ignored by the subprocess executor.
+	Hostname string
+	// FailOnStderr fails a task that writes anything to stderr, whatever its
+	// exit code, for frameworks that only report errors there.
+	FailOnStderr bool
+	// SuccessExitCodes lists the exit codes treated as a successful run.
+	// Empty means only 0 counts as success.
+	SuccessExitCodes []int
+	// MaxRetries is the number of times a failed task is retried before it
+	// counts as failed.
+	MaxRetries int
+	// RetryableExitCodes restricts retries to the listed exit codes. Empty
+	// means any unsuccessful exit code is retried.
+	RetryableExitCodes []int
+	// ExecutionRetries is how many times a failed execution is rerun as a
+	// new execution, after any task retries within it are exhausted.
+	ExecutionRetries int
+	// Schedule is a cron expression on which the emulator runs the job
+	// automatically, like a Cloud Scheduler trigger. Empty means never.
+	Schedule string
+	// ScheduleJitter delays each scheduled run by a random amount up to this
+	// long, like a real scheduler's dispatch latency.
+	ScheduleJitter time.Duration
+	// ConcurrencyGroup names a group of jobs whose executions run one at a
+	// time, e.g. jobs that write the same local database. Empty means none.
+	ConcurrencyGroup string
+	// ContainerName is the name of the main container, the one whose exit
+	// code is the task's result. Only needed when sidecars refer to it.
+	ContainerName string
+	// DependsOn names the sidecars the main container depends on. They are
+	// started after it regardless, since they join its network namespace.
+	DependsOn []string
+	// Sidecars are the job's other containers. Ignored by the subprocess
+	// executor.
+	Sidecars []Sidecar
+	// Volumes are host paths bind-mounted into the container. Ignored by
+	// the subprocess executor.
+	Volumes []Volume
+	// ReadOnlyRoot mounts the container's root filesystem read-only, with a
+	// tmpfs at /tmp unless a volume is mounted there. Ignored by the
+	// subprocess executor.
+	ReadOnlyRoot bool
+	// Platform is the os/arch[/variant] the job's images are pulled and run
+	// as, e.g. linux/amd64 for amd64-only images on an arm64 host. Empty
+	// uses the executor's default. Ignored by the subprocess executor.
+	Platform string
+	// FromConfig marks jobs registered from the jobs config file, which
+	// reloading the file may update or remove.
+	FromConfig bool
+}
+
+// Volume bind-mounts HostPath at ContainerPath, both absolute.
+type Volume struct {
+	HostPath      string
+	ContainerPath string
+	ReadOnly      bool
+}
+
+// Probe checks that a job's container has started, like a Kubernetes startup
+// probe. Exactly one of Command or TCPPort is set.
+type Probe struct {
+	// Command is run in the container; exit code 0 passes.
 	Command []string
-	Env     map[string]string
+	// TCPPort passes once a connection to the port can be opened.
+	TCPPort int
+	// InitialDelay is how long after the container starts to first probe.
+	InitialDelay time.Duration
+	// Period is the time between probes. Zero means 10s.
+	Period time.Duration
+	// FailureThreshold is how many consecutive failures fail the probe.
+	// Zero means 3.
+	FailureThreshold int
+}
+
+// EnvSource is a file of KEY=VALUE environment variables.
+type EnvSource struct {
+	Path string
+	// Optional skips the file if it doesn't exist instead of failing the run.
+	Optional bool
+}
+
+// StdinSource supplies data for a job's standard input. Exactly one of File
+// or Text is set.
+type StdinSource struct {
+	File string
+	Text string
+}
+
+// Open returns a reader over the stdin data.
+func (s *StdinSource) Open() (io.ReadCloser, error) {
+	if s.File != "" {
+		return os.Open(s.File)
+	}
+	return io.NopCloser(strings.NewReader(s.Text)), nil
 }
 
 // ShortName extracts the job ID from the full resource name.
 func (j *Job) ShortName() string {
 	return parseLastSegment(j.Name)
 }
+
+// IsSuccessExitCode reports whether code counts as a successful exit for this job.
+func (j *Job) IsSuccessExitCode(code int) bool {
+	if len(j.SuccessExitCodes) == 0 {
+		return code == 0
+	}
+	for _, c := range j.SuccessExitCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRetryableExitCode reports whether a run that exited with code may be retried.
+func (j *Job) IsRetryableExitCode(code int) bool {
+	if len(j.RetryableExitCodes) == 0 {
+		return true
+	}
+	for _, c := range j.RetryableExitCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package state
+
+import (
+	"sync"
+	"time"
+)
+
+// LogLine is a single captured line of execution output.
+type LogLine struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	// Task is the index of the task that wrote the line.
+	Task int    `json:"task"`
+	Text string `json:"text"`
+}
+
+// LogBuffer holds the most recent log lines of an execution, bounded by both
+// line count and total text size. When either limit is exceeded the oldest
+// lines are dropped and counted as truncated. A nil *LogBuffer discards
+// everything, so executors can append unconditionally.
+type LogBuffer struct {
+	mu        sync.Mutex
+	maxLines  int
+	maxBytes  int
+	lines     []LogLine
+	bytes     int
+	truncated int
+	// changed is closed, and replaced, when a line is appended.
+	changed chan struct{}
+}
+
+// NewLogBuffer returns a buffer keeping at most maxLines lines and maxBytes
+// bytes of text. A limit of zero or less disables that bound.
+func NewLogBuffer(maxLines, maxBytes int) *LogBuffer {
+	return &LogBuffer{maxLines: maxLines, maxBytes: maxBytes}
+}
+
+// Append adds a line, evicting the oldest lines as needed to stay in bounds.
+func (b *LogBuffer) Append(line LogLine) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lines = append(b.lines, line)
+	b.bytes += len(line.Text)
+	for len(b.lines) > 0 && b.overLimit() {
+		b.bytes -= len(b.lines[0].Text)
+		b.lines[0] = LogLine{}
+		b.lines = b.lines[1:]
+		b.truncated++
+	}
+	if b.changed != nil {
+		close(b.changed)
+		b.changed = nil
+	}
+}
+
+func (b *LogBuffer) overLimit() bool {
+	return (b.maxLines > 0 && len(b.lines) > b.maxLines) ||
+		(b.maxBytes > 0 && b.bytes > b.maxBytes)
+}
+
+// Snapshot returns a copy of the retained lines, oldest first, and the number
+// of lines dropped to stay within the limits.
+func (b *LogBuffer) Snapshot() ([]LogLine, int) {
+	if b == nil {
+		return nil, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]LogLine(nil), b.lines...), b.truncated
+}
+
+// Since returns the retained lines appended after the first n, and the total
+// number appended so far, to pass as n to the next call. Lines dropped before
+// they were read are skipped.
+func (b *LogBuffer) Since(n int) ([]LogLine, int) {
+	if b == nil {
+		return nil, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	total := b.truncated + len(b.lines)
+	if n >= total {
+		return nil, total
+	}
+	return append([]LogLine(nil), b.lines[max(n-b.truncated, 0):]...), total
+}
+
+// Changed returns a channel closed when the next line is appended. A nil
+// buffer never changes.
+func (b *LogBuffer) Changed() <-chan struct{} {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.changed == nil {
+		b.changed = make(chan struct{})
+	}
+	return b.changed
+}
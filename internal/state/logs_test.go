@@ -0,0 +1,68 @@
+package state_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+func TestLogBufferKeepsNewestLines(t *testing.T) {
+	buf := state.NewLogBuffer(3, 0)
+	for i := range 10 {
+		buf.Append(state.LogLine{Stream: "stdout", Text: fmt.Sprintf("line %d", i)})
+	}
+
+	lines, truncated := buf.Snapshot()
+	if truncated != 7 {
+		t.Errorf("truncated = %d, want 7", truncated)
+	}
+	var got []string
+	for _, l := range lines {
+		got = append(got, l.Text)
+	}
+	if want := "line 7,line 8,line 9"; strings.Join(got, ",") != want {
+		t.Errorf("retained %v, want %s", got, want)
+	}
+}
+
+func TestLogBufferByteLimit(t *testing.T) {
+	buf := state.NewLogBuffer(0, 10)
+	buf.Append(state.LogLine{Text: "aaaa"})
+	buf.Append(state.LogLine{Text: "bbbb"})
+	buf.Append(state.LogLine{Text: "cccc"})
+
+	lines, truncated := buf.Snapshot()
+	if truncated != 1 || len(lines) != 2 || lines[0].Text != "bbbb" {
+		t.Errorf("got %v (truncated %d), want [bbbb cccc] with 1 truncated", lines, truncated)
+	}
+}
+
+func TestLogBufferSince(t *testing.T) {
+	buf := state.NewLogBuffer(2, 0)
+	changed := buf.Changed()
+	buf.Append(state.LogLine{Text: "a"})
+	select {
+	case <-changed:
+	default:
+		t.Error("expected Changed to fire on append")
+	}
+
+	lines, next := buf.Since(0)
+	if len(lines) != 1 || next != 1 {
+		t.Fatalf("got %v, next %d; want [a], next 1", lines, next)
+	}
+	if lines, next = buf.Since(next); len(lines) != 0 || next != 1 {
+		t.Errorf("got %v, next %d; want nothing new", lines, next)
+	}
+
+	// Lines dropped before they were read are skipped.
+	for _, text := range []string{"b", "c", "d"} {
+		buf.Append(state.LogLine{Text: text})
+	}
+	lines, next = buf.Since(next)
+	if len(lines) != 2 || lines[0].Text != "c" || next != 4 {
+		t.Errorf("got %v, next %d; want [c d], next 4", lines, next)
+	}
+}
@@ -0,0 +1,153 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshot is the on-disk form of a Store.
+type snapshot struct {
+	Jobs       []*Job       `json:"jobs"`
+	Executions []*Execution `json:"executions"`
+	// Operations maps operation names to the execution each tracks, which
+	// may have been deleted since.
+	Operations map[string]*Execution `json:"operations"`
+}
+
+// OpenStore returns a store persisted to the JSON file at path, loading its
+// contents if the file exists. Every change made through the store rewrites
+// the file. Executions left pending or running by a previous emulator are
+// loaded as failed, since nothing is running them any more, and their logs
+// are not kept.
+func OpenStore(path string) (*Store, error) {
+	s := NewStore()
+	s.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %w", path, err)
+	}
+
+	now := time.Now()
+	for _, job := range snap.Jobs {
+		s.jobs[job.Name] = job
+	}
+	restore := func(exec *Execution) *Execution {
+		if existing, ok := s.executions[exec.Name]; ok {
+			return existing
+		}
+		// Share the stored job when it still exists, as executions created
+		// in this process do.
+		if job, ok := s.jobs[exec.Job.Name]; ok {
+			exec.Job = job
+		}
+		if !exec.Status.IsTerminal() {
+			exec.Status = StatusFailed
+			exec.ErrorMessage = "the emulator restarted before the execution finished"
+			exec.CompletionTime = now
+		}
+		return exec
+	}
+	for _, exec := range snap.Executions {
+		s.executions[exec.Name] = restore(exec)
+	}
+	for name, exec := range snap.Operations {
+		s.operations[name] = restore(exec)
+	}
+	return s, nil
+}
+
+// Sync writes the store to its state file, for changes made to stored
+// records in place, such as an execution finishing. It does nothing if the
+// store isn't persisted.
+func (s *Store) Sync() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.persistLocked()
+}
+
+// RemoveFile stops persisting the store and deletes its state file, so a
+// clean shutdown leaves nothing behind. It does nothing if the store isn't
+// persisted.
+func (s *Store) RemoveFile() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" {
+		return nil
+	}
+	path := s.path
+	s.path = ""
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing state file: %w", err)
+	}
+	return nil
+}
+
+// persistLocked writes the store to its state file, if it has one. Failures
+// are logged rather than returned: the in-memory state stays authoritative.
+// The caller must hold s.mu.
+func (s *Store) persistLocked() {
+	if s.path == "" {
+		return
+	}
+	// Executors update executions in place, so write snapshots of them.
+	snaps := make(map[*Execution]*Execution, len(s.executions))
+	snapshotOf := func(exec *Execution) *Execution {
+		if _, ok := snaps[exec]; !ok {
+			snaps[exec] = exec.Snapshot()
+		}
+		return snaps[exec]
+	}
+	snap := snapshot{Operations: make(map[string]*Execution, len(s.operations))}
+	for _, job := range s.jobs {
+		snap.Jobs = append(snap.Jobs, job)
+	}
+	for _, exec := range s.executions {
+		snap.Executions = append(snap.Executions, snapshotOf(exec))
+	}
+	for name, exec := range s.operations {
+		snap.Operations[name] = snapshotOf(exec)
+	}
+	if err := writeFileAtomic(s.path, snap); err != nil {
+		slog.Error("failed to write state file", "path", s.path, "error", err)
+	}
+}
+
+// writeFileAtomic writes v as JSON to a temporary file beside path and
+// renames it into place, so readers never see a partial file.
+func writeFileAtomic(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
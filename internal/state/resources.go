@@ -0,0 +1,151 @@
+package state
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Resources are compute limits for a job's container. Zero fields are unset.
+type Resources struct {
+	// MilliCPU is the CPU limit in thousandths of a CPU.
+	MilliCPU int64
+	// MemoryBytes is the memory limit in bytes.
+	MemoryBytes int64
+}
+
+// Or returns r with its unset fields taken from fallback.
+func (r Resources) Or(fallback Resources) Resources {
+	if r.MilliCPU == 0 {
+		r.MilliCPU = fallback.MilliCPU
+	}
+	if r.MemoryBytes == 0 {
+		r.MemoryBytes = fallback.MemoryBytes
+	}
+	return r
+}
+
+// ParseResources parses CPU and memory quantities, either of which may be
+// empty.
+func ParseResources(cpu, memory string) (Resources, error) {
+	milliCPU, err := ParseCPU(cpu)
+	if err != nil {
+		return Resources{}, err
+	}
+	memoryBytes, err := ParseMemory(memory)
+	if err != nil {
+		return Resources{}, err
+	}
+	return Resources{MilliCPU: milliCPU, MemoryBytes: memoryBytes}, nil
+}
+
+// ParseCPU parses a Kubernetes-style CPU quantity ("1", "0.5", "500m") into
+// millicpu. An empty string returns 0, meaning no limit.
+func ParseCPU(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if milli, ok := strings.CutSuffix(s, "m"); ok {
+		n, err := strconv.ParseInt(milli, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid cpu quantity %q", s)
+		}
+		return n, nil
+	}
+	cpus, ok := parseDecimal(s)
+	if !ok || cpus.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid cpu quantity %q", s)
+	}
+	// Round to the nearest millicpu.
+	milli := cpus.Mul(cpus, big.NewRat(1000, 1))
+	n := new(big.Int).Quo(
+		new(big.Int).Add(new(big.Int).Mul(milli.Num(), big.NewInt(2)), milli.Denom()),
+		new(big.Int).Mul(milli.Denom(), big.NewInt(2)))
+	if n.Sign() <= 0 {
+		return 0, fmt.Errorf("cpu quantity %q is below 1m", s)
+	}
+	if !n.IsInt64() {
+		return 0, fmt.Errorf("cpu quantity %q is too large", s)
+	}
+	return n.Int64(), nil
+}
+
+// decimalPattern matches the plain decimal numbers accepted in quantities:
+// no sign, exponent, NaN or Inf.
+var decimalPattern = regexp.MustCompile(`^([0-9]+(\.[0-9]*)?|\.[0-9]+)$`)
+
+// parseDecimal parses a plain decimal number such as "2", "1.5" or ".25"
+// exactly.
+func parseDecimal(s string) (*big.Rat, bool) {
+	if !decimalPattern.MatchString(s) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// FormatCPU renders millicpu in the canonical form used by Cloud Run
+// ("2" for whole CPUs, "500m" otherwise).
+func FormatCPU(milli int64) string {
+	if milli%1000 == 0 {
+		return strconv.FormatInt(milli/1000, 10)
+	}
+	return strconv.FormatInt(milli, 10) + "m"
+}
+
+// memoryUnits are the Kubernetes quantity suffixes accepted for memory,
+// binary units first so FormatMemory prefers them.
+var memoryUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"Gi", 1 << 30},
+	{"Mi", 1 << 20},
+	{"Ki", 1 << 10},
+	{"G", 1e9},
+	{"M", 1e6},
+	{"k", 1e3},
+}
+
+// ParseMemory parses a Kubernetes-style memory quantity ("512Mi", "1.5Gi",
+// "500M", "1048576") into bytes. An empty string returns 0, meaning no limit.
+func ParseMemory(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	digits, factor := s, int64(1)
+	for _, u := range memoryUnits {
+		if n, ok := strings.CutSuffix(s, u.suffix); ok {
+			digits, factor = n, u.factor
+			break
+		}
+	}
+	n, ok := parseDecimal(digits)
+	if !ok || n.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid memory quantity %q", s)
+	}
+	// Like Kubernetes, round fractional bytes up.
+	bytes := n.Mul(n, new(big.Rat).SetInt64(factor))
+	b := new(big.Int).Quo(bytes.Num(), bytes.Denom())
+	if !bytes.IsInt() {
+		b.Add(b, big.NewInt(1))
+	}
+	if !b.IsInt64() {
+		return 0, fmt.Errorf("memory quantity %q is too large", s)
+	}
+	return b.Int64(), nil
+}
+
+// FormatMemory renders bytes with the largest binary suffix that divides it
+// exactly ("512Mi", "1Gi").
+func FormatMemory(bytes int64) string {
+	for _, u := range memoryUnits[:3] {
+		if bytes%u.factor == 0 {
+			return strconv.FormatInt(bytes/u.factor, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(bytes, 10)
+}
@@ -0,0 +1,93 @@
+package state_test
+
+import (
+	"testing"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+func TestParseCPU(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "1", want: 1000},
+		{in: "2", want: 2000},
+		{in: "0.5", want: 500},
+		{in: "1.25", want: 1250},
+		{in: "500m", want: 500},
+		{in: "250m", want: 250},
+		{in: "abc", wantErr: true},
+		{in: "m", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "0", wantErr: true},
+		{in: "NaN", wantErr: true},
+		{in: "Inf", wantErr: true},
+		{in: "+Inf", wantErr: true},
+		{in: "1e3", wantErr: true},
+		{in: "0.0001", wantErr: true},
+		{in: "0.0005", want: 1},
+		{in: "99999999999999999999", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := state.ParseCPU(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseCPU(%q): expected error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCPU(%q): unexpected error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseCPU(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "512Mi", want: 512 << 20},
+		{in: "1Gi", want: 1 << 30},
+		{in: "64Ki", want: 64 << 10},
+		{in: "500M", want: 500e6},
+		{in: "2G", want: 2e9},
+		{in: "1048576", want: 1 << 20},
+		{in: "Mi", wantErr: true},
+		{in: "1.5Gi", want: 3 << 29},
+		{in: "0.5M", want: 500e3},
+		{in: ".5Ki", want: 512},
+		{in: "1.0001", want: 2},
+		{in: "0.0", wantErr: true},
+		{in: "NaN", wantErr: true},
+		{in: "-1Gi", wantErr: true},
+		{in: "9000000000Gi", wantErr: true},
+		{in: "99999999999999999999", wantErr: true},
+		{in: "0", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := state.ParseMemory(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseMemory(%q): expected error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseMemory(%q): unexpected error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMemory(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package state
+
+import "fmt"
+
+// Sidecar is a container run alongside a job's main container in each task,
+// such as a database proxy. Sidecars share the main container's network
+// namespace, so the containers reach each other on localhost, and are
+// stopped once the main container exits.
+type Sidecar struct {
+	Name    string
+	Image   string
+	Command []string
+	Args    []string
+	Env     map[string]string
+	// DependsOn names the containers that must be started before this one.
+	DependsOn []string
+}
+
+// SidecarStartOrder returns the job's sidecars in an order that starts each
+// after the sidecars it depends on, keeping their declared order otherwise.
+// It fails if a container depends on one the job doesn't have, or on
+// itself, directly or not.
+func (j *Job) SidecarStartOrder() ([]Sidecar, error) {
+	names := map[string]bool{}
+	if j.ContainerName != "" {
+		names[j.ContainerName] = true
+	}
+	for _, s := range j.Sidecars {
+		if s.Name == "" {
+			return nil, fmt.Errorf("sidecar %s must be named", s.Image)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("duplicate container name %q", s.Name)
+		}
+		names[s.Name] = true
+	}
+	for _, dep := range j.DependsOn {
+		if !names[dep] || dep == j.ContainerName {
+			return nil, fmt.Errorf("container %s depends on unknown container %q", j.ContainerName, dep)
+		}
+	}
+
+	ordered := make([]Sidecar, 0, len(j.Sidecars))
+	started := map[string]bool{j.ContainerName: true}
+	for len(ordered) < len(j.Sidecars) {
+		progress := false
+		for _, s := range j.Sidecars {
+			if started[s.Name] {
+				continue
+			}
+			ready := true
+			for _, dep := range s.DependsOn {
+				if !names[dep] || dep == s.Name {
+					return nil, fmt.Errorf("container %s depends on unknown container %q", s.Name, dep)
+				}
+				ready = ready && started[dep]
+			}
+			if ready {
+				ordered = append(ordered, s)
+				started[s.Name] = true
+				progress = true
+			}
+		}
+		if !progress {
+			var cycle []string
+			for _, s := range j.Sidecars {
+				if !started[s.Name] {
+					cycle = append(cycle, s.Name)
+				}
+			}
+			return nil, fmt.Errorf("containers %v depend on each other", cycle)
+		}
+	}
+	return ordered, nil
+}
@@ -0,0 +1,48 @@
+package state_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+func TestSidecarStartOrder(t *testing.T) {
+	job := &state.Job{
+		ContainerName: "app",
+		DependsOn:     []string{"proxy"},
+		Sidecars: []state.Sidecar{
+			{Name: "proxy", DependsOn: []string{"app", "cache"}},
+			{Name: "metrics"},
+			{Name: "cache"},
+		},
+	}
+	got, err := job.SidecarStartOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range got {
+		names = append(names, s.Name)
+	}
+	if want := "metrics cache proxy"; fmt.Sprint(names) != "["+want+"]" {
+		t.Errorf("expected sidecars to start in order %s, got %v", want, names)
+	}
+
+	invalid := map[string]*state.Job{
+		"unknown dependency": {Sidecars: []state.Sidecar{{Name: "a", DependsOn: []string{"b"}}}},
+		"self dependency":    {Sidecars: []state.Sidecar{{Name: "a", DependsOn: []string{"a"}}}},
+		"cycle": {Sidecars: []state.Sidecar{
+			{Name: "a", DependsOn: []string{"b"}},
+			{Name: "b", DependsOn: []string{"a"}},
+		}},
+		"unnamed sidecar":        {Sidecars: []state.Sidecar{{Image: "redis"}}},
+		"duplicate name":         {ContainerName: "a", Sidecars: []state.Sidecar{{Name: "a"}}},
+		"main depends on itself": {ContainerName: "a", DependsOn: []string{"a"}},
+	}
+	for name, job := range invalid {
+		if _, err := job.SidecarStartOrder(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
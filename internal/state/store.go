@@ -2,21 +2,30 @@ package state
 
 import (
 	"fmt"
+	"reflect"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 )
 
-// Store is a thread-safe in-memory store for jobs and executions.
+// Store is a thread-safe in-memory store for jobs, executions, and the
+// long-running operations that track them, optionally persisted to disk
+// (see OpenStore).
 type Store struct {
 	mu         sync.RWMutex
 	jobs       map[string]*Job       // keyed by full resource name
 	executions map[string]*Execution // keyed by full resource name
+	operations map[string]*Execution // keyed by operation name
+	// path is the state file the store is persisted to, if any.
+	path string
 }
 
 func NewStore() *Store {
 	return &Store{
 		jobs:       make(map[string]*Job),
 		executions: make(map[string]*Execution),
+		operations: make(map[string]*Execution),
 	}
 }
 
@@ -25,6 +34,7 @@ func (s *Store) SaveJob(job *Job) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.jobs[job.Name] = job
+	s.persistLocked()
 }
 
 // GetJob retrieves a job by full resource name.
@@ -58,6 +68,7 @@ func (s *Store) DeleteJob(name string) error {
 		return fmt.Errorf("job not found: %s", name)
 	}
 	delete(s.jobs, name)
+	s.persistLocked()
 	return nil
 }
 
@@ -67,7 +78,7 @@ func (s *Store) ListJobs(parent string) []*Job {
 	defer s.mu.RUnlock()
 	var jobs []*Job
 	for _, job := range s.jobs {
-		if parent == "" || strings.HasPrefix(job.Name, parent) {
+		if parent == "" || strings.HasPrefix(job.Name, parent+"/") {
 			jobs = append(jobs, job)
 		}
 	}
@@ -79,6 +90,7 @@ func (s *Store) SaveExecution(exec *Execution) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.executions[exec.Name] = exec
+	s.persistLocked()
 }
 
 // GetExecution retrieves an execution by full resource name.
@@ -92,6 +104,24 @@ func (s *Store) GetExecution(name string) (*Execution, error) {
 	return exec, nil
 }
 
+// GetExecutionByContainerID finds the execution whose Docker container has the
+// given ID.
+func (s *Store) GetExecutionByContainerID(id string) (*Execution, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if id != "" {
+		for _, exec := range s.executions {
+			exec.Lock()
+			containerID := exec.ContainerID
+			exec.Unlock()
+			if containerID == id {
+				return exec, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("execution not found for container: %s", id)
+}
+
 // DeleteExecution removes an execution by full resource name.
 func (s *Store) DeleteExecution(name string) error {
 	s.mu.Lock()
@@ -100,22 +130,207 @@ func (s *Store) DeleteExecution(name string) error {
 		return fmt.Errorf("execution not found: %s", name)
 	}
 	delete(s.executions, name)
+	s.persistLocked()
 	return nil
 }
 
-// ListExecutions returns executions for a given job (by job resource name prefix).
+// DeleteExecutionsForJob removes the finished executions of the named job,
+// returning how many were removed. Pending and running executions are kept.
+func (s *Store) DeleteExecutionsForJob(jobName string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for name, exec := range s.executions {
+		if _, finished := exec.finished(); strings.HasPrefix(name, jobName+"/executions/") && finished {
+			delete(s.executions, name)
+			n++
+		}
+	}
+	if n > 0 {
+		s.persistLocked()
+	}
+	return n
+}
+
+// ListExecutions returns executions for a given job (by job resource name
+// prefix), or all executions if jobName is empty.
 func (s *Store) ListExecutions(jobName string) []*Execution {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	var execs []*Execution
 	for _, exec := range s.executions {
-		if strings.HasPrefix(exec.Name, jobName+"/executions/") {
+		if jobName == "" || strings.HasPrefix(exec.Name, jobName+"/executions/") {
 			execs = append(execs, exec)
 		}
 	}
 	return execs
 }
 
+// SaveOperation records the operation tracking exec. Operations outlive
+// their execution's record, until pruned.
+func (s *Store) SaveOperation(name string, exec *Execution) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.operations[name] = exec
+	s.persistLocked()
+}
+
+// GetOperation returns the execution tracked by the named operation.
+func (s *Store) GetOperation(name string) (*Execution, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	exec, ok := s.operations[name]
+	if !ok {
+		return nil, fmt.Errorf("operation not found: %s", name)
+	}
+	return exec, nil
+}
+
+// PruneOperations removes operations whose execution finished before cutoff,
+// returning how many were removed. Operations for unfinished executions are
+// kept.
+func (s *Store) PruneOperations(cutoff time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for name, exec := range s.operations {
+		if completed, finished := exec.finished(); finished && completed.Before(cutoff) {
+			delete(s.operations, name)
+			n++
+		}
+	}
+	if n > 0 {
+		s.persistLocked()
+	}
+	return n
+}
+
+// PruneExecutions removes finished executions that completed before cutoff,
+// unless it is zero, and each job's oldest finished executions beyond the
+// newest maxPerJob, unless it is zero. It returns how many were removed.
+// Pending and running executions are never removed, nor counted toward
+// maxPerJob. Operations tracking removed executions are kept.
+func (s *Store) PruneExecutions(cutoff time.Time, maxPerJob int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	byJob := make(map[string][]*Execution)
+	for name, exec := range s.executions {
+		completed, finished := exec.finished()
+		if !finished {
+			continue
+		}
+		if !cutoff.IsZero() && completed.Before(cutoff) {
+			delete(s.executions, name)
+			n++
+			continue
+		}
+		job, _, _ := strings.Cut(name, "/executions/")
+		byJob[job] = append(byJob[job], exec)
+	}
+	if maxPerJob > 0 {
+		for _, execs := range byJob {
+			if len(execs) <= maxPerJob {
+				continue
+			}
+			// Newest first, by completion then name for a stable order.
+			slices.SortFunc(execs, func(a, b *Execution) int {
+				if c := b.CompletionTime.Compare(a.CompletionTime); c != 0 {
+					return c
+				}
+				return strings.Compare(b.Name, a.Name)
+			})
+			for _, exec := range execs[maxPerJob:] {
+				delete(s.executions, exec.Name)
+				n++
+			}
+		}
+	}
+	if n > 0 {
+		s.persistLocked()
+	}
+	return n
+}
+
+// ResetProject removes every job, execution and operation under
+// projects/{project}, leaving other projects untouched. It returns how many
+// jobs and executions were removed.
+func (s *Store) ResetProject(project string) (jobs, executions int) {
+	prefix := "projects/" + project + "/"
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range s.jobs {
+		if strings.HasPrefix(name, prefix) {
+			delete(s.jobs, name)
+			jobs++
+		}
+	}
+	for name := range s.executions {
+		if strings.HasPrefix(name, prefix) {
+			delete(s.executions, name)
+			executions++
+		}
+	}
+	for name := range s.operations {
+		if strings.HasPrefix(name, prefix) {
+			delete(s.operations, name)
+		}
+	}
+	s.persistLocked()
+	return jobs, executions
+}
+
+// ConfigJobChanges lists, by name, how SyncConfigJobs changed the store.
+type ConfigJobChanges struct {
+	Added, Updated, Removed []string
+	// Skipped are config jobs that weren't applied because a job of the
+	// same name was created through the API.
+	Skipped []string
+}
+
+// SyncConfigJobs makes the jobs registered from the jobs config file match
+// jobs: new jobs are added, changed ones replaced and those no longer
+// listed removed. Jobs created through the API are left alone, even if jobs
+// now defines one of the same name. Executions are unaffected; running ones
+// finish with the definition they started with.
+func (s *Store) SyncConfigJobs(jobs []*Job) ConfigJobChanges {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var changes ConfigJobChanges
+	listed := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		job.FromConfig = true
+		listed[job.Name] = true
+		existing, ok := s.jobs[job.Name]
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, job.Name)
+		case !existing.FromConfig:
+			changes.Skipped = append(changes.Skipped, job.Name)
+			continue
+		case reflect.DeepEqual(existing, job):
+			continue
+		default:
+			changes.Updated = append(changes.Updated, job.Name)
+		}
+		s.jobs[job.Name] = job
+	}
+	for name, job := range s.jobs {
+		if job.FromConfig && !listed[name] {
+			delete(s.jobs, name)
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+	slices.Sort(changes.Added)
+	slices.Sort(changes.Updated)
+	slices.Sort(changes.Removed)
+	slices.Sort(changes.Skipped)
+	if len(changes.Added)+len(changes.Updated)+len(changes.Removed) > 0 {
+		s.persistLocked()
+	}
+	return changes
+}
+
 // parseLastSegment extracts the last path segment from a resource name.
 func parseLastSegment(name string) string {
 	parts := strings.Split(name, "/")
@@ -0,0 +1,270 @@
+package state_test
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/matthewmarion/cloud-run-jobs-emulator/internal/state"
+)
+
+func TestGetExecutionByContainerID(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{Name: "projects/p/locations/l/jobs/j"}
+	store.SaveExecution(&state.Execution{
+		Name:        job.Name + "/executions/a",
+		Job:         job,
+		ContainerID: "abc123",
+	})
+	store.SaveExecution(&state.Execution{
+		Name: job.Name + "/executions/b",
+		Job:  job,
+	})
+
+	exec, err := store.GetExecutionByContainerID("abc123")
+	if err != nil {
+		t.Fatalf("GetExecutionByContainerID failed: %v", err)
+	}
+	if exec.Name != job.Name+"/executions/a" {
+		t.Errorf("unexpected execution: %s", exec.Name)
+	}
+
+	if _, err := store.GetExecutionByContainerID("missing"); err == nil {
+		t.Error("expected error for unknown container ID")
+	}
+	if _, err := store.GetExecutionByContainerID(""); err == nil {
+		t.Error("expected error for empty container ID")
+	}
+}
+
+func TestPruneOperations(t *testing.T) {
+	store := state.NewStore()
+	job := &state.Job{Name: "projects/p/locations/l/jobs/j"}
+	now := time.Now()
+	for _, exec := range []*state.Execution{
+		{Name: job.Name + "/executions/old", Job: job, Status: state.StatusSucceeded, CompletionTime: now.Add(-2 * time.Hour)},
+		{Name: job.Name + "/executions/recent", Job: job, Status: state.StatusFailed, CompletionTime: now.Add(-time.Minute)},
+		{Name: job.Name + "/executions/running", Job: job, Status: state.StatusRunning},
+	} {
+		store.SaveOperation(exec.Name, exec)
+	}
+
+	if n := store.PruneOperations(now.Add(-time.Hour)); n != 1 {
+		t.Errorf("expected 1 operation pruned, got %d", n)
+	}
+	if _, err := store.GetOperation(job.Name + "/executions/old"); err == nil {
+		t.Error("expected the old operation to be pruned")
+	}
+	for _, id := range []string{"recent", "running"} {
+		if _, err := store.GetOperation(job.Name + "/executions/" + id); err != nil {
+			t.Errorf("expected the %s operation to be kept: %v", id, err)
+		}
+	}
+}
+
+func TestPruneExecutions(t *testing.T) {
+	store := state.NewStore()
+	a := &state.Job{Name: "projects/p/locations/l/jobs/a"}
+	b := &state.Job{Name: "projects/p/locations/l/jobs/b"}
+	now := time.Now()
+	for _, exec := range []*state.Execution{
+		{Name: a.Name + "/executions/expired", Job: a, Status: state.StatusSucceeded, CompletionTime: now.Add(-2 * time.Hour)},
+		{Name: a.Name + "/executions/oldest", Job: a, Status: state.StatusFailed, CompletionTime: now.Add(-30 * time.Minute)},
+		{Name: a.Name + "/executions/older", Job: a, Status: state.StatusSucceeded, CompletionTime: now.Add(-20 * time.Minute)},
+		{Name: a.Name + "/executions/newest", Job: a, Status: state.StatusCancelled, CompletionTime: now.Add(-10 * time.Minute)},
+		{Name: a.Name + "/executions/running", Job: a, Status: state.StatusRunning},
+		{Name: a.Name + "/executions/pending", Job: a, Status: state.StatusPending},
+		{Name: b.Name + "/executions/only", Job: b, Status: state.StatusSucceeded, CompletionTime: now.Add(-40 * time.Minute)},
+	} {
+		store.SaveExecution(exec)
+	}
+
+	if n := store.PruneExecutions(time.Time{}, 0); n != 0 {
+		t.Errorf("expected nothing pruned without limits, got %d", n)
+	}
+	if n := store.PruneExecutions(now.Add(-time.Hour), 2); n != 2 {
+		t.Errorf("expected 2 executions pruned, got %d", n)
+	}
+	var kept []string
+	for _, exec := range store.ListExecutions("") {
+		kept = append(kept, path.Base(exec.Name))
+	}
+	slices.Sort(kept)
+	if want := []string{"newest", "older", "only", "pending", "running"}; !slices.Equal(kept, want) {
+		t.Errorf("expected %v kept, got %v", want, kept)
+	}
+}
+
+func TestResetProject(t *testing.T) {
+	store := state.NewStore()
+	for _, project := range []string{"suite-a", "suite-b"} {
+		job := &state.Job{Name: "projects/" + project + "/locations/l/jobs/j"}
+		store.SaveJob(job)
+		exec := &state.Execution{Name: job.Name + "/executions/e", Job: job}
+		store.SaveExecution(exec)
+		store.SaveOperation(exec.Name, exec)
+	}
+	// A project whose ID extends another's must not be matched by prefix.
+	store.SaveJob(&state.Job{Name: "projects/suite-a2/locations/l/jobs/j"})
+
+	jobs, execs := store.ResetProject("suite-a")
+	if jobs != 1 || execs != 1 {
+		t.Errorf("expected 1 job and 1 execution removed, got %d and %d", jobs, execs)
+	}
+	if got := store.ListJobs("projects/suite-a/locations/l"); len(got) != 0 {
+		t.Errorf("expected suite-a to be empty, got %d jobs", len(got))
+	}
+	if _, err := store.GetOperation("projects/suite-a/locations/l/jobs/j/executions/e"); err == nil {
+		t.Error("expected suite-a's operation to be removed")
+	}
+	if got := store.ListJobs("projects/suite-b/locations/l"); len(got) != 1 {
+		t.Errorf("expected suite-b's job to remain, got %d jobs", len(got))
+	}
+	if got := store.ListExecutions("projects/suite-b/locations/l/jobs/j"); len(got) != 1 {
+		t.Errorf("expected suite-b's execution to remain, got %d", len(got))
+	}
+	if _, err := store.GetJob("projects/suite-a2/locations/l/jobs/j"); err != nil {
+		t.Errorf("expected suite-a2's job to remain: %v", err)
+	}
+}
+
+func TestSyncConfigJobs(t *testing.T) {
+	const prefix = "projects/p/locations/l/jobs/"
+	store := state.NewStore()
+	store.SyncConfigJobs([]*state.Job{
+		{Name: prefix + "same", Image: "alpine"},
+		{Name: prefix + "edited", Image: "alpine:3.19"},
+		{Name: prefix + "dropped", Image: "alpine"},
+	})
+	store.SaveJob(&state.Job{Name: prefix + "api", Image: "busybox"})
+	running := &state.Execution{Name: prefix + "dropped/executions/e", Status: state.StatusRunning}
+	running.Job, _ = store.GetJob(prefix + "dropped")
+	store.SaveExecution(running)
+
+	changes := store.SyncConfigJobs([]*state.Job{
+		{Name: prefix + "same", Image: "alpine"},
+		{Name: prefix + "edited", Image: "alpine:3.20"},
+		{Name: prefix + "new", Image: "alpine"},
+		{Name: prefix + "api", Image: "alpine"},
+	})
+
+	want := state.ConfigJobChanges{
+		Added:   []string{prefix + "new"},
+		Updated: []string{prefix + "edited"},
+		Removed: []string{prefix + "dropped"},
+		Skipped: []string{prefix + "api"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("expected changes %+v, got %+v", want, changes)
+	}
+	if job, _ := store.GetJob(prefix + "edited"); job.Image != "alpine:3.20" {
+		t.Errorf("expected the edited job to be replaced, got image %s", job.Image)
+	}
+	if job, _ := store.GetJob(prefix + "api"); job.Image != "busybox" || job.FromConfig {
+		t.Errorf("expected the API-created job to be left alone, got %+v", job)
+	}
+	if _, err := store.GetJob(prefix + "dropped"); err == nil {
+		t.Error("expected the job removed from the config to be deleted")
+	}
+	if _, err := store.GetExecution(running.Name); err != nil {
+		t.Errorf("expected the removed job's execution to remain: %v", err)
+	}
+}
+
+func TestOpenStorePersistsState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := state.OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore failed: %v", err)
+	}
+	job := &state.Job{Name: "projects/p/locations/l/jobs/j", Image: "alpine:latest", Timeout: time.Minute}
+	gone := &state.Job{Name: "projects/p/locations/l/jobs/gone"}
+	store.SaveJob(job)
+	store.SaveJob(gone)
+	done := &state.Execution{Name: job.Name + "/executions/done", Job: job, Status: state.StatusSucceeded, Logs: state.NewLogBuffer(0, 0)}
+	running := &state.Execution{Name: job.Name + "/executions/running", Job: job, Status: state.StatusRunning}
+	store.SaveExecution(done)
+	store.SaveOperation(done.Name, done)
+	store.SaveExecution(running)
+	if err := store.DeleteJob(gone.Name); err != nil {
+		t.Fatal(err)
+	}
+	// Changes made in place are written by Sync.
+	done.SucceededCount = 1
+	store.Sync()
+
+	reopened, err := state.OpenStore(path)
+	if err != nil {
+		t.Fatalf("reopening store failed: %v", err)
+	}
+	if jobs := reopened.ListJobs(""); len(jobs) != 1 || jobs[0].Name != job.Name || jobs[0].Timeout != time.Minute {
+		t.Fatalf("expected only %s to be restored, got %+v", job.Name, jobs)
+	}
+	got, err := reopened.GetExecution(done.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != state.StatusSucceeded || got.SucceededCount != 1 || got.Logs != nil {
+		t.Errorf("unexpected restored execution %+v", got)
+	}
+	if got.Job != reopened.ListJobs("")[0] {
+		t.Error("expected the restored execution to share the restored job")
+	}
+	if op, err := reopened.GetOperation(done.Name); err != nil || op != got {
+		t.Errorf("expected the operation to track the restored execution, got %v, %v", op, err)
+	}
+	interrupted, err := reopened.GetExecution(running.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if interrupted.Status != state.StatusFailed || interrupted.CompletionTime.IsZero() {
+		t.Errorf("expected an execution running at shutdown to be restored as failed, got %s", interrupted.Status)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected only the state file to remain, got %v", entries)
+	}
+}
+
+func TestSyncWhileExecutionUpdated(t *testing.T) {
+	store, err := state.OpenStore(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("OpenStore failed: %v", err)
+	}
+	job := &state.Job{Name: "projects/p/locations/l/jobs/j"}
+	exec := &state.Execution{Name: job.Name + "/executions/e", Job: job, TaskCount: 100, Status: state.StatusRunning}
+	store.SaveExecution(exec)
+
+	// Executors update executions under their lock while the store is
+	// written; run with -race.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 100 {
+			exec.Lock()
+			exec.TaskState(i).Status = state.StatusSucceeded
+			exec.SucceededCount++
+			exec.KeptContainerIDs = append(exec.KeptContainerIDs, "container")
+			exec.Unlock()
+		}
+	}()
+	for range 20 {
+		store.Sync()
+	}
+	<-done
+}
+
+func TestOpenStoreRejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.OpenStore(path); err == nil {
+		t.Error("expected an error for a corrupt state file")
+	}
+}
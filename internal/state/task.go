@@ -0,0 +1,32 @@
+package state
+
+import "time"
+
+// TaskState records the progress of one task of an execution.
+type TaskState struct {
+	// Status is StatusPending until the task starts.
+	Status         ExecutionStatus
+	StartTime      time.Time
+	CompletionTime time.Time
+	// Retried is the number of the task's failed attempts that were retried.
+	Retried int32
+	// ExitCode is the exit code of the task's latest attempt.
+	ExitCode     int32
+	ErrorMessage string
+}
+
+// TaskState returns the state of the task with the given index, allocating
+// the states of all the execution's tasks on first use.
+func (e *Execution) TaskState(index int) *TaskState {
+	if len(e.TaskStates) < int(e.Tasks()) {
+		states := make([]*TaskState, e.Tasks())
+		copy(states, e.TaskStates)
+		for i := range states {
+			if states[i] == nil {
+				states[i] = &TaskState{}
+			}
+		}
+		e.TaskStates = states
+	}
+	return e.TaskStates[index]
+}